@@ -0,0 +1,93 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// AppUsage is the number of posts published by an app, broken down by version
+type AppUsage struct {
+	App      string         `json:"app"`
+	Count    int            `json:"count"`
+	Versions map[string]int `json:"versions,omitempty"`
+}
+
+// runApps implements the "apps" command, which prints the distinct apps that
+// published the stored posts as JSON, most used first, with a histogram of the
+// app versions where a version was recorded
+func runApps(config *Config, args []string) error {
+	fs := flag.NewFlagSet("apps", flag.ExitOnError)
+	fs.Parse(args)
+
+	db, err := initDB(config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	usage, err := getAppUsage(db)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(usage)
+}
+
+// getAppUsage aggregates the app column of all posts by app name. Values of
+// the form "name/version" count towards both the app and the version histogram.
+func getAppUsage(db *sql.DB) ([]AppUsage, error) {
+	rows, err := db.Query(`
+		SELECT app, COUNT(*) FROM ` + postsView + `
+		WHERE app IS NOT NULL AND app != ''
+		GROUP BY app
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying apps: %v", err)
+	}
+	defer rows.Close()
+
+	byName := make(map[string]*AppUsage)
+	for rows.Next() {
+		var app string
+		var count int
+		if err := rows.Scan(&app, &count); err != nil {
+			return nil, fmt.Errorf("error reading apps: %v", err)
+		}
+
+		name, version, _ := strings.Cut(app, "/")
+		usage, ok := byName[name]
+		if !ok {
+			usage = &AppUsage{App: name}
+			byName[name] = usage
+		}
+		usage.Count += count
+		if version != "" {
+			if usage.Versions == nil {
+				usage.Versions = make(map[string]int)
+			}
+			usage.Versions[version] += count
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading apps: %v", err)
+	}
+
+	result := make([]AppUsage, 0, len(byName))
+	for _, usage := range byName {
+		result = append(result, *usage)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].App < result[j].App
+	})
+	return result, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestGetAppUsage(t *testing.T) {
+	db, bp := newTestProcessor(t, nil)
+	withApp := func(permlink, metadata string) Operation {
+		op := testPost("alice", permlink, "Title")
+		op.Value.JsonMetadata = metadata
+		return op
+	}
+	block := testBlock(100,
+		withApp("a", `{"app":"peakd/2024.1.1"}`),
+		withApp("b", `{"app":{"name":"peakd","version":"2024.1.1"}}`),
+		withApp("c", `{"app":{"name":"peakd","version":"2024.2.0"}}`),
+		withApp("d", `{"app":"ecency"}`),
+		withApp("e", `{"app":{"name":"ecency"}}`),
+		withApp("f", `{"app":"hiveblog/0.1"}`),
+		withApp("g", `{"app":{"version":"1.0"}}`),
+		withApp("h", `{"tags":["hive"]}`),
+	)
+	if _, err := bp.processBlock(context.Background(), block); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := getAppUsage(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []AppUsage{
+		{App: "peakd", Count: 3, Versions: map[string]int{"2024.1.1": 2, "2024.2.0": 1}},
+		{App: "ecency", Count: 2},
+		{App: "hiveblog", Count: 1, Versions: map[string]int{"0.1": 1}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("getAppUsage() = %+v, want %+v", got, want)
+	}
+}
+
+func TestExtractApp(t *testing.T) {
+	tests := []struct {
+		metadata string
+		want     string
+	}{
+		{`{"app":"peakd/2024.1.1"}`, "peakd/2024.1.1"},
+		{`{"app":{"name":"ecency","version":"3.0"}}`, "ecency/3.0"},
+		{`{"app":{"name":"ecency"}}`, "ecency"},
+		{`{"app":{"version":"1.0"}}`, ""},
+		{`{"app":42}`, ""},
+		{`{"app":null}`, ""},
+		{`{"tags":["hive"]}`, ""},
+		{`not json`, ""},
+		{``, ""},
+	}
+	for _, tt := range tests {
+		if got := extractApp(tt.metadata); got != tt.want {
+			t.Errorf("extractApp(%q) = %q, want %q", tt.metadata, got, tt.want)
+		}
+	}
+
+	// Posts without a usable app store an empty string rather than NULL
+	db, bp := newTestProcessor(t, nil)
+	op := testPost("alice", "numeric-app", "Title")
+	op.Value.JsonMetadata = `{"app":42}`
+	if _, err := bp.processBlock(context.Background(), testBlock(100, testPost("bob", "no-app", "Title"), op)); err != nil {
+		t.Fatal(err)
+	}
+	var nonEmpty, null int
+	if err := db.QueryRow("SELECT COUNT(*) FILTER (WHERE app <> ''), COUNT(*) FILTER (WHERE app IS NULL) FROM posts").Scan(&nonEmpty, &null); err != nil {
+		t.Fatal(err)
+	}
+	if nonEmpty != 0 || null != 0 {
+		t.Errorf("%d posts store an app and %d store NULL, want every app empty", nonEmpty, null)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// BlockRange is an inclusive range of block numbers
+type BlockRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// AuditReport summarizes the block coverage recorded in processed_ranges
+type AuditReport struct {
+	// Batches is the number of recorded batch ranges
+	Batches int `json:"batches"`
+	// Covered are the processed ranges, merged where they touch or overlap
+	Covered []BlockRange `json:"covered"`
+	// Gaps are the unprocessed ranges between GenesisBlock and the highest
+	// processed block
+	Gaps          []BlockRange `json:"gaps"`
+	CoveredBlocks int64        `json:"covered_blocks"`
+	GapBlocks     int64        `json:"gap_blocks"`
+}
+
+// runAudit implements the "audit" command, which prints the processed block
+// coverage and the gaps in it as JSON. Ranges are only recorded while
+// RecordProcessedRanges is enabled.
+func runAudit(config *Config, args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	fs.Parse(args)
+
+	db, err := initDB(config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	report, err := getAuditReport(db, config.GenesisBlock)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// getAuditReport merges the recorded processed ranges and finds the gaps between
+// them, starting after the genesis block
+func getAuditReport(db *sql.DB, genesisBlock int64) (*AuditReport, error) {
+	rows, err := db.Query("SELECT start_block, end_block FROM processed_ranges ORDER BY start_block, end_block")
+	if err != nil {
+		return nil, fmt.Errorf("error querying processed ranges: %v", err)
+	}
+	defer rows.Close()
+
+	report := &AuditReport{Covered: []BlockRange{}, Gaps: []BlockRange{}}
+	for rows.Next() {
+		var r BlockRange
+		if err := rows.Scan(&r.Start, &r.End); err != nil {
+			return nil, fmt.Errorf("error reading processed range: %v", err)
+		}
+		report.Batches++
+
+		if n := len(report.Covered); n > 0 && r.Start <= report.Covered[n-1].End+1 {
+			if r.End > report.Covered[n-1].End {
+				report.Covered[n-1].End = r.End
+			}
+			continue
+		}
+		report.Covered = append(report.Covered, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading processed ranges: %v", err)
+	}
+
+	next := genesisBlock + 1
+	for _, r := range report.Covered {
+		if r.Start > next {
+			report.Gaps = append(report.Gaps, BlockRange{Start: next, End: r.Start - 1})
+			report.GapBlocks += r.Start - next
+		}
+		report.CoveredBlocks += r.End - r.Start + 1
+		if r.End+1 > next {
+			next = r.End + 1
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestProcessedRanges(t *testing.T) {
+	db, bp := newTestProcessor(t, func(c *Config) {
+		c.HiveAPIURLs = []string{testNode(t, 135, nil)}
+		c.GenesisBlock = 100
+		c.BatchSize = 10
+		c.InitialBatchSize = 0
+		c.RecordProcessedRanges = true
+	})
+	if _, err := syncBlocks(context.Background(), bp.config, db, bp, newHeadCache(bp.config), &pauseControl{}, NewStats()); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := db.Query("SELECT start_block, end_block FROM processed_ranges ORDER BY start_block")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var ranges []BlockRange
+	for rows.Next() {
+		var r BlockRange
+		if err := rows.Scan(&r.Start, &r.End); err != nil {
+			t.Fatal(err)
+		}
+		ranges = append(ranges, r)
+	}
+	want := []BlockRange{{101, 110}, {111, 120}, {121, 130}, {131, 135}}
+	if !reflect.DeepEqual(ranges, want) {
+		t.Errorf("recorded ranges %v, want %v", ranges, want)
+	}
+
+	// A later run that skipped some blocks leaves a gap in the coverage
+	if err := recordProcessedRange(db, 141, 150); err != nil {
+		t.Fatal(err)
+	}
+	report, err := getAuditReport(db, bp.config.GenesisBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantReport := &AuditReport{
+		Batches:       5,
+		Covered:       []BlockRange{{101, 135}, {141, 150}},
+		Gaps:          []BlockRange{{136, 140}},
+		CoveredBlocks: 45,
+		GapBlocks:     5,
+	}
+	if !reflect.DeepEqual(report, wantReport) {
+		t.Errorf("getAuditReport() = %+v, want %+v", report, wantReport)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// beneficiariesTableSQL creates the post_beneficiaries table, which holds the
+// beneficiaries of posts when StoreBeneficiaries is enabled. Each row gives an
+// account a share of a post's author rewards in basis points, and the rows are
+// linked to the post by its url.
+const beneficiariesTableSQL = `
+	CREATE TABLE IF NOT EXISTS post_beneficiaries (
+		url TEXT,
+		account TEXT,
+		weight INTEGER,
+		UNIQUE (url, account)
+	);
+	CREATE INDEX IF NOT EXISTS idx_post_beneficiaries_account ON post_beneficiaries(account);
+`
+
+// insertBeneficiarySQL inserts a beneficiary of a post. Beneficiaries can't be
+// changed once set, so a repeated operation keeps the stored row.
+const insertBeneficiarySQL = `
+	INSERT INTO post_beneficiaries (url, account, weight)
+	VALUES (?, ?, ?)
+	ON CONFLICT(url, account) DO NOTHING
+`
+
+// beneficiary is an account receiving part of a post's author rewards
+type beneficiary struct {
+	Account string `json:"account"`
+	Weight  int    `json:"weight"`
+}
+
+// parseBeneficiaries returns the beneficiaries listed in the extensions of a
+// comment_options operation. block_api sends each extension as a
+// {"type": ..., "value": ...} object and condenser_api as a [type, value] pair
+// with a numeric type; both forms are understood. Extensions that can't be
+// decoded are ignored.
+func parseBeneficiaries(extensions json.RawMessage) []beneficiary {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(extensions, &raw); err != nil {
+		return nil
+	}
+
+	var beneficiaries []beneficiary
+	for _, ext := range raw {
+		var value struct {
+			Beneficiaries []beneficiary `json:"beneficiaries"`
+		}
+		var object struct {
+			Type  string          `json:"type"`
+			Value json.RawMessage `json:"value"`
+		}
+		var pair []json.RawMessage
+		switch {
+		case json.Unmarshal(ext, &object) == nil:
+			if object.Type != "comment_payout_beneficiaries" {
+				continue
+			}
+			if json.Unmarshal(object.Value, &value) != nil {
+				continue
+			}
+		case json.Unmarshal(ext, &pair) == nil && len(pair) == 2:
+			// Type 0 is comment_payout_beneficiaries
+			if string(pair[0]) != "0" || json.Unmarshal(pair[1], &value) != nil {
+				continue
+			}
+		default:
+			continue
+		}
+		beneficiaries = append(beneficiaries, value.Beneficiaries...)
+	}
+	return beneficiaries
+}
+
+// handleCommentOptions stores the beneficiaries set by a
+// "comment_options_operation" in the post_beneficiaries table. Like the post
+// itself, the rows are written in the batch's transaction when there is one.
+// They only describe a post, so they are not counted as inserts.
+func (bp *BlockProcessor) handleCommentOptions(ctx *OpContext, value OperationValue) (int, error) {
+	beneficiaries := parseBeneficiaries(value.Extensions)
+	if len(beneficiaries) == 0 {
+		return 0, nil
+	}
+
+	url := constructAuthorPerm(value.Author, value.Permlink)
+	err := bp.retryDB(func() error {
+		for _, b := range beneficiaries {
+			if _, err := bp.txStmt(bp.beneficiaryStmt).Exec(url, b.Account, b.Weight); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error inserting beneficiaries of %s: %w", url, err)
+	}
+	return 0, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestStoreBeneficiaries(t *testing.T) {
+	// block_api sends extensions as typed objects, condenser_api as [type, value]
+	// pairs
+	var ops []Operation
+	if err := json.Unmarshal([]byte(`[
+		{"type": "comment_operation", "value": {"author": "alice", "permlink": "shared", "title": "Shared", "parent_permlink": "hive", "json_metadata": "{}"}},
+		{"type": "comment_options_operation", "value": {"author": "alice", "permlink": "shared", "extensions": [
+			{"type": "comment_payout_beneficiaries", "value": {"beneficiaries": [{"account": "hive.fund", "weight": 1000}, {"account": "peakd", "weight": 500}]}}
+		]}},
+		{"type": "comment_options_operation", "value": {"author": "bob", "permlink": "legacy", "extensions": [
+			[0, {"beneficiaries": [{"account": "peakd", "weight": 300}]}]
+		]}},
+		{"type": "comment_options_operation", "value": {"author": "carol", "permlink": "none", "extensions": []}}
+	]`), &ops); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, store := range []bool{true, false} {
+		db, bp := newTestProcessor(t, func(c *Config) { c.StoreBeneficiaries = store; c.BatchTransaction = true })
+		if err := bp.Begin(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := bp.processBlock(context.Background(), testBlock(100, ops...)); err != nil {
+			t.Fatal(err)
+		}
+		if err := bp.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		rows, err := db.Query("SELECT url || ' ' || account || ' ' || weight FROM post_beneficiaries ORDER BY url, account")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for rows.Next() {
+			var row string
+			if err := rows.Scan(&row); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, row)
+		}
+		rows.Close()
+
+		want := []string{"@alice/shared hive.fund 1000", "@alice/shared peakd 500", "@bob/legacy peakd 300"}
+		if !store {
+			want = []string{}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("StoreBeneficiaries %v: post_beneficiaries holds %v, want %v", store, got, want)
+		}
+	}
+}
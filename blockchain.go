@@ -1,21 +1,64 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
 	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
 )
 
 // Block represents a blockchain block
 type Block struct {
 	BlockNum     string        `json:"block_id"`
 	Timestamp    string        `json:"timestamp"`
+	Witness      string        `json:"witness"`
 	Transactions []Transaction `json:"transactions"`
+	// TransactionIDs are the ids of Transactions, in the same order
+	TransactionIDs []string `json:"transaction_ids"`
+
+	// FetchedAt is when the block was received from the node
+	FetchedAt time.Time `json:"-"`
+}
+
+// Number returns the block number encoded in the first 8 hex characters of the
+// block id
+func (b Block) Number() (int64, error) {
+	if len(b.BlockNum) < 8 {
+		return 0, fmt.Errorf("invalid block id %q", b.BlockNum)
+	}
+	n, err := strconv.ParseInt(b.BlockNum[:8], 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid block id %q: %w", b.BlockNum, err)
+	}
+	return n, nil
+}
+
+// TransactionID returns the id of the transaction at index txIndex. block_api
+// lists the ids of a block's transactions separately, while condenser_api
+// includes each id in its transaction, so both are looked at.
+func (b Block) TransactionID(txIndex int) string {
+	if id := b.Transactions[txIndex].TransactionID; id != "" {
+		return id
+	}
+	if txIndex < len(b.TransactionIDs) {
+		return b.TransactionIDs[txIndex]
+	}
+	return ""
 }
 
 // Transaction represents a transaction within a block
 type Transaction struct {
-	Operations []Operation `json:"operations"`
+	Operations    []Operation `json:"operations"`
+	TransactionID string      `json:"transaction_id"`
 }
 
 // Operation represents an operation within a transaction
@@ -26,11 +69,49 @@ type Operation struct {
 
 // OperationValue represents the value of an operation
 type OperationValue struct {
-	Author       string `json:"author"`
-	Title        string `json:"title"`
-	Permlink     string `json:"permlink"`
-	ParentAuthor string `json:"parent_author"`
-	JsonMetadata string `json:"json_metadata"`
+	Author         string `json:"author"`
+	Title          string `json:"title"`
+	Permlink       string `json:"permlink"`
+	ParentAuthor   string `json:"parent_author"`
+	ParentPermlink string `json:"parent_permlink"`
+	JsonMetadata   string `json:"json_metadata"`
+	Body           string `json:"body"`
+
+	// Fields of custom_json operations
+	Id                   flexString `json:"id"`
+	Json                 string     `json:"json"`
+	RequiredAuths        []string   `json:"required_auths"`
+	RequiredPostingAuths []string   `json:"required_posting_auths"`
+
+	// Fields of transfer operations
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Amount Asset  `json:"amount"`
+	Memo   string `json:"memo"`
+
+	// Fields of vote operations
+	Voter  string `json:"voter"`
+	Weight int64  `json:"weight"`
+
+	// Fields of comment_options operations. Other operations use extensions
+	// of other shapes, so they are only decoded by the handler.
+	Extensions json.RawMessage `json:"extensions"`
+}
+
+// flexString is a string field of operation values that some operation types
+// send as a number instead, like the id of a custom_operation, which would
+// otherwise fail to decode the whole block
+type flexString string
+
+// UnmarshalJSON accepts a JSON string or any other JSON value, kept as its text
+func (s *flexString) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		*s = flexString(data)
+		return nil
+	}
+	*s = flexString(str)
+	return nil
 }
 
 // Metadata represents the metadata of a post
@@ -39,34 +120,231 @@ type Metadata struct {
 	App  string   `json:"app"`
 }
 
+// hiveTransport is the HTTP transport shared by all requests to the Hive API, so
+// connections to the node are kept alive and reused across batches
+var hiveTransport = http.DefaultTransport.(*http.Transport).Clone()
+
+// hiveLatencies records the latency of the requests made to each API node
+var hiveLatencies = newNodeLatencies()
+
+// hiveClient is the HTTP client used for all requests to the Hive API
+var hiveClient = &http.Client{Transport: &latencyTransport{next: hiveTransport, latencies: hiveLatencies}}
+
+// hiveNodes distributes the requests to the Hive API across the configured nodes.
+// It is set up by configureHTTP.
+var hiveNodes *nodePool
+
+// configureHTTP applies the connection settings from the configuration to the
+// shared transport. It should be called once at startup, before any requests
+// are made.
+func configureHTTP(config *Config) {
+	hiveClient.Timeout = config.HTTPTimeout
+	hiveNodes = newNodePool(config.HiveAPIURLs, config.NodeMaxFailures, config.NodeCooldown)
+	hiveTransport.IdleConnTimeout = config.IdleConnTimeout
+	hiveTransport.MaxIdleConnsPerHost = config.MaxIdleConnsPerHost
+}
+
+// postRPC sends a JSON-RPC request body to the configured API nodes and decodes
+// the response of the first node that answers into v. The nodes are tried in the
+// order of a sweep of hiveNodes, see nodePool.Sweep, or in configured order when
+// no pool has been set up. A node that fails, including with a response that
+// can't be decoded, is logged and the next node is tried, so an error is only
+// returned once every healthy node has failed. Callers retry with backoff around the whole
+// sweep, giving every node the same number of attempts. The request is abandoned
+// when ctx is cancelled.
+func postRPC(ctx context.Context, config *Config, body []byte, v interface{}) error {
+	nodes := config.HiveAPIURLs
+	if len(nodes) == 0 {
+		return fmt.Errorf("no API node configured")
+	}
+	pool := hiveNodes
+	if pool == nil {
+		pool = newNodePool(nodes, 0, 0)
+	}
+
+	var err error
+	for i, node := range pool.Sweep() {
+		if err = postNode(ctx, node, body, v); err == nil {
+			pool.Succeeded(node)
+			if i > 0 {
+				slog.Info("Request served by fallback node", "node", node)
+			} else {
+				slog.Debug("Request served", "node", node)
+			}
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		pool.Failed(node)
+		if len(nodes) > 1 {
+			slog.Warn("Node failed", "node", node, "error", err)
+		}
+	}
+	if len(nodes) > 1 {
+		return fmt.Errorf("all %d API nodes failed, last error: %w", len(nodes), err)
+	}
+	return err
+}
+
+// postNode sends a JSON-RPC request body to a single API node and decodes the
+// response into v
+func postNode(ctx context.Context, node string, body []byte, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, node, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := hiveClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return decodeResponse(resp, v)
+}
+
+// errDecode is returned when a node answers with something other than a JSON-RPC
+// response, such as the HTML error page of a proxy in front of it
+var errDecode = errors.New("node returned an invalid response")
+
+// decodePreviewLength is how much of an invalid response body is included in
+// the error
+const decodePreviewLength = 200
+
+// decodeResponse decodes the JSON-RPC response in resp into v. A body that is not
+// JSON, which proxies often send with a 200 status, is reported as errDecode
+// along with the status, content type and the start of the body.
+func decodeResponse(resp *http.Response, v interface{}) error {
+	body := bufio.NewReader(resp.Body)
+	peeked, _ := body.Peek(decodePreviewLength)
+	start := bytes.TrimSpace(peeked)
+
+	contentType := resp.Header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "text/html" || len(start) == 0 || (start[0] != '{' && start[0] != '[') {
+		return fmt.Errorf("%w (status %d, content type %q): %q", errDecode, resp.StatusCode, contentType, start)
+	}
+
+	if err := json.NewDecoder(body).Decode(v); err != nil {
+		return fmt.Errorf("%w: %v", errDecode, err)
+	}
+	return nil
+}
+
 // getLatestBlock retrieves the latest block number from the Hive blockchain
 //
 // It makes a request to the Hive API to retrieve the dynamic global properties,
 // which contain the latest block number. The function returns the latest block
 // number and an error if the request fails.
-func getLatestBlock(config *Config) (int, error) {
-	resp, err := http.Post(config.HiveAPIURL, "application/json", bytes.NewBuffer([]byte(`{
+func getLatestBlock(ctx context.Context, config *Config) (int64, error) {
+	var result struct {
+		Result struct {
+			HeadBlockNumber int64 `json:"head_block_number"`
+		} `json:"result"`
+	}
+
+	err := postRPC(ctx, config, []byte(`{
 		"jsonrpc": "2.0",
 		"method": "database_api.get_dynamic_global_properties",
 		"params": {},
 		"id": 1
-	}`)))
+	}`), &result)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.Result.HeadBlockNumber, nil
+}
+
+// getAccountReputation retrieves the current raw reputation of an account
+//
+// It calls condenser_api.get_accounts for the account, returning an error if the
+// request fails or the account does not exist. Nodes return the reputation either
+// as a number or as a numeric string; both are accepted. The request is abandoned
+// when ctx is cancelled.
+func getAccountReputation(ctx context.Context, config *Config, account string) (int64, error) {
+	payload := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  "condenser_api.get_accounts",
+		"params":  []interface{}{[]string{account}},
+		"id":      1,
+	}
+
+	jsonData, err := json.Marshal(payload)
 	if err != nil {
 		return 0, err
 	}
-	defer resp.Body.Close()
 
 	var result struct {
-		Result struct {
-			HeadBlockNumber int `json:"head_block_number"`
+		Result []struct {
+			Name       string          `json:"name"`
+			Reputation json.RawMessage `json:"reputation"`
 		} `json:"result"`
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if err := postRPC(ctx, config, jsonData, &result); err != nil {
 		return 0, err
 	}
+	if len(result.Result) == 0 {
+		return 0, fmt.Errorf("account %s not found", account)
+	}
 
-	return result.Result.HeadBlockNumber, nil
+	raw := string(result.Result[0].Reputation)
+	if unquoted, err := strconv.Unquote(raw); err == nil {
+		raw = unquoted
+	}
+	return strconv.ParseInt(raw, 10, 64)
+}
+
+// headCache is a read-through cache in front of getLatestBlock
+//
+// Repeated reads within the configured TTL return the cached head block number
+// instead of querying the node again. A TTL of zero disables caching.
+type headCache struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	fetch     func(ctx context.Context) (int64, error)
+	head      int64
+	fetchedAt time.Time
+}
+
+// newHeadCache creates a headCache that fetches the head block from the
+// configured Hive API nodes.
+func newHeadCache(config *Config) *headCache {
+	return &headCache{
+		ttl: config.HeadCacheTTL,
+		fetch: func(ctx context.Context) (int64, error) {
+			return getLatestBlock(ctx, config)
+		},
+	}
+}
+
+// Get returns the latest block number, querying the node only when the cached
+// value is missing or older than the TTL.
+func (c *headCache) Get(ctx context.Context) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.fetchedAt.IsZero() && time.Since(c.fetchedAt) < c.ttl {
+		return c.head, nil
+	}
+
+	head, err := c.fetch(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	c.head = head
+	c.fetchedAt = time.Now()
+	return head, nil
+}
+
+// Invalidate discards the cached head so the next Get queries the node
+func (c *headCache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fetchedAt = time.Time{}
 }
 
 // getBlockRange retrieves a range of blocks from the Hive blockchain
@@ -74,7 +352,7 @@ func getLatestBlock(config *Config) (int, error) {
 // It sends a request to the Hive API's block_api.get_block_range method, specifying
 // the starting block number and the number of blocks to retrieve. The function
 // returns a slice of Block structs and an error if the request or decoding fails.
-func getBlockRange(config *Config, startBlock, count int) ([]Block, error) {
+func getBlockRange(ctx context.Context, config *Config, startBlock int64, count int) ([]Block, error) {
 	payload := map[string]interface{}{
 		"jsonrpc": "2.0",
 		"method":  "block_api.get_block_range",
@@ -90,21 +368,95 @@ func getBlockRange(config *Config, startBlock, count int) ([]Block, error) {
 		return nil, err
 	}
 
-	resp, err := http.Post(config.HiveAPIURL, "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
 	var result struct {
 		Result struct {
 			Blocks []Block `json:"blocks"`
 		} `json:"result"`
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if err := postRPC(ctx, config, jsonData, &result); err != nil {
 		return nil, err
 	}
 
 	return result.Result.Blocks, nil
 }
+
+// BlockFetchError describes a block that could not be retrieved as part of a
+// batched request
+type BlockFetchError struct {
+	BlockNum int64
+	Message  string
+	// NotFound is set when the node answered that the block does not exist, which
+	// is expected for blocks beyond its head
+	NotFound bool
+}
+
+// getBlocksBatch retrieves a range of blocks using a single JSON-RPC batch request
+//
+// Each block in the range is requested with its own block_api.get_block call inside
+// the batch, so the node may answer some calls successfully and others with an
+// error. The successfully retrieved blocks are returned in ascending order together
+// with the blocks that failed; an error is only returned if the batch as a whole
+// could not be sent or decoded.
+func getBlocksBatch(ctx context.Context, config *Config, startBlock int64, count int) ([]Block, []BlockFetchError, error) {
+	payload := make([]map[string]interface{}, 0, count)
+	for blockNum := startBlock; blockNum < startBlock+int64(count); blockNum++ {
+		payload = append(payload, map[string]interface{}{
+			"jsonrpc": "2.0",
+			"method":  "block_api.get_block",
+			"params": map[string]interface{}{
+				"block_num": blockNum,
+			},
+			"id": blockNum,
+		})
+	}
+
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var results []struct {
+		ID     int64 `json:"id"`
+		Result *struct {
+			Block *Block `json:"block"`
+		} `json:"result"`
+		Error *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+
+	if err := postRPC(ctx, config, jsonData, &results); err != nil {
+		return nil, nil, err
+	}
+
+	answered := make(map[int64]bool, len(results))
+	var blocks []Block
+	var failed []BlockFetchError
+	for _, r := range results {
+		answered[r.ID] = true
+		switch {
+		case r.Error != nil:
+			failed = append(failed, BlockFetchError{
+				BlockNum: r.ID,
+				Message:  fmt.Sprintf("rpc error %d: %s", r.Error.Code, r.Error.Message),
+			})
+		case r.Result == nil || r.Result.Block == nil:
+			failed = append(failed, BlockFetchError{BlockNum: r.ID, Message: "block not found", NotFound: true})
+		default:
+			blocks = append(blocks, *r.Result.Block)
+		}
+	}
+
+	for blockNum := startBlock; blockNum < startBlock+int64(count); blockNum++ {
+		if !answered[blockNum] {
+			failed = append(failed, BlockFetchError{BlockNum: blockNum, Message: "missing from batch response"})
+		}
+	}
+
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].BlockNum < blocks[j].BlockNum })
+	sort.Slice(failed, func(i, j int) bool { return failed[i].BlockNum < failed[j].BlockNum })
+
+	return blocks, failed, nil
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPostRPCFailover(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "<html>bad gateway</html>", http.StatusBadGateway)
+	}))
+	defer down.Close()
+	up := testNode(t, 130, nil)
+
+	tests := []struct {
+		name    string
+		nodes   []string
+		wantLog string
+		wantErr bool
+	}{
+		{"first node answers", []string{up, down.URL}, "level=DEBUG msg=\"Request served\" node=" + up, false},
+		{"fallback node answers", []string{down.URL, up}, "level=INFO msg=\"Request served by fallback node\" node=" + up, false},
+		{"every node fails", []string{down.URL, down.URL}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			defer slog.SetDefault(slog.Default())
+			slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+			config := DefaultConfig()
+			config.HiveAPIURLs = tt.nodes
+			head, err := getLatestBlock(context.Background(), config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getLatestBlock() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && head != 130 {
+				t.Errorf("getLatestBlock() = %d, want 130", head)
+			}
+			if !strings.Contains(logs.String(), tt.wantLog) {
+				t.Errorf("log %q does not contain %q", logs.String(), tt.wantLog)
+			}
+		})
+	}
+}
+
+func TestHeadCache(t *testing.T) {
+	calls := 0
+	cache := &headCache{
+		ttl: 50 * time.Millisecond,
+		fetch: func(ctx context.Context) (int64, error) {
+			calls++
+			return int64(100 + calls), nil
+		},
+	}
+	get := func(want int64, wantCalls int) {
+		t.Helper()
+		head, err := cache.Get(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if head != want || calls != wantCalls {
+			t.Errorf("Get() = %d after %d fetches, want %d after %d", head, calls, want, wantCalls)
+		}
+	}
+
+	get(101, 1)
+	get(101, 1)
+	get(101, 1)
+	time.Sleep(60 * time.Millisecond)
+	get(102, 2)
+	cache.Invalidate()
+	get(103, 3)
+
+	cache.ttl = 0
+	get(104, 4)
+	get(105, 5)
+}
+
+// withHTTPConfig applies config with configureHTTP for the rest of the test,
+// restoring the shared client, transport and node pool afterwards
+func withHTTPConfig(t *testing.T, config *Config) {
+	t.Helper()
+	timeout, nodes := hiveClient.Timeout, hiveNodes
+	idle, perHost := hiveTransport.IdleConnTimeout, hiveTransport.MaxIdleConnsPerHost
+	t.Cleanup(func() {
+		hiveClient.Timeout, hiveNodes = timeout, nodes
+		hiveTransport.IdleConnTimeout, hiveTransport.MaxIdleConnsPerHost = idle, perHost
+	})
+	configureHTTP(config)
+}
+
+func TestConfigureHTTP(t *testing.T) {
+	var mu sync.Mutex
+	conns := 0
+	node := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","result":{"head_block_number":130},"id":1}`))
+	}))
+	node.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mu.Lock()
+			conns++
+			mu.Unlock()
+		}
+	}
+	node.Start()
+	defer node.Close()
+
+	config := DefaultConfig()
+	config.HiveAPIURLs = []string{node.URL}
+	config.HTTPTimeout = 7 * time.Second
+	config.IdleConnTimeout = 42 * time.Second
+	config.MaxIdleConnsPerHost = 3
+	withHTTPConfig(t, config)
+
+	if hiveClient.Timeout != config.HTTPTimeout {
+		t.Errorf("client timeout = %v, want %v", hiveClient.Timeout, config.HTTPTimeout)
+	}
+	if hiveTransport.IdleConnTimeout != config.IdleConnTimeout || hiveTransport.MaxIdleConnsPerHost != config.MaxIdleConnsPerHost {
+		t.Errorf("transport idle timeout = %v, idle connections per host = %d, want %v, %d",
+			hiveTransport.IdleConnTimeout, hiveTransport.MaxIdleConnsPerHost, config.IdleConnTimeout, config.MaxIdleConnsPerHost)
+	}
+
+	// Requests in sequence reuse the kept-alive connection
+	for i := 0; i < 3; i++ {
+		if _, err := getLatestBlock(context.Background(), config); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if conns != 1 {
+		t.Errorf("3 requests opened %d connections, want 1", conns)
+	}
+}
+
+func TestBlockNumber(t *testing.T) {
+	tests := []struct {
+		id      string
+		want    int64
+		wantErr bool
+	}{
+		{"05f5e100" + strings.Repeat("0", 32), 100000000, false},
+		{"80000001" + strings.Repeat("a", 32), 1<<31 + 1, false},
+		{"ffffffff" + strings.Repeat("b", 32), 1<<32 - 1, false},
+		{"0123", 0, true},
+		{"zz000000" + strings.Repeat("0", 32), 0, true},
+	}
+	for _, tt := range tests {
+		got, err := Block{BlockNum: tt.id}.Number()
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("Number() of %s = %d, %v, want %d with error %v", tt.id, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	// Block numbers past 2^31 are stored without wrapping around
+	db, bp := newTestProcessor(t, nil)
+	const blockNum = 1<<31 + 5
+	if _, err := bp.processBlock(context.Background(), testBlock(blockNum, testPost("alice", "far", "Far"))); err != nil {
+		t.Fatal(err)
+	}
+	var stored int64
+	if err := db.QueryRow("SELECT block_num FROM posts WHERE url = '@alice/far'").Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	last, err := getLastProcessedBlock(db, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored != blockNum || last != blockNum {
+		t.Errorf("stored block %d, last processed %d, want %d", stored, last, blockNum)
+	}
+}
+
+func TestRPCCancellation(t *testing.T) {
+	// The node never answers before the test ends
+	var requests atomic.Int32
+	stop := make(chan struct{})
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		select {
+		case <-r.Context().Done():
+		case <-stop:
+		}
+	}))
+	defer hanging.Close()
+	defer close(stop)
+
+	calls := map[string]func(ctx context.Context, config *Config) error{
+		"getLatestBlock": func(ctx context.Context, config *Config) error {
+			_, err := getLatestBlock(ctx, config)
+			return err
+		},
+		"getBlockRange": func(ctx context.Context, config *Config) error {
+			_, err := getBlockRange(ctx, config, 101, 10)
+			return err
+		},
+	}
+	for name, call := range calls {
+		t.Run(name, func(t *testing.T) {
+			requests.Store(0)
+			config := DefaultConfig()
+			// An interrupted request must not fail over to the next node
+			config.HiveAPIURLs = []string{hanging.URL, hanging.URL}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(50*time.Millisecond, cancel)
+			start := time.Now()
+			err := call(ctx, config)
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("%s() = %v after cancellation, want context.Canceled", name, err)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("%s() returned %s after cancellation", name, elapsed)
+			}
+
+			ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			if err := call(ctx, config); !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("%s() = %v past the deadline, want context.DeadlineExceeded", name, err)
+			}
+			if n := requests.Load(); n != 2 {
+				t.Errorf("node received %d requests, want 1 per call", n)
+			}
+		})
+	}
+}
+
+func TestDecodeHTMLResponse(t *testing.T) {
+	page := "<html><body>" + strings.Repeat("upstream unavailable ", 30) + "</body></html>"
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{"html content type", "text/html; charset=utf-8", page},
+		{"html body without a content type", "application/json", "  " + page},
+		{"empty body", "application/json", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Write([]byte(tt.body))
+			}))
+			defer proxy.Close()
+
+			config := DefaultConfig()
+			config.HiveAPIURLs = []string{proxy.URL}
+			_, err := getLatestBlock(context.Background(), config)
+			if !errors.Is(err, errDecode) {
+				t.Fatalf("getLatestBlock() = %v, want errDecode", err)
+			}
+			if !strings.Contains(err.Error(), "status 200") {
+				t.Errorf("error %q does not report the status", err)
+			}
+			// Only the start of the body is included
+			if len(err.Error()) > decodePreviewLength+200 {
+				t.Errorf("error holds %d bytes, want the body truncated to %d", len(err.Error()), decodePreviewLength)
+			}
+
+			// The next node is asked instead, and the failure logged with the preview
+			var logs bytes.Buffer
+			defer slog.SetDefault(slog.Default())
+			slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+			config.HiveAPIURLs = []string{proxy.URL, testNode(t, 130, nil)}
+			head, err := getLatestBlock(context.Background(), config)
+			if err != nil || head != 130 {
+				t.Fatalf("getLatestBlock() = %d, %v, want 130 from the fallback node", head, err)
+			}
+			if !strings.Contains(logs.String(), "msg=\"Node failed\"") || !strings.Contains(logs.String(), errDecode.Error()) {
+				t.Errorf("logs do not report the invalid response:\n%s", logs.String())
+			}
+		})
+	}
+}
+
+func TestHTTPTimeout(t *testing.T) {
+	// The node takes far longer to answer than the timeout allows
+	stop := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-stop:
+		}
+	}))
+	defer slow.Close()
+	defer close(stop)
+
+	config := DefaultConfig()
+	config.HiveAPIURLs = []string{slow.URL}
+	config.HTTPTimeout = 50 * time.Millisecond
+	withHTTPConfig(t, config)
+
+	start := time.Now()
+	_, err := getLatestBlock(context.Background(), config)
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("getLatestBlock() = %v, want a timeout error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("getLatestBlock() returned after %s, want it bounded by the timeout", elapsed)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// sqliteMaxVariables is the number of bound parameters a statement may use in
+// SQLite builds with the historical default limit. Multi-row inserts are chunked
+// to stay below it, so they work regardless of how SQLite was compiled.
+const sqliteMaxVariables = 999
+
+// queuePost queues a post for the next multi-row insert. A post whose URL is
+// already queued is dropped, just as ON CONFLICT would ignore it, unless
+// UpdateOnConflict applies it as an edit of the queued post.
+func (bp *BlockProcessor) queuePost(row *postRow) {
+	if bp.pendingURLs[row.url] {
+		if bp.config.UpdateOnConflict {
+			for _, queued := range bp.pending {
+				if queued.url == row.url {
+					applyEdit(queued, row)
+				}
+			}
+		}
+		return
+	}
+	bp.pendingURLs[row.url] = true
+	bp.pending = append(bp.pending, row)
+}
+
+// dropQueued removes a post from the queue of the next multi-row insert, so a
+// post deleted in the batch that created it isn't inserted once the batch is
+// flushed
+func (bp *BlockProcessor) dropQueued(url string) {
+	if !bp.pendingURLs[url] {
+		return
+	}
+	delete(bp.pendingURLs, url)
+	kept := bp.pending[:0]
+	for _, row := range bp.pending {
+		if row.url != url {
+			kept = append(kept, row)
+		}
+	}
+	bp.pending = kept
+}
+
+// Flush writes the queued posts with multi-row INSERT statements, chunked to
+// respect sqliteMaxVariables, and returns the number of new posts stored.
+//
+// Each statement returns the ids of the rows it inserted, so the work that follows
+// an insert (compact tags, the exec hook) runs only for posts that were not
+// already stored. It is a no-op unless MultiRowInsert is enabled.
+func (bp *BlockProcessor) Flush() (int, error) {
+	pending := bp.pending
+	bp.pending = nil
+	bp.pendingURLs = make(map[string]bool)
+
+	// With PartitionByMonth, each partition gets its own statements
+	tables := []string{"posts"}
+	byTable := map[string][]*postRow{"posts": pending}
+	if bp.config.PartitionByMonth {
+		tables = nil
+		byTable = make(map[string][]*postRow)
+		for _, row := range pending {
+			// Partitions only detect conflicts with the posts they hold themselves
+			stored, err := bp.storedTable(row.url)
+			if err != nil {
+				return 0, err
+			}
+			if stored != "" {
+				if err := bp.handleStoredPost(stored, row); err != nil {
+					return 0, err
+				}
+				continue
+			}
+
+			table := partitionTable(row)
+			if _, ok := byTable[table]; !ok {
+				if _, err := bp.insertStmt(row); err != nil {
+					return 0, err
+				}
+				tables = append(tables, table)
+			}
+			byTable[table] = append(byTable[table], row)
+		}
+	}
+
+	chunkSize := sqliteMaxVariables / postColumnCount
+	inserted := 0
+	for _, table := range tables {
+		rows := byTable[table]
+		for start := 0; start < len(rows); start += chunkSize {
+			end := start + chunkSize
+			if end > len(rows) {
+				end = len(rows)
+			}
+			chunk := rows[start:end]
+
+			ids, err := bp.insertPosts(table, chunk)
+			if err != nil {
+				return inserted, err
+			}
+			for _, row := range chunk {
+				id, ok := ids[row.url]
+				if !ok {
+					if bp.config.UpdateOnConflict {
+						if err := bp.updatePost(table, row); err != nil {
+							return inserted, err
+						}
+					}
+					continue
+				}
+				if err := bp.afterInsert(row, id); err != nil {
+					return inserted, err
+				}
+				inserted++
+			}
+		}
+	}
+	return inserted, nil
+}
+
+// insertPosts inserts rows into table with a single statement, returning the ids
+// of the newly inserted posts by URL
+func (bp *BlockProcessor) insertPosts(table string, rows []*postRow) (map[string]int64, error) {
+	tuples := make([]string, len(rows))
+	args := make([]interface{}, 0, len(rows)*postColumnCount)
+	for i, row := range rows {
+		tuples[i] = postPlaceholders
+		args = append(args, row.values()...)
+	}
+	query := "INSERT INTO " + table + " (" + postColumns + ") VALUES " + strings.Join(tuples, ", ") +
+		" ON CONFLICT(url) DO NOTHING RETURNING _id, url"
+
+	var ids map[string]int64
+	err := bp.retryDB(func() error {
+		ids = make(map[string]int64, len(rows))
+		result, err := bp.execer().Query(query, args...)
+		if err != nil {
+			return err
+		}
+		defer result.Close()
+
+		for result.Next() {
+			var id int64
+			var url string
+			if err := result.Scan(&id, &url); err != nil {
+				return err
+			}
+			ids[url] = id
+		}
+		return result.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error inserting posts: %w", err)
+	}
+	return ids, nil
+}
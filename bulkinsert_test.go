@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestMultiRowInsertDelete(t *testing.T) {
+	deletePost := func(author, permlink string) Operation {
+		return Operation{Type: "delete_comment_operation", Value: OperationValue{Author: author, Permlink: permlink}}
+	}
+
+	tests := []struct {
+		name     string
+		batches  [][]Block
+		wantURLs []string
+	}{
+		{
+			name: "deleted in the same block",
+			batches: [][]Block{{
+				testBlock(100, testPost("alice", "first", "First"), deletePost("alice", "first")),
+			}},
+			wantURLs: []string{},
+		},
+		{
+			name: "deleted in a later block of the batch",
+			batches: [][]Block{{
+				testBlock(100, testPost("alice", "first", "First"), testPost("bob", "second", "Second")),
+				testBlock(101, deletePost("alice", "first")),
+			}},
+			wantURLs: []string{"@bob/second"},
+		},
+		{
+			name: "deleted in a later batch",
+			batches: [][]Block{
+				{testBlock(100, testPost("alice", "first", "First"))},
+				{testBlock(101, deletePost("alice", "first"))},
+			},
+			wantURLs: []string{},
+		},
+		{
+			name: "published again after the delete",
+			batches: [][]Block{{
+				testBlock(100, testPost("alice", "first", "First")),
+				testBlock(101, deletePost("alice", "first")),
+				testBlock(102, testPost("alice", "first", "Again")),
+			}},
+			wantURLs: []string{"@alice/first"},
+		},
+		{
+			name: "delete of an unknown post",
+			batches: [][]Block{{
+				testBlock(100, testPost("alice", "first", "First"), deletePost("carol", "other")),
+			}},
+			wantURLs: []string{"@alice/first"},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, batchTransaction := range []bool{false, true} {
+			name := tt.name
+			if batchTransaction {
+				name += " in a batch transaction"
+			}
+			t.Run(name, func(t *testing.T) {
+				db, bp := newTestProcessor(t, func(c *Config) {
+					c.MultiRowInsert = true
+					c.ProcessDeletes = true
+					c.BatchTransaction = batchTransaction
+				})
+
+				for _, batch := range tt.batches {
+					if err := bp.Begin(); err != nil {
+						t.Fatal(err)
+					}
+					for _, block := range batch {
+						if _, err := bp.processBlock(context.Background(), block); err != nil {
+							t.Fatal(err)
+						}
+					}
+					if _, err := bp.Flush(); err != nil {
+						t.Fatal(err)
+					}
+					if err := bp.Commit(); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				rows, err := db.Query("SELECT url FROM posts")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer rows.Close()
+				urls := []string{}
+				for rows.Next() {
+					var url string
+					if err := rows.Scan(&url); err != nil {
+						t.Fatal(err)
+					}
+					urls = append(urls, url)
+				}
+				sort.Strings(urls)
+				if !reflect.DeepEqual(urls, tt.wantURLs) {
+					t.Errorf("stored %v, want %v", urls, tt.wantURLs)
+				}
+			})
+		}
+	}
+}
+
+func TestMultiRowInsertCount(t *testing.T) {
+	for _, multiRow := range []bool{false, true} {
+		t.Run(fmt.Sprintf("multi-row=%v", multiRow), func(t *testing.T) {
+			db, bp := newTestProcessor(t, func(c *Config) { c.MultiRowInsert = multiRow })
+			stats := NewStats()
+
+			batches := []struct {
+				blocks      []Block
+				wantInserts int
+			}{
+				{[]Block{testBlock(100, testPost("alice", "first", "First"))}, 1},
+				// A post already stored and a post repeated within the batch are
+				// not inserted
+				{[]Block{
+					testBlock(101, testPost("alice", "first", "First again"), testPost("bob", "second", "Second")),
+					testBlock(102, testPost("bob", "second", "Second again")),
+				}, 1},
+			}
+			for _, batch := range batches {
+				start, _ := batch.blocks[0].Number()
+				res, err := processFetched(context.Background(), bp.config, db, bp, stats,
+					fetchedBatch{startBlock: start, count: len(batch.blocks), blocks: batch.blocks})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if res.inserts != batch.wantInserts {
+					t.Errorf("batch from block %d inserted %d posts, want %d", start, res.inserts, batch.wantInserts)
+				}
+			}
+			if inserts := stats.Snapshot().Inserts; inserts != 2 {
+				t.Errorf("stats count %d inserts, want 2", inserts)
+			}
+		})
+	}
+}
+
+func TestMultiRowInsertChunks(t *testing.T) {
+	db, bp := newTestProcessor(t, func(c *Config) {
+		c.MultiRowInsert = true
+		c.CompactTags = true
+	})
+
+	// Enough posts for several chunks, with the last chunk only partly filled
+	chunkSize := sqliteMaxVariables / postColumnCount
+	total := 2*chunkSize + 5
+	var blocks []Block
+	for i := 0; i < total; i++ {
+		blocks = append(blocks, testBlock(int64(101+i), testPost("alice", fmt.Sprintf("post-%d", i), fmt.Sprintf("Post %d", i))))
+	}
+	// A repeat of a post queued in the first chunk
+	blocks = append(blocks, testBlock(int64(101+total), testPost("alice", "post-0", "Post 0 again")))
+
+	res, err := processFetched(context.Background(), bp.config, db, bp, NewStats(),
+		fetchedBatch{startBlock: 101, count: len(blocks), blocks: blocks})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.inserts != total {
+		t.Errorf("inserted %d posts, want %d", res.inserts, total)
+	}
+
+	rows, err := db.Query("SELECT url, title, block_num FROM posts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	stored := 0
+	for rows.Next() {
+		var url, title string
+		var blockNum int64
+		if err := rows.Scan(&url, &title, &blockNum); err != nil {
+			t.Fatal(err)
+		}
+		var i int
+		fmt.Sscanf(url, "@alice/post-%d", &i)
+		if want := fmt.Sprintf("@alice/post-%d", i); url != want || title != fmt.Sprintf("Post %d", i) || blockNum != int64(101+i) {
+			t.Errorf("stored %s %q at block %d, want %s %q at block %d", url, title, blockNum, want, fmt.Sprintf("Post %d", i), 101+i)
+		}
+		stored++
+	}
+	if stored != total {
+		t.Errorf("stored %d posts, want %d", stored, total)
+	}
+
+	// Compact tags are linked through the ids returned for every chunk
+	var linked int
+	if err := db.QueryRow("SELECT COUNT(DISTINCT post_id) FROM post_tag").Scan(&linked); err != nil {
+		t.Fatal(err)
+	}
+	if linked != total {
+		t.Errorf("linked tags for %d posts, want %d", linked, total)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+)
+
+// checkChain makes sure the database is not filled from two chains. The first
+// sync records config.Chain and config.GenesisBlock in the meta table; later
+// syncs with a different chain or genesis block are refused, unless ForceChain
+// is set, in which case the mismatch is only logged and the record kept.
+func checkChain(db *sql.DB, config *Config) error {
+	var (
+		chain   string
+		genesis int64
+	)
+	err := db.QueryRow("SELECT chain, genesis FROM meta WHERE id = 1").Scan(&chain, &genesis)
+	if err == sql.ErrNoRows {
+		_, err := db.Exec("INSERT INTO meta (id, chain, genesis) VALUES (1, ?, ?)", config.Chain, config.GenesisBlock)
+		if err != nil {
+			return fmt.Errorf("error recording chain: %v", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading chain: %v", err)
+	}
+
+	if chain == config.Chain && genesis == config.GenesisBlock {
+		return nil
+	}
+	mismatch := fmt.Sprintf("database %s was filled from chain %q with genesis block %d, not chain %q with genesis block %d",
+		config.DBPath, chain, genesis, config.Chain, config.GenesisBlock)
+	if !config.ForceChain {
+		return fmt.Errorf("%s; use -force to sync anyway", mismatch)
+	}
+	slog.Warn("Chain mismatch ignored with -force", "detail", mismatch)
+	return nil
+}
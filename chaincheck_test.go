@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestCheckChain(t *testing.T) {
+	db, bp := newTestProcessor(t, func(c *Config) {
+		c.Chain = "hive"
+		c.GenesisBlock = 41818752
+	})
+	// The first run records the chain
+	if err := checkChain(db, bp.config); err != nil {
+		t.Fatalf("checkChain() of a new database = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		chain   string
+		genesis int64
+		force   bool
+		wantErr bool
+	}{
+		{"same chain", "hive", 41818752, false, false},
+		{"other chain", "steem", 41818752, false, true},
+		{"other genesis block", "hive", 1, false, true},
+		{"other chain with -force", "steem", 1, true, false},
+	}
+	for _, tt := range tests {
+		config := *bp.config
+		config.Chain, config.GenesisBlock, config.ForceChain = tt.chain, tt.genesis, tt.force
+		if err := checkChain(db, &config); (err != nil) != tt.wantErr {
+			t.Errorf("%s: checkChain() = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	// A forced run keeps the recorded chain
+	var chain string
+	var genesis int64
+	if err := db.QueryRow("SELECT chain, genesis FROM meta").Scan(&chain, &genesis); err != nil {
+		t.Fatal(err)
+	}
+	if chain != "hive" || genesis != 41818752 {
+		t.Errorf("meta records chain %q with genesis block %d, want hive with 41818752", chain, genesis)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// checkpointFile is the content of the file mirroring the checkpoint when
+// CheckpointFile is set
+type checkpointFile struct {
+	// Direction is "forward" or "reverse"
+	Direction string `json:"direction"`
+	// Checkpoint is the checkpoint as stored in the database: the last block
+	// handled by a forward run, and the lowest fully processed block in a reverse
+	// run
+	Checkpoint int64 `json:"checkpoint"`
+	// ProcessedThrough is the last block handled by a forward run, which is
+	// ahead of Checkpoint when the forward checkpoint could not be saved
+	ProcessedThrough int64 `json:"processed_through,omitempty"`
+	// Head is the head block a reverse run started from
+	Head      int64  `json:"head,omitempty"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// writeCheckpointFile replaces the checkpoint file at path. The file is written
+// under a temporary name and renamed, so a reader never sees a partial file.
+func writeCheckpointFile(path string, checkpoint *checkpointFile) error {
+	checkpoint.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("error writing checkpoint file: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("error writing checkpoint file: %v", err)
+	}
+	return nil
+}
+
+// mirrorForwardCheckpoint writes the checkpoint of a forward run to
+// config.CheckpointFile, if set. The checkpoint is read back from the database so
+// the file shows what a restart would resume from. Errors are only logged, since
+// the file is informational.
+func mirrorForwardCheckpoint(config *Config, db *sql.DB, processedThrough int64) {
+	if config.CheckpointFile == "" {
+		return
+	}
+	last, err := getForwardCheckpoint(db, config.GenesisBlock)
+	if err != nil {
+		slog.Error("Error reading checkpoint for the checkpoint file", "error", err)
+		return
+	}
+	err = writeCheckpointFile(config.CheckpointFile, &checkpointFile{
+		Direction:        "forward",
+		Checkpoint:       last,
+		ProcessedThrough: processedThrough,
+	})
+	if err != nil {
+		slog.Error("Error writing checkpoint file", "path", config.CheckpointFile, "error", err)
+	}
+}
+
+// mirrorReverseCheckpoint writes the checkpoint of a reverse run to
+// config.CheckpointFile, if set. Errors are only logged, since the file is
+// informational.
+func mirrorReverseCheckpoint(config *Config, low, high int64) {
+	if config.CheckpointFile == "" {
+		return
+	}
+	err := writeCheckpointFile(config.CheckpointFile, &checkpointFile{
+		Direction:  "reverse",
+		Checkpoint: low,
+		Head:       high,
+	})
+	if err != nil {
+		slog.Error("Error writing checkpoint file", "path", config.CheckpointFile, "error", err)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCheckpointFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	read := func() checkpointFile {
+		var checkpoint checkpointFile
+		data, err := os.ReadFile(path)
+		if err == nil {
+			err = json.Unmarshal(data, &checkpoint)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			t.Errorf("reading checkpoint file: %v", err)
+		}
+		return checkpoint
+	}
+
+	// Every batch is requested after the previous one was checkpointed
+	var mirrored []int64
+	node := testNode(t, 130, func(start int64, count, request int) (int64, int) {
+		mirrored = append(mirrored, read().Checkpoint)
+		return start, count
+	})
+	db, bp := newTestProcessor(t, func(c *Config) {
+		c.HiveAPIURLs = []string{node}
+		c.GenesisBlock = 100
+		c.BatchSize = 10
+		c.InitialBatchSize = 0
+		c.CheckpointFile = path
+	})
+	if _, err := syncBlocks(context.Background(), bp.config, db, bp, newHeadCache(bp.config), &pauseControl{}, NewStats()); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []int64{0, 110, 120}; !reflect.DeepEqual(mirrored, want) {
+		t.Errorf("checkpoint file held %v before each batch, want %v", mirrored, want)
+	}
+	checkpoint, err := getForwardCheckpoint(db, bp.config.GenesisBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := read()
+	if got.Direction != "forward" || got.Checkpoint != checkpoint || got.ProcessedThrough != 130 || got.UpdatedAt == "" {
+		t.Errorf("checkpoint file = %+v, want the database checkpoint %d", got, checkpoint)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary checkpoint file left behind: %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// command is a subcommand of the program. run receives the arguments following
+// the command name and parses them with its own flag set.
+type command struct {
+	name    string
+	summary string
+	run     func(config *Config, args []string) error
+}
+
+// commands lists the subcommands in the order they are shown by help. The help
+// and completion commands are added in init, since they refer to this list.
+var commands = []command{
+	{"sync", "index posts from the last processed block up to the head (default)", runSync},
+	{"serve", "serve the indexed posts over HTTP", runServe},
+	{"export", "write the indexed posts as JSONL or CSV", runExport},
+	{"apps", "print how many posts each app published", runApps},
+	{"stats", "print how many tags the indexed posts have", runStats},
+	{"audit", "print the processed block ranges and the gaps between them", runAudit},
+	{"diff", "compare the posts against another database", runDiff},
+	{"info", "print the schema version and table sizes of the database", runInfo},
+	{"repair-timestamps", "rewrite stored timestamps in the normalized format", runRepairTimestamps},
+	{"rebuild-aux", "recompute tag counts, the tag dictionary, the search index and indexes", runRebuildAux},
+}
+
+func init() {
+	commands = append(commands,
+		command{"help", "list the commands", runHelp},
+		command{"completion", "print a bash completion script", runCompletion},
+	)
+}
+
+// defaultCommand runs when no command is given
+const defaultCommand = "sync"
+
+// programName is the name the program was invoked as, used in usage messages
+var programName = filepath.Base(os.Args[0])
+
+// lookupCommand returns the command with the given name
+func lookupCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+// runCommand dispatches the arguments remaining after the global flags to the
+// named command, running sync when there is none
+func runCommand(config *Config, args []string) error {
+	name := defaultCommand
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+
+	cmd, ok := lookupCommand(name)
+	if !ok {
+		return fmt.Errorf("unknown command %q; run \"%s help\" for the list of commands", name, programName)
+	}
+	return cmd.run(config, args)
+}
+
+// runHelp implements the "help" command
+func runHelp(config *Config, args []string) error {
+	fs := flag.NewFlagSet("help", flag.ExitOnError)
+	fs.Parse(args)
+
+	printUsage(os.Stdout)
+	return nil
+}
+
+// printUsage writes the list of commands and the global flags to w
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s [flags] [command] [command flags]\n\nCommands:\n", programName)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-18s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nRun \"%s <command> -h\" for the flags of a command.\n\n", programName)
+	fmt.Fprintf(w, "Flags (each can also be set through the environment, e.g. -batch as %s):\n", envName("batch"))
+	flag.CommandLine.SetOutput(w)
+	flag.PrintDefaults()
+}
+
+// runCompletion implements the "completion" command, which prints a bash
+// completion script for the command names. Load it with
+// `source <(post-stuffer completion)`.
+func runCompletion(config *Config, args []string) error {
+	fs := flag.NewFlagSet("completion", flag.ExitOnError)
+	fs.Parse(args)
+
+	names := make([]string, len(commands))
+	for i, cmd := range commands {
+		names[i] = cmd.name
+	}
+	fmt.Printf("complete -W %q %s\n", strings.Join(names, " "), programName)
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRunCommand(t *testing.T) {
+	var ran string
+	var ranArgs []string
+	fake := func(name string) func(*Config, []string) error {
+		return func(config *Config, args []string) error {
+			ran, ranArgs = name, args
+			return nil
+		}
+	}
+	saved := commands
+	defer func() { commands = saved }()
+	commands = []command{
+		{"sync", "", fake("sync")},
+		{"export", "", fake("export")},
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantRun  string
+		wantArgs []string
+		wantErr  string
+	}{
+		{"no command runs sync", nil, "sync", nil, ""},
+		{"named command", []string{"export"}, "export", []string{}, ""},
+		{"command flags passed on", []string{"export", "-format", "csv"}, "export", []string{"-format", "csv"}, ""},
+		{"unknown command", []string{"exprot"}, "", nil, `unknown command "exprot"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ran, ranArgs = "", nil
+			err := runCommand(DefaultConfig(), tt.args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("runCommand(%q) error = %v, want %q", tt.args, err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if ran != tt.wantRun || !reflect.DeepEqual(ranArgs, tt.wantArgs) {
+				t.Errorf("runCommand(%q) ran %q with %q, want %q with %q", tt.args, ran, ranArgs, tt.wantRun, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestSyncFlags(t *testing.T) {
+	config := DefaultConfig()
+	config.BatchSize = 50 // set before the command, e.g. by a global flag
+	if err := newSyncFlags(config).Parse([]string{"-follow", "-db", "other.db"}); err != nil {
+		t.Fatal(err)
+	}
+	if !config.Follow || config.DBPath != "other.db" {
+		t.Errorf("Follow = %v, DBPath = %q after flags following the command, want true, %q", config.Follow, config.DBPath, "other.db")
+	}
+	if config.BatchSize != 50 {
+		t.Errorf("BatchSize = %d, want the value set before the command, 50", config.BatchSize)
+	}
+}
@@ -4,22 +4,501 @@ import "time"
 
 // Config holds the application configuration
 type Config struct {
-	HiveAPIURL   string
-	GenesisBlock int
+	// HiveAPIURLs are the API nodes requests are sent to. Requests are spread
+	// round-robin across them and fail over to the next node when one fails.
+	HiveAPIURLs []string
+	// NodeMaxFailures is how many consecutive failed requests make a node be
+	// skipped for NodeCooldown, while requests are spread round-robin across the
+	// others. Zero never skips a node.
+	NodeMaxFailures int
+	NodeCooldown    time.Duration
+
+	GenesisBlock int64
 	BatchSize    int
 	DBPath       string
 	MaxRetries   int
 	RetryDelay   time.Duration
+
+	// Chain names the chain the database is filled from, such as "hive" or
+	// "steem". It is recorded in the meta table together with GenesisBlock on
+	// first use, and a sync refuses a database recorded for another chain or
+	// genesis block unless ForceChain is set.
+	Chain      string
+	ForceChain bool
+
+	// FetchFailureDelay is the wait before a batch that failed to fetch after
+	// MaxRetries attempts is requested again, doubled after every further
+	// consecutive failure up to five minutes. Zero requests it again right
+	// away.
+	FetchFailureDelay time.Duration
+	// MaxFetchFailures stops a sync with an error once this many batches in a
+	// row failed to fetch. Zero keeps retrying.
+	MaxFetchFailures int
+
+	// LogFormat is the format of the log written to stderr: LogFormatText for
+	// key=value lines or LogFormatJSON for one JSON object per line
+	LogFormat string
+	// LogLevel is the lowest level logged: "debug" adds a message for every
+	// operation and skipped comment, "info" logs progress, and "warn" and
+	// "error" only log problems
+	LogLevel string
+
+	// InitialBatchSize is the size of the first batch, doubled after every
+	// successful fetch until it reaches BatchSize. Zero starts at BatchSize.
+	InitialBatchSize int
+
+	// PrefetchBlocks is the number of blocks fetched and decoded ahead of the
+	// processor in the background. Batches are shrunk as needed so no more than
+	// this many blocks are held at once. Zero fetches each batch only after the
+	// previous one has been processed.
+	PrefetchBlocks int
+	// PrefetchWorkers is the number of batches fetched concurrently while
+	// prefetching. Batches are still processed in ascending order.
+	PrefetchWorkers int
+	// MaxBufferedRows pauses prefetching while the prefetched blocks contain this
+	// many posts that have not been stored yet. Zero only limits PrefetchBlocks.
+	MaxBufferedRows int
+
+	// BeyondHeadBlocks lets batches request up to this many blocks past the head
+	// block known when the run started, since the head keeps advancing while
+	// earlier batches are processed. Blocks the node has not produced yet are
+	// neither processed nor recorded as failed, and the cursor only advances to
+	// the highest block actually returned.
+	BeyondHeadBlocks int
+
+	// TraceBlock makes the sync command fetch this block, print how each of its
+	// operations would be handled and exit without writing to the database. Zero
+	// runs a normal sync.
+	TraceBlock int64
+
+	// SkipBelowBlock ignores the operations of blocks below it. Unlike a later
+	// GenesisBlock, those blocks are still fetched and the cursor advances through
+	// them as usual, so progress is still measured from the genesis. Zero
+	// disables it.
+	SkipBelowBlock int64
+
+	// Reverse processes blocks from the head back towards GenesisBlock instead of
+	// forwards from the last processed block
+	Reverse bool
+
+	// Follow keeps running after catching up to the head block, polling for new
+	// blocks every PollInterval
+	Follow       bool
+	PollInterval time.Duration
+
+	// MaxRunDuration stops a run at the first batch boundary after it has lasted
+	// this long, for scheduled runs of bounded length. Zero means unlimited.
+	MaxRunDuration time.Duration
+
+	// WSURL is a WebSocket endpoint (ws:// or wss://) of a node that pushes new
+	// blocks. When set, the indexer keeps running after catching up and processes
+	// blocks as they are pushed, polling over HTTP while the socket is down.
+	WSURL string
+	// HeartbeatInterval is how often a "caught up" message is logged while
+	// following and no new blocks arrive. Zero disables it.
+	HeartbeatInterval time.Duration
+
+	// SelfCheck verifies at startup that the node is reachable and supports the
+	// API methods in use, exiting with an error if it does not
+	SelfCheck bool
+
+	// MaxRestarts is how many times the database is reopened after a recoverable
+	// database error before giving up
+	MaxRestarts int
+
+	// SQLiteCacheSizeKB sets the page cache size of each database connection in KiB.
+	// Zero keeps SQLite's default.
+	SQLiteCacheSizeKB int
+	// SQLitePageSize sets the database page size in bytes. It must be a power of two
+	// between 512 and 65536, and only takes effect when the database file is first
+	// created; changing it on an existing database requires a VACUUM. Zero keeps
+	// SQLite's default.
+	SQLitePageSize int
+	// SQLiteJournalMode is the journal mode of the database, WAL by default so
+	// readers don't block the indexer's writes. Empty keeps the database's
+	// current mode.
+	SQLiteJournalMode string
+	// SQLiteSynchronous sets how often SQLite syncs to disk on each connection.
+	// NORMAL is safe in WAL mode and much faster than SQLite's default of FULL.
+	// Empty keeps SQLite's default.
+	SQLiteSynchronous string
+	// BusyRetries is how many times a write that fails because the database is
+	// busy or locked is retried after BusyRetryDelay plus up to as much jitter,
+	// before the regular MaxRetries backoff applies
+	BusyRetries    int
+	BusyRetryDelay time.Duration
+
+	// MigrationLockTimeout is how long the lock serializing schema migrations
+	// between instances may be held before a waiting instance assumes its holder
+	// crashed and takes it over
+	MigrationLockTimeout time.Duration
+
+	// OTLPEndpoint is the OTLP/HTTP collector (e.g. "http://localhost:4318") that
+	// batch traces are exported to. Tracing is disabled when empty.
+	OTLPEndpoint string
+
+	// HTTPTimeout bounds every request to the API node, including reading the
+	// response, so a hung node can't stall the indexer. Zero means no timeout.
+	HTTPTimeout time.Duration
+	// IdleConnTimeout is how long an idle keep-alive connection to the API node is
+	// kept open. It should comfortably exceed the time spent processing a batch so
+	// the connection survives until the next fetch.
+	IdleConnTimeout time.Duration
+	// MaxIdleConnsPerHost is how many idle connections are kept per API node. The
+	// net/http default of 2 is too low once requests are made concurrently.
+	MaxIdleConnsPerHost int
+
+	// HeadCacheTTL is how long a fetched head block number is reused before the
+	// node is queried again. Zero disables caching.
+	HeadCacheTTL time.Duration
+
+	// ProcessComments enables the built-in handler that stores top-level posts
+	ProcessComments bool
+	// IndexReplies stores the comments replying to posts and other comments in
+	// the replies table, which records what each reply is a reply to
+	IndexReplies bool
+	// StrictTopLevel only treats a comment as a top-level post when its parent
+	// permlink is a well-formed category, skipping spam that leaves the parent
+	// author empty but fills the parent permlink with something else
+	StrictTopLevel bool
+	// ProcessCustomJSON stores custom_json operations (follows, reblogs, game
+	// actions and the like) in the custom_json table. They make up much of the
+	// chain's activity, so this grows the database quickly.
+	ProcessCustomJSON bool
+	// CustomJSONIDs restricts the stored custom_json operations to those with one
+	// of these ids, such as "follow" for follows and reblogs. Empty stores all.
+	CustomJSONIDs []string
+	// ProcessTransfers stores transfer operations in the transfers table
+	ProcessTransfers bool
+	// ProcessVotes registers the built-in handler storing vote operations in the
+	// votes table. Votes are the most frequent operation on the chain, so turning
+	// it off keeps the database much smaller.
+	ProcessVotes bool
+	// StoreBeneficiaries stores the beneficiaries set by comment_options
+	// operations in the post_beneficiaries table, one row per account
+	StoreBeneficiaries bool
+	// ProcessDeletes registers the built-in handler that removes deleted posts
+	ProcessDeletes bool
+	// BatchRequests fetches blocks with a JSON-RPC batch of get_block calls instead
+	// of get_block_range, so individual failed blocks don't fail the whole batch
+	BatchRequests bool
+
+	// NormalizePermlinks lowercases the permlink of every operation before it is
+	// handled, so case variants of a permlink in malformed operation data map to
+	// the same post
+	NormalizePermlinks bool
+
+	// MultiRowInsert queues the posts of a batch and stores them with a few
+	// multi-row INSERT statements once the batch is processed, instead of one
+	// statement per post
+	MultiRowInsert bool
+
+	// BatchTransaction writes each batch in a single transaction that is committed
+	// once the batch has been processed and rolled back when it fails, which is
+	// much faster than committing every statement and leaves no partial batches.
+	// Exec hooks and secondary outputs still see posts as they are inserted.
+	BatchTransaction bool
+	// PostSavepoints stores each post of a batch transaction in a savepoint, so
+	// a post that fails to store is rolled back on its own while the rest of its
+	// block and batch is still stored. Posts written by a multi-row insert are
+	// not covered. It needs BatchTransaction.
+	PostSavepoints bool
+
+	// CollapseDuplicateOps handles only the first comment operation for each
+	// author/permlink within a block
+	CollapseDuplicateOps bool
+	// UpdateOnConflict stores edits of a post, a later comment operation with the
+	// same author and permlink, by replacing the title, tags, raw metadata and
+	// block number of the stored post. Without it the first version is kept. It
+	// is pointless with CollapseDuplicateOps, which drops edits made in the same
+	// block.
+	UpdateOnConflict bool
+	// SkipUnchangedEdits only applies an edit with UpdateOnConflict when it
+	// changes the title, tags or metadata of the stored post, compared by their
+	// content hash, so republishing a post unchanged writes nothing
+	SkipUnchangedEdits bool
+
+	// TitleContains restricts the stored posts to those whose title contains at
+	// least one of the keywords, ignoring case. Empty stores every post.
+	TitleContains []string
+
+	// MaxTagLength is the longest tag stored; longer tags, like tags containing
+	// anything but lowercase letters, digits and dashes, are dropped. Zero
+	// disables the length limit.
+	MaxTagLength int
+	// MaxTags is the most tags stored per post; the tags after the first MaxTags
+	// valid ones are dropped. Zero stores every tag.
+	MaxTags int
+
+	// StoreWitness records the producer of each post's block in the witness column
+	StoreWitness bool
+	// StoreReputation looks up each post author's current reputation and stores it
+	// in the author_reputation column. The value is a snapshot taken at indexing
+	// time and costs an extra API request per new author.
+	StoreReputation bool
+	// ReputationConcurrency limits the number of reputation requests in flight
+	ReputationConcurrency int
+	// StoreThumbnail records the first image URL from each post's metadata in the
+	// thumbnail column
+	StoreThumbnail bool
+	// StoreWordCount records a rough word count of each post's markdown body in
+	// the word_count column
+	StoreWordCount bool
+	// StoreBlockSeq records the position of each post's operation within its block
+	// in the block_seq column, so posts sharing a block and timestamp can be sorted
+	// in on-chain order
+	StoreBlockSeq bool
+	// StoreTransactionID records the id of the transaction holding each post's
+	// operation in the transaction_id column, for looking posts up on block
+	// explorers
+	StoreTransactionID bool
+	// StoreSlug records a URL-friendly form of each post's title in the slug
+	// column (see slugify). Posts whose slug is the same as their permlink, as
+	// for most posts whose permlink a frontend derived from the title, get no
+	// slug, so routing falls back to the permlink.
+	StoreSlug bool
+	// StoreRawMetadata keeps each post's raw JSON metadata in the json_metadata
+	// column, so more fields can be extracted later without re-syncing. It is on by
+	// default; turning it off saves space when only the extracted columns are used.
+	StoreRawMetadata bool
+	// CompressRawMetadata gzips the stored raw metadata into a BLOB, which is
+	// decompressed again when it is read
+	CompressRawMetadata bool
+	// CompactTags stores tags as references into the tag_dict table (linked through
+	// post_tag) instead of a JSON string in the tags column
+	CompactTags bool
+
+	// PartitionByMonth stores posts in monthly tables named posts_YYYYMM after
+	// their timestamp, created on demand, instead of the posts table. The
+	// posts_all view combines them for queries. A post's url stays unique across
+	// all partitions, so an edit made in a later month is handled like one in the
+	// same month (see UpdateOnConflict). It cannot be combined with CompactTags.
+	PartitionByMonth bool
+
+	// JSONLOutput is a file every new post is appended to as a line of JSON, in
+	// addition to being stored in the database. Disabled when empty.
+	JSONLOutput string
+	// SocketPath is a Unix domain socket local processes can connect to in
+	// order to receive every new post as a line of JSON. Disabled when empty.
+	SocketPath string
+	// SocketBuffer is how many posts are queued for the socket while no consumer
+	// is connected or the consumers fall behind; later posts are dropped
+	SocketBuffer int
+
+	// S3Region is the region of the bucket that s3:// export outputs are
+	// uploaded to
+	S3Region string
+	// S3Endpoint replaces the AWS endpoint for S3-compatible stores such as MinIO.
+	// Empty uses the regional AWS endpoint.
+	S3Endpoint string
+
+	// ExecHook is a shell command run for every newly inserted post, receiving the
+	// post as JSON on stdin. Disabled when empty.
+	ExecHook string
+	// ExecHookConcurrency is the maximum number of hook commands running at once
+	ExecHookConcurrency int
+	// ExecHookRate is the maximum number of hook commands started per second.
+	// Zero means unlimited.
+	ExecHookRate int
+	// ConfirmationDepth holds back exec hook deliveries until the post's block
+	// has this many blocks on top of it, and drops them if the block is replaced
+	// in the meantime. Zero delivers right away.
+	ConfirmationDepth int
+	// ExecHookFatal stops processing when a hook command exits with an error
+	// instead of only logging it
+	ExecHookFatal bool
+
+	// InconsistentBlocks decides what happens to a fetched block whose number,
+	// derived from its block id, lies outside the requested range or does not
+	// increase over the previous block: InconsistentBlocksSkip records it in
+	// failed_blocks instead of processing it, InconsistentBlocksWarn only logs it
+	InconsistentBlocks string
+	// SkipProcessedBlocks skips the blocks of a fetched batch at or below the
+	// last processed block instead of processing them again, for batches that
+	// overlap the checkpoint
+	SkipProcessedBlocks bool
+	// ValidateBlockCount checks that a block range returned by the node starts at
+	// the requested block and has no gaps, and fetches it again otherwise.
+	// Blocks missing from the end are only allowed once the range reaches the
+	// head block, since the node has no blocks beyond it.
+	ValidateBlockCount bool
+
+	// OnParserUpgrade decides what happens at startup to posts stored by an older
+	// parser version: ParserUpgradeWarn logs how many there are,
+	// ParserUpgradeQueue also adds them to the reprocess_queue table
+	OnParserUpgrade string
+
+	// RecordProcessedRanges records the block range handled by every batch in the
+	// processed_ranges table, for the audit command
+	RecordProcessedRanges bool
+
+	// CheckpointFile, when set, is the path of a JSON file mirroring the
+	// checkpoint after every batch, so other processes can follow progress
+	// without opening the database. The database stays authoritative; the file
+	// is never read back.
+	CheckpointFile string
+
+	// RecordPostLatency measures the time from fetching a block to storing each of
+	// its posts and reports the distribution at the end of the run
+	RecordPostLatency bool
+
+	// StrictTimestamps skips blocks whose timestamp is earlier than a previously
+	// processed block and records them in failed_blocks, instead of only logging
+	// the regression
+	StrictTimestamps bool
+
+	// ValidateMetadata runs a dry pass that classifies the metadata of every post
+	// and reports the tallies. No operation is stored and no checkpoint, failed
+	// block or processed range is recorded, so a later run still processes the
+	// validated blocks. The database is still opened, and so created or migrated,
+	// like for any run, since the pass starts from its checkpoint.
+	ValidateMetadata bool
 }
 
+// Values of Config.LogFormat
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// Values of Config.InconsistentBlocks
+const (
+	InconsistentBlocksSkip = "skip"
+	InconsistentBlocksWarn = "warn"
+)
+
+// Values of Config.OnParserUpgrade
+const (
+	ParserUpgradeWarn  = "warn"
+	ParserUpgradeQueue = "queue"
+)
+
 // DefaultConfig returns the default configuration
 func DefaultConfig() *Config {
 	return &Config{
-		HiveAPIURL:   "https://api.hive.blog",
+		HiveAPIURLs:     []string{"https://api.hive.blog"},
+		NodeMaxFailures: 3,
+		NodeCooldown:    time.Second * 30,
+
 		GenesisBlock: 41818753,
 		BatchSize:    1000,
 		DBPath:       "blocks.db",
 		MaxRetries:   3,
 		RetryDelay:   time.Second * 2,
+
+		Chain:      "hive",
+		ForceChain: false,
+
+		FetchFailureDelay: time.Second * 5,
+		MaxFetchFailures:  0,
+
+		LogFormat: LogFormatText,
+		LogLevel:  "info",
+
+		InitialBatchSize: 100,
+		PrefetchBlocks:   0,
+		PrefetchWorkers:  1,
+		MaxBufferedRows:  0,
+		BeyondHeadBlocks: 0,
+
+		TraceBlock: 0,
+
+		SkipBelowBlock: 0,
+
+		Reverse: false,
+
+		Follow:       false,
+		PollInterval: time.Second * 3,
+
+		MaxRunDuration: 0,
+
+		WSURL:             "",
+		HeartbeatInterval: time.Minute,
+
+		SelfCheck: false,
+
+		MaxRestarts: 5,
+
+		SQLiteCacheSizeKB: 0,
+		SQLitePageSize:    0,
+		SQLiteJournalMode: "WAL",
+		SQLiteSynchronous: "NORMAL",
+		BusyRetries:       5,
+		BusyRetryDelay:    time.Millisecond * 50,
+
+		MigrationLockTimeout: time.Minute * 5,
+
+		OTLPEndpoint: "",
+
+		HTTPTimeout:         time.Second * 30,
+		IdleConnTimeout:     time.Second * 90,
+		MaxIdleConnsPerHost: 8,
+
+		HeadCacheTTL: time.Second * 3,
+
+		ProcessComments:      true,
+		IndexReplies:         false,
+		StrictTopLevel:       false,
+		ProcessCustomJSON:    false,
+		CustomJSONIDs:        nil,
+		ProcessTransfers:     false,
+		ProcessVotes:         true,
+		StoreBeneficiaries:   false,
+		ProcessDeletes:       true,
+		BatchRequests:        false,
+		CollapseDuplicateOps: false,
+		UpdateOnConflict:     false,
+		SkipUnchangedEdits:   false,
+		MultiRowInsert:       false,
+		BatchTransaction:     false,
+		PostSavepoints:       false,
+		NormalizePermlinks:   false,
+
+		TitleContains: nil,
+
+		MaxTagLength: 24,
+		MaxTags:      10,
+
+		StoreWitness:        false,
+		StoreThumbnail:      false,
+		StoreWordCount:      false,
+		StoreBlockSeq:       false,
+		StoreTransactionID:  false,
+		StoreSlug:           false,
+		StoreRawMetadata:    true,
+		CompressRawMetadata: false,
+		CompactTags:         false,
+
+		PartitionByMonth: false,
+
+		StoreReputation:       false,
+		ReputationConcurrency: 4,
+
+		JSONLOutput:  "",
+		SocketPath:   "",
+		SocketBuffer: 1000,
+
+		S3Region:   "us-east-1",
+		S3Endpoint: "",
+
+		ExecHook:            "",
+		ExecHookConcurrency: 4,
+		ExecHookRate:        0,
+		ConfirmationDepth:   0,
+		ExecHookFatal:       false,
+
+		InconsistentBlocks:  InconsistentBlocksSkip,
+		SkipProcessedBlocks: false,
+		ValidateBlockCount:  true,
+
+		OnParserUpgrade: ParserUpgradeWarn,
+
+		RecordProcessedRanges: false,
+		CheckpointFile:        "",
+		RecordPostLatency:     false,
+
+		StrictTimestamps: false,
+
+		ValidateMetadata: false,
 	}
 }
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// CorpusStats are statistics over the stored posts, as printed by the stats
+// command
+type CorpusStats struct {
+	Posts int64 `json:"posts"`
+	// TagsPerPost is the number of posts by their number of tags: the first entry
+	// counts the posts without tags, the second those with one tag, and so on up
+	// to the highest tag count stored
+	TagsPerPost []int64 `json:"tags_per_post"`
+}
+
+// runStats implements the "stats" command, which prints the CorpusStats of the
+// stored posts as JSON
+func runStats(config *Config, args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	fs.Parse(args)
+
+	db, err := openCurrentDB(config.DBPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tagsPerPost, err := getTagsPerPost(db)
+	if err != nil {
+		return err
+	}
+	stats := CorpusStats{TagsPerPost: tagsPerPost}
+	for _, n := range tagsPerPost {
+		stats.Posts += n
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(stats)
+}
+
+// getTagsPerPost returns the number of stored posts by their tag_count, indexed
+// by the tag count. Counts without posts are included as zeros.
+func getTagsPerPost(db *sql.DB) ([]int64, error) {
+	rows, err := db.Query(`
+		SELECT tag_count, COUNT(*) FROM ` + postsView + `
+		WHERE tag_count IS NOT NULL
+		GROUP BY tag_count
+		ORDER BY tag_count
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying tag counts: %v", err)
+	}
+	defer rows.Close()
+
+	var histogram []int64
+	for rows.Next() {
+		var tagCount int
+		var posts int64
+		if err := rows.Scan(&tagCount, &posts); err != nil {
+			return nil, fmt.Errorf("error reading tag counts: %v", err)
+		}
+		if tagCount < 0 {
+			continue
+		}
+		for len(histogram) <= tagCount {
+			histogram = append(histogram, 0)
+		}
+		histogram[tagCount] = posts
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading tag counts: %v", err)
+	}
+	return histogram, nil
+}
+
+// writeTagsPerPostMetric writes the tags per post as a histogram in the
+// Prometheus text format, with a bucket for every tag count
+func writeTagsPerPostMetric(w io.Writer, tagsPerPost []int64) {
+	fmt.Fprintf(w, "# HELP post_stuffer_tags_per_post Number of tags of the stored posts.\n")
+	fmt.Fprintf(w, "# TYPE post_stuffer_tags_per_post histogram\n")
+	var cumulative, sum int64
+	for tagCount, posts := range tagsPerPost {
+		cumulative += posts
+		sum += int64(tagCount) * posts
+		fmt.Fprintf(w, "post_stuffer_tags_per_post_bucket{le=\"%d\"} %d\n", tagCount, cumulative)
+	}
+	fmt.Fprintf(w, "post_stuffer_tags_per_post_bucket{le=\"+Inf\"} %d\n", cumulative)
+	fmt.Fprintf(w, "post_stuffer_tags_per_post_sum %d\n", sum)
+	fmt.Fprintf(w, "post_stuffer_tags_per_post_count %d\n", cumulative)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTagsPerPost(t *testing.T) {
+	db, bp := newTestProcessor(t, nil)
+	withTags := func(permlink, metadata string) Operation {
+		op := testPost("alice", permlink, permlink)
+		op.Value.JsonMetadata = metadata
+		return op
+	}
+	if _, err := bp.processBlock(context.Background(), testBlock(100,
+		withTags("none", `{}`),
+		withTags("one", `{"tags":["hive"]}`),
+		withTags("two", `{"tags":["hive","art"]}`),
+		withTags("also-two", `{"tags":["hive","photo"]}`),
+		withTags("four", `{"tags":["hive","art","photo","travel"]}`),
+	)); err != nil {
+		t.Fatal(err)
+	}
+
+	tagsPerPost, err := getTagsPerPost(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Tag counts without posts are kept as empty buckets
+	if want := []int64{1, 1, 2, 0, 1}; !reflect.DeepEqual(tagsPerPost, want) {
+		t.Errorf("getTagsPerPost() = %v, want %v", tagsPerPost, want)
+	}
+
+	rec := httptest.NewRecorder()
+	handleMetrics(db)(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /metrics = %d: %s", rec.Code, rec.Body.String())
+	}
+	want := "# TYPE post_stuffer_tags_per_post histogram\n" +
+		"post_stuffer_tags_per_post_bucket{le=\"0\"} 1\n" +
+		"post_stuffer_tags_per_post_bucket{le=\"1\"} 2\n" +
+		"post_stuffer_tags_per_post_bucket{le=\"2\"} 4\n" +
+		"post_stuffer_tags_per_post_bucket{le=\"3\"} 4\n" +
+		"post_stuffer_tags_per_post_bucket{le=\"4\"} 5\n" +
+		"post_stuffer_tags_per_post_bucket{le=\"+Inf\"} 5\n" +
+		"post_stuffer_tags_per_post_sum 9\n" +
+		"post_stuffer_tags_per_post_count 5\n"
+	if !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("GET /metrics:\n%s\nwant it to contain:\n%s", rec.Body.String(), want)
+	}
+}
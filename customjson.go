@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// customJSONTableSQL creates the custom_json table, which holds custom_json
+// operations when ProcessCustomJSON is enabled. An operation is identified by
+// its position in the chain, so processing a block again doesn't store its
+// operations twice.
+const customJSONTableSQL = `
+	CREATE TABLE IF NOT EXISTS custom_json (
+		_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		block_num INTEGER,
+		tx_index INTEGER,
+		op_index INTEGER,
+		timestamp TEXT,
+		id TEXT,
+		json TEXT,
+		required_auths TEXT,
+		required_posting_auths TEXT,
+		UNIQUE (block_num, tx_index, op_index)
+	);
+	CREATE INDEX IF NOT EXISTS idx_custom_json_id ON custom_json(id);
+`
+
+// insertCustomJSONSQL inserts a custom_json operation
+const insertCustomJSONSQL = `
+	INSERT INTO custom_json (block_num, tx_index, op_index, timestamp, id, json, required_auths, required_posting_auths)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(block_num, tx_index, op_index) DO NOTHING
+`
+
+// handleCustomJSON stores a "custom_json_operation" in the custom_json table,
+// unless CustomJSONIDs is set and does not list its id. The auths are stored as
+// JSON arrays.
+func (bp *BlockProcessor) handleCustomJSON(ctx *OpContext, value OperationValue) (int, error) {
+	if bp.customJSONIDs != nil && !bp.customJSONIDs[string(value.Id)] {
+		return 0, nil
+	}
+
+	requiredAuths, err := json.Marshal(nonNil(value.RequiredAuths))
+	if err != nil {
+		return 0, err
+	}
+	postingAuths, err := json.Marshal(nonNil(value.RequiredPostingAuths))
+	if err != nil {
+		return 0, err
+	}
+	timestamp, _ := normalizeTimestamp(ctx.Timestamp)
+
+	var inserted int64
+	err = bp.retryDB(func() error {
+		result, err := bp.txStmt(bp.customJSONStmt).Exec(ctx.BlockNum, ctx.TxIndex, ctx.OpIndex, timestamp,
+			string(value.Id), value.Json, string(requiredAuths), string(postingAuths))
+		if err != nil {
+			return err
+		}
+		inserted, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error inserting custom_json operation: %w", err)
+	}
+	return int(inserted), nil
+}
+
+// nonNil returns an empty slice for a nil one, so it is encoded as [] rather
+// than null
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
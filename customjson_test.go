@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestProcessCustomJSON(t *testing.T) {
+	// As sent by a node
+	var ops []Operation
+	if err := json.Unmarshal([]byte(`[
+		{"type": "custom_json_operation", "value": {"id": "follow", "json": "[\"reblog\",{\"account\":\"bob\",\"author\":\"alice\",\"permlink\":\"post\"}]", "required_auths": [], "required_posting_auths": ["bob"]}},
+		{"type": "custom_json_operation", "value": {"id": "sm_battle", "json": "{\"match\":1}", "required_auths": ["carol"], "required_posting_auths": []}},
+		{"type": "comment_operation", "value": {"author": "alice", "permlink": "post", "title": "Post", "parent_permlink": "hive", "json_metadata": "{}"}}
+	]`), &ops); err != nil {
+		t.Fatal(err)
+	}
+
+	follow := "follow|[\"reblog\",{\"account\":\"bob\",\"author\":\"alice\",\"permlink\":\"post\"}]|[]|[\"bob\"]|0"
+	battle := "sm_battle|{\"match\":1}|[\"carol\"]|[]|1"
+	tests := []struct {
+		name      string
+		configure func(*Config)
+		want      []string
+	}{
+		{"disabled", nil, []string{}},
+		{"enabled", func(c *Config) { c.ProcessCustomJSON = true }, []string{follow, battle}},
+		{"restricted ids", func(c *Config) { c.ProcessCustomJSON = true; c.CustomJSONIDs = []string{"follow"} }, []string{follow}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, bp := newTestProcessor(t, tt.configure)
+			for i := 0; i < 2; i++ {
+				// Processing the block again stores nothing twice
+				if _, err := bp.processBlock(context.Background(), testBlock(100, ops...)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			rows, err := db.Query(`
+				SELECT id || '|' || json || '|' || required_auths || '|' || required_posting_auths || '|' || op_index
+				FROM custom_json ORDER BY op_index
+			`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+			got := []string{}
+			for rows.Next() {
+				var row string
+				if err := rows.Scan(&row); err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, row)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("custom_json holds %q, want %q", got, tt.want)
+			}
+
+			var posts int
+			if err := db.QueryRow("SELECT COUNT(*) FROM posts").Scan(&posts); err != nil {
+				t.Fatal(err)
+			}
+			if posts != 1 {
+				t.Errorf("stored %d posts, want the post next to the custom_json operations", posts)
+			}
+		})
+	}
+}
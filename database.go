@@ -1,14 +1,30 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
-// initDB initializes the SQLite database and creates the "posts" table if it doesn't
-// exist. The table has the following columns:
+// schemaVersion is the version of the database schema created and migrated to by
+// initDB. It is stored in the database's user_version and must be incremented
+// whenever the schema changes.
+const schemaVersion = 20
+
+// initDB opens the SQLite database at config.DBPath and creates the "posts" table
+// if it doesn't exist. The table has the following columns:
 //
 //   - _id: an autoincrementing unique identifier
 //   - url: a unique string identifier for the post
@@ -17,19 +33,296 @@ import (
 //   - title: the title of the post
 //   - tags: the tags of the post
 //   - block_num: the block number that the post was published in
-//   - timestamp: the timestamp of the post
+//   - timestamp: the timestamp of the post, in RFC 3339 format in UTC
+//   - timestamp_epoch: the timestamp of the post as Unix time
+//   - witness: the witness that produced the block (only populated when enabled)
+//   - app: the app that published the post, as "name" or "name/version"
+//   - author_reputation: the author's raw reputation when the post was indexed
+//     (only populated when enabled)
+//   - thumbnail: the first image URL from the post's metadata (only populated
+//     when enabled)
+//   - json_metadata: the post's raw JSON metadata, gzipped into a BLOB when
+//     compressed (only populated when enabled)
+//   - tag_count: the number of tags stored for the post
+//   - parser_version: the parserVersion of the code that stored the post
+//   - word_count: a rough word count of the post's body (only populated when
+//     enabled)
+//   - block_seq: the position of the post's operation within its block (only
+//     populated when enabled)
+//   - link: the path of the post on Hive frontends, "/category/@author/permlink"
+//   - transaction_id: the id of the transaction holding the post's operation
+//     (only populated when enabled)
+//   - slug: a URL-friendly form of the title, when it differs from the permlink
+//     (only populated when enabled)
+//
+// Additionally, the function creates indexes on the block_num, author and
+// tag_count fields. Columns added after the table was first created are migrated
+// onto existing databases and their monthly partitions, after which the schema
+// version is recorded. The "posts_all" view combines posts with its partitions;
+// see PartitionByMonth.
+//
+// A "failed_blocks" table is also created to record blocks that could not be
+// fetched, so they can be retried later, along with the "tag_dict" and "post_tag"
+// tables used to store tags compactly when CompactTags is enabled, the
+// "sync_state" table holding named checkpoints, the "processed_ranges" table
+// recording the block ranges processed when RecordProcessedRanges is enabled, the
+// "meta" table recording the chain the database is filled from, the
+// "reprocess_queue" table listing posts stored by an outdated parser, the
+// "replies" table holding replies when IndexReplies is enabled, and the
+// "custom_json" and "transfers" tables holding custom_json and transfer
+// operations when ProcessCustomJSON and ProcessTransfers are enabled, the "votes"
+// table when ProcessVotes is enabled, and the "post_beneficiaries" table when
+// StoreBeneficiaries is enabled.
 //
-// Additionally, the function creates two indexes on the table, one on the block_num
-// field and one on the author field.
-func initDB() (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", "blocks.db")
+// Builds with -tags sqlite_fts5 also create the "posts_fts" search index over
+// post titles, kept up to date by triggers on posts; see migrateSearchIndex. Once
+// a database has the index, it can only be written by such a build.
+//
+// The SQLite tuning options from the configuration are applied when the database is
+// opened. SQLitePageSize only takes effect on a fresh database, so it is set on the
+// same connection that creates the tables, before anything is written.
+func initDB(config *Config) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", sqliteDSN(config.DBPath, config))
+	if err != nil {
+		return nil, fmt.Errorf("error opening database: %v", err)
+	}
+
+	// Use a single connection so the page size is in effect when the tables are created
+	conn, err := db.Conn(context.Background())
 	if err != nil {
+		db.Close()
 		return nil, fmt.Errorf("error opening database: %v", err)
 	}
+	defer conn.Close()
+
+	if config.SQLitePageSize > 0 {
+		if _, err := conn.ExecContext(context.Background(), fmt.Sprintf("PRAGMA page_size = %d", config.SQLitePageSize)); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("error setting page size: %v", err)
+		}
+	}
+
+	// The journal mode is stored in the database file, so setting it once applies
+	// to every connection. SQLite answers with the mode in effect, which differs
+	// from the requested one when it can't be used, e.g. WAL on a network share.
+	if config.SQLiteJournalMode != "" {
+		var mode string
+		if err := conn.QueryRowContext(context.Background(), "PRAGMA journal_mode = "+config.SQLiteJournalMode).Scan(&mode); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("error setting journal mode: %v", err)
+		}
+		if !strings.EqualFold(mode, config.SQLiteJournalMode) {
+			db.Close()
+			return nil, fmt.Errorf("error setting journal mode: requested %s, database uses %s", config.SQLiteJournalMode, mode)
+		}
+	}
+
+	// Only one instance at a time creates and migrates the tables
+	release, err := acquireMigrationLock(context.Background(), conn, config.MigrationLockTimeout)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	defer release()
 
 	// Create the posts table if it doesn't exist
 	createTableSQL := `
-	CREATE TABLE IF NOT EXISTS posts (
+	CREATE TABLE IF NOT EXISTS posts (` + postsTableColumns + `);
+	CREATE INDEX IF NOT EXISTS idx_block_num ON posts(block_num);
+	CREATE INDEX IF NOT EXISTS idx_author ON posts(author);
+	CREATE TABLE IF NOT EXISTS failed_blocks (
+		block_num INTEGER PRIMARY KEY,
+		error TEXT,
+		failed_at TEXT
+	);
+	CREATE TABLE IF NOT EXISTS tag_dict (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		tag TEXT UNIQUE
+	);
+	CREATE TABLE IF NOT EXISTS post_tag (
+		post_id INTEGER,
+		tag_id INTEGER,
+		position INTEGER,
+		PRIMARY KEY (post_id, tag_id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_post_tag_tag ON post_tag(tag_id);
+	CREATE TABLE IF NOT EXISTS sync_state (
+		key TEXT PRIMARY KEY,
+		value INTEGER
+	);
+	CREATE TABLE IF NOT EXISTS processed_ranges (
+		start_block INTEGER,
+		end_block INTEGER,
+		processed_at TEXT
+	);
+	CREATE INDEX IF NOT EXISTS idx_processed_ranges_start ON processed_ranges(start_block);
+	CREATE TABLE IF NOT EXISTS meta (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		chain TEXT,
+		genesis INTEGER
+	);
+	CREATE TABLE IF NOT EXISTS reprocess_queue (
+		url TEXT PRIMARY KEY,
+		block_num INTEGER,
+		parser_version INTEGER,
+		queued_at TEXT
+	);
+	` + repliesTableSQL + customJSONTableSQL + transfersTableSQL + votesTableSQL + beneficiariesTableSQL
+
+	if _, err := conn.ExecContext(context.Background(), createTableSQL); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating table: %v", err)
+	}
+
+	tables, err := postTables(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	for _, table := range tables {
+		for _, col := range addedPostColumns {
+			if err := ensureColumn(db, table, col.name, col.definition); err != nil {
+				db.Close()
+				return nil, err
+			}
+		}
+	}
+	if err := refreshPostsView(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	previous, err := getSchemaVersion(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if previous < 5 {
+		if err := backfillTagCounts(db); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_tag_count ON posts(tag_count)"); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating tag_count index: %v", err)
+	}
+	if previous < 20 {
+		if err := dropSearchChangeLog(db); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	if err := migrateSearchIndex(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if _, err := db.Exec(fmt.Sprintf("PRAGMA user_version = %d", schemaVersion)); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error setting schema version: %v", err)
+	}
+
+	return db, nil
+}
+
+// dropSearchChangeLog removes the log of edited and deleted posts, and the
+// triggers filling it, that the server used to maintain the search index with
+// before the indexer kept it up to date itself
+func dropSearchChangeLog(db *sql.DB) error {
+	_, err := db.Exec(`
+		DROP TRIGGER IF EXISTS posts_fts_update;
+		DROP TRIGGER IF EXISTS posts_fts_delete;
+		DROP TABLE IF EXISTS posts_fts_changes;
+		DELETE FROM sync_state WHERE key = 'search_indexed_id';
+	`)
+	if err != nil {
+		return fmt.Errorf("error removing search change log: %v", err)
+	}
+	return nil
+}
+
+// searchIndexInstalled reports whether the triggers maintaining the search index
+// of an FTS5 build exist
+func searchIndexInstalled(db *sql.DB) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name = 'posts_fts_insert'").Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("error reading search index: %v", err)
+	}
+	return count > 0, nil
+}
+
+// openReadOnlyDB opens an existing database without write access, so inspecting
+// a database never creates or migrates it
+func openReadOnlyDB(path string) (*sql.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("error opening database: %v", err)
+	}
+	dsn := &url.URL{Scheme: "file", Opaque: url.PathEscape(path), RawQuery: "mode=ro"}
+	db, err := sql.Open("sqlite3", dsn.String())
+	if err != nil {
+		return nil, fmt.Errorf("error opening database: %v", err)
+	}
+	return db, nil
+}
+
+// openCurrentDB opens an existing database read-only, like openReadOnlyDB, for
+// the commands that only read it. Since it is not migrated, a database older than
+// schemaVersion is refused rather than queried for columns it may lack; a sync
+// migrates it.
+func openCurrentDB(path string) (*sql.DB, error) {
+	db, err := openReadOnlyDB(path)
+	if err != nil {
+		return nil, err
+	}
+	version, err := getSchemaVersion(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if version < schemaVersion {
+		db.Close()
+		return nil, fmt.Errorf("database %s has schema version %d, older than %d; run a sync to migrate it",
+			path, version, schemaVersion)
+	}
+	return db, nil
+}
+
+// getSchemaVersion returns the schema version recorded in the database
+func getSchemaVersion(db *sql.DB) (int, error) {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("error reading schema version: %v", err)
+	}
+	return version, nil
+}
+
+// sqliteDSN builds the data source name used to open the SQLite database at path.
+//
+// SQLiteCacheSizeKB and SQLiteSynchronous are passed as connection parameters
+// rather than executed as a PRAGMA, because they are per-connection settings and
+// the driver applies connection parameters to every connection in the pool.
+func sqliteDSN(path string, config *Config) string {
+	params := url.Values{}
+	if config.SQLiteCacheSizeKB > 0 {
+		// A negative cache_size is interpreted by SQLite as a size in KiB
+		params.Set("_cache_size", strconv.Itoa(-config.SQLiteCacheSizeKB))
+	}
+	if config.SQLiteSynchronous != "" {
+		params.Set("_synchronous", config.SQLiteSynchronous)
+	}
+
+	if len(params) == 0 {
+		return path
+	}
+	dsn := &url.URL{Scheme: "file", Opaque: url.PathEscape(path), RawQuery: params.Encode()}
+	return dsn.String()
+}
+
+// postsTableColumns are the column definitions of the posts table, shared with
+// its monthly partitions
+const postsTableColumns = `
 		_id INTEGER PRIMARY KEY AUTOINCREMENT,
 		url TEXT UNIQUE,
 		author TEXT,
@@ -37,18 +330,310 @@ func initDB() (*sql.DB, error) {
 		title TEXT,
 		tags TEXT,
 		block_num INTEGER,
-		timestamp TEXT
-	);
-	CREATE INDEX IF NOT EXISTS idx_block_num ON posts(block_num);
-	CREATE INDEX IF NOT EXISTS idx_author ON posts(author);
+		timestamp TEXT,
+		timestamp_epoch INTEGER,
+		witness TEXT,
+		app TEXT,
+		author_reputation INTEGER,
+		thumbnail TEXT,
+		json_metadata TEXT,
+		tag_count INTEGER,
+		parser_version INTEGER,
+		word_count INTEGER,
+		block_seq INTEGER,
+		link TEXT,
+		transaction_id TEXT,
+		slug TEXT,
+		content_hash TEXT
 	`
 
-	if _, err := db.Exec(createTableSQL); err != nil {
-		db.Close()
-		return nil, fmt.Errorf("error creating table: %v", err)
+// addedPostColumns lists the columns added to the posts table after it was first
+// released, which are migrated onto existing databases by initDB
+var addedPostColumns = []struct {
+	name       string
+	definition string
+}{
+	{"witness", "TEXT"},
+	{"app", "TEXT"},
+	{"author_reputation", "INTEGER"},
+	{"timestamp_epoch", "INTEGER"},
+	{"thumbnail", "TEXT"},
+	{"json_metadata", "TEXT"},
+	{"tag_count", "INTEGER"},
+	{"parser_version", "INTEGER"},
+	{"word_count", "INTEGER"},
+	{"block_seq", "INTEGER"},
+	{"link", "TEXT"},
+	{"transaction_id", "TEXT"},
+	{"slug", "TEXT"},
+	{"content_hash", "TEXT"},
+}
+
+// backfillTagCounts fills in tag_count for posts stored before the column was
+// added, counting either the JSON tags or, for compact tags, the post_tag links
+func backfillTagCounts(db *sql.DB) error {
+	_, err := db.Exec(`
+		UPDATE posts SET tag_count = CASE
+			WHEN tags IS NOT NULL THEN json_array_length(tags)
+			ELSE (SELECT COUNT(*) FROM post_tag WHERE post_tag.post_id = posts._id)
+		END
+		WHERE tag_count IS NULL
+	`)
+	if err != nil {
+		return fmt.Errorf("error backfilling tag counts: %v", err)
 	}
+	return nil
+}
 
-	return db, nil
+// ensureColumn adds a column to an existing table if it is not already present.
+//
+// This lets databases created by older versions pick up new columns, since
+// CREATE TABLE IF NOT EXISTS leaves an existing table untouched.
+func ensureColumn(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return fmt.Errorf("error reading columns of %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return fmt.Errorf("error reading columns of %s: %v", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading columns of %s: %v", table, err)
+	}
+	rows.Close()
+
+	if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("error adding column %s to %s: %v", column, table, err)
+	}
+	return nil
+}
+
+// recordFailedBlocks stores blocks that could not be fetched in the failed_blocks
+// table. A block that fails again has its error and failure time updated.
+func recordFailedBlocks(db *sql.DB, failed []BlockFetchError) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, f := range failed {
+		_, err := db.Exec(`
+			INSERT INTO failed_blocks (block_num, error, failed_at)
+			VALUES (?, ?, ?)
+			ON CONFLICT(block_num) DO UPDATE SET error = excluded.error, failed_at = excluded.failed_at
+		`, f.BlockNum, f.Message, now)
+		if err != nil {
+			return fmt.Errorf("error recording failed block %d: %w", f.BlockNum, err)
+		}
+	}
+	return nil
+}
+
+// recordProcessedRange records that the blocks from start to end inclusive have
+// been processed
+func recordProcessedRange(db *sql.DB, start, end int64) error {
+	_, err := db.Exec(`INSERT INTO processed_ranges (start_block, end_block, processed_at) VALUES (?, ?, ?)`,
+		start, end, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("error recording processed range %d-%d: %w", start, end, err)
+	}
+	return nil
+}
+
+// getRawMetadata returns the raw JSON metadata stored for the post with the given
+// url, decompressing it if it was stored with CompressRawMetadata. The boolean is
+// false when the post does not exist or its metadata was not stored.
+func getRawMetadata(db *sql.DB, url string) (string, bool, error) {
+	var kind string
+	var raw []byte
+	err := db.QueryRow("SELECT typeof(json_metadata), json_metadata FROM "+postsView+" WHERE url = ?", url).Scan(&kind, &raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("error reading metadata of %s: %w", url, err)
+	}
+
+	switch kind {
+	case "null":
+		return "", false, nil
+	case "blob":
+		metadata, err := decompressMetadata(raw)
+		if err != nil {
+			return "", false, fmt.Errorf("error decompressing metadata of %s: %w", url, err)
+		}
+		return metadata, true, nil
+	default:
+		return string(raw), true, nil
+	}
+}
+
+// compressMetadata gzips raw JSON metadata for storage as a BLOB. Plain metadata
+// is stored as TEXT, so the storage class tells the two apart on read.
+func compressMetadata(metadata string) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(metadata)); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressMetadata reverses compressMetadata
+func decompressMetadata(compressed []byte) (string, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+
+	metadata, err := io.ReadAll(zr)
+	if err != nil {
+		return "", err
+	}
+	return string(metadata), nil
+}
+
+// lookupOrCreateTag returns the dictionary ID of tag, adding it to the tag_dict
+// table if it isn't present yet
+func lookupOrCreateTag(db sqlExecer, tag string) (int64, error) {
+	if _, err := db.Exec(`INSERT INTO tag_dict (tag) VALUES (?) ON CONFLICT(tag) DO NOTHING`, tag); err != nil {
+		return 0, fmt.Errorf("error adding tag %q: %w", tag, err)
+	}
+
+	var id int64
+	if err := db.QueryRow(`SELECT id FROM tag_dict WHERE tag = ?`, tag).Scan(&id); err != nil {
+		return 0, fmt.Errorf("error looking up tag %q: %w", tag, err)
+	}
+	return id, nil
+}
+
+// sqlExecer is implemented by both *sql.DB and *sql.Tx, so the helpers taking it
+// work inside and outside of a transaction
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// getPostTags reassembles the tags of a post stored in compact mode, in the order
+// they appeared in the post's metadata
+func getPostTags(db *sql.DB, postID int64) ([]string, error) {
+	rows, err := db.Query(`
+		SELECT tag_dict.tag
+		FROM post_tag
+		JOIN tag_dict ON tag_dict.id = post_tag.tag_id
+		WHERE post_tag.post_id = ?
+		ORDER BY post_tag.position
+	`, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []string{}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// isRecoverableDBError reports whether err is a database error that may go away
+// after reopening the database, such as a closed connection, a locked database or
+// an I/O error.
+func isRecoverableDBError(err error) bool {
+	if errors.Is(err, sql.ErrConnDone) {
+		return true
+	}
+
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code {
+		case sqlite3.ErrBusy, sqlite3.ErrLocked, sqlite3.ErrIoErr, sqlite3.ErrCantOpen, sqlite3.ErrProtocol:
+			return true
+		}
+	}
+	return false
+}
+
+// isBusyError reports whether err is SQLite reporting that the database is busy
+// or a table is locked by another connection
+func isBusyError(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+	return false
+}
+
+// retryOnBusy runs operation, retrying it up to maxRetries times while it fails
+// with a busy or locked error. Lock contention usually clears within milliseconds,
+// so the delay is short and jittered to keep competing writers from retrying in
+// lockstep. Any other error is returned right away.
+func retryOnBusy(maxRetries int, delay time.Duration, operation func() error) error {
+	err := operation()
+	for i := 0; i < maxRetries && isBusyError(err); i++ {
+		wait := delay
+		if delay > 0 {
+			wait += rand.N(delay)
+		}
+		time.Sleep(wait)
+		err = operation()
+	}
+	return err
+}
+
+// Keys of the checkpoints stored in the sync_state table
+const (
+	// syncStateReverseLow is the lowest block fully processed by a reverse run
+	syncStateReverseLow = "reverse_low"
+	// syncStateReverseHigh is the head block a reverse run started from
+	syncStateReverseHigh = "reverse_high"
+	// syncStateForwardBlock is the last block handled by a forward run
+	syncStateForwardBlock = "forward_block"
+)
+
+// getSyncState returns the checkpoint stored under key, or 0 if it isn't set
+func getSyncState(db sqlExecer, key string) (int64, error) {
+	var value int64
+	err := db.QueryRow("SELECT value FROM sync_state WHERE key = ?", key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return value, nil
+}
+
+// setSyncState stores a checkpoint under key, replacing any previous value
+func setSyncState(db sqlExecer, key string, value int64) error {
+	_, err := db.Exec(`
+		INSERT INTO sync_state (key, value) VALUES (?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value
+	`, key, value)
+	if err != nil {
+		return fmt.Errorf("error saving %s: %w", key, err)
+	}
+	return nil
 }
 
 // getLastProcessedBlock retrieves the last processed block number from the database.
@@ -64,16 +649,46 @@ func initDB() (*sql.DB, error) {
 //
 //	the last processed block number
 //	an error if there is an issue with the database query
-func getLastProcessedBlock(db *sql.DB, genesisBlock int) (int, error) {
-	var blockNum sql.NullInt64
-	err := db.QueryRow("SELECT MAX(block_num) FROM posts").Scan(&blockNum)
+func getLastProcessedBlock(db *sql.DB, genesisBlock int64) (int64, error) {
+	tables, err := postTables(db)
 	if err != nil {
 		return 0, err
 	}
 
-	if !blockNum.Valid {
+	// Each table is asked separately so the block_num index is used
+	var last sql.NullInt64
+	for _, table := range tables {
+		var blockNum sql.NullInt64
+		if err := db.QueryRow("SELECT MAX(block_num) FROM " + table).Scan(&blockNum); err != nil {
+			return 0, err
+		}
+		if blockNum.Valid && (!last.Valid || blockNum.Int64 > last.Int64) {
+			last = blockNum
+		}
+	}
+
+	if !last.Valid {
 		return genesisBlock, nil
 	}
 
-	return int(blockNum.Int64), nil
+	return last.Int64, nil
+}
+
+// getForwardCheckpoint returns the last block handled by a forward run. This is
+// the forward checkpoint in sync_state, which also covers the blocks of runs that
+// store no posts, or the highest stored block_num if that is higher, as it is in a
+// database filled before the checkpoint was recorded.
+func getForwardCheckpoint(db *sql.DB, genesisBlock int64) (int64, error) {
+	last, err := getLastProcessedBlock(db, genesisBlock)
+	if err != nil {
+		return 0, err
+	}
+	checkpoint, err := getSyncState(db, syncStateForwardBlock)
+	if err != nil {
+		return 0, err
+	}
+	if checkpoint > last {
+		last = checkpoint
+	}
+	return last, nil
 }
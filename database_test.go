@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func TestInitDBPath(t *testing.T) {
+	// Run from an empty directory, so a database created at the default path
+	// instead of the configured one would show up there
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	path := filepath.Join(t.TempDir(), "custom.db")
+	config := DefaultConfig()
+	config.DBPath = path
+	db, err := initDB(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("database not created at its configured path: %v", err)
+	}
+	if entries, err := os.ReadDir("."); err != nil || len(entries) > 0 {
+		t.Errorf("working directory holds %v, %v, want it left empty", entries, err)
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM posts").Scan(&count); err != nil {
+		t.Errorf("database at %s was not migrated: %v", path, err)
+	}
+}
+
+func TestOpenReadOnlyDBPath(t *testing.T) {
+	// Characters that have a meaning in a file: URI
+	path := filepath.Join(t.TempDir(), "posts ?mode=rw#1%20.db")
+	config := DefaultConfig()
+	config.DBPath = path
+	config.SQLiteCacheSizeKB = 1024
+	db, err := initDB(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("database not created at its configured path: %v", err)
+	}
+	readOnly, err := openCurrentDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readOnly.Close()
+	var count int
+	if err := readOnly.QueryRow("SELECT COUNT(*) FROM posts").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readOnly.Exec("DELETE FROM posts"); err == nil {
+		t.Error("database opened with openReadOnlyDB accepted a write")
+	}
+}
+
+func TestSQLiteTuning(t *testing.T) {
+	config := DefaultConfig()
+	config.DBPath = filepath.Join(t.TempDir(), "blocks.db")
+	config.SQLitePageSize = 8192
+	config.SQLiteCacheSizeKB = 4096
+	config.SQLiteSynchronous = "FULL"
+	db, err := initDB(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	pragmas := []struct {
+		pragma string
+		want   int
+	}{
+		{"page_size", 8192},
+		{"cache_size", -4096},
+		{"synchronous", 2},
+	}
+	for _, p := range pragmas {
+		var got int
+		if err := db.QueryRow("PRAGMA " + p.pragma).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != p.want {
+			t.Errorf("PRAGMA %s = %d, want %d", p.pragma, got, p.want)
+		}
+	}
+}
+
+func TestJournalMode(t *testing.T) {
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{DefaultConfig().SQLiteJournalMode, "wal"},
+		{"DELETE", "delete"},
+		{"TRUNCATE", "truncate"},
+	}
+	for _, tt := range tests {
+		config := DefaultConfig()
+		config.DBPath = filepath.Join(t.TempDir(), "blocks.db")
+		config.SQLiteJournalMode = tt.mode
+		db, err := initDB(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The journal mode is kept in the database, so every connection uses it
+		var mode string
+		var synchronous int
+		err = db.QueryRow("PRAGMA journal_mode").Scan(&mode)
+		if err == nil {
+			err = db.QueryRow("PRAGMA synchronous").Scan(&synchronous)
+		}
+		db.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode != tt.want {
+			t.Errorf("SQLiteJournalMode %s: PRAGMA journal_mode = %s, want %s", tt.mode, mode, tt.want)
+		}
+		// NORMAL by default
+		if synchronous != 1 {
+			t.Errorf("SQLiteJournalMode %s: PRAGMA synchronous = %d, want 1", tt.mode, synchronous)
+		}
+	}
+
+}
+
+func TestRetryOnBusy(t *testing.T) {
+	busy := fmt.Errorf("error storing post: %w", sqlite3.Error{Code: sqlite3.ErrBusy})
+	locked := sqlite3.Error{Code: sqlite3.ErrLocked}
+	other := errors.New("constraint failed")
+
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   error
+	}{
+		{"busy then success", []error{busy, locked}, 3, nil},
+		{"still busy after every retry", []error{busy, busy, busy, busy, busy}, 4, busy},
+		{"other errors are not retried", []error{other}, 1, other},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := retryOnBusy(3, time.Millisecond, func() error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+			if err != tt.wantErr {
+				t.Errorf("retryOnBusy() = %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("operation ran %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetryDBBusy(t *testing.T) {
+	// The regular retries are exhausted by a single failure, so only the busy
+	// retries can let the write succeed
+	_, bp := newTestProcessor(t, func(c *Config) {
+		c.MaxRetries = 1
+		c.BusyRetries = 5
+		c.BusyRetryDelay = time.Millisecond
+	})
+	calls := 0
+	err := bp.retryDB(func() error {
+		calls++
+		if calls <= 4 {
+			return sqlite3.Error{Code: sqlite3.ErrBusy}
+		}
+		return nil
+	})
+	if err != nil || calls != 5 {
+		t.Errorf("retryDB() = %v after %d calls, want success after 5", err, calls)
+	}
+}
+
+func TestStoreRawMetadata(t *testing.T) {
+	// Stored byte for byte, including the whitespace and escapes a parse would lose
+	const metadata = `{"tags": ["hive", "caf\u00e9"],  "app":"peakd/2024.1.1", "image":["https://images.hive.blog/a.png"]}`
+	op := testPost("alice", "raw", "Raw")
+	op.Value.JsonMetadata = metadata
+
+	tests := []struct {
+		name      string
+		configure func(*Config)
+		want      sql.NullString
+	}{
+		{"default", nil, sql.NullString{String: metadata, Valid: true}},
+		{"multi-row insert", func(c *Config) { c.MultiRowInsert = true }, sql.NullString{String: metadata, Valid: true}},
+		{"disabled", func(c *Config) { c.StoreRawMetadata = false }, sql.NullString{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, bp := newTestProcessor(t, tt.configure)
+			if err := bp.Begin(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := bp.processBlock(context.Background(), testBlock(100, op)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := bp.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if err := bp.Commit(); err != nil {
+				t.Fatal(err)
+			}
+
+			var got sql.NullString
+			if err := db.QueryRow("SELECT json_metadata FROM posts WHERE url = '@alice/raw'").Scan(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("json_metadata = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompressRawMetadata(t *testing.T) {
+	db, bp := newTestProcessor(t, func(c *Config) { c.CompressRawMetadata = true })
+	body := strings.Repeat("A long paragraph of the post repeated in its metadata. ", 2000)
+	metadata := fmt.Sprintf(`{"tags":["hive","test"],"app":"peakd/2024.1.1","description":%q}`, body)
+	op := testPost("alice", "large", "Large")
+	op.Value.JsonMetadata = metadata
+	if _, err := bp.processBlock(context.Background(), testBlock(100, op)); err != nil {
+		t.Fatal(err)
+	}
+
+	var kind string
+	var size int
+	if err := db.QueryRow("SELECT typeof(json_metadata), length(json_metadata) FROM posts WHERE url = '@alice/large'").Scan(&kind, &size); err != nil {
+		t.Fatal(err)
+	}
+	if kind != "blob" || size >= len(metadata)/10 {
+		t.Errorf("stored metadata as a %s of %d bytes, want a compressed blob of the %d bytes", kind, size, len(metadata))
+	}
+
+	got, ok, err := getRawMetadata(db, "@alice/large")
+	if err != nil || !ok {
+		t.Fatalf("getRawMetadata() = %v, %v", ok, err)
+	}
+	if got != metadata {
+		t.Errorf("getRawMetadata() returned %d bytes that differ from the %d bytes stored", len(got), len(metadata))
+	}
+}
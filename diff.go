@@ -0,0 +1,186 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+)
+
+// DiffSummary counts the differences found between two databases
+type DiffSummary struct {
+	// OnlyHere are posts present only in the database being compared
+	OnlyHere int
+	// OnlyOther are posts present only in the other database
+	OnlyOther int
+	// Changed are posts present in both whose title or tags differ
+	Changed int
+}
+
+// runDiff implements the "diff" command, which compares the posts of the
+// configured database against another database, typically an older index of the
+// same range. One tab-separated line is printed per difference: "-" and the url
+// for a post only in the configured database, "+" and the url for a post only in
+// the other database, and "~", the url and the differing fields for a post whose
+// title or tags changed.
+func runDiff(config *Config, args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	other := fs.String("other", "", "path of the database to compare against")
+	fs.Parse(args)
+
+	if *other == "" {
+		return errors.New("diff requires -other")
+	}
+
+	db, err := openReadOnlyDB(config.DBPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	otherDB, err := openReadOnlyDB(*other)
+	if err != nil {
+		return err
+	}
+	defer otherDB.Close()
+
+	summary, err := diffPosts(db, otherDB, os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Diff complete", "db", config.DBPath, "only_here", summary.OnlyHere,
+		"other_db", *other, "only_other", summary.OnlyOther, "changed", summary.Changed)
+	return nil
+}
+
+// diffPosts compares the posts of two databases by url and writes the
+// differences to w in the format described on runDiff
+//
+// Both tables are read in url order and merged, so only the current row of each
+// database is held in memory regardless of their size.
+func diffPosts(db, other *sql.DB, w io.Writer) (DiffSummary, error) {
+	var summary DiffSummary
+
+	here, err := newDiffCursor(db)
+	if err != nil {
+		return summary, err
+	}
+	defer here.Close()
+
+	there, err := newDiffCursor(other)
+	if err != nil {
+		return summary, err
+	}
+	defer there.Close()
+
+	if err := here.Next(); err != nil {
+		return summary, err
+	}
+	if err := there.Next(); err != nil {
+		return summary, err
+	}
+
+	for !here.done || !there.done {
+		switch {
+		case there.done || (!here.done && here.post.URL < there.post.URL):
+			summary.OnlyHere++
+			if _, err := fmt.Fprintf(w, "-\t%s\n", here.post.URL); err != nil {
+				return summary, err
+			}
+			err = here.Next()
+		case here.done || there.post.URL < here.post.URL:
+			summary.OnlyOther++
+			if _, err := fmt.Fprintf(w, "+\t%s\n", there.post.URL); err != nil {
+				return summary, err
+			}
+			err = there.Next()
+		default:
+			if fields := changedFields(here.post, there.post); len(fields) > 0 {
+				summary.Changed++
+				if _, err := fmt.Fprintf(w, "~\t%s\t%s\n", here.post.URL, strings.Join(fields, ",")); err != nil {
+					return summary, err
+				}
+			}
+			if err = here.Next(); err == nil {
+				err = there.Next()
+			}
+		}
+		if err != nil {
+			return summary, err
+		}
+	}
+	return summary, nil
+}
+
+// changedFields returns the names of the compared fields that differ between two
+// versions of a post
+func changedFields(a, b ExportedPost) []string {
+	var fields []string
+	if a.Title != b.Title {
+		fields = append(fields, "title")
+	}
+	if !slices.Equal(a.Tags, b.Tags) {
+		fields = append(fields, "tags")
+	}
+	return fields
+}
+
+// diffCursor iterates over the posts of a database in url order
+type diffCursor struct {
+	db   *sql.DB
+	rows *sql.Rows
+	post ExportedPost
+	done bool
+}
+
+// newDiffCursor starts reading the posts of db
+func newDiffCursor(db *sql.DB) (*diffCursor, error) {
+	rows, err := db.Query("SELECT _id, url, title, tags FROM posts ORDER BY url")
+	if err != nil {
+		return nil, fmt.Errorf("error querying posts: %v", err)
+	}
+	return &diffCursor{db: db, rows: rows}, nil
+}
+
+// Next advances to the next post, setting done once the posts are exhausted.
+// Tags stored in compact mode are reassembled from the tag dictionary, so
+// databases indexed with and without CompactTags compare equal.
+func (c *diffCursor) Next() error {
+	if !c.rows.Next() {
+		c.done = true
+		return c.rows.Err()
+	}
+
+	var (
+		id   int64
+		tags sql.NullString
+	)
+	c.post = ExportedPost{}
+	if err := c.rows.Scan(&id, &c.post.URL, &c.post.Title, &tags); err != nil {
+		return fmt.Errorf("error reading post: %v", err)
+	}
+
+	if tags.Valid {
+		c.post.Tags = tagList(tags.String)
+	} else {
+		var err error
+		if c.post.Tags, err = getPostTags(c.db, id); err != nil {
+			return fmt.Errorf("error reading tags of %s: %v", c.post.URL, err)
+		}
+	}
+	if c.post.Tags == nil {
+		c.post.Tags = []string{}
+	}
+	return nil
+}
+
+// Close releases the underlying query
+func (c *diffCursor) Close() error {
+	return c.rows.Close()
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestDiffPosts(t *testing.T) {
+	withTags := func(op Operation, metadata string) Operation {
+		op.Value.JsonMetadata = metadata
+		return op
+	}
+
+	// The databases were indexed with and without compact tags, which must not
+	// count as a difference
+	db, bp := newTestProcessor(t, func(c *Config) { c.CompactTags = true })
+	if _, err := bp.processBlock(context.Background(), testBlock(100,
+		testPost("alice", "same", "Same"),
+		testPost("bob", "retitled", "New title"),
+		withTags(testPost("carol", "retagged", "Retagged"), `{"tags":["hive","art"]}`),
+		testPost("dave", "only-here", "Only here"),
+	)); err != nil {
+		t.Fatal(err)
+	}
+	other, otherBP := newTestProcessor(t, nil)
+	if _, err := otherBP.processBlock(context.Background(), testBlock(100,
+		testPost("alice", "same", "Same"),
+		testPost("bob", "retitled", "Old title"),
+		testPost("carol", "retagged", "Retagged"),
+		testPost("erin", "only-other", "Only other"),
+	)); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	summary, err := diffPosts(db, other, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "~\t@bob/retitled\ttitle\n" +
+		"~\t@carol/retagged\ttags\n" +
+		"-\t@dave/only-here\n" +
+		"+\t@erin/only-other\n"
+	if out.String() != want {
+		t.Errorf("diff output:\n%s\nwant:\n%s", out.String(), want)
+	}
+	if wantSummary := (DiffSummary{OnlyHere: 1, OnlyOther: 1, Changed: 2}); summary != wantSummary {
+		t.Errorf("diffPosts() = %+v, want %+v", summary, wantSummary)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// updatePostSQL returns the statement applying an edit to a post already stored
+// in table. Only a version from the same or a later block replaces the stored
+// one, so a reverse run doesn't overwrite an edit with the original post. With
+// onlyChanges, an edit whose content hash matches the stored one isn't applied.
+func updatePostSQL(table string, onlyChanges bool) string {
+	query := "UPDATE " + table + " SET title = ?, tags = ?, json_metadata = ?, block_num = ?, tag_count = ?, slug = ?," +
+		" content_hash = ? WHERE url = ? AND block_num <= ?"
+	if onlyChanges {
+		query += " AND content_hash IS NOT ?"
+	}
+	return query + " RETURNING _id"
+}
+
+// contentHash returns the hex SHA-256 of the title, filtered tags and raw JSON
+// metadata of a post, which tells whether an edit changed any of them. Posts
+// stored before the hash was recorded have none, so their first edit is always
+// applied.
+func contentHash(title, tagsJson, metadata string) string {
+	h := sha256.New()
+	for _, field := range []string{title, tagsJson, metadata} {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// updatePost stores an edit of a post whose insert conflicted with the version
+// already in table, when UpdateOnConflict is enabled. The title, tags, raw
+// metadata, block number, slug and content hash of the stored post are replaced,
+// along with its compact tags. With SkipUnchangedEdits, an edit that changes none
+// of the title, tags and metadata is not written at all. Exec hooks and outputs
+// only see new posts, so they don't run for an edit.
+func (bp *BlockProcessor) updatePost(table string, row *postRow) error {
+	var (
+		postID  int64
+		updated bool
+	)
+	err := bp.retryDB(func() error {
+		args := []interface{}{row.title, row.tags, row.metadata, row.blockNum, row.tagCount, row.slug,
+			row.contentHash, row.url, row.blockNum}
+		if bp.config.SkipUnchangedEdits {
+			args = append(args, row.contentHash)
+		}
+		result, err := bp.execer().Query(updatePostSQL(table, bp.config.SkipUnchangedEdits), args...)
+		if err != nil {
+			return err
+		}
+		defer result.Close()
+
+		updated = result.Next()
+		if updated {
+			if err := result.Scan(&postID); err != nil {
+				return err
+			}
+		}
+		return result.Err()
+	})
+	if err != nil {
+		return fmt.Errorf("error updating post: %w", err)
+	}
+	// A version from a later block is already stored, or the edit changed nothing
+	if !updated {
+		return nil
+	}
+	bp.edited++
+
+	if bp.config.CompactTags {
+		if _, err := bp.execer().Exec("DELETE FROM post_tag WHERE post_id = ?", postID); err != nil {
+			return fmt.Errorf("error updating tags of %s: %v", row.url, err)
+		}
+		if err := bp.storeCompactTags(postID, tagList(row.tagsJson)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyEdit copies the fields updatePost replaces from an edit into a post that
+// is still queued for a multi-row insert, so the queued post is stored as edited
+func applyEdit(queued, edit *postRow) {
+	queued.title = edit.title
+	queued.tagsJson = edit.tagsJson
+	queued.tags = edit.tags
+	queued.metadata = edit.metadata
+	queued.blockNum = edit.blockNum
+	queued.tagCount = edit.tagCount
+	queued.slug = edit.slug
+	queued.contentHash = edit.contentHash
+}
+
+// Edited returns the number of stored posts replaced by a later edit with
+// UpdateOnConflict
+func (bp *BlockProcessor) Edited() int {
+	return bp.edited
+}
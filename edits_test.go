@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSkipUnchangedEdits(t *testing.T) {
+	edition := func(title, metadata string) Operation {
+		op := testPost("alice", "first", title)
+		op.Value.JsonMetadata = metadata
+		return op
+	}
+	const metadata = `{"tags":["hive","test"]}`
+
+	tests := []struct {
+		name          string
+		skipUnchanged bool
+		edit          Operation
+		wantEdited    int
+		wantTitle     string
+		wantBlock     int64
+	}{
+		{"identical edition", true, edition("First", metadata), 0, "First", 100},
+		{"changed title", true, edition("Renamed", metadata), 1, "Renamed", 101},
+		{"changed tags", true, edition("First", `{"tags":["hive","other"]}`), 1, "First", 101},
+		{"changed metadata", true, edition("First", `{"tags":["hive","test"],"app":"peakd/1.0"}`), 1, "First", 101},
+		{"identical edition without the option", false, edition("First", metadata), 1, "First", 101},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, bp := newTestProcessor(t, func(c *Config) {
+				c.UpdateOnConflict = true
+				c.SkipUnchangedEdits = tt.skipUnchanged
+			})
+
+			if _, err := bp.processBlock(context.Background(), testBlock(100, edition("First", metadata))); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := bp.processBlock(context.Background(), testBlock(101, tt.edit)); err != nil {
+				t.Fatal(err)
+			}
+
+			if edited := bp.Edited(); edited != tt.wantEdited {
+				t.Errorf("Edited() = %d, want %d", edited, tt.wantEdited)
+			}
+			var (
+				title    string
+				blockNum int64
+			)
+			if err := db.QueryRow("SELECT title, block_num FROM posts WHERE url = '@alice/first'").Scan(&title, &blockNum); err != nil {
+				t.Fatal(err)
+			}
+			if title != tt.wantTitle || blockNum != tt.wantBlock {
+				t.Errorf("stored %q from block %d, want %q from block %d", title, blockNum, tt.wantTitle, tt.wantBlock)
+			}
+		})
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	base := contentHash("title", `["a"]`, `{"tags":["a"]}`)
+	tests := []struct {
+		name                      string
+		title, tagsJson, metadata string
+		wantSame                  bool
+	}{
+		{"same content", "title", `["a"]`, `{"tags":["a"]}`, true},
+		{"other title", "Title", `["a"]`, `{"tags":["a"]}`, false},
+		{"other tags", "title", `["b"]`, `{"tags":["a"]}`, false},
+		{"other metadata", "title", `["a"]`, `{"tags":["a"],"app":"x"}`, false},
+		{"fields not run together", "title[", `"a"]`, `{"tags":["a"]}`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if same := contentHash(tt.title, tt.tagsJson, tt.metadata) == base; same != tt.wantSame {
+				t.Errorf("same hash = %v, want %v", same, tt.wantSame)
+			}
+		})
+	}
+}
+
+func TestUpdateOnConflict(t *testing.T) {
+	edit := testPost("alice", "first", "Renamed")
+	edit.Value.JsonMetadata = `{"tags":["hive","edited"]}`
+
+	tests := []struct {
+		update       bool
+		wantTitle    string
+		wantTags     string
+		wantMetadata string
+		wantBlock    int64
+	}{
+		{false, "First", `["hive","test"]`, `{"tags":["hive","test"]}`, 100},
+		{true, "Renamed", `["hive","edited"]`, `{"tags":["hive","edited"]}`, 101},
+	}
+	for _, tt := range tests {
+		db, bp := newTestProcessor(t, func(c *Config) { c.UpdateOnConflict = tt.update })
+		for _, block := range []Block{testBlock(100, testPost("alice", "first", "First")), testBlock(101, edit)} {
+			if _, err := bp.processBlock(context.Background(), block); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var title, tags, metadata string
+		var blockNum, posts int64
+		if err := db.QueryRow("SELECT title, tags, json_metadata, block_num, (SELECT COUNT(*) FROM posts) FROM posts WHERE url = '@alice/first'").
+			Scan(&title, &tags, &metadata, &blockNum, &posts); err != nil {
+			t.Fatal(err)
+		}
+		if title != tt.wantTitle || tags != tt.wantTags || metadata != tt.wantMetadata || blockNum != tt.wantBlock {
+			t.Errorf("UpdateOnConflict %v: stored %q, %s, %s from block %d, want %q, %s, %s from block %d", tt.update,
+				title, tags, metadata, blockNum, tt.wantTitle, tt.wantTags, tt.wantMetadata, tt.wantBlock)
+		}
+		if posts != 1 {
+			t.Errorf("UpdateOnConflict %v: %d posts stored, want the edit to replace the post", tt.update, posts)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Error categories used in the end-of-run error report
+const (
+	errCategoryNetwork = "network"
+	errCategoryDecode  = "decode"
+	errCategoryDB      = "db"
+	errCategoryParse   = "parse"
+	errCategoryOther   = "other"
+)
+
+// errorCategories lists the categories in the order they are reported
+var errorCategories = []string{
+	errCategoryNetwork,
+	errCategoryDecode,
+	errCategoryDB,
+	errCategoryParse,
+	errCategoryOther,
+}
+
+// classifyError determines the category of an error by inspecting its wrapped
+// chain: network failures talking to the node, responses that could not be
+// decoded, database errors, and block data that could not be parsed.
+func classifyError(err error) string {
+	var netErr net.Error
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var sqliteErr sqlite3.Error
+	var numErr *strconv.NumError
+
+	switch {
+	case errors.As(err, &netErr):
+		return errCategoryNetwork
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr), errors.Is(err, io.ErrUnexpectedEOF):
+		return errCategoryDecode
+	case errors.As(err, &sqliteErr), errors.Is(err, sql.ErrConnDone), errors.Is(err, sql.ErrTxDone):
+		return errCategoryDB
+	case errors.As(err, &numErr), errors.Is(err, errTimestampRegression):
+		return errCategoryParse
+	default:
+		return errCategoryOther
+	}
+}
+
+// errorCounts tallies the errors encountered during a run by category
+type errorCounts map[string]int
+
+// record counts err under its category
+func (c errorCounts) record(err error) {
+	c[classifyError(err)]++
+}
+
+// total returns the number of errors recorded
+func (c errorCounts) total() int {
+	total := 0
+	for _, n := range c {
+		total += n
+	}
+	return total
+}
+
+// String formats the counts as a single report line, e.g.
+// "network: 3, decode: 0, db: 1, parse: 0, other: 0"
+func (c errorCounts) String() string {
+	parts := make([]string, 0, len(errorCategories))
+	for _, category := range errorCategories {
+		parts = append(parts, fmt.Sprintf("%s: %d", category, c[category]))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ExportedPost is a single post as written by the export command
+type ExportedPost struct {
+	URL       string   `json:"url"`
+	Link      string   `json:"link,omitempty"`
+	Author    string   `json:"author"`
+	Permlink  string   `json:"permlink"`
+	Title     string   `json:"title"`
+	Tags      []string `json:"tags"`
+	BlockNum  int64    `json:"block_num"`
+	Timestamp string   `json:"timestamp"`
+}
+
+// postWriter writes exported posts in a specific output format
+type postWriter interface {
+	Write(post ExportedPost) error
+	Flush() error
+}
+
+// runExport implements the "export" command, which writes the stored posts as
+// JSON lines or CSV to a file, stdout, or an S3 object given as s3://bucket/key.
+//
+// The -where flag restricts the export with a filter expression over the posts
+// columns; see parseFilter for the accepted syntax.
+//
+// With -resume, an export to a local file records its progress in a sidecar file
+// every exportCheckpointInterval posts, and a rerun with the same flags continues
+// after the last recorded post instead of starting over. The sidecar file is
+// removed once the export completes.
+func runExport(config *Config, args []string) (err error) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", "jsonl", "output format: jsonl or csv")
+	out := fs.String("out", "", "output file or s3://bucket/key (default stdout)")
+	where := fs.String("where", "", "filter expression, e.g. \"author = 'alice' AND block_num > 1000\"")
+	resume := fs.Bool("resume", false, "record progress next to the output file and continue an interrupted export")
+	fs.Parse(args)
+
+	_, _, isS3 := parseS3URL(*out)
+	if *resume && (*out == "" || isS3) {
+		return fmt.Errorf("-resume requires -out to be a local file")
+	}
+
+	// A resumed export must continue with the same settings it was started with
+	progressPath := exportProgressPath(*out)
+	var resumeFrom *exportProgress
+	if *resume {
+		resumeFrom, err = readExportProgress(progressPath)
+		if err != nil {
+			return err
+		}
+		if resumeFrom != nil && (resumeFrom.Format != *format || resumeFrom.Where != *where) {
+			return fmt.Errorf("the export to %s was started with different -format or -where flags", *out)
+		}
+	}
+
+	query := "SELECT _id, url, COALESCE(link, ''), author, permlink, title, tags, block_num, timestamp FROM " + postsView
+	var conditions []string
+	var queryArgs []interface{}
+	if *where != "" {
+		clause, filterArgs, err := parseFilter(*where)
+		if err != nil {
+			return fmt.Errorf("invalid -where filter: %v", err)
+		}
+		conditions = append(conditions, "("+clause+")")
+		queryArgs = filterArgs
+	}
+	// Partitions number their posts independently, so the url breaks ties
+	if resumeFrom != nil {
+		conditions = append(conditions, "(_id > ? OR (_id = ? AND url > ?))")
+		queryArgs = append(queryArgs, resumeFrom.LastID, resumeFrom.LastID, resumeFrom.LastURL)
+	}
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY _id, url"
+
+	var w io.WriteCloser
+	if *resume {
+		var offset int64
+		if resumeFrom != nil {
+			offset = resumeFrom.Offset
+		}
+		w, err = openResumableOutput(*out, offset)
+	} else {
+		var uploader Uploader
+		if isS3 {
+			s3, err := newS3Uploader(config)
+			if err != nil {
+				return err
+			}
+			uploader = s3
+		}
+		w, err = openExportOutput(*out, uploader)
+	}
+	if err != nil {
+		return err
+	}
+	// Closing an upload is what completes it, so a failed export aborts it instead
+	defer func() {
+		if a, ok := w.(interface{ Abort(error) }); ok && err != nil {
+			a.Abort(err)
+		}
+		w.Close()
+	}()
+
+	var writer postWriter
+	switch *format {
+	case "jsonl":
+		writer = newJSONLWriter(w)
+	case "csv":
+		writer = newCSVWriter(w)
+	default:
+		return fmt.Errorf("unknown export format %q", *format)
+	}
+	// The header was written before the export was interrupted
+	if cw, ok := writer.(*csvWriter); ok && resumeFrom != nil && resumeFrom.Offset > 0 {
+		cw.wroteHeader = true
+	}
+
+	var checkpoint func(lastID int64, lastURL string, count int) error
+	resumed := 0
+	if *resume {
+		progress := &exportProgress{Format: *format, Where: *where}
+		if resumeFrom != nil {
+			progress = resumeFrom
+			resumed = resumeFrom.Count
+		}
+		f := w.(*os.File)
+		checkpoint = func(lastID int64, lastURL string, count int) error {
+			if err := writer.Flush(); err != nil {
+				return fmt.Errorf("error writing export: %v", err)
+			}
+			offset, err := f.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return fmt.Errorf("error writing export: %v", err)
+			}
+			progress.LastID, progress.LastURL, progress.Offset = lastID, lastURL, offset
+			progress.Count = resumed + count
+			return writeExportProgress(progressPath, progress)
+		}
+	}
+
+	db, err := openCurrentDB(config.DBPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	count, err := exportPosts(db, config, query, queryArgs, writer, checkpoint)
+	if err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("error writing export: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("error writing export: %v", err)
+	}
+	if *resume {
+		if err := os.Remove(progressPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("error removing export progress: %v", err)
+		}
+	}
+
+	if resumed > 0 {
+		fmt.Fprintf(os.Stderr, "Exported %d posts after the %d exported before\n", count, resumed)
+	} else {
+		fmt.Fprintf(os.Stderr, "Exported %d posts\n", count)
+	}
+	return nil
+}
+
+// exportPosts runs the export query and writes every resulting post, returning the
+// number of posts written. When checkpoint is set, it is called with the last
+// post written and the count so far after every exportCheckpointInterval posts.
+func exportPosts(db *sql.DB, config *Config, query string, args []interface{}, writer postWriter, checkpoint func(lastID int64, lastURL string, count int) error) (int, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("error querying posts: %v", err)
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		var (
+			id   int64
+			post ExportedPost
+			tags sql.NullString
+		)
+		if err := rows.Scan(&id, &post.URL, &post.Link, &post.Author, &post.Permlink, &post.Title, &tags,
+			&post.BlockNum, &post.Timestamp); err != nil {
+			return count, fmt.Errorf("error reading post: %v", err)
+		}
+
+		if tags.Valid {
+			post.Tags = tagList(tags.String)
+		} else if config.CompactTags {
+			if post.Tags, err = getPostTags(db, id); err != nil {
+				return count, fmt.Errorf("error reading tags of %s: %v", post.URL, err)
+			}
+		}
+		if post.Tags == nil {
+			post.Tags = []string{}
+		}
+
+		if err := writer.Write(post); err != nil {
+			return count, fmt.Errorf("error writing export: %v", err)
+		}
+		count++
+
+		if checkpoint != nil && count%exportCheckpointInterval == 0 {
+			if err := checkpoint(id, post.URL, count); err != nil {
+				return count, err
+			}
+		}
+	}
+	return count, rows.Err()
+}
+
+// openExportOutput opens the destination of an export: stdout when out is empty,
+// an upload through uploader for an s3://bucket/key path, and a local file
+// otherwise
+func openExportOutput(out string, uploader Uploader) (io.WriteCloser, error) {
+	if out == "" {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+	if bucket, key, ok := parseS3URL(out); ok {
+		return newS3Writer(context.Background(), uploader, bucket, key), nil
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return nil, fmt.Errorf("error creating output file: %v", err)
+	}
+	return f, nil
+}
+
+// nopWriteCloser adds a no-op Close to a writer that must stay open, like stdout
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// jsonlWriter writes one JSON object per line
+type jsonlWriter struct {
+	buf *bufio.Writer
+	enc *json.Encoder
+}
+
+// newJSONLWriter creates a jsonlWriter that writes to w
+func newJSONLWriter(w io.Writer) *jsonlWriter {
+	buf := bufio.NewWriter(w)
+	return &jsonlWriter{buf: buf, enc: json.NewEncoder(buf)}
+}
+
+func (w *jsonlWriter) Write(post ExportedPost) error {
+	return w.enc.Encode(post)
+}
+
+func (w *jsonlWriter) Flush() error {
+	return w.buf.Flush()
+}
+
+// csvWriter writes posts as CSV with a header row. Tags are joined with spaces.
+type csvWriter struct {
+	csv         *csv.Writer
+	wroteHeader bool
+}
+
+// newCSVWriter creates a csvWriter that writes to w
+func newCSVWriter(w io.Writer) *csvWriter {
+	return &csvWriter{csv: csv.NewWriter(w)}
+}
+
+// writeHeader writes the header row once, before the first post
+func (w *csvWriter) writeHeader() error {
+	if w.wroteHeader {
+		return nil
+	}
+	w.wroteHeader = true
+	return w.csv.Write([]string{"url", "author", "permlink", "title", "tags", "block_num", "timestamp", "link"})
+}
+
+func (w *csvWriter) Write(post ExportedPost) error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	return w.csv.Write([]string{
+		post.URL,
+		post.Author,
+		post.Permlink,
+		post.Title,
+		strings.Join(post.Tags, " "),
+		strconv.FormatInt(post.BlockNum, 10),
+		post.Timestamp,
+		post.Link,
+	})
+}
+
+func (w *csvWriter) Flush() error {
+	// An empty export still gets a header row
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	w.csv.Flush()
+	return w.csv.Error()
+}
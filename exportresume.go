@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// exportCheckpointInterval is the number of posts written between two updates of
+// a resumable export's progress file
+const exportCheckpointInterval = 1000
+
+// exportProgress is the progress of a resumable export, kept in a sidecar file
+// next to the output. Everything up to Offset bytes of the output has been
+// written completely, ending with the post identified by LastID and LastURL.
+type exportProgress struct {
+	Format  string `json:"format"`
+	Where   string `json:"where"`
+	LastID  int64  `json:"last_id"`
+	LastURL string `json:"last_url"`
+	Offset  int64  `json:"offset"`
+	Count   int    `json:"count"`
+}
+
+// exportProgressPath returns the path of the progress file of an export to out
+func exportProgressPath(out string) string {
+	return out + ".progress"
+}
+
+// readExportProgress reads the progress file at path, returning nil if there is
+// none
+func readExportProgress(path string) (*exportProgress, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading export progress: %v", err)
+	}
+
+	var progress exportProgress
+	if err := json.Unmarshal(data, &progress); err != nil {
+		return nil, fmt.Errorf("error reading export progress %s: %v", path, err)
+	}
+	return &progress, nil
+}
+
+// writeExportProgress replaces the progress file at path. The file is written
+// under a temporary name and renamed, so an interruption never leaves a partial
+// progress file behind.
+func writeExportProgress(path string, progress *exportProgress) error {
+	data, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("error writing export progress: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("error writing export progress: %v", err)
+	}
+	return nil
+}
+
+// openResumableOutput opens the output file of a resumable export for writing at
+// offset, discarding anything after it. Posts written after the last checkpoint
+// of an interrupted export are dropped that way and written again, so resuming
+// never duplicates them.
+func openResumableOutput(out string, offset int64) (*os.File, error) {
+	f, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening output file: %v", err)
+	}
+	if err := f.Truncate(offset); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error truncating output file: %v", err)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error seeking output file: %v", err)
+	}
+	return f, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResumeExport(t *testing.T) {
+	db, bp := newTestProcessor(t, nil)
+	const posts = 2*exportCheckpointInterval + 500
+	for block := 0; block < posts/100; block++ {
+		var ops []Operation
+		for i := 0; i < 100; i++ {
+			ops = append(ops, testPost("alice", fmt.Sprintf("post-%d", block*100+i), "Post"))
+		}
+		if _, err := bp.processBlock(context.Background(), testBlock(int64(100+block), ops...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, format := range []string{"jsonl", "csv"} {
+		t.Run(format, func(t *testing.T) {
+			dir := t.TempDir()
+			complete := filepath.Join(dir, "complete."+format)
+			if err := runExport(bp.config, []string{"-format", format, "-out", complete}); err != nil {
+				t.Fatal(err)
+			}
+			want, err := os.ReadFile(complete)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The export was interrupted after its first checkpoint, having written
+			// more posts and half of a line since
+			out := filepath.Join(dir, "resumed."+format)
+			lines := bytes.SplitAfter(want, []byte("\n"))
+			checkpointed := exportCheckpointInterval
+			if format == "csv" {
+				checkpointed++ // the header
+			}
+			offset := len(bytes.Join(lines[:checkpointed], nil))
+			partial := bytes.Join(lines[:checkpointed+37], nil)
+			partial = append(partial, lines[checkpointed+37][:10]...)
+			if err := os.WriteFile(out, partial, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			var lastID int64
+			var lastURL string
+			if err := db.QueryRow("SELECT _id, url FROM "+postsView+" ORDER BY _id, url LIMIT 1 OFFSET ?",
+				exportCheckpointInterval-1).Scan(&lastID, &lastURL); err != nil {
+				t.Fatal(err)
+			}
+			if err := writeExportProgress(exportProgressPath(out), &exportProgress{
+				Format:  format,
+				LastID:  lastID,
+				LastURL: lastURL,
+				Offset:  int64(offset),
+				Count:   exportCheckpointInterval,
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			// Resuming with other settings than the export was started with fails
+			if err := runExport(bp.config, []string{"-format", format, "-out", out, "-resume", "-where", "author = 'bob'"}); err == nil {
+				t.Error("runExport() resumed an export with a different -where filter")
+			}
+
+			if err := runExport(bp.config, []string{"-format", format, "-out", out, "-resume"}); err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("resumed export holds %d lines, want the %d lines of a complete export",
+					bytes.Count(got, []byte("\n")), bytes.Count(want, []byte("\n")))
+			}
+			if _, err := os.Stat(exportProgressPath(out)); !os.IsNotExist(err) {
+				t.Errorf("progress file left behind after the export completed: %v", err)
+			}
+		})
+	}
+}
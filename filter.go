@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// filterColumns lists the posts columns that may be referenced in a filter
+// expression. Any other identifier is rejected.
+var filterColumns = map[string]bool{
+	"url":               true,
+	"author":            true,
+	"permlink":          true,
+	"title":             true,
+	"tags":              true,
+	"block_num":         true,
+	"timestamp":         true,
+	"timestamp_epoch":   true,
+	"witness":           true,
+	"app":               true,
+	"author_reputation": true,
+	"thumbnail":         true,
+	"tag_count":         true,
+	"parser_version":    true,
+	"word_count":        true,
+	"block_seq":         true,
+	"link":              true,
+	"transaction_id":    true,
+	"slug":              true,
+}
+
+// filterToken is a lexical token of a filter expression
+type filterToken struct {
+	kind  string // "ident", "string", "number", "op", "(", ")"
+	value string
+}
+
+// parseFilter parses a user supplied WHERE expression such as
+//
+//	author = 'alice' AND block_num > 1000
+//
+// and returns an equivalent SQL fragment in which every literal is replaced by a
+// placeholder, together with the placeholder arguments.
+//
+// Only the columns in filterColumns, the comparison operators =, !=, <>, <, <=, >,
+// >=, LIKE and IS [NOT] NULL, the connectives AND, OR and NOT, and parentheses are
+// accepted, so the resulting fragment is safe to append to a query.
+func parseFilter(expr string) (string, []interface{}, error) {
+	tokens, err := tokenizeFilter(expr)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(tokens) == 0 {
+		return "", nil, fmt.Errorf("empty filter")
+	}
+
+	p := &filterParser{tokens: tokens}
+	sql, err := p.parseOr()
+	if err != nil {
+		return "", nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return "", nil, fmt.Errorf("unexpected %q in filter", p.tokens[p.pos].value)
+	}
+	return sql, p.args, nil
+}
+
+// tokenizeFilter splits a filter expression into tokens
+func tokenizeFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			tokens = append(tokens, filterToken{kind: string(r), value: string(r)})
+			i++
+		case r == '\'':
+			// Single-quoted string; a doubled quote is an escaped quote
+			var sb strings.Builder
+			i++
+			closed := false
+			for i < len(runes) {
+				if runes[i] == '\'' {
+					if i+1 < len(runes) && runes[i+1] == '\'' {
+						sb.WriteRune('\'')
+						i += 2
+						continue
+					}
+					closed = true
+					i++
+					break
+				}
+				sb.WriteRune(runes[i])
+				i++
+			}
+			if !closed {
+				return nil, fmt.Errorf("unterminated string in filter")
+			}
+			tokens = append(tokens, filterToken{kind: "string", value: sb.String()})
+		case strings.ContainsRune("=<>!", r):
+			op := string(r)
+			if i+1 < len(runes) && strings.ContainsRune("=>", runes[i+1]) {
+				op += string(runes[i+1])
+			}
+			switch op {
+			case "=", "!=", "<>", "<", "<=", ">", ">=":
+			default:
+				return nil, fmt.Errorf("unknown operator %q in filter", op)
+			}
+			tokens = append(tokens, filterToken{kind: "op", value: op})
+			i += len(op)
+		case unicode.IsDigit(r) || r == '-':
+			start := i
+			i++
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, filterToken{kind: "number", value: string(runes[start:i])})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, filterToken{kind: "ident", value: string(runes[start:i])})
+		default:
+			return nil, fmt.Errorf("unexpected character %q in filter", r)
+		}
+	}
+	return tokens, nil
+}
+
+// filterParser is a recursive descent parser over filter tokens
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+	args   []interface{}
+}
+
+// peekKeyword reports whether the next token is the given keyword
+func (p *filterParser) peekKeyword(keyword string) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].kind == "ident" &&
+		strings.EqualFold(p.tokens[p.pos].value, keyword)
+}
+
+// parseOr parses expressions joined by OR
+func (p *filterParser) parseOr() (string, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return "", err
+	}
+	for p.peekKeyword("OR") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return "", err
+		}
+		left = left + " OR " + right
+	}
+	return left, nil
+}
+
+// parseAnd parses expressions joined by AND
+func (p *filterParser) parseAnd() (string, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return "", err
+	}
+	for p.peekKeyword("AND") {
+		p.pos++
+		right, err := p.parseNot()
+		if err != nil {
+			return "", err
+		}
+		left = left + " AND " + right
+	}
+	return left, nil
+}
+
+// parseNot parses an optionally negated comparison or parenthesized expression
+func (p *filterParser) parseNot() (string, error) {
+	if p.peekKeyword("NOT") {
+		p.pos++
+		inner, err := p.parseNot()
+		if err != nil {
+			return "", err
+		}
+		return "NOT " + inner, nil
+	}
+
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == "(" {
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return "", err
+		}
+		if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != ")" {
+			return "", fmt.Errorf("missing closing parenthesis in filter")
+		}
+		p.pos++
+		return "(" + inner + ")", nil
+	}
+
+	return p.parseComparison()
+}
+
+// parseComparison parses "column op literal", "column LIKE literal" or
+// "column IS [NOT] NULL"
+func (p *filterParser) parseComparison() (string, error) {
+	if p.pos >= len(p.tokens) {
+		return "", fmt.Errorf("unexpected end of filter")
+	}
+	tok := p.tokens[p.pos]
+	if tok.kind != "ident" {
+		return "", fmt.Errorf("expected column name in filter, got %q", tok.value)
+	}
+	column := strings.ToLower(tok.value)
+	if !filterColumns[column] {
+		return "", fmt.Errorf("unknown column %q in filter", tok.value)
+	}
+	p.pos++
+
+	if p.peekKeyword("IS") {
+		p.pos++
+		not := ""
+		if p.peekKeyword("NOT") {
+			p.pos++
+			not = "NOT "
+		}
+		if !p.peekKeyword("NULL") {
+			return "", fmt.Errorf("expected NULL after IS in filter")
+		}
+		p.pos++
+		return column + " IS " + not + "NULL", nil
+	}
+
+	var op string
+	switch {
+	case p.peekKeyword("LIKE"):
+		op = "LIKE"
+	case p.pos < len(p.tokens) && p.tokens[p.pos].kind == "op":
+		op = p.tokens[p.pos].value
+	default:
+		return "", fmt.Errorf("expected operator after %q in filter", column)
+	}
+	p.pos++
+
+	if p.pos >= len(p.tokens) {
+		return "", fmt.Errorf("expected value after %s in filter", op)
+	}
+	lit := p.tokens[p.pos]
+	switch lit.kind {
+	case "string":
+		p.args = append(p.args, lit.value)
+	case "number":
+		if n, err := strconv.ParseInt(lit.value, 10, 64); err == nil {
+			p.args = append(p.args, n)
+		} else if f, err := strconv.ParseFloat(lit.value, 64); err == nil {
+			p.args = append(p.args, f)
+		} else {
+			return "", fmt.Errorf("invalid number %q in filter", lit.value)
+		}
+	default:
+		return "", fmt.Errorf("expected a string or number after %s in filter, got %q", op, lit.value)
+	}
+	p.pos++
+
+	return column + " " + op + " ?", nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		expr     string
+		wantSQL  string
+		wantArgs []interface{}
+		wantErr  bool
+	}{
+		{
+			name:     "comparison",
+			expr:     "author = 'alice'",
+			wantSQL:  "author = ?",
+			wantArgs: []interface{}{"alice"},
+		},
+		{
+			name:     "connectives",
+			expr:     "author = 'alice' AND block_num > 1000 OR tag_count >= 2.5",
+			wantSQL:  "author = ? AND block_num > ? OR tag_count >= ?",
+			wantArgs: []interface{}{"alice", int64(1000), 2.5},
+		},
+		{
+			name:     "not and parentheses",
+			expr:     "NOT (app LIKE 'peakd%' or app <> 'ecency')",
+			wantSQL:  "NOT (app LIKE ? OR app <> ?)",
+			wantArgs: []interface{}{"peakd%", "ecency"},
+		},
+		{
+			name:    "is null",
+			expr:    "slug IS NOT NULL and thumbnail is null",
+			wantSQL: "slug IS NOT NULL AND thumbnail IS NULL",
+		},
+		{
+			name:     "escaped quote",
+			expr:     "title = 'it''s'",
+			wantSQL:  "title = ?",
+			wantArgs: []interface{}{"it's"},
+		},
+		{
+			name:     "negative number",
+			expr:     "author_reputation < -5",
+			wantSQL:  "author_reputation < ?",
+			wantArgs: []interface{}{int64(-5)},
+		},
+		{
+			name:     "column names ignore case",
+			expr:     "Word_Count != 0",
+			wantSQL:  "word_count != ?",
+			wantArgs: []interface{}{int64(0)},
+		},
+		{name: "empty", expr: "   ", wantErr: true},
+		{name: "unknown column", expr: "body = 'x'", wantErr: true},
+		{name: "injection", expr: "author = 'a'; DROP TABLE posts", wantErr: true},
+		{name: "unterminated string", expr: "author = 'alice", wantErr: true},
+		{name: "unknown operator", expr: "block_num => 1", wantErr: true},
+		{name: "missing parenthesis", expr: "(author = 'alice'", wantErr: true},
+		{name: "missing value", expr: "author =", wantErr: true},
+		{name: "column as value", expr: "author = permlink", wantErr: true},
+		{name: "trailing tokens", expr: "author = 'a' 'b'", wantErr: true},
+		{name: "is without null", expr: "slug IS 'x'", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sql, args, err := parseFilter(tt.expr)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseFilter(%q) = %q, want an error", tt.expr, sql)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseFilter(%q) error = %v", tt.expr, err)
+			}
+			if sql != tt.wantSQL {
+				t.Errorf("parseFilter(%q) sql = %q, want %q", tt.expr, sql, tt.wantSQL)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("parseFilter(%q) args = %#v, want %#v", tt.expr, args, tt.wantArgs)
+			}
+		})
+	}
+}
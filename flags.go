@@ -0,0 +1,183 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix is prepended to a flag's name, uppercased with dashes replaced by
+// underscores, to get the environment variable that sets it, e.g. -batch is
+// POST_STUFFER_BATCH
+const envPrefix = "POST_STUFFER_"
+
+// registerFlags defines a flag on fs for every Config field, defaulting to the
+// field's current value
+func registerFlags(fs *flag.FlagSet, config *Config) {
+	fs.Var((*stringList)(&config.HiveAPIURLs), "api", "comma-separated Hive API node URLs, used round-robin")
+	fs.IntVar(&config.NodeMaxFailures, "node-max-failures", config.NodeMaxFailures, "consecutive failures after which a node is skipped (0 never skips)")
+	fs.DurationVar(&config.NodeCooldown, "node-cooldown", config.NodeCooldown, "how long a failing node is skipped")
+	fs.Int64Var(&config.GenesisBlock, "genesis", config.GenesisBlock, "block after which indexing starts")
+	fs.IntVar(&config.BatchSize, "batch", config.BatchSize, "number of blocks requested per batch")
+	fs.StringVar(&config.DBPath, "db", config.DBPath, "path of the SQLite database")
+	fs.IntVar(&config.MaxRetries, "max-retries", config.MaxRetries, "attempts for a failed request or write")
+	fs.DurationVar(&config.RetryDelay, "retry-delay", config.RetryDelay, "delay before the first retry, doubled on each attempt")
+	fs.StringVar(&config.Chain, "chain", config.Chain, "name of the chain the database is filled from")
+	fs.BoolVar(&config.ForceChain, "force", config.ForceChain, "sync even if the database was filled from another chain or genesis block")
+	fs.DurationVar(&config.FetchFailureDelay, "fetch-failure-delay", config.FetchFailureDelay, "wait before requesting a failed batch again, doubled on each further failure")
+	fs.IntVar(&config.MaxFetchFailures, "max-fetch-failures", config.MaxFetchFailures, "consecutive failed batches after which a sync stops (0 keeps retrying)")
+	fs.StringVar(&config.LogFormat, "log-format", config.LogFormat, "format of the log: text or json")
+	fs.StringVar(&config.LogLevel, "log-level", config.LogLevel, "lowest level logged: debug, info, warn or error")
+
+	fs.IntVar(&config.InitialBatchSize, "initial-batch", config.InitialBatchSize, "size of the first batch, doubled up to -batch (0 starts at -batch)")
+	fs.IntVar(&config.PrefetchBlocks, "prefetch-blocks", config.PrefetchBlocks, "blocks fetched ahead of processing in the background (0 disables)")
+	fs.IntVar(&config.PrefetchWorkers, "prefetch-workers", config.PrefetchWorkers, "batches fetched concurrently while prefetching")
+	fs.IntVar(&config.MaxBufferedRows, "max-buffered-rows", config.MaxBufferedRows, "pause prefetching while this many posts are waiting to be stored (0 disables)")
+	fs.IntVar(&config.BeyondHeadBlocks, "beyond-head", config.BeyondHeadBlocks, "blocks that may be requested past the head block")
+	fs.Int64Var(&config.TraceBlock, "trace-block", config.TraceBlock, "print how the operations of this block would be handled, then exit")
+	fs.Int64Var(&config.SkipBelowBlock, "skip-below", config.SkipBelowBlock, "ignore the operations of blocks below this one while still advancing through them (0 disables)")
+
+	fs.BoolVar(&config.Reverse, "reverse", config.Reverse, "index from the head back towards -genesis")
+
+	fs.BoolVar(&config.Follow, "follow", config.Follow, "keep polling for new blocks after catching up")
+	fs.DurationVar(&config.PollInterval, "poll-interval", config.PollInterval, "how often to poll for new blocks with -follow")
+
+	fs.DurationVar(&config.MaxRunDuration, "max-duration", config.MaxRunDuration, "stop at the next batch boundary after running this long (0 for unlimited)")
+
+	fs.StringVar(&config.WSURL, "ws", config.WSURL, "WebSocket endpoint to follow new blocks from after catching up")
+	fs.DurationVar(&config.HeartbeatInterval, "heartbeat", config.HeartbeatInterval, "interval of the caught-up message while following (0 disables)")
+
+	fs.BoolVar(&config.SelfCheck, "self-check", config.SelfCheck, "verify the API node at startup")
+
+	fs.IntVar(&config.MaxRestarts, "max-restarts", config.MaxRestarts, "database reconnects after recoverable errors before giving up")
+
+	fs.IntVar(&config.SQLiteCacheSizeKB, "sqlite-cache-kb", config.SQLiteCacheSizeKB, "page cache size per connection in KiB (0 keeps the default)")
+	fs.IntVar(&config.SQLitePageSize, "sqlite-page-size", config.SQLitePageSize, "page size in bytes of a new database (0 keeps the default)")
+	fs.StringVar(&config.SQLiteJournalMode, "sqlite-journal-mode", config.SQLiteJournalMode, "journal mode of the database, e.g. WAL or DELETE (empty keeps the current mode)")
+	fs.StringVar(&config.SQLiteSynchronous, "sqlite-synchronous", config.SQLiteSynchronous, "synchronous setting per connection: OFF, NORMAL, FULL or EXTRA (empty keeps the default)")
+	fs.IntVar(&config.BusyRetries, "busy-retries", config.BusyRetries, "quick retries of a write while the database is busy or locked")
+	fs.DurationVar(&config.BusyRetryDelay, "busy-retry-delay", config.BusyRetryDelay, "delay between busy retries, plus up to as much jitter")
+	fs.DurationVar(&config.MigrationLockTimeout, "migration-lock-timeout", config.MigrationLockTimeout, "age after which another instance's migration lock is taken over")
+
+	fs.StringVar(&config.OTLPEndpoint, "otlp-endpoint", config.OTLPEndpoint, "OTLP/HTTP collector batch traces are exported to")
+
+	fs.DurationVar(&config.HTTPTimeout, "http-timeout", config.HTTPTimeout, "timeout of each request to the API node, 0 for none")
+	fs.DurationVar(&config.IdleConnTimeout, "idle-conn-timeout", config.IdleConnTimeout, "how long idle connections to the API node are kept open")
+	fs.IntVar(&config.MaxIdleConnsPerHost, "max-idle-conns", config.MaxIdleConnsPerHost, "idle connections kept per API node")
+
+	fs.DurationVar(&config.HeadCacheTTL, "head-cache-ttl", config.HeadCacheTTL, "how long the head block number is reused (0 disables caching)")
+
+	fs.BoolVar(&config.ProcessComments, "comments", config.ProcessComments, "store top-level posts")
+	fs.BoolVar(&config.IndexReplies, "replies", config.IndexReplies, "store replies in the replies table")
+	fs.BoolVar(&config.StrictTopLevel, "strict-top-level", config.StrictTopLevel, "skip top-level posts whose parent permlink is not a valid category")
+	fs.BoolVar(&config.ProcessCustomJSON, "custom-json", config.ProcessCustomJSON, "store custom_json operations")
+	fs.Var((*stringList)(&config.CustomJSONIDs), "custom-json-ids", "comma-separated custom_json ids to store (default all)")
+	fs.BoolVar(&config.ProcessTransfers, "transfers", config.ProcessTransfers, "store transfer operations")
+	fs.BoolVar(&config.ProcessVotes, "votes", config.ProcessVotes, "store vote operations")
+	fs.BoolVar(&config.StoreBeneficiaries, "beneficiaries", config.StoreBeneficiaries, "store the beneficiaries of posts")
+	fs.BoolVar(&config.ProcessDeletes, "deletes", config.ProcessDeletes, "remove posts deleted by their author")
+	fs.BoolVar(&config.BatchRequests, "batch-requests", config.BatchRequests, "fetch blocks with batched get_block calls")
+	fs.BoolVar(&config.NormalizePermlinks, "normalize-permlinks", config.NormalizePermlinks, "lowercase permlinks before handling operations")
+	fs.BoolVar(&config.MultiRowInsert, "multi-row-insert", config.MultiRowInsert, "store the posts of a batch with multi-row inserts")
+	fs.BoolVar(&config.BatchTransaction, "batch-transaction", config.BatchTransaction, "write each batch in a single transaction")
+	fs.BoolVar(&config.PostSavepoints, "savepoints", config.PostSavepoints, "with -batch-transaction, roll back a post that fails to store on its own")
+	fs.BoolVar(&config.CollapseDuplicateOps, "collapse-duplicates", config.CollapseDuplicateOps, "handle only the first comment operation per post in a block")
+	fs.BoolVar(&config.UpdateOnConflict, "update-on-conflict", config.UpdateOnConflict, "replace the title, tags and metadata of a stored post when it is edited")
+	fs.BoolVar(&config.SkipUnchangedEdits, "skip-unchanged-edits", config.SkipUnchangedEdits, "with -update-on-conflict, skip edits that change neither title, tags nor metadata")
+
+	fs.Var((*stringList)(&config.TitleContains), "title-contains", "comma-separated keywords, one of which a stored post's title must contain")
+
+	fs.IntVar(&config.MaxTagLength, "max-tag-length", config.MaxTagLength, "longest tag stored (0 disables the limit)")
+	fs.IntVar(&config.MaxTags, "max-tags", config.MaxTags, "most tags stored per post (0 disables the limit)")
+
+	fs.BoolVar(&config.StoreWitness, "store-witness", config.StoreWitness, "store the producer of each post's block")
+	fs.BoolVar(&config.StoreReputation, "store-reputation", config.StoreReputation, "store each author's reputation when the post is indexed")
+	fs.IntVar(&config.ReputationConcurrency, "reputation-concurrency", config.ReputationConcurrency, "reputation requests in flight at once")
+	fs.BoolVar(&config.StoreThumbnail, "store-thumbnail", config.StoreThumbnail, "store the first image URL of each post")
+	fs.BoolVar(&config.StoreWordCount, "store-word-count", config.StoreWordCount, "store a rough word count of each post body")
+	fs.BoolVar(&config.StoreBlockSeq, "store-block-seq", config.StoreBlockSeq, "store the position of each post within its block")
+	fs.BoolVar(&config.StoreTransactionID, "store-transaction-id", config.StoreTransactionID, "store the id of the transaction holding each post")
+	fs.BoolVar(&config.StoreSlug, "store-slug", config.StoreSlug, "store a URL-friendly slug of each post's title when it differs from the permlink")
+	fs.BoolVar(&config.StoreRawMetadata, "store-raw-metadata", config.StoreRawMetadata, "store each post's raw JSON metadata (disable with -store-raw-metadata=false)")
+	fs.BoolVar(&config.CompressRawMetadata, "compress-raw-metadata", config.CompressRawMetadata, "gzip the stored raw metadata")
+	fs.BoolVar(&config.CompactTags, "compact-tags", config.CompactTags, "store tags through the tag dictionary")
+
+	fs.BoolVar(&config.PartitionByMonth, "partition-by-month", config.PartitionByMonth, "store posts in monthly posts_YYYYMM tables")
+
+	fs.StringVar(&config.JSONLOutput, "jsonl-output", config.JSONLOutput, "file every new post is also appended to as JSON")
+	fs.StringVar(&config.SocketPath, "socket", config.SocketPath, "Unix socket every new post is also streamed to as JSON")
+	fs.IntVar(&config.SocketBuffer, "socket-buffer", config.SocketBuffer, "posts queued for the socket before further posts are dropped")
+
+	fs.StringVar(&config.S3Region, "s3-region", config.S3Region, "region of the bucket s3:// exports are uploaded to")
+	fs.StringVar(&config.S3Endpoint, "s3-endpoint", config.S3Endpoint, "endpoint of an S3-compatible store (default AWS)")
+
+	fs.StringVar(&config.ExecHook, "exec-hook", config.ExecHook, "shell command run with every new post as JSON on stdin")
+	fs.IntVar(&config.ExecHookConcurrency, "exec-hook-concurrency", config.ExecHookConcurrency, "hook commands running at once")
+	fs.IntVar(&config.ExecHookRate, "exec-hook-rate", config.ExecHookRate, "hook commands started per second (0 is unlimited)")
+	fs.IntVar(&config.ConfirmationDepth, "confirmation-depth", config.ConfirmationDepth, "blocks on top of a post's block before its hook runs")
+	fs.BoolVar(&config.ExecHookFatal, "exec-hook-fatal", config.ExecHookFatal, "stop when a hook command fails")
+
+	fs.StringVar(&config.InconsistentBlocks, "inconsistent-blocks", config.InconsistentBlocks, "what to do with blocks out of sequence: skip or warn")
+	fs.BoolVar(&config.SkipProcessedBlocks, "skip-processed", config.SkipProcessedBlocks, "skip blocks of a batch that were already processed")
+	fs.BoolVar(&config.ValidateBlockCount, "validate-block-count", config.ValidateBlockCount, "fetch a block range again when the node skipped blocks in it")
+
+	fs.StringVar(&config.OnParserUpgrade, "on-parser-upgrade", config.OnParserUpgrade, "what to do with posts stored by an older parser: warn or queue")
+
+	fs.BoolVar(&config.RecordProcessedRanges, "record-ranges", config.RecordProcessedRanges, "record the block range of every batch for the audit command")
+	fs.StringVar(&config.CheckpointFile, "checkpoint-file", config.CheckpointFile, "mirror the checkpoint to this JSON file after every batch")
+	fs.BoolVar(&config.RecordPostLatency, "record-latency", config.RecordPostLatency, "report the time from fetching a block to storing its posts")
+
+	fs.BoolVar(&config.StrictTimestamps, "strict-timestamps", config.StrictTimestamps, "skip blocks whose timestamp goes backwards")
+
+	fs.BoolVar(&config.ValidateMetadata, "validate-metadata", config.ValidateMetadata,
+		"classify post metadata and report the tallies without storing posts")
+}
+
+// applyEnv sets the flags of fs that were not given on the command line from
+// their environment variables, so flags take precedence over the environment,
+// which takes precedence over the defaults
+func applyEnv(fs *flag.FlagSet) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || err != nil {
+			return
+		}
+		name := envName(f.Name)
+		if value, ok := os.LookupEnv(name); ok {
+			if setErr := fs.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("invalid value %q for %s: %v", value, name, setErr)
+			}
+		}
+	})
+	return err
+}
+
+// envName returns the environment variable for the flag with the given name
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// stringList is a flag.Value holding a comma-separated list
+type stringList []string
+
+// String returns the list joined by commas
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set replaces the list with the comma-separated values, ignoring empty entries
+func (l *stringList) Set(value string) error {
+	*l = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
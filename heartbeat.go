@@ -0,0 +1,81 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// heartbeat periodically logs that the indexer is caught up and waiting while no
+// new blocks arrive, so a quiet follower can be told apart from a stuck one
+type heartbeat struct {
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+
+	mu           sync.Mutex
+	block        int64
+	lastActivity time.Time
+}
+
+// startHeartbeat starts logging a heartbeat every interval without activity,
+// reporting block as the latest processed block. It returns nil, which is safe to
+// use, when interval is not positive.
+func startHeartbeat(interval time.Duration, block int64) *heartbeat {
+	if interval <= 0 {
+		return nil
+	}
+
+	h := &heartbeat{
+		interval:     interval,
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+		block:        block,
+		lastActivity: time.Now(),
+	}
+	go h.run()
+	return h
+}
+
+// run logs the heartbeat until Stop is called
+func (h *heartbeat) run() {
+	defer close(h.done)
+
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			h.mu.Lock()
+			idle := time.Since(h.lastActivity) >= h.interval
+			block := h.block
+			h.mu.Unlock()
+
+			if idle {
+				slog.Info("Caught up, waiting for new blocks", "block_num", block)
+			}
+		case <-h.stop:
+			return
+		}
+	}
+}
+
+// Advance records that block was processed, postponing the next heartbeat
+func (h *heartbeat) Advance(block int64) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.block = block
+	h.lastActivity = time.Now()
+}
+
+// Stop stops the heartbeat
+func (h *heartbeat) Stop() {
+	if h == nil {
+		return
+	}
+	close(h.stop)
+	<-h.done
+}
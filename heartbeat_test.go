@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer safe for the concurrent writes of a logger
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestHeartbeat(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	var logs syncBuffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	if h := startHeartbeat(0, 42); h != nil {
+		t.Fatal("startHeartbeat() without an interval returned a heartbeat")
+	}
+
+	// Blocks processed more often than the interval keep the heartbeat quiet
+	h := startHeartbeat(30*time.Millisecond, 42)
+	for deadline := time.Now().Add(60 * time.Millisecond); time.Now().Before(deadline); {
+		h.Advance(43)
+		time.Sleep(time.Millisecond)
+	}
+	if strings.Contains(logs.String(), "Caught up") {
+		t.Errorf("heartbeat logged while blocks were processed:\n%s", logs.String())
+	}
+
+	// An idle period is reported with the last processed block
+	time.Sleep(100 * time.Millisecond)
+	h.Stop()
+	if !strings.Contains(logs.String(), "Caught up, waiting for new blocks") || !strings.Contains(logs.String(), "block_num=43") {
+		t.Errorf("no heartbeat logged for block 43 while idle:\n%s", logs.String())
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// errExecHookFailed is returned once the exec hook has failed and
+// ExecHookFatal is set, so processing stops instead of carrying on
+var errExecHookFailed = errors.New("exec hook failed")
+
+// execHook runs an external command for every newly inserted post, passing the
+// post as JSON on the command's stdin.
+//
+// The command is run through "sh -c" so it may contain arguments and pipes. At
+// most ExecHookConcurrency commands run at once and, when ExecHookRate is set, no
+// more than that many are started per second; when either limit is reached the
+// caller blocks, which slows processing down rather than queueing without bound.
+type execHook struct {
+	command string
+	fatal   bool
+	sem     chan struct{}
+	ticker  *time.Ticker
+	wg      sync.WaitGroup
+
+	mu      sync.Mutex
+	lastErr error
+}
+
+// newExecHook creates the exec hook described by the configuration, or returns nil
+// if no hook is configured
+func newExecHook(config *Config) *execHook {
+	if config.ExecHook == "" {
+		return nil
+	}
+
+	concurrency := config.ExecHookConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	h := &execHook{
+		command: config.ExecHook,
+		fatal:   config.ExecHookFatal,
+		sem:     make(chan struct{}, concurrency),
+	}
+	if config.ExecHookRate > 0 {
+		h.ticker = time.NewTicker(time.Second / time.Duration(config.ExecHookRate))
+	}
+	return h
+}
+
+// Run starts the hook command for post in the background.
+//
+// A failure of the command is logged. If the hook is configured as fatal, the
+// failure is also reported by the next call to Run, wrapped in errExecHookFailed.
+func (h *execHook) Run(post ExportedPost) error {
+	if err := h.err(); err != nil && h.fatal {
+		return fmt.Errorf("%w: %v", errExecHookFailed, err)
+	}
+
+	payload, err := json.Marshal(post)
+	if err != nil {
+		return fmt.Errorf("error encoding post for exec hook: %v", err)
+	}
+
+	if h.ticker != nil {
+		<-h.ticker.C
+	}
+	h.sem <- struct{}{}
+	h.wg.Add(1)
+
+	go func() {
+		defer func() {
+			<-h.sem
+			h.wg.Done()
+		}()
+
+		cmd := exec.Command("sh", "-c", h.command)
+		cmd.Stdin = bytes.NewReader(payload)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			slog.Error("Exec hook failed", "url", post.URL, "error", err, "output", string(bytes.TrimSpace(out)))
+			h.mu.Lock()
+			h.lastErr = fmt.Errorf("hook for %s: %v", post.URL, err)
+			h.mu.Unlock()
+		}
+	}()
+
+	return nil
+}
+
+// err returns the most recent hook failure, if any
+func (h *execHook) err() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.lastErr
+}
+
+// Close waits for running hook commands to finish and stops the rate limiter
+func (h *execHook) Close() {
+	h.wg.Wait()
+	if h.ticker != nil {
+		h.ticker.Stop()
+	}
+}
+
+// pendingDelivery is a hook delivery waiting for its block to be confirmed
+type pendingDelivery struct {
+	blockNum int64
+	blockID  string
+	post     ExportedPost
+}
+
+// confirmationQueue holds back exec hook deliveries until the post's block is
+// ConfirmationDepth blocks deep, so posts on blocks that get replaced by a fork
+// switch are never delivered.
+//
+// A fork switch shows up as a block number that is seen again with a different
+// block id; deliveries queued for that block and any later block are dropped.
+// Deliveries still pending when processing stops are dropped as well, since
+// their blocks were never confirmed.
+type confirmationQueue struct {
+	depth   int
+	pending []pendingDelivery
+	// blockIDs holds the ids of the blocks with pending deliveries
+	blockIDs map[int64]string
+}
+
+// newConfirmationQueue creates a confirmationQueue, or returns nil when
+// deliveries need no confirmations
+func newConfirmationQueue(config *Config) *confirmationQueue {
+	if config.ConfirmationDepth <= 0 {
+		return nil
+	}
+	return &confirmationQueue{depth: config.ConfirmationDepth, blockIDs: make(map[int64]string)}
+}
+
+// Add queues the delivery of a post stored from the given block
+func (q *confirmationQueue) Add(blockNum int64, blockID string, post ExportedPost) {
+	q.pending = append(q.pending, pendingDelivery{blockNum: blockNum, blockID: blockID, post: post})
+	q.blockIDs[blockNum] = blockID
+}
+
+// Observe records that a block is about to be processed. It drops the deliveries
+// orphaned if the block replaces one seen before, and returns the posts whose
+// blocks are now confirmed, in the order they were added.
+func (q *confirmationQueue) Observe(blockNum int64, blockID string) []ExportedPost {
+	if id, ok := q.blockIDs[blockNum]; ok && id != blockID {
+		kept := q.pending[:0]
+		for _, d := range q.pending {
+			if d.blockNum < blockNum {
+				kept = append(kept, d)
+				continue
+			}
+			slog.Warn("Dropping hook delivery, block was replaced", "url", d.post.URL, "block_num", d.blockNum)
+			delete(q.blockIDs, d.blockNum)
+		}
+		q.pending = kept
+	}
+
+	var ready []ExportedPost
+	i := 0
+	for ; i < len(q.pending) && q.pending[i].blockNum <= blockNum-int64(q.depth); i++ {
+		ready = append(ready, q.pending[i].post)
+		delete(q.blockIDs, q.pending[i].blockNum)
+	}
+	q.pending = q.pending[i:]
+	return ready
+}
+
+// Len returns the number of pending deliveries
+func (q *confirmationQueue) Len() int {
+	return len(q.pending)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExecHook(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "hook.jsonl")
+	_, bp := newTestProcessor(t, func(c *Config) {
+		c.ExecHook = "cat >> " + out + " && echo >> " + out
+		c.ExecHookConcurrency = 1
+	})
+
+	blocks := []Block{
+		testBlock(100, testPost("alice", "first", "First"), testPost("bob", "second", "Second")),
+		// A post stored before is not a new post and must not run the hook again
+		testBlock(101, testPost("alice", "first", "First")),
+	}
+	for _, block := range blocks {
+		if _, err := bp.processBlock(context.Background(), block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bp.hook.Close()
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var got []ExportedPost
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var post ExportedPost
+		if err := json.Unmarshal(scanner.Bytes(), &post); err != nil {
+			t.Fatalf("hook received invalid JSON %q: %v", scanner.Text(), err)
+		}
+		got = append(got, post)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("hook ran %d times, want 2: %+v", len(got), got)
+	}
+	for i, want := range []ExportedPost{
+		{URL: "@alice/first", Author: "alice", Permlink: "first", Title: "First", Tags: []string{"hive", "test"}, BlockNum: 100},
+		{URL: "@bob/second", Author: "bob", Permlink: "second", Title: "Second", Tags: []string{"hive", "test"}, BlockNum: 100},
+	} {
+		post := got[i]
+		if post.URL != want.URL || post.Author != want.Author || post.Permlink != want.Permlink ||
+			post.Title != want.Title || post.BlockNum != want.BlockNum || !reflect.DeepEqual(post.Tags, want.Tags) {
+			t.Errorf("hook post %d = %+v, want %+v", i, post, want)
+		}
+		if post.Timestamp == "" {
+			t.Errorf("hook post %d has no timestamp", i)
+		}
+	}
+}
+
+func TestConfirmationQueue(t *testing.T) {
+	q := newConfirmationQueue(&Config{ConfirmationDepth: 2})
+	post := func(url string) ExportedPost { return ExportedPost{URL: url} }
+	urls := func(posts []ExportedPost) []string {
+		result := []string{}
+		for _, p := range posts {
+			result = append(result, p.URL)
+		}
+		return result
+	}
+
+	steps := []struct {
+		blockNum int64
+		blockID  string
+		posts    []string
+		want     []string
+	}{
+		{100, "a100", []string{"@alice/kept"}, []string{}},
+		{101, "a101", []string{"@bob/orphaned"}, []string{}},
+		// Block 101 is replaced by a fork switch before it is confirmed
+		{101, "b101", []string{"@carol/replacement"}, []string{}},
+		{102, "b102", nil, []string{"@alice/kept"}},
+		{103, "b103", nil, []string{"@carol/replacement"}},
+		{104, "b104", nil, []string{}},
+	}
+	for _, step := range steps {
+		got := urls(q.Observe(step.blockNum, step.blockID))
+		if !reflect.DeepEqual(got, step.want) {
+			t.Errorf("Observe(%d, %s) = %v, want %v", step.blockNum, step.blockID, got, step.want)
+		}
+		for _, url := range step.posts {
+			q.Add(step.blockNum, step.blockID, post(url))
+		}
+	}
+	if q.Len() != 0 {
+		t.Errorf("Len() = %d after every block was confirmed, want 0", q.Len())
+	}
+
+	if q := newConfirmationQueue(&Config{}); q != nil {
+		t.Error("newConfirmationQueue() without a depth returned a queue")
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestGetDBInfo(t *testing.T) {
+	db, bp := newTestProcessor(t, nil)
+	if _, err := bp.processBlock(context.Background(), testBlock(100,
+		testPost("alice", "a", "A"),
+		testPost("bob", "b", "B"),
+	)); err != nil {
+		t.Fatal(err)
+	}
+	if err := recordFailedBlocks(db, []BlockFetchError{{BlockNum: 101, Message: "not found"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := getDBInfo(db, bp.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.SchemaVersion != schemaVersion || info.CurrentSchemaVersion != schemaVersion || info.MigrationNeeded {
+		t.Errorf("schema version %d of %d, migration needed %v, want %d without a migration",
+			info.SchemaVersion, info.CurrentSchemaVersion, info.MigrationNeeded, schemaVersion)
+	}
+	if info.RowCounts["posts"] != 2 || info.RowCounts["failed_blocks"] != 1 {
+		t.Errorf("row counts = %v, want 2 posts and 1 failed block", info.RowCounts)
+	}
+	if !strings.HasPrefix(info.Driver, "sqlite3 ") {
+		t.Errorf("driver = %q, want the sqlite3 version", info.Driver)
+	}
+
+	// A database last migrated by an older build needs a migration
+	if _, err := db.Exec("PRAGMA user_version = 1"); err != nil {
+		t.Fatal(err)
+	}
+	// The hash changes with the effective configuration
+	changed := *bp.config
+	changed.BatchSize++
+	old, err := getDBInfo(db, &changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.SchemaVersion != 1 || !old.MigrationNeeded {
+		t.Errorf("schema version %d, migration needed %v, want version 1 needing a migration", old.SchemaVersion, old.MigrationNeeded)
+	}
+	if old.ConfigHash == info.ConfigHash {
+		t.Errorf("config hash %s did not change with the configuration", old.ConfigHash)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the latency histogram buckets. The
+// last bucket also counts every request slower than its bound.
+var latencyBuckets = []time.Duration{
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+}
+
+// latencyHistogram counts latencies in latencyBuckets
+type latencyHistogram struct {
+	counts []int
+	total  int
+	sum    time.Duration
+}
+
+// LatencySummary summarizes the latencies recorded in a histogram
+type LatencySummary struct {
+	Name  string
+	Count int
+	Avg   time.Duration
+	// P50, P95 and P99 are the upper bounds of the buckets containing the
+	// percentiles, so they overestimate by at most one bucket
+	P50 time.Duration
+	P95 time.Duration
+	P99 time.Duration
+}
+
+// String formats the summary for logging
+func (s LatencySummary) String() string {
+	return fmt.Sprintf("%s: %d samples, avg %s, p50 %s, p95 %s, p99 %s",
+		s.Name, s.Count, s.Avg.Round(time.Millisecond), s.P50, s.P95, s.P99)
+}
+
+// newLatencyHistogram creates an empty latencyHistogram
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{counts: make([]int, len(latencyBuckets))}
+}
+
+// Record adds a latency to the histogram
+func (h *latencyHistogram) Record(d time.Duration) {
+	i := sort.Search(len(latencyBuckets), func(i int) bool { return d <= latencyBuckets[i] })
+	if i == len(latencyBuckets) {
+		i--
+	}
+	h.counts[i]++
+	h.total++
+	h.sum += d
+}
+
+// Summary returns the summary of the recorded latencies under the given name
+func (h *latencyHistogram) Summary(name string) LatencySummary {
+	s := LatencySummary{Name: name, Count: h.total}
+	if h.total > 0 {
+		s.Avg = h.sum / time.Duration(h.total)
+		s.P50 = h.percentile(0.50)
+		s.P95 = h.percentile(0.95)
+		s.P99 = h.percentile(0.99)
+	}
+	return s
+}
+
+// nodeLatencies records the latency of requests per API node
+type nodeLatencies struct {
+	mu    sync.Mutex
+	nodes map[string]*latencyHistogram
+}
+
+// newNodeLatencies creates an empty nodeLatencies
+func newNodeLatencies() *nodeLatencies {
+	return &nodeLatencies{nodes: make(map[string]*latencyHistogram)}
+}
+
+// Record adds the latency of a request to node
+func (l *nodeLatencies) Record(node string, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	h, ok := l.nodes[node]
+	if !ok {
+		h = newLatencyHistogram()
+		l.nodes[node] = h
+	}
+	h.Record(d)
+}
+
+// Summary returns the latency of every node seen, slowest average first
+func (l *nodeLatencies) Summary() []LatencySummary {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	summary := make([]LatencySummary, 0, len(l.nodes))
+	for node, h := range l.nodes {
+		summary = append(summary, h.Summary(node))
+	}
+	sort.Slice(summary, func(i, j int) bool {
+		if summary[i].Avg != summary[j].Avg {
+			return summary[i].Avg > summary[j].Avg
+		}
+		return summary[i].Name < summary[j].Name
+	})
+	return summary
+}
+
+// String formats the summary for logging
+func (l *nodeLatencies) String() string {
+	var parts []string
+	for _, n := range l.Summary() {
+		parts = append(parts, n.String())
+	}
+	return strings.Join(parts, " | ")
+}
+
+// percentile returns the upper bound of the bucket containing the p-th percentile
+func (h *latencyHistogram) percentile(p float64) time.Duration {
+	rank := int(p*float64(h.total) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	seen := 0
+	for i, count := range h.counts {
+		seen += count
+		if seen >= rank {
+			return latencyBuckets[i]
+		}
+	}
+	return latencyBuckets[len(latencyBuckets)-1]
+}
+
+// latencyTransport is an http.RoundTripper that records the time until the
+// response headers arrive for every request, keyed by the node's host
+type latencyTransport struct {
+	next      http.RoundTripper
+	latencies *nodeLatencies
+}
+
+// RoundTrip performs the request through the wrapped transport and records its
+// latency, including failed requests
+func (t *latencyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	t.latencies.Record(req.URL.Host, time.Since(start))
+	return resp, err
+}
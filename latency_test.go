@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestNodeLatencies(t *testing.T) {
+	newNode := func(delay time.Duration) string {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(delay)
+		}))
+		t.Cleanup(srv.Close)
+		u, _ := url.Parse(srv.URL)
+		return u.Host
+	}
+	fast, slow := newNode(0), newNode(30*time.Millisecond)
+
+	latencies := newNodeLatencies()
+	client := &http.Client{Transport: &latencyTransport{next: http.DefaultTransport, latencies: latencies}}
+	for i := 0; i < 3; i++ {
+		for _, node := range []string{fast, slow} {
+			resp, err := client.Get("http://" + node)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+		}
+	}
+
+	summary := latencies.Summary()
+	if len(summary) != 2 {
+		t.Fatalf("Summary() has %d nodes, want 2: %v", len(summary), latencies)
+	}
+	if summary[0].Name != slow || summary[1].Name != fast {
+		t.Fatalf("Summary() lists %s before %s, want the slow node %s first", summary[0].Name, summary[1].Name, slow)
+	}
+	for _, s := range summary {
+		if s.Count != 3 {
+			t.Errorf("%s recorded %d requests, want 3", s.Name, s.Count)
+		}
+	}
+	if s := summary[0]; s.Avg < 30*time.Millisecond || s.P50 < 50*time.Millisecond || s.P99 < s.P50 {
+		t.Errorf("slow node summary = %v, want an average of at least 30ms in the 50ms bucket", s)
+	}
+}
+
+func TestLatencyHistogramPercentiles(t *testing.T) {
+	h := newLatencyHistogram()
+	for i := 0; i < 90; i++ {
+		h.Record(5 * time.Millisecond)
+	}
+	for i := 0; i < 9; i++ {
+		h.Record(200 * time.Millisecond)
+	}
+	// Latencies beyond the last bucket count towards it
+	h.Record(time.Minute)
+
+	s := h.Summary("node")
+	if s.Count != 100 || s.P50 != 10*time.Millisecond || s.P95 != 250*time.Millisecond || s.P99 != 250*time.Millisecond {
+		t.Errorf("Summary() = %v, want 100 samples with p50 10ms, p95 250ms, p99 250ms", s)
+	}
+	if want := (90*5*time.Millisecond + 9*200*time.Millisecond + time.Minute) / 100; s.Avg != want {
+		t.Errorf("Summary().Avg = %s, want %s", s.Avg, want)
+	}
+}
+
+func TestPostLatency(t *testing.T) {
+	for _, multiRow := range []bool{false, true} {
+		t.Run(fmt.Sprintf("multi-row=%v", multiRow), func(t *testing.T) {
+			db, bp := newTestProcessor(t, func(c *Config) {
+				c.RecordPostLatency = true
+				c.MultiRowInsert = multiRow
+			})
+			fetchedAt := time.Now().Add(-30 * time.Millisecond)
+			blocks := []Block{
+				testBlock(101, testPost("alice", "a", "A"), testPost("bob", "b", "B")),
+				testBlock(102, testPost("carol", "c", "C")),
+			}
+			for i := range blocks {
+				blocks[i].FetchedAt = fetchedAt
+			}
+			batch := fetchedBatch{startBlock: 101, count: len(blocks), blocks: blocks}
+			if _, err := processFetched(context.Background(), bp.config, db, bp, NewStats(), batch); err != nil {
+				t.Fatal(err)
+			}
+
+			latency := bp.PostLatency()
+			if latency.Count != 3 {
+				t.Errorf("recorded the latency of %d posts, want 3", latency.Count)
+			}
+			if latency.Avg < 30*time.Millisecond {
+				t.Errorf("average latency %s is shorter than the time since the blocks were fetched", latency.Avg)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// setupLogging makes the default slog logger write messages of config.LogLevel
+// and above to stderr in config.LogFormat. Messages written through the log
+// package, such as by dependencies, go through the same handler at the info
+// level.
+func setupLogging(config *Config) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(config.LogLevel)); err != nil {
+		return fmt.Errorf("unknown log level %q; use debug, info, warn or error", config.LogLevel)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	switch config.LogFormat {
+	case LogFormatText:
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	case LogFormatJSON:
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("unknown log format %q; use text or json", config.LogFormat)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureLogging sets up logging for config with stderr redirected to a file,
+// runs log, and returns what was written
+func captureLogging(t *testing.T, config *Config, log func()) string {
+	t.Helper()
+	defer slog.SetDefault(slog.Default())
+	f, err := os.Create(filepath.Join(t.TempDir(), "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	stderr := os.Stderr
+	os.Stderr = f
+	err = setupLogging(config)
+	os.Stderr = stderr
+	if err != nil {
+		t.Fatal(err)
+	}
+	log()
+
+	out, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestSetupLoggingFormat(t *testing.T) {
+	stats := NewStats()
+	stats.AddProcessed(50)
+	stats.AddInserts(7)
+	res := batchResult{fetched: true, blocks: 50, inserts: 7, duration: 2 * time.Second}
+	progress := func() { logProgress(25, 1000, res, stats) }
+
+	config := DefaultConfig()
+	config.LogFormat = LogFormatJSON
+	var entry map[string]any
+	out := captureLogging(t, config, progress)
+	if err := json.Unmarshal([]byte(out), &entry); err != nil {
+		t.Fatalf("JSON log is not one JSON object: %q: %v", out, err)
+	}
+	want := map[string]any{
+		"msg":               "Progress",
+		"level":             "INFO",
+		"percent":           25.0,
+		"block_num":         1000.0,
+		"batch_size":        50.0,
+		"posts_inserted":    7.0,
+		"duration_ms":       2000.0,
+		"blocks_per_second": 25.0,
+		"total_blocks":      50.0,
+		"total_posts":       7.0,
+	}
+	for key, value := range want {
+		if entry[key] != value {
+			t.Errorf("JSON progress %s = %v, want %v", key, entry[key], value)
+		}
+	}
+
+	config.LogFormat = LogFormatText
+	out = captureLogging(t, config, progress)
+	for _, attr := range []string{"msg=Progress", "block_num=1000", "batch_size=50", "posts_inserted=7", "duration_ms=2000"} {
+		if !strings.Contains(out, attr) {
+			t.Errorf("text progress %q lacks %s", out, attr)
+		}
+	}
+
+	config.LogFormat = "xml"
+	if err := setupLogging(config); err == nil {
+		t.Error("setupLogging() accepted an unknown log format")
+	}
+}
+
+func TestSetupLoggingLevel(t *testing.T) {
+	reply := testPost("bob", "reply", "")
+	reply.Value.ParentAuthor, reply.Value.ParentPermlink = "alice", "post"
+	block := testBlock(100, testPost("alice", "post", "Post"), reply)
+	res := batchResult{fetched: true, blocks: 1, inserts: 1, duration: time.Second}
+
+	tests := []struct {
+		level   string
+		want    []string
+		notWant []string
+	}{
+		{"debug", []string{"Processing operation", "Skipped comment", "reply to @alice/post", "msg=Progress"}, nil},
+		{"info", []string{"msg=Progress"}, []string{"Processing operation", "Skipped comment"}},
+		{"warn", []string{"Block failed"}, []string{"Processing operation", "Skipped comment", "msg=Progress"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.level, func(t *testing.T) {
+			config := DefaultConfig()
+			config.LogLevel = tt.level
+			out := captureLogging(t, config, func() {
+				// The processor picks up the level when it is created
+				_, bp := newTestProcessor(t, nil)
+				if _, err := bp.processBlock(context.Background(), block); err != nil {
+					t.Fatal(err)
+				}
+				logProgress(100, 100, res, NewStats())
+				slog.Warn("Block failed", "block_num", 101)
+			})
+			for _, s := range tt.want {
+				if !strings.Contains(out, s) {
+					t.Errorf("log at level %s lacks %q:\n%s", tt.level, s, out)
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(out, s) {
+					t.Errorf("log at level %s holds %q:\n%s", tt.level, s, out)
+				}
+			}
+		})
+	}
+
+	config := DefaultConfig()
+	config.LogLevel = "verbose"
+	if err := setupLogging(config); err == nil {
+		t.Error("setupLogging() accepted an unknown log level")
+	}
+}
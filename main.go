@@ -1,126 +1,265 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
-	"strconv"
-	"time"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 )
 
 func main() {
 	// Initialize configuration
 	config := DefaultConfig()
+	registerFlags(flag.CommandLine, config)
+	flag.Usage = func() { printUsage(os.Stderr) }
+	flag.Parse()
+	if err := applyEnv(flag.CommandLine); err != nil {
+		log.Fatal(err)
+	}
+	if err := setupLogging(config); err != nil {
+		log.Fatal(err)
+	}
+	configureHTTP(config)
 
-	// Initialize database with retry
-	var db *sql.DB
-	err := retryWithBackoff(config.MaxRetries, config.RetryDelay, func() error {
-		var err error
-		db, err = initDB()
-		return err
-	})
+	if err := runCommand(config, flag.Args()); err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+// newSyncFlags returns the flag set of the sync command, which accepts every
+// option, defaulting to the values already in config
+func newSyncFlags(config *Config) *flag.FlagSet {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	registerFlags(fs, config)
+	return fs
+}
+
+// runSync implements the "sync" command, the default, which indexes the posts of
+// all blocks from the last processed block up to the head block. It keeps
+// following new blocks with -follow or when a WebSocket endpoint is configured.
+// With -trace-block, it only prints how a single block would be processed.
+//
+// The options can be given before or after the command name.
+func runSync(config *Config, args []string) error {
+	fs := newSyncFlags(config)
+	fs.Parse(args)
+	// Options given after the command name may change the logging and the nodes
+	// set up by main
+	if fs.NFlag() > 0 {
+		if err := setupLogging(config); err != nil {
+			return err
+		}
+		configureHTTP(config)
+	}
+
+	// SIGINT and SIGTERM cancel ctx, which stops processing after the block in
+	// progress so everything processed so far is stored before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if config.TraceBlock > 0 {
+		return traceBlock(ctx, config, os.Stdout)
+	}
+
+	if config.SelfCheck {
+		if err := selfCheck(config); err != nil {
+			return err
+		}
+		slog.Info("Self-check passed", "nodes", strings.Join(config.HiveAPIURLs, ", "))
+	}
+
+	// Initialize tracing; this is a no-op unless an OTLP endpoint is configured
+	shutdownTracing, err := initTracing(config)
 	if err != nil {
-		log.Fatal("Error initializing database:", err)
+		return fmt.Errorf("error initializing tracing: %w", err)
 	}
-	defer db.Close()
+	// Spans still buffered are exported even after a shutdown signal, but an
+	// unreachable collector must not keep the program from exiting
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Warn("Error flushing traces", "error", err)
+		}
+	}()
 
-	// Create block processor
-	processor, err := NewBlockProcessor(db, config)
+	db, processor, err := openStore(config)
 	if err != nil {
-		log.Fatal("Error creating block processor:", err)
+		return err
 	}
-	defer processor.Close()
+	// The store may be reopened, or fail to reopen, while processing
+	defer func() {
+		if processor != nil {
+			processor.Close()
+			db.Close()
+		}
+	}()
 
-	// Get current block and last processed block with retry
-	var currentBlock, lastProcessed int
-	err = retryWithBackoff(config.MaxRetries, config.RetryDelay, func() error {
-		var err error
-		currentBlock, err = getLatestBlock(config)
-		if err != nil {
-			return fmt.Errorf("error getting latest block: %v", err)
+	// A validation run stores nothing, so it doesn't claim the database for a
+	// chain or queue outdated posts
+	if !config.ValidateMetadata {
+		if err := checkChain(db, config); err != nil {
+			return err
+		}
+		if err := checkParserVersion(db, config); err != nil {
+			return err
 		}
+	}
 
-		lastProcessed, err = getLastProcessedBlock(db, config.GenesisBlock)
-		if err != nil {
-			return fmt.Errorf("error getting last processed block: %v", err)
+	head := newHeadCache(config)
+	pause := newPauseControl()
+	stats := NewStats()
+
+	// Process blocks, reconnecting to the database on recoverable errors until
+	// the restart budget is used up
+	db, processor, err = processWithRestarts(config, db, processor, stats, func(db *sql.DB, processor *BlockProcessor) error {
+		var err error
+		switch {
+		case config.WSURL != "":
+			err = followStream(ctx, config, db, processor, head, pause, stats)
+		case config.Follow:
+			err = followPolling(ctx, config, db, processor, head, pause, stats)
+		default:
+			_, err = syncBlocks(ctx, config, db, processor, head, pause, stats)
 		}
-		return nil
+		return err
 	})
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	// Initialize lastProcessed to genesisBlock if it is 0
-	if lastProcessed == 0 {
-		lastProcessed = config.GenesisBlock
+	if ctx.Err() != nil {
+		logShutdown(db, config)
+	} else if runExpired(config, stats) {
+		slog.Info("Stopped after the maximum run duration", "max_run_duration", config.MaxRunDuration)
 	}
 
-	// Calculate initial variance
-	variance := currentBlock - lastProcessed
-	log.Printf("Starting block processing - Current: %d, Last: %d, Variance: %d\n",
-		currentBlock, lastProcessed, variance)
+	snap := stats.Snapshot()
+	slog.Info("Processing complete", "blocks", snap.Processed, "posts_inserted", snap.Inserts,
+		"posts_per_block", snap.PostsPerBlock(), "duration_ms", snap.Elapsed.Milliseconds())
 
-	// Process blocks in batches
-	startTime := time.Now()
-	totalProcessed := 0
-	totalInserts := 0
+	if snap.Errors.total() > 0 {
+		slog.Warn("Errors", "errors", snap.Errors.String())
+	}
 
-	for variance > 0 {
-		startBlock := lastProcessed + 1
-		count := config.BatchSize
-		if startBlock+count > currentBlock {
-			count = currentBlock - startBlock + 1
-		}
+	if skipped := processor.TitleSkipped(); skipped > 0 {
+		slog.Info("Skipped posts whose title matched none of the keywords", "posts", skipped)
+	}
 
-		// Fetch blocks with retry
-		var blocks []Block
-		err := retryWithBackoff(config.MaxRetries, config.RetryDelay, func() error {
-			var err error
-			blocks, err = getBlockRange(config, startBlock, count)
-			return err
-		})
+	if skipped := processor.MalformedSkipped(); skipped > 0 {
+		slog.Info("Skipped posts with a malformed parent permlink", "posts", skipped)
+	}
+
+	if rolledBack := processor.RolledBackPosts(); rolledBack > 0 {
+		slog.Warn("Rolled back posts that failed to store", "posts", rolledBack)
+	}
+
+	if edited := processor.Edited(); edited > 0 {
+		slog.Info("Updated stored posts with their edits", "posts", edited)
+	}
+
+	if dropped := processor.DroppedTags(); dropped > 0 {
+		slog.Info("Dropped invalid tags", "tags", dropped)
+	}
+
+	if latency := processor.PostLatency(); latency.Count > 0 {
+		slog.Info("Post latency", "latency", latency.String())
+	}
+
+	// Nodes are listed slowest first
+	if summary := hiveLatencies.Summary(); len(summary) > 0 {
+		slog.Info("Node latency", "latency", hiveLatencies.String())
+	}
+
+	if regressions := processor.TimestampRegressions(); regressions > 0 {
+		slog.Warn("Blocks had a timestamp earlier than a previous block", "blocks", regressions)
+	}
+
+	if config.ValidateMetadata {
+		stats := processor.MetadataStats()
+		slog.Info("Metadata validation", "posts", stats.Total(), "valid_json", stats.Valid,
+			"single_string_fallback", stats.Fallback, "unparsable_tags", stats.Unparsable, "empty", stats.Empty)
+	}
+	return nil
+}
+
+// logShutdown logs the last fully processed block after processing was stopped
+// by a signal. For a reverse run this is the lowest processed block.
+func logShutdown(db *sql.DB, config *Config) {
+	if config.Reverse {
+		low, err := getSyncState(db, syncStateReverseLow)
 		if err != nil {
-			log.Printf("Error getting blocks: %v\n", err)
-			continue
+			slog.Error("Shutting down", "error", err)
+			return
 		}
+		slog.Info("Shutting down", "lowest_processed_block", low)
+		return
+	}
+
+	last, err := getForwardCheckpoint(db, config.GenesisBlock)
+	if err != nil {
+		slog.Error("Shutting down", "error", err)
+		return
+	}
+	slog.Info("Shutting down", "last_processed_block", last)
+}
 
-		batchStartTime := time.Now()
-		batchInserts := 0
-
-		for _, block := range blocks {
-			if block.BlockNum == "0" {
-				continue
-			}
-
-			insertCount, err := processor.processBlock(block)
-			if err != nil {
-				log.Printf("Error processing block %s: %v\n", block.BlockNum, err)
-				continue
-			}
-
-			// Update progress tracking
-			hexBlockNum := block.BlockNum[:8]
-			blockNum, _ := strconv.ParseInt(hexBlockNum, 16, 32)
-			lastProcessed = int(blockNum)
-			batchInserts += insertCount
-			totalProcessed++
+// processWithRestarts runs process on the database and processor, reopening
+// both and running it again when it fails with a recoverable database error (see
+// isRecoverableDBError), up to config.MaxRestarts times. It returns the database
+// and processor in use, which are nil if reopening them failed. A process
+// interrupted by a shutdown signal counts as a success.
+func processWithRestarts(config *Config, db *sql.DB, processor *BlockProcessor, stats *Stats, process func(*sql.DB, *BlockProcessor) error) (*sql.DB, *BlockProcessor, error) {
+	restarts := 0
+	for {
+		err := process(db, processor)
+		// An API call interrupted by a shutdown signal ends the run like a clean stop
+		if err == nil || errors.Is(err, context.Canceled) {
+			return db, processor, nil
 		}
+		if !isRecoverableDBError(err) || restarts >= config.MaxRestarts {
+			return db, processor, err
+		}
+
+		restarts++
+		stats.RecordError(err)
+		slog.Error("Database error, reconnecting", "error", err, "restart", restarts, "max_restarts", config.MaxRestarts)
+		processor.Close()
+		db.Close()
 
-		totalInserts += batchInserts
-		batchDuration := time.Since(batchStartTime)
-		totalDuration := time.Since(startTime)
-		percentage := float64(lastProcessed-config.GenesisBlock) / float64(currentBlock-config.GenesisBlock) * 100
+		db, processor, err = openStore(config)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+}
 
-		// Log progress with detailed statistics
-		log.Printf("Progress: %.2f%% | Block: %d | Batch: %d blocks, %d posts in %.2fs (%.1f blocks/s) | Total: %d blocks, %d posts in %.0fs\n",
-			percentage, startBlock, len(blocks), batchInserts, batchDuration.Seconds(),
-			float64(len(blocks))/batchDuration.Seconds(),
-			totalProcessed, totalInserts, totalDuration.Seconds())
+// openStore initializes the database with retry and creates the block processor
+// that writes to it
+func openStore(config *Config) (*sql.DB, *BlockProcessor, error) {
+	var db *sql.DB
+	err := retryWithBackoff(config.MaxRetries, config.RetryDelay, func() error {
+		var err error
+		db, err = initDB(config)
+		return err
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error initializing database: %w", err)
+	}
 
-		// Recalculate variance
-		variance = currentBlock - lastProcessed
+	processor, err := NewBlockProcessor(db, config)
+	if err != nil {
+		db.Close()
+		return nil, nil, fmt.Errorf("error creating block processor: %w", err)
 	}
 
-	log.Printf("Processing complete - Total blocks: %d, Total posts: %d, Time: %.0fs\n",
-		totalProcessed, totalInserts, time.Since(startTime).Seconds())
+	return db, processor, nil
 }
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func TestProcessWithRestarts(t *testing.T) {
+	broken := fmt.Errorf("error inserting post: %w", sqlite3.Error{Code: sqlite3.ErrIoErr})
+
+	tests := []struct {
+		name        string
+		maxRestarts int
+		failures    []error
+		wantCalls   int
+		wantErr     bool
+	}{
+		{"recovers from a broken connection", 5, []error{broken}, 2, false},
+		{"recovers from a closed connection", 5, []error{sql.ErrConnDone, broken}, 3, false},
+		{"other errors are not retried", 5, []error{errors.New("bad config")}, 1, true},
+		{"restart budget used up", 1, []error{broken, broken}, 2, true},
+		{"restarts disabled", 0, []error{broken}, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, bp := newTestProcessor(t, func(c *Config) { c.MaxRestarts = tt.maxRestarts })
+
+			calls := 0
+			var dbs []*sql.DB
+			db, processor, err := processWithRestarts(bp.config, first, bp, NewStats(), func(db *sql.DB, processor *BlockProcessor) error {
+				calls++
+				dbs = append(dbs, db)
+				if calls <= len(tt.failures) {
+					return tt.failures[calls-1]
+				}
+				_, err := processor.processBlock(context.Background(), testBlock(100, testPost("alice", "first", "First")))
+				return err
+			})
+			if processor != nil && processor != bp {
+				defer func() {
+					processor.Close()
+					db.Close()
+				}()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("processWithRestarts() error = %v, want error %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("process ran %d times, want %d", calls, tt.wantCalls)
+			}
+			for i, used := range dbs[:len(dbs)-1] {
+				if used.Ping() == nil {
+					t.Errorf("database of run %d was not closed before reconnecting", i+1)
+				}
+			}
+			if tt.wantErr {
+				return
+			}
+
+			var posts int
+			if err := db.QueryRow("SELECT COUNT(*) FROM posts").Scan(&posts); err != nil {
+				t.Fatal(err)
+			}
+			if posts != 1 {
+				t.Errorf("stored %d posts after reconnecting, want 1", posts)
+			}
+		})
+	}
+}
+
+// cancelStore cancels a run once it receives the post at url, as a shutdown
+// signal arriving while that post's block is processed would
+type cancelStore struct {
+	url    string
+	cancel context.CancelFunc
+}
+
+func (s *cancelStore) StorePost(post ExportedPost) error {
+	if post.URL == s.url {
+		s.cancel()
+	}
+	return nil
+}
+
+func (s *cancelStore) Close() error {
+	return nil
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	var logs syncBuffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	db, bp := newTestProcessor(t, func(c *Config) {
+		c.HiveAPIURLs = []string{testNode(t, 130, nil)}
+		c.GenesisBlock = 100
+		c.BatchSize = 10
+		c.InitialBatchSize = 0
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// The signal arrives while block 105, in the middle of the first batch, is
+	// processed
+	bp.outputs = NewMultiStore(&cancelStore{url: "@alice/post-105", cancel: cancel})
+
+	done := make(chan error, 1)
+	var last int64
+	go func() {
+		var err error
+		last, err = syncBlocks(ctx, bp.config, db, bp, newHeadCache(bp.config), &pauseControl{}, NewStats())
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("syncBlocks() = %v after a shutdown signal, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("syncBlocks() did not stop after a shutdown signal")
+	}
+
+	// The block in flight is finished and committed, and nothing after it is
+	// processed
+	if last != 105 {
+		t.Errorf("syncBlocks() = block %d, want 105", last)
+	}
+	bp.Close()
+	var posts, maxBlock int64
+	if err := db.QueryRow("SELECT COUNT(*), MAX(block_num) FROM posts").Scan(&posts, &maxBlock); err != nil {
+		t.Fatal(err)
+	}
+	if posts != 5 || maxBlock != 105 {
+		t.Errorf("stored %d posts up to block %d, want 5 up to block 105", posts, maxBlock)
+	}
+
+	logShutdown(db, bp.config)
+	if !strings.Contains(logs.String(), "msg=\"Shutting down\" last_processed_block=105") {
+		t.Errorf("logs do not report the last processed block:\n%s", logs.String())
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// migrationLockPoll is how often an instance waiting for the migration lock
+// checks whether it has been released
+const migrationLockPoll = time.Millisecond * 200
+
+// acquireMigrationLock takes the advisory lock that serializes schema migrations
+// between indexer instances sharing a database. The lock is the single row of the
+// migration_lock table; an instance finding it held waits until it is released,
+// or until it is older than timeout, in which case its holder is assumed to have
+// crashed and the lock is taken over. The returned function releases the lock.
+func acquireMigrationLock(ctx context.Context, conn *sql.Conn, timeout time.Duration) (func(), error) {
+	_, err := conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS migration_lock (
+			id INTEGER PRIMARY KEY CHECK (id = 1),
+			owner TEXT,
+			acquired_at INTEGER
+		)
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("error creating migration lock: %v", err)
+	}
+
+	hostname, _ := os.Hostname()
+	owner := fmt.Sprintf("%s:%d:%d", hostname, os.Getpid(), time.Now().UnixNano())
+
+	waiting := false
+	for {
+		now := time.Now()
+		stale, err := conn.ExecContext(ctx, "DELETE FROM migration_lock WHERE acquired_at < ?", now.Add(-timeout).Unix())
+		if err != nil {
+			return nil, fmt.Errorf("error acquiring migration lock: %v", err)
+		}
+		if n, _ := stale.RowsAffected(); n > 0 {
+			slog.Warn("Took over a stale migration lock", "timeout", timeout)
+		}
+
+		res, err := conn.ExecContext(ctx, `
+			INSERT INTO migration_lock (id, owner, acquired_at) VALUES (1, ?, ?)
+			ON CONFLICT(id) DO NOTHING
+		`, owner, now.Unix())
+		if err != nil {
+			return nil, fmt.Errorf("error acquiring migration lock: %v", err)
+		}
+		if n, _ := res.RowsAffected(); n == 1 {
+			break
+		}
+
+		if !waiting {
+			slog.Info("Waiting for another instance to finish migrating the database")
+			waiting = true
+		}
+		time.Sleep(migrationLockPoll)
+	}
+
+	release := func() {
+		if _, err := conn.ExecContext(ctx, "DELETE FROM migration_lock WHERE owner = ?", owner); err != nil {
+			slog.Error("Error releasing migration lock", "error", err)
+		}
+	}
+	return release, nil
+}
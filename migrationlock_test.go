@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMigrationLockConcurrentStartups(t *testing.T) {
+	config := DefaultConfig()
+	config.DBPath = filepath.Join(t.TempDir(), "posts.db")
+
+	const instances = 4
+	var wg sync.WaitGroup
+	errs := make(chan error, instances)
+	for i := 0; i < instances; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			db, err := initDB(config)
+			if err == nil {
+				db.Close()
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("initDB() of a concurrent startup = %v", err)
+		}
+	}
+
+	db, err := initDB(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	version, err := getSchemaVersion(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var locks int
+	if err := db.QueryRow("SELECT COUNT(*) FROM migration_lock").Scan(&locks); err != nil {
+		t.Fatal(err)
+	}
+	if version != schemaVersion || locks != 0 {
+		t.Errorf("schema version %d with %d locks held, want %d with none", version, locks, schemaVersion)
+	}
+}
+
+func TestMigrationLockWaits(t *testing.T) {
+	config := DefaultConfig()
+	config.DBPath = filepath.Join(t.TempDir(), "posts.db")
+
+	// Another instance holds the lock while it migrates
+	other, err := sql.Open("sqlite3", config.DBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	conn, err := other.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	release, err := acquireMigrationLock(context.Background(), conn, config.MigrationLockTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		db, err := initDB(config)
+		if err == nil {
+			db.Close()
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("initDB() = %v while another instance held the migration lock, want it to wait", err)
+	case <-time.After(3 * migrationLockPoll):
+	}
+	var tables int
+	if err := other.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'posts'").Scan(&tables); err != nil {
+		t.Fatal(err)
+	}
+	if tables != 0 {
+		t.Error("the waiting instance created the posts table before the lock was released")
+	}
+
+	release()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("initDB() = %v after the migration lock was released", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("initDB() did not take the released migration lock")
+	}
+}
+
+func TestMigrationLockStale(t *testing.T) {
+	config := DefaultConfig()
+	config.DBPath = filepath.Join(t.TempDir(), "posts.db")
+	config.MigrationLockTimeout = time.Minute
+
+	// An instance that crashed while migrating left its lock behind
+	other, err := sql.Open("sqlite3", config.DBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if _, err := other.Exec(`
+		CREATE TABLE migration_lock (id INTEGER PRIMARY KEY CHECK (id = 1), owner TEXT, acquired_at INTEGER);
+		INSERT INTO migration_lock VALUES (1, 'crashed', ?);
+	`, time.Now().Add(-time.Hour).Unix()); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		db, err := initDB(config)
+		if err == nil {
+			db.Close()
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("initDB() = %v with a stale migration lock", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("initDB() did not take over a stale migration lock")
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// nodePool spreads requests round-robin across the configured API nodes. A node
+// that fails maxFailures times in a row is skipped for cooldown, after which it
+// gets another chance. It is safe for concurrent use.
+type nodePool struct {
+	mu          sync.Mutex
+	nodes       []string
+	next        int
+	failures    []int
+	skipUntil   []time.Time
+	maxFailures int
+	cooldown    time.Duration
+}
+
+// newNodePool creates a nodePool cycling through nodes, starting at the first.
+// A maxFailures of zero never skips a node.
+func newNodePool(nodes []string, maxFailures int, cooldown time.Duration) *nodePool {
+	return &nodePool{
+		nodes:       nodes,
+		failures:    make([]int, len(nodes)),
+		skipUntil:   make([]time.Time, len(nodes)),
+		maxFailures: maxFailures,
+		cooldown:    cooldown,
+	}
+}
+
+// Sweep returns the order in which a request tries the nodes: the healthy nodes
+// in rotation order, or all of them when every node is being skipped, since a
+// request to a node that has been failing still beats no request at all. The
+// rotation moves on past the first node of the sweep, so concurrent requests start
+// their sweeps at different nodes while every sweep still covers each node once.
+func (p *nodePool) Sweep() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	sweep := make([]string, 0, len(p.nodes))
+	for i := 0; i < len(p.nodes); i++ {
+		idx := (p.next + i) % len(p.nodes)
+		if !now.Before(p.skipUntil[idx]) {
+			sweep = append(sweep, p.nodes[idx])
+		}
+	}
+	if len(sweep) == 0 {
+		for i := 0; i < len(p.nodes); i++ {
+			sweep = append(sweep, p.nodes[(p.next+i)%len(p.nodes)])
+		}
+	}
+	p.next = (p.index(sweep[0]) + 1) % len(p.nodes)
+	return sweep
+}
+
+// Succeeded resets the consecutive failures of node
+func (p *nodePool) Succeeded(node string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if idx := p.index(node); idx >= 0 {
+		p.failures[idx] = 0
+	}
+}
+
+// Failed records a failed request to node, skipping the node for the cooldown
+// once it has failed maxFailures times in a row
+func (p *nodePool) Failed(node string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	idx := p.index(node)
+	if idx < 0 {
+		return
+	}
+	p.failures[idx]++
+	if p.maxFailures > 0 && p.failures[idx] >= p.maxFailures {
+		slog.Warn("Skipping node", "node", node, "cooldown", p.cooldown, "failures", p.failures[idx])
+		p.failures[idx] = 0
+		p.skipUntil[idx] = time.Now().Add(p.cooldown)
+	}
+}
+
+// index returns the position of node in the pool, or -1 if it isn't part of it
+func (p *nodePool) index(node string) int {
+	for i, n := range p.nodes {
+		if n == node {
+			return i
+		}
+	}
+	return -1
+}
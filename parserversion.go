@@ -0,0 +1,62 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// parserVersion identifies how posts are parsed from operations and is stored
+// with every post. It must be incremented whenever a change to the parsing would
+// store different values for the same operation, so rows written by older code
+// can be found.
+const parserVersion = 1
+
+// checkParserVersion looks for posts stored by an older parser version at
+// startup. Depending on OnParserUpgrade, it only logs how many there are or also
+// adds them to the reprocess_queue table, from which they can be refreshed.
+// Posts stored before the version was recorded count as version 0.
+func checkParserVersion(db *sql.DB, config *Config) error {
+	tables, err := postTables(db)
+	if err != nil {
+		return err
+	}
+
+	outdated := 0
+	queued := int64(0)
+	for _, table := range tables {
+		var n int
+		err := db.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE COALESCE(parser_version, 0) < ?", parserVersion).Scan(&n)
+		if err != nil {
+			return fmt.Errorf("error counting outdated posts: %v", err)
+		}
+		outdated += n
+		if n == 0 || config.OnParserUpgrade != ParserUpgradeQueue {
+			continue
+		}
+
+		result, err := db.Exec(`
+			INSERT OR IGNORE INTO reprocess_queue (url, block_num, parser_version, queued_at)
+			SELECT url, block_num, COALESCE(parser_version, 0), ? FROM `+table+`
+			WHERE COALESCE(parser_version, 0) < ?
+		`, time.Now().UTC().Format(time.RFC3339), parserVersion)
+		if err != nil {
+			return fmt.Errorf("error queueing outdated posts: %v", err)
+		}
+		if n, err := result.RowsAffected(); err == nil {
+			queued += n
+		}
+	}
+
+	if outdated == 0 {
+		return nil
+	}
+	if config.OnParserUpgrade == ParserUpgradeQueue {
+		slog.Info("Posts stored by an older parser queued for reprocessing",
+			"outdated", outdated, "parser_version", parserVersion, "queued", queued)
+	} else {
+		slog.Warn("Posts stored by an older parser", "outdated", outdated, "parser_version", parserVersion)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestCheckParserVersion(t *testing.T) {
+	tests := []struct {
+		mode       string
+		wantQueued []string
+	}{
+		{ParserUpgradeWarn, []string{}},
+		{ParserUpgradeQueue, []string{"@alice/unversioned:0", "@bob/outdated:0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			db, bp := newTestProcessor(t, func(c *Config) { c.OnParserUpgrade = tt.mode })
+			if _, err := bp.processBlock(context.Background(), testBlock(100,
+				testPost("alice", "unversioned", "Unversioned"),
+				testPost("bob", "outdated", "Outdated"),
+				testPost("carol", "current", "Current"),
+			)); err != nil {
+				t.Fatal(err)
+			}
+			// Simulate a parser version bump: the posts stored before the version
+			// was recorded and those marked as version 0 are outdated
+			if _, err := db.Exec(`
+				UPDATE posts SET parser_version = NULL WHERE url = '@alice/unversioned';
+				UPDATE posts SET parser_version = 0 WHERE url = '@bob/outdated';
+			`); err != nil {
+				t.Fatal(err)
+			}
+
+			// Startup checks run on every start, so the queue must not fill up
+			for i := 0; i < 2; i++ {
+				if err := checkParserVersion(db, bp.config); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			rows, err := db.Query("SELECT url || ':' || parser_version FROM reprocess_queue ORDER BY url")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+			queued := []string{}
+			for rows.Next() {
+				var entry string
+				if err := rows.Scan(&entry); err != nil {
+					t.Fatal(err)
+				}
+				queued = append(queued, entry)
+			}
+			if !reflect.DeepEqual(queued, tt.wantQueued) {
+				t.Errorf("reprocess_queue holds %v, want %v", queued, tt.wantQueued)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// postsView is the view combining the posts table with its monthly partitions.
+// Queries that should see every post read from it instead of posts.
+const postsView = "posts_all"
+
+// partitionPattern matches the names of the monthly partition tables
+const partitionPattern = "posts_[0-9][0-9][0-9][0-9][0-9][0-9]"
+
+// partitionTable returns the table a post is stored in when PartitionByMonth is
+// enabled: "posts_YYYYMM" for the month of its timestamp, or the posts table itself
+// for a post without a valid timestamp.
+func partitionTable(row *postRow) string {
+	if !row.epoch.Valid {
+		return "posts"
+	}
+	return "posts_" + time.Unix(row.epoch.Int64, 0).UTC().Format("200601")
+}
+
+// postTables returns the posts table followed by its monthly partitions in
+// chronological order
+func postTables(db sqlExecer) ([]string, error) {
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name GLOB ? ORDER BY name", partitionPattern)
+	if err != nil {
+		return nil, fmt.Errorf("error listing partitions: %v", err)
+	}
+	defer rows.Close()
+
+	tables := []string{"posts"}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("error listing partitions: %v", err)
+		}
+		tables = append(tables, name)
+	}
+	return tables, rows.Err()
+}
+
+// createPartition creates a monthly partition table with the schema and indexes
+// of the posts table, if it doesn't exist yet, and adds it to the posts view
+func createPartition(db sqlExecer, table string) error {
+	_, err := db.Exec(fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %[1]s (%[2]s);
+		CREATE INDEX IF NOT EXISTS idx_%[1]s_block_num ON %[1]s(block_num);
+		CREATE INDEX IF NOT EXISTS idx_%[1]s_author ON %[1]s(author);
+		CREATE INDEX IF NOT EXISTS idx_%[1]s_tag_count ON %[1]s(tag_count);
+	`, table, postsTableColumns))
+	if err != nil {
+		return fmt.Errorf("error creating partition %s: %v", table, err)
+	}
+	return refreshPostsView(db)
+}
+
+// refreshPostsView recreates the posts view as the union of the posts table and
+// all of its partitions.
+//
+// Columns are listed explicitly because migrated tables may have them in a
+// different order than freshly created partitions.
+func refreshPostsView(db sqlExecer) error {
+	tables, err := postTables(db)
+	if err != nil {
+		return err
+	}
+
+	selects := make([]string, len(tables))
+	for i, table := range tables {
+		selects[i] = "SELECT _id, " + postColumns + " FROM " + table
+	}
+	_, err = db.Exec("DROP VIEW IF EXISTS " + postsView + "; CREATE VIEW " + postsView + " AS " +
+		strings.Join(selects, " UNION ALL "))
+	if err != nil {
+		return fmt.Errorf("error creating %s view: %v", postsView, err)
+	}
+	return nil
+}
+
+// insertStmt returns the prepared insert statement for the table row belongs in,
+// creating the partition on first use when PartitionByMonth is enabled. Within a
+// batch transaction, the statement runs in the transaction.
+func (bp *BlockProcessor) insertStmt(row *postRow) (*sql.Stmt, error) {
+	if !bp.config.PartitionByMonth {
+		return bp.txStmt(bp.stmt), nil
+	}
+
+	table := partitionTable(row)
+	if table == "posts" {
+		return bp.txStmt(bp.stmt), nil
+	}
+	if stmt, ok := bp.partitionStmts[table]; ok {
+		return bp.txStmt(stmt), nil
+	}
+	if stmt, ok := bp.txPartitionStmts[table]; ok {
+		return stmt, nil
+	}
+
+	if err := createPartition(bp.execer(), table); err != nil {
+		return nil, err
+	}
+	// A partition created in a batch transaction only exists in the transaction
+	// until it commits, so its statement is prepared in the transaction and
+	// prepared for good by Commit
+	if bp.tx != nil {
+		stmt, err := bp.tx.Prepare(insertPostSQL(table))
+		if err != nil {
+			return nil, fmt.Errorf("error preparing statement for %s: %v", table, err)
+		}
+		bp.txPartitionStmts[table] = stmt
+		return stmt, nil
+	}
+	stmt, err := bp.db.Prepare(insertPostSQL(table))
+	if err != nil {
+		return nil, fmt.Errorf("error preparing statement for %s: %v", table, err)
+	}
+	bp.partitionStmts[table] = stmt
+	return stmt, nil
+}
+
+// closePartitionStmts closes the partition insert statements of stmts
+func closePartitionStmts(stmts map[string]*sql.Stmt) {
+	for _, stmt := range stmts {
+		stmt.Close()
+	}
+}
+
+// storedTable returns the table among posts and its partitions that already holds
+// the post with the given url, or "" if it isn't stored yet.
+//
+// A post is edited with a comment operation of its own, which may come months
+// after the post. With PartitionByMonth such an edit belongs in a different
+// partition than the post, whose ON CONFLICT clause can't see the stored post,
+// so new posts are first looked up across all tables.
+func (bp *BlockProcessor) storedTable(url string) (string, error) {
+	tables, err := postTables(bp.execer())
+	if err != nil {
+		return "", err
+	}
+
+	selects := make([]string, len(tables))
+	args := make([]interface{}, len(tables))
+	for i, table := range tables {
+		selects[i] = "SELECT '" + table + "' FROM " + table + " WHERE url = ?"
+		args[i] = url
+	}
+	var table string
+	err = bp.execer().QueryRow(strings.Join(selects, " UNION ALL ")+" LIMIT 1", args...).Scan(&table)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error looking up %s: %v", url, err)
+	}
+	return table, nil
+}
+
+// handleStoredPost handles a new version of a post that storedTable found in
+// table: it is applied as an edit with UpdateOnConflict and dropped otherwise,
+// just as ON CONFLICT would handle it within a single table
+func (bp *BlockProcessor) handleStoredPost(table string, row *postRow) error {
+	if !bp.config.UpdateOnConflict {
+		return nil
+	}
+	return bp.updatePost(table, row)
+}
+
+// deleteFromPartitions deletes the post with the given url from the posts table
+// and every partition, since the month it was stored under isn't known
+func (bp *BlockProcessor) deleteFromPartitions(url string) error {
+	tables, err := postTables(bp.execer())
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		if _, err := bp.execer().Exec("DELETE FROM "+table+" WHERE url = ?", url); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestPartitionByMonth(t *testing.T) {
+	db, bp := newTestProcessor(t, func(c *Config) { c.PartitionByMonth = true })
+	blocks := []struct {
+		block       Block
+		wantInserts int
+	}{
+		{testBlock(100, testPost("alice", "january", "January")), 1},
+		{testBlock(101, testPost("bob", "february", "February")), 1},
+		// A post stored in an earlier month is not stored again
+		{testBlock(102, testPost("alice", "january", "January again"), testPost("carol", "march", "March")), 1},
+	}
+	for i, timestamp := range []string{"2024-01-31T23:59:59", "2024-02-01T00:00:01", "2024-03-15T12:00:00"} {
+		blocks[i].block.Timestamp = timestamp
+	}
+	for _, b := range blocks {
+		inserts, err := bp.processBlock(context.Background(), b.block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if inserts != b.wantInserts {
+			t.Errorf("block %s inserted %d posts, want %d", b.block.BlockNum, inserts, b.wantInserts)
+		}
+	}
+
+	urls := func(table string) []string {
+		t.Helper()
+		rows, err := db.Query(fmt.Sprintf("SELECT url FROM %s ORDER BY url", table))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		result := []string{}
+		for rows.Next() {
+			var url string
+			if err := rows.Scan(&url); err != nil {
+				t.Fatal(err)
+			}
+			result = append(result, url)
+		}
+		return result
+	}
+
+	want := map[string][]string{
+		"posts":        {},
+		"posts_202401": {"@alice/january"},
+		"posts_202402": {"@bob/february"},
+		"posts_202403": {"@carol/march"},
+		postsView:      {"@alice/january", "@bob/february", "@carol/march"},
+	}
+	for table, wantURLs := range want {
+		if got := urls(table); !reflect.DeepEqual(got, wantURLs) {
+			t.Errorf("%s holds %v, want %v", table, got, wantURLs)
+		}
+	}
+
+	tables, err := postTables(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wantTables := []string{"posts", "posts_202401", "posts_202402", "posts_202403"}; !reflect.DeepEqual(tables, wantTables) {
+		t.Errorf("postTables() = %v, want %v", tables, wantTables)
+	}
+}
+
+func TestPartitionEdits(t *testing.T) {
+	db, bp := newTestProcessor(t, func(c *Config) {
+		c.PartitionByMonth = true
+		c.UpdateOnConflict = true
+	})
+	edit := testPost("alice", "post", "Edited in March")
+	edit.Value.JsonMetadata = `{"tags":["hive","edited"]}`
+	blocks := []Block{
+		testBlock(100, testPost("alice", "post", "Written in January")),
+		testBlock(101, testPost("bob", "other", "Other")),
+		// The edit is made in a later month, whose partition exists already
+		testBlock(102, edit),
+	}
+	for i, timestamp := range []string{"2024-01-31T23:59:59", "2024-03-01T00:00:00", "2024-03-15T12:00:00"} {
+		blocks[i].Timestamp = timestamp
+	}
+	for _, block := range blocks {
+		if _, err := bp.processBlock(context.Background(), block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The edit updates the post in the partition it was stored in, rather than
+	// storing it again in the partition of the edit's month
+	var title, tags string
+	var blockNum int64
+	var count int
+	if err := db.QueryRow("SELECT title, tags, block_num FROM posts_202401 WHERE url = '@alice/post'").
+		Scan(&title, &tags, &blockNum); err != nil {
+		t.Fatalf("the edited post left its partition: %v", err)
+	}
+	if title != "Edited in March" || tags != `["hive","edited"]` || blockNum != 102 {
+		t.Errorf("posts_202401 holds %q, %s from block %d, want the edit from block 102", title, tags, blockNum)
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM posts_202403 WHERE url = '@alice/post'").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Error("the edit was stored again in posts_202403")
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM " + postsView + " WHERE url = '@alice/post'").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("%s holds %d rows for the edited post, want 1", postsView, count)
+	}
+	if edited := bp.Edited(); edited != 1 {
+		t.Errorf("Edited() = %d, want 1", edited)
+	}
+}
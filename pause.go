@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// pauseControl holds the paused state toggled by SIGUSR1 (pause) and SIGUSR2
+// (resume), letting operators pause indexing for maintenance without stopping
+// the process
+type pauseControl struct {
+	mu     sync.Mutex
+	paused bool
+}
+
+// newPauseControl creates a pauseControl and starts listening for the pause and
+// resume signals
+func newPauseControl() *pauseControl {
+	p := &pauseControl{}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range signals {
+			p.SetPaused(sig == syscall.SIGUSR1)
+		}
+	}()
+
+	return p
+}
+
+// SetPaused pauses or resumes indexing
+func (p *pauseControl) SetPaused(paused bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.paused != paused {
+		if paused {
+			slog.Info("Pause requested, indexing will stop after the current batch")
+		} else {
+			slog.Info("Resume requested")
+		}
+	}
+	p.paused = paused
+}
+
+// Paused reports whether indexing is currently paused
+func (p *pauseControl) Paused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused
+}
+
+// Wait blocks while indexing is paused, polling every interval. It is called at
+// batch boundaries, so a pause never interrupts a batch in progress. A shutdown
+// ends the wait, returning ctx.Err().
+func (p *pauseControl) Wait(ctx context.Context, interval time.Duration) error {
+	if !p.Paused() {
+		return nil
+	}
+
+	slog.Info("Indexing paused")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for p.Paused() {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	slog.Info("Indexing resumed")
+	return nil
+}
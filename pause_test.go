@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPauseControlWait(t *testing.T) {
+	p := &pauseControl{}
+	if err := p.Wait(context.Background(), time.Millisecond); err != nil {
+		t.Fatalf("Wait() while running = %v", err)
+	}
+
+	p.SetPaused(true)
+	done := make(chan error, 1)
+	go func() { done <- p.Wait(context.Background(), time.Millisecond) }()
+	select {
+	case err := <-done:
+		t.Fatalf("Wait() returned %v while paused", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	p.SetPaused(false)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Wait() after resuming = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Wait() did not return after resuming")
+	}
+
+	p.SetPaused(true)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := p.Wait(ctx, time.Millisecond); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait() after a shutdown = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestSyncPause(t *testing.T) {
+	var requests atomic.Int32
+	node := testNode(t, 120, func(start int64, count, request int) (int64, int) {
+		requests.Add(1)
+		return start, count
+	})
+
+	pause := &pauseControl{}
+	pause.SetPaused(true)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		if n := requests.Load(); n != 0 {
+			t.Errorf("fetched %d batches while paused", n)
+		}
+		pause.SetPaused(false)
+	}()
+
+	db, bp := newTestProcessor(t, func(c *Config) {
+		c.HiveAPIURLs = []string{node}
+		c.GenesisBlock = 100
+		c.BatchSize = 10
+		c.InitialBatchSize = 0
+	})
+	last, err := syncBlocks(context.Background(), bp.config, db, bp, newHeadCache(bp.config), pause, NewStats())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last != 120 {
+		t.Errorf("synced through block %d after resuming, want 120", last)
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// blockPrefetcher fetches batches of blocks in the background, ahead of the
+// processor, while keeping at most a fixed number of blocks buffered.
+//
+// Batches are planned in ascending order and fetched by a pool of workers, so the
+// requests for several batches can be in flight at once. Fetched batches are
+// still handed to the processor in the order they were planned, which keeps the
+// checkpoint derived from the stored posts correct.
+//
+// Batches are planned from the number of blocks requested, so when a node returns
+// fewer blocks than requested before reaching the head, the batches planned after
+// it would skip the blocks in between. Those batches are dropped, and planning
+// starts over after the last block returned.
+//
+// Blocks count against the limit from the moment their batch is requested until
+// the processor calls Release for it, so the limit bounds every decoded block held
+// in memory, not just the batches waiting in the queue.
+//
+// When maxRows is set, fetching also pauses while the buffered blocks hold that
+// many posts waiting to be stored, so a slow database applies backpressure to the
+// fetcher even when blocks are dense with posts.
+type blockPrefetcher struct {
+	limit   int
+	maxRows int
+	head    int64
+	batches chan fetchedBatch
+	cancel  context.CancelFunc
+	done    chan struct{}
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	ramp     *batchRamp
+	buffered int
+	rows     int
+	stopped  bool
+	// generation is incremented whenever planning starts over at resume; jobs
+	// planned in an earlier generation are dropped
+	generation int
+	resume     int64
+	// finished is set once the batch reaching the end block was delivered
+	finished bool
+}
+
+// prefetchJob is a batch planned for fetching. Every attempt to fetch it is sent
+// on results, which is closed after the attempt that succeeded.
+type prefetchJob struct {
+	startBlock int64
+	count      int
+	generation int
+	results    chan fetchedBatch
+}
+
+// newBlockPrefetcher starts fetching the blocks from startBlock up to and
+// including endBlock with config.PrefetchWorkers workers, keeping up to
+// config.PrefetchBlocks blocks buffered. head is the head block the range was
+// planned against.
+func newBlockPrefetcher(ctx context.Context, config *Config, startBlock, endBlock, head int64) *blockPrefetcher {
+	ctx, cancel := context.WithCancel(ctx)
+	p := &blockPrefetcher{
+		limit:   config.PrefetchBlocks,
+		maxRows: config.MaxBufferedRows,
+		head:    head,
+		batches: make(chan fetchedBatch),
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		ramp:    newBatchRamp(config),
+	}
+	p.cond = sync.NewCond(&p.mu)
+
+	workers := config.PrefetchWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	jobs := make(chan *prefetchJob)
+	order := make(chan *prefetchJob, workers)
+
+	var wg sync.WaitGroup
+	wg.Add(workers + 2)
+	go func() {
+		defer wg.Done()
+		p.plan(ctx, startBlock, endBlock, jobs, order)
+	}()
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			p.fetch(ctx, config, jobs)
+		}()
+	}
+	go func() {
+		defer wg.Done()
+		p.deliver(ctx, endBlock, order)
+	}()
+	go func() {
+		wg.Wait()
+		close(p.done)
+	}()
+	return p
+}
+
+// plan splits the blocks up to endBlock into batches as buffer space becomes
+// available, queueing each for the workers and, in the same order, for delivery.
+// Once every batch is planned, it waits until the last one is delivered, as a
+// short delivery may still make it start over.
+func (p *blockPrefetcher) plan(ctx context.Context, startBlock, endBlock int64, jobs, order chan<- *prefetchJob) {
+	defer close(jobs)
+	defer close(order)
+
+	generation := 0
+	for {
+		p.mu.Lock()
+		for !p.stopped && !p.finished && p.generation == generation && startBlock > endBlock {
+			p.cond.Wait()
+		}
+		if p.stopped || p.finished {
+			p.mu.Unlock()
+			return
+		}
+		if p.generation != generation {
+			generation, startBlock = p.generation, p.resume
+		}
+		count := p.ramp.Size()
+		p.mu.Unlock()
+		if startBlock+int64(count) > endBlock {
+			count = int(endBlock - startBlock + 1)
+		}
+		count = p.reserve(count)
+		if count == 0 {
+			return
+		}
+
+		job := &prefetchJob{startBlock: startBlock, count: count, generation: generation, results: make(chan fetchedBatch, 1)}
+		select {
+		case order <- job:
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case jobs <- job:
+		case <-ctx.Done():
+			return
+		}
+		startBlock += int64(count)
+	}
+}
+
+// fetch fetches the batches of the jobs it receives. A batch that fails to fetch
+// is handed on so the error is reported, and then requested again. A job that was
+// dropped is no longer fetched.
+func (p *blockPrefetcher) fetch(ctx context.Context, config *Config, jobs <-chan *prefetchJob) {
+	for job := range jobs {
+		for !p.dropped(job) {
+			batch := fetchBatch(ctx, config, job.startBlock, job.count, p.head)
+			batch.rows = countPosts(batch.blocks)
+			p.mu.Lock()
+			p.rows += batch.rows
+			if batch.err == nil {
+				p.ramp.Succeeded()
+			}
+			p.mu.Unlock()
+
+			select {
+			case job.results <- batch:
+			case <-ctx.Done():
+				return
+			}
+			if batch.err == nil {
+				break
+			}
+		}
+		close(job.results)
+	}
+}
+
+// dropped returns whether job was planned before planning last started over
+func (p *blockPrefetcher) dropped(job *prefetchJob) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return job.generation != p.generation
+}
+
+// deliver hands the fetched batches to Next in the order they were planned,
+// waiting for each job to be fetched before moving on to the next. After a batch
+// that ends short of both its range and the head, planning starts over after its
+// last block and the jobs planned before that are dropped.
+func (p *blockPrefetcher) deliver(ctx context.Context, endBlock int64, order <-chan *prefetchJob) {
+	defer close(p.batches)
+
+	for job := range order {
+		if p.dropped(job) {
+			p.drop(ctx, job)
+			continue
+		}
+		for {
+			var batch fetchedBatch
+			var ok bool
+			select {
+			case batch, ok = <-job.results:
+			case <-ctx.Done():
+				return
+			}
+			if !ok {
+				break
+			}
+
+			select {
+			case p.batches <- batch:
+			case <-ctx.Done():
+				return
+			}
+			if batch.err != nil {
+				continue
+			}
+
+			next := batch.startBlock + int64(len(batch.blocks))
+			p.mu.Lock()
+			if len(batch.blocks) < batch.count && next <= p.head {
+				p.generation++
+				p.resume = next
+			} else if next > endBlock || batch.startBlock+int64(batch.count) > endBlock {
+				p.finished = true
+			}
+			p.mu.Unlock()
+			p.cond.Broadcast()
+		}
+	}
+}
+
+// drop waits for the attempts of a dropped job to finish and returns the buffer
+// space claimed for it
+func (p *blockPrefetcher) drop(ctx context.Context, job *prefetchJob) {
+	for {
+		select {
+		case batch, ok := <-job.results:
+			if !ok {
+				p.mu.Lock()
+				p.buffered -= job.count
+				p.mu.Unlock()
+				p.cond.Broadcast()
+				return
+			}
+			p.mu.Lock()
+			p.rows -= batch.rows
+			p.mu.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// reserve waits until buffer space is available and fewer than maxRows posts are
+// buffered, and claims up to count blocks of the space, returning the number
+// claimed, or 0 once the prefetcher is stopped
+func (p *blockPrefetcher) reserve(count int) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for !p.stopped && (p.buffered >= p.limit || (p.maxRows > 0 && p.rows >= p.maxRows)) {
+		p.cond.Wait()
+	}
+	if p.stopped {
+		return 0
+	}
+	if free := p.limit - p.buffered; count > free {
+		count = free
+	}
+	p.buffered += count
+	return count
+}
+
+// Next returns the next fetched batch, waiting for it if necessary. It returns
+// false once every batch up to the end block has been returned.
+func (p *blockPrefetcher) Next() (fetchedBatch, bool) {
+	batch, ok := <-p.batches
+	return batch, ok
+}
+
+// Release returns the buffer space of a batch once it has been processed. A batch
+// that failed to fetch keeps its space, since the same blocks are requested again.
+func (p *blockPrefetcher) Release(batch fetchedBatch) {
+	p.mu.Lock()
+	if batch.err == nil {
+		p.buffered -= batch.count
+	}
+	p.rows -= batch.rows
+	p.mu.Unlock()
+	p.cond.Signal()
+}
+
+// Buffered returns the number of blocks currently counted against the limit
+func (p *blockPrefetcher) Buffered() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.buffered
+}
+
+// BufferedRows returns the number of posts in the buffered blocks
+func (p *blockPrefetcher) BufferedRows() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.rows
+}
+
+// countPosts returns the number of top-level posts in a list of blocks, which is
+// the number of rows they may add to the posts table
+func countPosts(blocks []Block) int {
+	count := 0
+	for _, block := range blocks {
+		for _, tx := range block.Transactions {
+			for _, op := range tx.Operations {
+				if op.Type == "comment_operation" && op.Value.ParentAuthor == "" {
+					count++
+				}
+			}
+		}
+	}
+	return count
+}
+
+// Stop stops fetching and waits for the background fetcher to exit
+func (p *blockPrefetcher) Stop() {
+	p.cancel()
+	p.mu.Lock()
+	p.stopped = true
+	p.mu.Unlock()
+	p.cond.Broadcast()
+	<-p.done
+}
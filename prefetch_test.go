@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBlockPrefetcherShortBatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		short   int
+		workers int
+	}{
+		{"first batch short", 0, 1},
+		{"first batch short with several workers", 0, 3},
+		{"later batch short", 2, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := testNode(t, 160, func(start int64, count, request int) (int64, int) {
+				if request == tt.short {
+					return start, count / 2
+				}
+				return start, count
+			})
+			config := DefaultConfig()
+			config.HiveAPIURLs = []string{node}
+			config.BatchSize = 10
+			config.InitialBatchSize = 0
+			config.ValidateBlockCount = false
+			config.PrefetchBlocks = 30
+			config.PrefetchWorkers = tt.workers
+			config.MaxRetries = 1
+			config.RetryDelay = time.Millisecond
+
+			p := newBlockPrefetcher(context.Background(), config, 101, 160, 160)
+			defer p.Stop()
+
+			next := int64(101)
+			for {
+				batch, ok := p.Next()
+				if !ok {
+					break
+				}
+				if batch.err != nil {
+					t.Fatal(batch.err)
+				}
+				if batch.startBlock != next {
+					t.Fatalf("batch starts at block %d, want %d", batch.startBlock, next)
+				}
+				next += int64(len(batch.blocks))
+				p.Release(batch)
+			}
+			if next != 161 {
+				t.Errorf("delivered blocks up to %d, want 160", next-1)
+			}
+			if buffered := p.Buffered(); buffered != 0 {
+				t.Errorf("Buffered() = %d after every batch was released, want 0", buffered)
+			}
+		})
+	}
+}
+
+func TestBlockPrefetcherLimit(t *testing.T) {
+	const limit = 25
+	var mu sync.Mutex
+	var requested, released, maxHeld int
+	node := testNode(t, 200, func(start int64, count, request int) (int64, int) {
+		mu.Lock()
+		requested += count
+		if held := requested - released; held > maxHeld {
+			maxHeld = held
+		}
+		mu.Unlock()
+		return start, count
+	})
+	config := DefaultConfig()
+	config.HiveAPIURLs = []string{node}
+	config.BatchSize = 10
+	config.InitialBatchSize = 0
+	config.PrefetchBlocks = limit
+	config.PrefetchWorkers = 3
+	config.MaxRetries = 1
+	config.RetryDelay = time.Millisecond
+
+	p := newBlockPrefetcher(context.Background(), config, 101, 200, 200)
+	defer p.Stop()
+
+	delivered := 0
+	for {
+		batch, ok := p.Next()
+		if !ok {
+			break
+		}
+		if batch.err != nil {
+			t.Fatal(batch.err)
+		}
+		// A slow processor lets the workers fill the buffer up
+		time.Sleep(5 * time.Millisecond)
+		if buffered := p.Buffered(); buffered > limit {
+			t.Errorf("Buffered() = %d, over the limit of %d", buffered, limit)
+		}
+		delivered += len(batch.blocks)
+		mu.Lock()
+		p.Release(batch)
+		released += batch.count
+		mu.Unlock()
+	}
+
+	if delivered != 100 {
+		t.Errorf("delivered %d blocks, want 100", delivered)
+	}
+	if maxHeld > limit {
+		t.Errorf("up to %d blocks were requested but not released, over the limit of %d", maxHeld, limit)
+	}
+}
+
+func TestBlockPrefetcherMaxBufferedRows(t *testing.T) {
+	var mu sync.Mutex
+	requests := 0
+	node := testNode(t, 200, func(start int64, count, request int) (int64, int) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		return start, count
+	})
+	config := DefaultConfig()
+	config.HiveAPIURLs = []string{node}
+	config.BatchSize = 10
+	config.InitialBatchSize = 0
+	config.PrefetchBlocks = 100
+	config.PrefetchWorkers = 1
+	config.MaxBufferedRows = 15
+	config.MaxRetries = 1
+	config.RetryDelay = time.Millisecond
+
+	p := newBlockPrefetcher(context.Background(), config, 101, 200, 200)
+	defer p.Stop()
+
+	// Every block holds a post, so fetching pauses once two batches of ten posts
+	// are waiting for a processor that is too slow to take them
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	paused := requests
+	mu.Unlock()
+	if paused != 2 {
+		t.Errorf("fetched %d batches before pausing, want 2", paused)
+	}
+	if rows := p.BufferedRows(); rows != 20 {
+		t.Errorf("BufferedRows() = %d while paused, want 20", rows)
+	}
+
+	delivered := 0
+	for {
+		batch, ok := p.Next()
+		if !ok {
+			break
+		}
+		if batch.err != nil {
+			t.Fatal(batch.err)
+		}
+		delivered += len(batch.blocks)
+		p.Release(batch)
+	}
+	if delivered != 100 {
+		t.Errorf("delivered %d blocks after the processor caught up, want 100", delivered)
+	}
+	if rows := p.BufferedRows(); rows != 0 {
+		t.Errorf("BufferedRows() = %d after every batch was released, want 0", rows)
+	}
+}
+
+func TestBlockPrefetcherPipeline(t *testing.T) {
+	const head = 200
+	// Earlier ranges take longer to answer, so the workers' fetches overlap and
+	// complete out of order
+	var inFlight, maxInFlight atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string `json:"method"`
+			Params struct {
+				StartingBlockNum int64 `json:"starting_block_num"`
+				Count            int   `json:"count"`
+			} `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Method != "block_api.get_block_range" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"result": map[string]int64{"head_block_number": head}})
+			return
+		}
+
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			highest := maxInFlight.Load()
+			if n <= highest || maxInFlight.CompareAndSwap(highest, n) {
+				break
+			}
+		}
+		time.Sleep(time.Duration(head-req.Params.StartingBlockNum) / 10 * 3 * time.Millisecond)
+
+		blocks := []Block{}
+		for n := req.Params.StartingBlockNum; n < req.Params.StartingBlockNum+int64(req.Params.Count) && n <= head; n++ {
+			blocks = append(blocks, testBlock(n, testPost("alice", fmt.Sprintf("post-%d", n), "Post")))
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"result": map[string][]Block{"blocks": blocks}})
+	}))
+	defer srv.Close()
+
+	configure := func(c *Config) {
+		c.HiveAPIURLs = []string{srv.URL}
+		c.GenesisBlock = 100
+		c.BatchSize = 10
+		c.InitialBatchSize = 0
+		c.PrefetchBlocks = 40
+		c.PrefetchWorkers = 4
+	}
+
+	// Batches are delivered in ascending order however their fetches complete
+	config := DefaultConfig()
+	configure(config)
+	config.MaxRetries = 1
+	config.RetryDelay = time.Millisecond
+	p := newBlockPrefetcher(context.Background(), config, 101, head, head)
+	next := int64(101)
+	for {
+		batch, ok := p.Next()
+		if !ok {
+			break
+		}
+		if batch.err != nil {
+			t.Fatal(batch.err)
+		}
+		if batch.startBlock != next {
+			t.Fatalf("batch starts at block %d, want %d", batch.startBlock, next)
+		}
+		next += int64(len(batch.blocks))
+		p.Release(batch)
+	}
+	p.Stop()
+	if next != head+1 {
+		t.Errorf("delivered blocks up to %d, want %d", next-1, head)
+	}
+	if highest := maxInFlight.Load(); highest < 2 {
+		t.Errorf("at most %d ranges were fetched at once, want the workers to overlap", highest)
+	}
+
+	// A sync commits the prefetched blocks in ascending order
+	db, bp := newTestProcessor(t, configure)
+	last, err := syncBlocks(context.Background(), bp.config, db, bp, newHeadCache(bp.config), &pauseControl{}, NewStats())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last != head {
+		t.Errorf("syncBlocks() = %d, want %d", last, head)
+	}
+	rows, err := db.Query("SELECT block_num FROM posts ORDER BY _id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var stored []int64
+	for rows.Next() {
+		var blockNum int64
+		if err := rows.Scan(&blockNum); err != nil {
+			t.Fatal(err)
+		}
+		stored = append(stored, blockNum)
+	}
+	if len(stored) != head-100 || !sort.SliceIsSorted(stored, func(i, j int) bool { return stored[i] < stored[j] }) {
+		t.Errorf("stored blocks in the order %v, want 101 to %d ascending", stored, head)
+	}
+}
@@ -1,19 +1,100 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"strconv"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // BlockProcessor handles the processing of blockchain blocks
 type BlockProcessor struct {
-	db     *sql.DB
-	config *Config
-	stmt   *sql.Stmt
+	db         *sql.DB
+	config     *Config
+	stmt       *sql.Stmt
+	deleteStmt *sql.Stmt
+	replyStmt  *sql.Stmt
+	// customJSONStmt inserts custom_json operations, and customJSONIDs holds
+	// the CustomJSONIDs to store, nil to store all
+	customJSONStmt  *sql.Stmt
+	customJSONIDs   map[string]bool
+	transferStmt    *sql.Stmt
+	voteStmt        *sql.Stmt
+	beneficiaryStmt *sql.Stmt
+	// partitionStmts are the insert statements of the monthly partitions
+	partitionStmts map[string]*sql.Stmt
+	registry       *OpRegistry
+	tagIDs         map[string]int64
+	hook           *execHook
+
+	reputations *reputationCache
+
+	// titleKeywords are the lowercased TitleContains keywords
+	titleKeywords []string
+	titleSkipped  int
+
+	// malformedSkipped counts comments skipped by StrictTopLevel
+	malformedSkipped int
+
+	// droppedTags counts tags dropped for breaking Hive's tag rules
+	droppedTags int
+
+	// debugLog is set when the log level includes the per-operation debug
+	// messages, which are too frequent to build otherwise
+	debugLog bool
+
+	// rolledBackPosts counts posts rolled back to their savepoint with
+	// PostSavepoints
+	rolledBackPosts int
+	// edited counts stored posts replaced by an edit with UpdateOnConflict
+	edited int
+
+	// postLatency records the time from fetching a block to storing each of its
+	// posts, when RecordPostLatency is enabled
+	postLatency *latencyHistogram
+
+	// pending holds the posts queued for a multi-row insert, with their URLs in
+	// pendingURLs so duplicates within a batch are only queued once
+	pending     []*postRow
+	pendingURLs map[string]bool
+
+	// tx is the batch transaction opened by Begin, if any, with txStmts caching
+	// the prepared statements bound to it and txPartitionStmts the insert
+	// statements of partitions created in it
+	tx               *sql.Tx
+	txStmts          map[*sql.Stmt]*sql.Stmt
+	txPartitionStmts map[string]*sql.Stmt
+
+	// outputs receives every new post in addition to the database
+	outputs *MultiStore
+
+	// confirmations holds back hook deliveries when ConfirmationDepth is set
+	confirmations *confirmationQueue
+
+	metadataStats MetadataStats
+
+	// lastTimestamp is the latest block timestamp seen, used to detect blocks
+	// whose timestamp goes backwards
+	lastTimestamp        time.Time
+	timestampRegressions int
 }
 
+// errTimestampRegression is returned by processBlock in strict timestamp mode for a
+// block whose timestamp is earlier than that of a previously processed block
+var errTimestampRegression = errors.New("block timestamp regression")
+
+// hiveTimeLayout is the layout of block timestamps returned by the Hive API
+const hiveTimeLayout = "2006-01-02T15:04:05"
+
 // NewBlockProcessor creates a new BlockProcessor instance
 //
 // The BlockProcessor instance will be connected to the given database and configured
@@ -23,116 +104,1180 @@ type BlockProcessor struct {
 // for each block processed.
 //
 // The ON CONFLICT(url) DO NOTHING statement means that if a post with the same URL
-// already exists in the database, this statement will not overwrite it.
+// already exists in the database, this statement will not overwrite it; with
+// UpdateOnConflict, the post is updated separately instead (see updatePost).
+//
+// The built-in operation handlers are registered according to the configuration;
+// additional handlers can be added through Registry.
 func NewBlockProcessor(db *sql.DB, config *Config) (*BlockProcessor, error) {
-	stmt, err := db.Prepare(`
-		INSERT INTO posts (url, author, permlink, title, tags, block_num, timestamp)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-		ON CONFLICT(url) DO NOTHING
-	`)
+	// Compact tags reference posts by id, which is only unique within a table
+	if config.PartitionByMonth && config.CompactTags {
+		return nil, errors.New("PartitionByMonth cannot be combined with CompactTags")
+	}
+
+	stmt, err := db.Prepare(insertPostSQL("posts"))
 	if err != nil {
 		return nil, fmt.Errorf("error preparing statement: %v", err)
 	}
 
-	return &BlockProcessor{
-		db:     db,
-		config: config,
-		stmt:   stmt,
-	}, nil
+	deleteStmt, err := db.Prepare(`DELETE FROM posts WHERE url = ?`)
+	if err != nil {
+		stmt.Close()
+		return nil, fmt.Errorf("error preparing delete statement: %v", err)
+	}
+
+	// The statements of the optional handlers are only prepared when enabled
+	var optionalStmts []*sql.Stmt
+	closeStmts := func() {
+		stmt.Close()
+		deleteStmt.Close()
+		for _, s := range optionalStmts {
+			s.Close()
+		}
+	}
+	prepareOptional := func(enabled bool, query, name string) (*sql.Stmt, error) {
+		if !enabled {
+			return nil, nil
+		}
+		s, err := db.Prepare(query)
+		if err != nil {
+			closeStmts()
+			return nil, fmt.Errorf("error preparing %s statement: %v", name, err)
+		}
+		optionalStmts = append(optionalStmts, s)
+		return s, nil
+	}
+
+	replyStmt, err := prepareOptional(config.IndexReplies, insertReplySQL, "reply")
+	if err != nil {
+		return nil, err
+	}
+	customJSONStmt, err := prepareOptional(config.ProcessCustomJSON, insertCustomJSONSQL, "custom_json")
+	if err != nil {
+		return nil, err
+	}
+	transferStmt, err := prepareOptional(config.ProcessTransfers, insertTransferSQL, "transfer")
+	if err != nil {
+		return nil, err
+	}
+	voteStmt, err := prepareOptional(config.ProcessVotes, insertVoteSQL, "vote")
+	if err != nil {
+		return nil, err
+	}
+	beneficiaryStmt, err := prepareOptional(config.StoreBeneficiaries, insertBeneficiarySQL, "beneficiary")
+	if err != nil {
+		return nil, err
+	}
+
+	// A validation run stores nothing, so it has no secondary outputs either
+	outputs := NewMultiStore()
+	if !config.ValidateMetadata {
+		outputs, err = newOutputStores(config)
+		if err != nil {
+			closeStmts()
+			return nil, err
+		}
+	}
+
+	bp := &BlockProcessor{
+		db:              db,
+		config:          config,
+		stmt:            stmt,
+		deleteStmt:      deleteStmt,
+		replyStmt:       replyStmt,
+		customJSONStmt:  customJSONStmt,
+		transferStmt:    transferStmt,
+		voteStmt:        voteStmt,
+		beneficiaryStmt: beneficiaryStmt,
+		partitionStmts:  make(map[string]*sql.Stmt),
+		registry:        NewOpRegistry(),
+		tagIDs:          make(map[string]int64),
+		hook:            newExecHook(config),
+		pendingURLs:     make(map[string]bool),
+		outputs:         outputs,
+		debugLog:        slog.Default().Enabled(context.Background(), slog.LevelDebug),
+	}
+
+	if config.StoreReputation {
+		bp.reputations = newReputationCache(config)
+	}
+
+	if config.RecordPostLatency {
+		bp.postLatency = newLatencyHistogram()
+	}
+
+	// Reverse runs only see old, long confirmed blocks
+	if bp.hook != nil && !config.Reverse {
+		bp.confirmations = newConfirmationQueue(config)
+	}
+
+	for _, keyword := range config.TitleContains {
+		bp.titleKeywords = append(bp.titleKeywords, strings.ToLower(keyword))
+	}
+
+	// A validation run only classifies metadata, so no other operation is stored
+	if config.ValidateMetadata {
+		bp.registry.Register("comment_operation", bp.handleValidateMetadata)
+		return bp, nil
+	}
+	if config.ProcessComments {
+		bp.registry.Register("comment_operation", bp.handleComment)
+	} else if config.IndexReplies {
+		bp.registry.Register("comment_operation", bp.handleReply)
+	}
+	if config.ProcessDeletes {
+		bp.registry.Register("delete_comment_operation", bp.handleDeleteComment)
+	}
+	if config.ProcessCustomJSON {
+		if len(config.CustomJSONIDs) > 0 {
+			bp.customJSONIDs = make(map[string]bool)
+			for _, id := range config.CustomJSONIDs {
+				bp.customJSONIDs[id] = true
+			}
+		}
+		bp.registry.Register("custom_json_operation", bp.handleCustomJSON)
+	}
+	if config.ProcessTransfers {
+		bp.registry.Register("transfer_operation", bp.handleTransfer)
+	}
+	if config.ProcessVotes {
+		bp.registry.Register("vote_operation", bp.handleVote)
+	}
+	if config.StoreBeneficiaries {
+		bp.registry.Register("comment_options_operation", bp.handleCommentOptions)
+	}
+
+	return bp, nil
+}
+
+// Registry returns the operation registry used to dispatch operations
+func (bp *BlockProcessor) Registry() *OpRegistry {
+	return bp.registry
 }
 
 // Close releases resources held by the BlockProcessor
 //
 // This function should be called when the BlockProcessor is no longer needed
-// to release the resources held by the prepared statement.
+// to release the resources held by the prepared statement. It waits for any
+// running exec hook commands to finish and closes the secondary outputs.
 func (bp *BlockProcessor) Close() error {
+	if bp.confirmations != nil && bp.confirmations.Len() > 0 {
+		slog.Warn("Dropping hook deliveries for unconfirmed blocks", "deliveries", bp.confirmations.Len())
+	}
+	if bp.hook != nil {
+		bp.hook.Close()
+	}
+	if bp.outputs != nil {
+		if err := bp.outputs.Close(); err != nil {
+			slog.Error("Error closing outputs", "error", err)
+		}
+	}
+	closePartitionStmts(bp.partitionStmts)
+	if bp.deleteStmt != nil {
+		bp.deleteStmt.Close()
+	}
+	if bp.replyStmt != nil {
+		bp.replyStmt.Close()
+	}
+	if bp.customJSONStmt != nil {
+		bp.customJSONStmt.Close()
+	}
+	if bp.transferStmt != nil {
+		bp.transferStmt.Close()
+	}
+	if bp.voteStmt != nil {
+		bp.voteStmt.Close()
+	}
+	if bp.beneficiaryStmt != nil {
+		bp.beneficiaryStmt.Close()
+	}
 	if bp.stmt != nil {
 		return bp.stmt.Close()
 	}
 	return nil
 }
 
-// processBlock processes a single block and stores relevant post information in the database.
+// processBlock processes a single block by dispatching each of its operations to
+// the handler registered for the operation type. Operations without a handler are
+// skipped. Each dispatched operation is traced as a child span of ctx. Blocks
+// below SkipBelowBlock are not dispatched at all.
 //
-// It iterates over the transactions and operations within the block, filtering for
-// "comment_operation" types. It skips comments that are replies (i.e., have a parent author).
-// For each valid operation, it attempts to parse the JSON metadata, handling malformed
-// metadata by using a fallback structure. The post information is then inserted into the
-// database using a prepared statement, with retries applied in case of failure.
+// With CollapseDuplicateOps, only the first comment operation for a given
+// author/permlink within the block is handled; later duplicates would be ignored by
+// the insert's ON CONFLICT clause anyway, but would still cost a statement and be
+// counted.
 //
-// Returns the number of processed posts and an error if any database operation fails.
-func (bp *BlockProcessor) processBlock(block Block) (int, error) {
-	// Take first 8 characters of block ID and parse as hex
-	hexBlockNum := block.BlockNum[:8]
-	blockNum, err := strconv.ParseInt(hexBlockNum, 16, 32)
+// Returns the number of processed rows and an error if any handler fails.
+func (bp *BlockProcessor) processBlock(ctx context.Context, block Block) (int, error) {
+	blockNum, err := block.Number()
 	if err != nil {
-		return 0, fmt.Errorf("error converting block number from hex: %v", err)
+		return 0, fmt.Errorf("error converting block number from hex: %w", err)
+	}
+
+	if bp.checkTimestampRegression(blockNum, block.Timestamp) && bp.config.StrictTimestamps {
+		return 0, fmt.Errorf("%w: block %d at %s is earlier than %s",
+			errTimestampRegression, blockNum, block.Timestamp, bp.lastTimestamp.Format(hiveTimeLayout))
+	}
+
+	// The block still counts as processed, so the cursor moves past it
+	if blockNum < bp.config.SkipBelowBlock {
+		return 0, nil
+	}
+
+	// Hook deliveries wait for their blocks to be confirmed
+	if bp.confirmations != nil {
+		for _, post := range bp.confirmations.Observe(blockNum, block.BlockNum) {
+			if err := bp.hook.Run(post); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	opCtx := &OpContext{
+		Context:   ctx,
+		BlockNum:  blockNum,
+		BlockID:   block.BlockNum,
+		Timestamp: block.Timestamp,
+		Witness:   block.Witness,
+		FetchedAt: block.FetchedAt,
+	}
+
+	// Posts already handled in this block, used to collapse duplicate operations
+	var seen map[string]bool
+	if bp.config.CollapseDuplicateOps {
+		seen = make(map[string]bool)
 	}
 
 	var processedCount int
-	for _, tx := range block.Transactions {
-		for _, op := range tx.Operations {
-			// log.Printf("Processing operation type: %s", op.Type) // Log operation type
-			if op.Type != "comment_operation" {
+	for txIndex, tx := range block.Transactions {
+		for opIndex, op := range tx.Operations {
+			handler, ok := bp.registry.Lookup(op.Type)
+			if bp.debugLog {
+				slog.Debug("Processing operation", "block_num", opCtx.BlockNum, "tx_index", txIndex,
+					"op_index", opIndex, "type", op.Type, "handled", ok)
+			}
+			if !ok {
 				continue
 			}
+			opCtx.TxIndex, opCtx.OpIndex = txIndex, opIndex
+			opCtx.TransactionID = block.TransactionID(txIndex)
 
-			value := op.Value
-			// log.Printf("Operation value: %+v", value) // Log operation value
-			if value.ParentAuthor != "" {
-				continue // Skip comments/replies
+			if bp.config.NormalizePermlinks {
+				op.Value.Permlink = normalizePermlink(op.Value.Permlink)
 			}
+			// Account names are lowercased like in the urls built by
+			// constructAuthorPerm, so stored authors match their urls
+			op.Value.Author = strings.ToLower(op.Value.Author)
+			op.Value.ParentAuthor = strings.ToLower(op.Value.ParentAuthor)
 
-			var metadata struct {
-				Tags interface{} `json:"tags"`
-			}
-			var tagsJson string
-			if value.JsonMetadata != "" {
-				if err := json.Unmarshal([]byte(value.JsonMetadata), &metadata); err != nil {
-					// If parsing fails, try to handle it as a single tag string
-					metadata.Tags = value.JsonMetadata
+			if seen != nil && op.Type == "comment_operation" {
+				key := constructAuthorPerm(op.Value.Author, op.Value.Permlink)
+				if seen[key] {
+					continue
 				}
+				seen[key] = true
+			}
 
-				// Convert tags to JSON string based on type
-				switch v := metadata.Tags.(type) {
-				case string:
-					// If it's a single string, create a JSON array with one element
-					tagsJson = fmt.Sprintf("[%q]", v)
-				case []interface{}:
-					// If it's already an array, convert it to JSON
-					tagsBytes, err := json.Marshal(v)
-					if err == nil {
-						tagsJson = string(tagsBytes)
-					} else {
-						tagsJson = "[]"
-					}
-				default:
-					tagsJson = "[]"
-				}
-			} else {
-				tagsJson = "[]"
-			}
-
-			// Retry the database operation with backoff
-			err = retryWithBackoff(bp.config.MaxRetries, bp.config.RetryDelay, func() error {
-				_, err := bp.stmt.Exec(
-					constructAuthorPerm(value.Author, value.Permlink),
-					value.Author,
-					value.Permlink,
-					value.Title,
-					tagsJson,
-					int(blockNum),
-					block.Timestamp,
-				)
-				return err
-			})
+			_, span := tracer.Start(ctx, "handle_op", trace.WithAttributes(
+				attribute.String("op.type", op.Type),
+				attribute.Int64("block_num", opCtx.BlockNum),
+			))
+			count, err := handler(opCtx, op.Value)
 			if err != nil {
-				return processedCount, fmt.Errorf("error inserting post: %v", err)
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+				span.End()
+				return processedCount, err
 			}
-
-			processedCount++
+			span.End()
+			processedCount += count
 		}
 	}
 
 	return processedCount, nil
 }
+
+// checkTimestampRegression reports whether the timestamp of a block is earlier than
+// the latest timestamp seen so far. Regressions are logged and counted; blocks
+// with unparsable timestamps are not checked.
+func (bp *BlockProcessor) checkTimestampRegression(blockNum int64, timestamp string) bool {
+	ts, err := time.Parse(hiveTimeLayout, timestamp)
+	if err != nil {
+		return false
+	}
+
+	if ts.Before(bp.lastTimestamp) {
+		bp.timestampRegressions++
+		slog.Warn("Timestamp regression", "block_num", blockNum, "timestamp", timestamp,
+			"previous_timestamp", bp.lastTimestamp.Format(hiveTimeLayout))
+		return true
+	}
+
+	bp.lastTimestamp = ts
+	return false
+}
+
+// resetTimestampCheck forgets the latest timestamp seen, so the next block is not
+// compared against earlier ones. Reverse runs call it at the start of every batch,
+// since their batches legitimately go back in time.
+func (bp *BlockProcessor) resetTimestampCheck() {
+	bp.lastTimestamp = time.Time{}
+}
+
+// TimestampRegressions returns the number of blocks seen whose timestamp was
+// earlier than that of a previous block
+func (bp *BlockProcessor) TimestampRegressions() int {
+	return bp.timestampRegressions
+}
+
+// titleMatches reports whether a post title contains one of the TitleContains
+// keywords, ignoring case. Every title matches when no keywords are configured.
+func (bp *BlockProcessor) titleMatches(title string) bool {
+	if len(bp.titleKeywords) == 0 {
+		return true
+	}
+	title = strings.ToLower(title)
+	for _, keyword := range bp.titleKeywords {
+		if strings.Contains(title, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// TitleSkipped returns the number of posts skipped because their title matched
+// none of the TitleContains keywords
+func (bp *BlockProcessor) TitleSkipped() int {
+	return bp.titleSkipped
+}
+
+// MalformedSkipped returns the number of comments without a parent author that
+// StrictTopLevel skipped because their parent permlink was not a valid category
+func (bp *BlockProcessor) MalformedSkipped() int {
+	return bp.malformedSkipped
+}
+
+// isTopLevel reports whether a comment is a top-level post rather than a reply.
+// Replies have a parent author; with StrictTopLevel, a comment without one must
+// also name a well-formed category as its parent permlink, as every genuine post
+// does, and not its own permlink.
+func (bp *BlockProcessor) isTopLevel(value OperationValue) bool {
+	if value.ParentAuthor != "" {
+		return false
+	}
+	if bp.config.StrictTopLevel && (!validTag(value.ParentPermlink, 0) || value.ParentPermlink == value.Permlink) {
+		bp.malformedSkipped++
+		return false
+	}
+	return true
+}
+
+// DroppedTags returns the number of tags dropped because they broke Hive's tag
+// rules
+func (bp *BlockProcessor) DroppedTags() int {
+	return bp.droppedTags
+}
+
+// PostLatency returns the distribution of the time from fetching a block to storing
+// each of its posts. It is empty unless RecordPostLatency is enabled.
+func (bp *BlockProcessor) PostLatency() LatencySummary {
+	if bp.postLatency == nil {
+		return LatencySummary{Name: "posts"}
+	}
+	return bp.postLatency.Summary("posts")
+}
+
+// handleComment stores a top-level post from a "comment_operation".
+//
+// It skips comments that are not top-level posts (see isTopLevel), except for
+// replies, which are handed to handleReply with IndexReplies, and posts whose
+// title does not match the TitleContains keywords. It attempts to
+// parse the JSON metadata, handling malformed metadata by using a fallback
+// structure, and drops tags that are not valid Hive tags. The post information
+// is then inserted into the database using a prepared statement, with retries
+// applied in case of failure, or queued for Flush when MultiRowInsert is enabled.
+func (bp *BlockProcessor) handleComment(ctx *OpContext, value OperationValue) (int, error) {
+	if value.ParentAuthor != "" && bp.replyStmt != nil {
+		return bp.handleReply(ctx, value)
+	}
+	if reason := bp.commentSkipReason(value); reason != "" {
+		if bp.debugLog {
+			slog.Debug("Skipped comment", "url", constructAuthorPerm(value.Author, value.Permlink), "reason", reason)
+		}
+		return 0, nil
+	}
+
+	row, err := bp.newPostRow(ctx, value)
+	if err != nil {
+		return 0, err
+	}
+
+	// Multi-row inserts are written, and counted, when the batch is flushed
+	if bp.config.MultiRowInsert {
+		bp.queuePost(row)
+		return 0, nil
+	}
+
+	var count int
+	err = bp.withSavepoint(row.url, func() error {
+		var err error
+		count, err = bp.storePost(row)
+		return err
+	})
+	return count, err
+}
+
+// storePost inserts a post, or applies it as an edit of the stored post with
+// UpdateOnConflict, and runs the work following a new post's insert. It returns
+// 1 when a new post was stored and 0 when the post was stored before.
+func (bp *BlockProcessor) storePost(row *postRow) (int, error) {
+	// Partitions only detect conflicts with the posts they hold themselves
+	if bp.config.PartitionByMonth {
+		stored, err := bp.storedTable(row.url)
+		if err != nil {
+			return 0, err
+		}
+		if stored != "" {
+			if err := bp.handleStoredPost(stored, row); err != nil {
+				return 0, err
+			}
+			return 0, nil
+		}
+	}
+
+	stmt, err := bp.insertStmt(row)
+	if err != nil {
+		return 0, err
+	}
+
+	// Retry the database operation with backoff
+	var result sql.Result
+	err = bp.retryDB(func() error {
+		var err error
+		result, err = stmt.Exec(row.values()...)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error inserting post: %w", err)
+	}
+
+	// Posts skipped by ON CONFLICT were already stored by an earlier operation,
+	// of which this one is an edit
+	inserted, err := result.RowsAffected()
+	if err != nil || inserted == 0 {
+		if bp.config.UpdateOnConflict {
+			table := "posts"
+			if bp.config.PartitionByMonth {
+				table = partitionTable(row)
+			}
+			if err := bp.updatePost(table, row); err != nil {
+				return 0, err
+			}
+		}
+		return 0, nil
+	}
+
+	postID, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("error reading post id: %v", err)
+	}
+	if err := bp.afterInsert(row, postID); err != nil {
+		return 0, err
+	}
+
+	return 1, nil
+}
+
+// commentSkipReason returns why handleComment doesn't store a comment as a
+// top-level post, or "" when it does, counting the skip
+func (bp *BlockProcessor) commentSkipReason(value OperationValue) string {
+	switch {
+	case value.ParentAuthor != "":
+		return "reply to " + constructAuthorPerm(value.ParentAuthor, value.ParentPermlink)
+	case !bp.isTopLevel(value):
+		return fmt.Sprintf("malformed parent permlink %q", value.ParentPermlink)
+	case !bp.titleMatches(value.Title):
+		bp.titleSkipped++
+		return "title matches none of the keywords"
+	}
+	return ""
+}
+
+// newPostRow builds the row stored for a top-level post from its comment
+// operation, with the optional columns filled in as configured
+func (bp *BlockProcessor) newPostRow(ctx *OpContext, value OperationValue) (*postRow, error) {
+	tagsJson, _ := extractTags(value.JsonMetadata)
+	tagsJson, dropped := filterTags(tagsJson, bp.config.MaxTagLength, bp.config.MaxTags)
+	bp.droppedTags += dropped
+
+	row := &postRow{
+		url:       constructAuthorPerm(value.Author, value.Permlink),
+		author:    value.Author,
+		permlink:  value.Permlink,
+		title:     value.Title,
+		tagsJson:  tagsJson,
+		tags:      tagsJson,
+		tagCount:  len(tagList(tagsJson)),
+		link:      constructPostLink(value.ParentPermlink, tagList(tagsJson), value.Author, value.Permlink),
+		blockNum:  ctx.BlockNum,
+		blockID:   ctx.BlockID,
+		app:       extractApp(value.JsonMetadata),
+		fetchedAt: ctx.FetchedAt,
+	}
+	row.timestamp, row.epoch = normalizeTimestamp(ctx.Timestamp)
+	row.contentHash = contentHash(value.Title, tagsJson, value.JsonMetadata)
+
+	// In compact mode the tags are stored through the tag dictionary instead
+	if bp.config.CompactTags {
+		row.tags = nil
+	}
+
+	// The block producer is only stored when enabled to keep rows small
+	if bp.config.StoreWitness {
+		row.witness = sql.NullString{String: ctx.Witness, Valid: true}
+	}
+
+	// The reputation snapshot costs an extra API request, so it is opt-in
+	if bp.reputations != nil {
+		row.reputation.Int64, row.reputation.Valid = bp.reputations.Get(ctx.Context, value.Author)
+	}
+
+	if bp.config.StoreThumbnail {
+		row.thumbnail.String = extractThumbnail(value.JsonMetadata)
+		row.thumbnail.Valid = row.thumbnail.String != ""
+	}
+
+	if bp.config.StoreWordCount {
+		row.wordCount = sql.NullInt64{Int64: int64(countWords(value.Body)), Valid: true}
+	}
+
+	if bp.config.StoreBlockSeq {
+		row.blockSeq = sql.NullInt64{Int64: blockSeq(ctx.TxIndex, ctx.OpIndex), Valid: true}
+	}
+
+	if bp.config.StoreTransactionID {
+		row.transactionID.String = ctx.TransactionID
+		row.transactionID.Valid = row.transactionID.String != ""
+	}
+
+	// A slug matching the permlink would only repeat it
+	if bp.config.StoreSlug {
+		row.slug.String = slugify(value.Title)
+		row.slug.Valid = row.slug.String != "" && row.slug.String != row.permlink
+	}
+
+	if bp.config.StoreRawMetadata {
+		row.metadata = value.JsonMetadata
+		if bp.config.CompressRawMetadata {
+			compressed, err := compressMetadata(value.JsonMetadata)
+			if err != nil {
+				return nil, fmt.Errorf("error compressing metadata: %v", err)
+			}
+			row.metadata = compressed
+		}
+	}
+	return row, nil
+}
+
+// blockSeqOpBits is the number of low bits of a block_seq holding the operation's
+// index within its transaction, far more than a transaction can hold
+const blockSeqOpBits = 16
+
+// blockSeq combines the index of a transaction within its block and of an
+// operation within its transaction into a single number that increases with the
+// operation's position in the block
+func blockSeq(txIndex, opIndex int) int64 {
+	return int64(txIndex)<<blockSeqOpBits | int64(opIndex)
+}
+
+// postColumns are the posts columns written for a new post, in the order of
+// postRow.values
+const postColumns = "url, author, permlink, title, tags, block_num, timestamp, timestamp_epoch, witness, app, author_reputation, thumbnail, json_metadata, tag_count, parser_version, word_count, block_seq, link, transaction_id, slug, content_hash"
+
+// insertPostSQL returns the statement inserting a single post into table. The ON
+// CONFLICT(url) DO NOTHING clause keeps an existing post from being overwritten.
+func insertPostSQL(table string) string {
+	return "INSERT INTO " + table + " (" + postColumns + ") VALUES " + postPlaceholders +
+		" ON CONFLICT(url) DO NOTHING"
+}
+
+// postColumnCount is the number of columns in postColumns
+const postColumnCount = 21
+
+// postPlaceholders is the VALUES tuple for a single post
+var postPlaceholders = "(" + strings.TrimSuffix(strings.Repeat("?, ", postColumnCount), ", ") + ")"
+
+// postRow is a post ready to be inserted into the posts table
+type postRow struct {
+	url        string
+	author     string
+	permlink   string
+	title      string
+	tagsJson   string
+	tags       interface{} // tags column value, nil in compact mode
+	blockNum   int64
+	blockID    string
+	timestamp  string
+	epoch      sql.NullInt64
+	witness    sql.NullString
+	app        string
+	reputation sql.NullInt64
+	thumbnail  sql.NullString
+	// metadata is nil, the raw JSON metadata or its gzipped form
+	metadata  interface{}
+	tagCount  int
+	wordCount sql.NullInt64
+	blockSeq  sql.NullInt64
+	link      string
+	// transactionID is the id of the transaction holding the post's operation
+	transactionID sql.NullString
+	slug          sql.NullString
+	// contentHash identifies the title, tags and metadata, see contentHash
+	contentHash string
+	fetchedAt   time.Time
+}
+
+// values returns the column values of the row in the order of postColumns
+func (r *postRow) values() []interface{} {
+	return []interface{}{
+		r.url,
+		r.author,
+		r.permlink,
+		r.title,
+		r.tags,
+		r.blockNum,
+		r.timestamp,
+		r.epoch,
+		r.witness,
+		r.app,
+		r.reputation,
+		r.thumbnail,
+		r.metadata,
+		r.tagCount,
+		parserVersion,
+		r.wordCount,
+		r.blockSeq,
+		r.link,
+		r.transactionID,
+		r.slug,
+		r.contentHash,
+	}
+}
+
+// afterInsert runs the work that follows storing a new post: recording its
+// latency, linking its compact tags, writing it to the secondary outputs and
+// running the exec hook
+func (bp *BlockProcessor) afterInsert(row *postRow, postID int64) error {
+	if bp.postLatency != nil && !row.fetchedAt.IsZero() {
+		bp.postLatency.Record(time.Since(row.fetchedAt))
+	}
+
+	if bp.config.CompactTags {
+		if err := bp.storeCompactTags(postID, tagList(row.tagsJson)); err != nil {
+			return err
+		}
+	}
+
+	if bp.hook == nil && bp.outputs.Len() == 0 {
+		return nil
+	}
+	post := ExportedPost{
+		URL:       row.url,
+		Link:      row.link,
+		Author:    row.author,
+		Permlink:  row.permlink,
+		Title:     row.title,
+		Tags:      tagList(row.tagsJson),
+		BlockNum:  row.blockNum,
+		Timestamp: row.timestamp,
+	}
+
+	// Secondary outputs don't affect checkpointing, so their errors are only
+	// logged
+	if err := bp.outputs.StorePost(post); err != nil {
+		slog.Error("Error writing post to outputs", "url", post.URL, "error", err)
+	}
+
+	if bp.confirmations != nil {
+		bp.confirmations.Add(row.blockNum, row.blockID, post)
+		return nil
+	}
+	if bp.hook != nil {
+		return bp.hook.Run(post)
+	}
+	return nil
+}
+
+// metadataKind classifies how a post's JSON metadata was interpreted
+type metadataKind int
+
+const (
+	// metadataEmpty means the post had no metadata
+	metadataEmpty metadataKind = iota
+	// metadataValid means the metadata was a JSON object with usable tags
+	metadataValid
+	// metadataFallback means the metadata was not a JSON object and was stored as
+	// a single tag string
+	metadataFallback
+	// metadataUnparsable means the metadata was a JSON object but its tags could
+	// not be interpreted, so no tags were stored
+	metadataUnparsable
+)
+
+// extractTags returns the tags found in a post's JSON metadata as a JSON array
+// string, along with how the metadata was interpreted.
+//
+// Malformed metadata that isn't valid JSON is treated as a single tag string, and
+// metadata without usable tags produces an empty array.
+func extractTags(jsonMetadata string) (string, metadataKind) {
+	if jsonMetadata == "" {
+		return "[]", metadataEmpty
+	}
+
+	kind := metadataValid
+	var metadata struct {
+		Tags interface{} `json:"tags"`
+	}
+	if err := json.Unmarshal([]byte(jsonMetadata), &metadata); err != nil {
+		// If parsing fails, try to handle it as a single tag string
+		metadata.Tags = jsonMetadata
+		kind = metadataFallback
+	}
+
+	// Convert tags to JSON string based on type
+	switch v := metadata.Tags.(type) {
+	case string:
+		// If it's a single string, create a JSON array with one element
+		return fmt.Sprintf("[%q]", v), kind
+	case []interface{}:
+		// If it's already an array, convert it to JSON
+		tagsBytes, err := json.Marshal(v)
+		if err != nil {
+			return "[]", metadataUnparsable
+		}
+		return string(tagsBytes), kind
+	case nil:
+		// Metadata without a tags field is valid, it just has no tags
+		return "[]", kind
+	default:
+		return "[]", metadataUnparsable
+	}
+}
+
+// extractApp returns the app that published a post, taken from the "app" field of
+// its JSON metadata.
+//
+// The field is either a string such as "peakd/2024.1.1" or an object such as
+// {"name": "ecency", "version": "3.0"}; the object form is normalized to
+// "name/version". Missing or unrecognized values produce an empty string.
+func extractApp(jsonMetadata string) string {
+	var metadata struct {
+		App interface{} `json:"app"`
+	}
+	if err := json.Unmarshal([]byte(jsonMetadata), &metadata); err != nil {
+		return ""
+	}
+
+	switch v := metadata.App.(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		name, _ := v["name"].(string)
+		if name == "" {
+			return ""
+		}
+		if version, ok := v["version"].(string); ok && version != "" {
+			return name + "/" + version
+		}
+		return name
+	default:
+		return ""
+	}
+}
+
+// extractThumbnail returns the first image URL from the "image" field of a post's
+// JSON metadata, which is either a single string or an array of strings. Values
+// that are not absolute http or https URLs are ignored, and an empty string is
+// returned when there is no usable image.
+func extractThumbnail(jsonMetadata string) string {
+	var metadata struct {
+		Image interface{} `json:"image"`
+	}
+	if err := json.Unmarshal([]byte(jsonMetadata), &metadata); err != nil {
+		return ""
+	}
+
+	var candidates []interface{}
+	switch v := metadata.Image.(type) {
+	case string:
+		candidates = []interface{}{v}
+	case []interface{}:
+		candidates = v
+	}
+
+	for _, c := range candidates {
+		image, ok := c.(string)
+		if !ok {
+			continue
+		}
+		image = strings.TrimSpace(image)
+		u, err := url.Parse(image)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			continue
+		}
+		return image
+	}
+	return ""
+}
+
+// MetadataStats tallies how post metadata was interpreted during a validation run
+type MetadataStats struct {
+	Empty      int
+	Valid      int
+	Fallback   int
+	Unparsable int
+}
+
+// record adds a single post's metadata classification to the tallies
+func (s *MetadataStats) record(kind metadataKind) {
+	switch kind {
+	case metadataEmpty:
+		s.Empty++
+	case metadataValid:
+		s.Valid++
+	case metadataFallback:
+		s.Fallback++
+	case metadataUnparsable:
+		s.Unparsable++
+	}
+}
+
+// Total returns the number of posts whose metadata was classified
+func (s *MetadataStats) Total() int {
+	return s.Empty + s.Valid + s.Fallback + s.Unparsable
+}
+
+// MetadataStats returns the metadata tallies collected in validation mode
+func (bp *BlockProcessor) MetadataStats() MetadataStats {
+	return bp.metadataStats
+}
+
+// handleValidateMetadata classifies the metadata of a top-level post from a
+// "comment_operation" without storing the post. It is registered instead of
+// handleComment when ValidateMetadata is enabled.
+func (bp *BlockProcessor) handleValidateMetadata(ctx *OpContext, value OperationValue) (int, error) {
+	if !bp.isTopLevel(value) {
+		return 0, nil // Skip comments/replies
+	}
+
+	_, kind := extractTags(value.JsonMetadata)
+	bp.metadataStats.record(kind)
+	return 0, nil
+}
+
+// tagList decodes a JSON array of tags produced by extractTags, keeping only the
+// string entries
+func tagList(tagsJson string) []string {
+	var raw []interface{}
+	if err := json.Unmarshal([]byte(tagsJson), &raw); err != nil {
+		return nil
+	}
+
+	tags := make([]string, 0, len(raw))
+	for _, t := range raw {
+		if tag, ok := t.(string); ok {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// validTag reports whether a tag follows Hive's tag rules: at most maxLength
+// characters (unlimited when zero) of lowercase letters, digits and dashes,
+// starting with a letter
+func validTag(tag string, maxLength int) bool {
+	if tag == "" || (maxLength > 0 && len(tag) > maxLength) {
+		return false
+	}
+	for i, r := range tag {
+		switch {
+		case r >= 'a' && r <= 'z':
+		case (r >= '0' && r <= '9') || r == '-':
+			if i == 0 {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeTags lowercases and trims tags, dropping empty tags and repeats of an
+// earlier tag. The order is kept, so the first tag stays first.
+func normalizeTags(tags []string) []string {
+	normalized := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
+// filterTags normalizes the tags of a post with normalizeTags and drops the ones
+// that are not valid Hive tags, returning the remaining tags as a JSON array
+// along with the number of tags dropped. Only the first maxTags valid tags are
+// kept, unless maxTags is zero. Repeated tags and tags beyond maxTags are not
+// counted as dropped.
+func filterTags(tagsJson string, maxLength, maxTags int) (string, int) {
+	var raw []interface{}
+	if err := json.Unmarshal([]byte(tagsJson), &raw); err != nil {
+		return "[]", 0
+	}
+
+	var (
+		strs     []string
+		nonEmpty int
+	)
+	for _, t := range raw {
+		if tag, ok := t.(string); ok {
+			strs = append(strs, tag)
+			if strings.TrimSpace(tag) != "" {
+				nonEmpty++
+			}
+		}
+	}
+
+	normalized := normalizeTags(strs)
+	repeated := nonEmpty - len(normalized)
+	tags := make([]string, 0, len(normalized))
+	var truncated int
+	for _, tag := range normalized {
+		if !validTag(tag, maxLength) {
+			continue
+		}
+		if maxTags > 0 && len(tags) == maxTags {
+			truncated++
+			continue
+		}
+		tags = append(tags, tag)
+	}
+
+	tagsBytes, err := json.Marshal(tags)
+	if err != nil {
+		return "[]", len(raw)
+	}
+	return string(tagsBytes), len(raw) - repeated - truncated - len(tags)
+}
+
+// storeCompactTags links a post to its tags through the tag dictionary, adding any
+// tags that are not in the dictionary yet. Dictionary IDs are cached so repeated
+// tags only cost a single insert into post_tag.
+func (bp *BlockProcessor) storeCompactTags(postID int64, tags []string) error {
+	for position, tag := range tags {
+		tagID, ok := bp.tagIDs[tag]
+		if !ok {
+			var err error
+			tagID, err = lookupOrCreateTag(bp.execer(), tag)
+			if err != nil {
+				return err
+			}
+			bp.tagIDs[tag] = tagID
+		}
+
+		err := bp.retryDB(func() error {
+			_, err := bp.execer().Exec(`
+				INSERT INTO post_tag (post_id, tag_id, position)
+				VALUES (?, ?, ?)
+				ON CONFLICT(post_id, tag_id) DO NOTHING
+			`, postID, tagID, position)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("error linking tag %q: %w", tag, err)
+		}
+	}
+	return nil
+}
+
+// Begin opens the transaction the writes of a batch go through when
+// BatchTransaction is enabled, so the batch is committed at once by Commit or
+// discarded by Rollback. Otherwise every statement commits on its own and Begin,
+// Commit and Rollback do nothing.
+func (bp *BlockProcessor) Begin() error {
+	if !bp.config.BatchTransaction {
+		return nil
+	}
+	tx, err := bp.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	bp.tx = tx
+	bp.txStmts = make(map[*sql.Stmt]*sql.Stmt)
+	bp.txPartitionStmts = make(map[string]*sql.Stmt)
+	return nil
+}
+
+// Commit commits the batch transaction opened by Begin
+func (bp *BlockProcessor) Commit() error {
+	if bp.tx == nil {
+		return nil
+	}
+	tx, created := bp.tx, bp.txPartitionStmts
+	bp.tx, bp.txStmts, bp.txPartitionStmts = nil, nil, nil
+	closePartitionStmts(created)
+	if err := tx.Commit(); err != nil {
+		// A failed commit rolls the transaction back
+		bp.tagIDs = make(map[string]int64)
+		return fmt.Errorf("error committing batch: %w", err)
+	}
+
+	// The partitions created in the transaction exist now, so their statements
+	// are prepared for good
+	for table := range created {
+		stmt, err := bp.db.Prepare(insertPostSQL(table))
+		if err != nil {
+			return fmt.Errorf("error preparing statement for %s: %v", table, err)
+		}
+		bp.partitionStmts[table] = stmt
+	}
+	return nil
+}
+
+// withSavepoint runs store, which stores the post with the given url, in a
+// savepoint of the batch transaction when PostSavepoints is enabled. A post that
+// fails to store is then rolled back on its own and logged, and the rest of the
+// batch carries on; only errors that fail the whole batch, such as a lost
+// database connection or a failed exec hook, are returned. Like with Rollback,
+// exec hooks and secondary outputs may already have seen a rolled back post.
+func (bp *BlockProcessor) withSavepoint(url string, store func() error) error {
+	if bp.tx == nil || !bp.config.PostSavepoints {
+		return store()
+	}
+	if _, err := bp.tx.Exec("SAVEPOINT post"); err != nil {
+		return fmt.Errorf("error creating savepoint: %w", err)
+	}
+	partitions := make(map[string]bool, len(bp.txPartitionStmts))
+	for table := range bp.txPartitionStmts {
+		partitions[table] = true
+	}
+
+	err := store()
+	if err != nil {
+		if _, rbErr := bp.tx.Exec("ROLLBACK TO post"); rbErr != nil {
+			return fmt.Errorf("error rolling back to savepoint: %w", rbErr)
+		}
+		// Partitions and tags added since the savepoint are gone again
+		for table, stmt := range bp.txPartitionStmts {
+			if !partitions[table] {
+				stmt.Close()
+				delete(bp.txPartitionStmts, table)
+			}
+		}
+		bp.tagIDs = make(map[string]int64)
+	}
+	if _, relErr := bp.tx.Exec("RELEASE post"); relErr != nil && err == nil {
+		return fmt.Errorf("error releasing savepoint: %w", relErr)
+	}
+
+	if err == nil || isRecoverableDBError(err) || errors.Is(err, errExecHookFailed) {
+		return err
+	}
+	bp.rolledBackPosts++
+	slog.Error("Rolled back post that failed to store", "url", url, "error", err)
+	return nil
+}
+
+// RolledBackPosts returns the number of posts rolled back to their savepoint
+// with PostSavepoints
+func (bp *BlockProcessor) RolledBackPosts() int {
+	return bp.rolledBackPosts
+}
+
+// Rollback discards the batch transaction opened by Begin along with the posts
+// still queued for a multi-row insert. Exec hooks and secondary outputs have
+// already seen the discarded posts.
+func (bp *BlockProcessor) Rollback() {
+	bp.pending = nil
+	bp.pendingURLs = make(map[string]bool)
+	if bp.tx == nil {
+		return
+	}
+	closePartitionStmts(bp.txPartitionStmts)
+	bp.tx.Rollback()
+	bp.tx, bp.txStmts, bp.txPartitionStmts = nil, nil, nil
+	// Tags added in the transaction are gone again
+	bp.tagIDs = make(map[string]int64)
+}
+
+// execer returns the batch transaction while one is open, or the database
+func (bp *BlockProcessor) execer() sqlExecer {
+	if bp.tx != nil {
+		return bp.tx
+	}
+	return bp.db
+}
+
+// txStmt returns stmt bound to the batch transaction while one is open, or stmt
+// itself
+func (bp *BlockProcessor) txStmt(stmt *sql.Stmt) *sql.Stmt {
+	if bp.tx == nil {
+		return stmt
+	}
+	bound, ok := bp.txStmts[stmt]
+	if !ok {
+		bound = bp.tx.Stmt(stmt)
+		bp.txStmts[stmt] = bound
+	}
+	return bound
+}
+
+// retryDB runs a database operation, retrying busy and locked errors quickly
+// before falling back to the regular backoff for any error
+func (bp *BlockProcessor) retryDB(operation func() error) error {
+	return retryWithBackoff(bp.config.MaxRetries, bp.config.RetryDelay, func() error {
+		return retryOnBusy(bp.config.BusyRetries, bp.config.BusyRetryDelay, operation)
+	})
+}
+
+// handleDeleteComment removes a previously stored post when its author deletes it
+// with a "delete_comment_operation". Deletions of posts that were never stored are
+// harmless no-ops. With IndexReplies, deleted replies are removed as well. A post
+// still queued for a multi-row insert is dropped from the queue.
+func (bp *BlockProcessor) handleDeleteComment(ctx *OpContext, value OperationValue) (int, error) {
+	url := constructAuthorPerm(value.Author, value.Permlink)
+	bp.dropQueued(url)
+	err := bp.retryDB(func() error {
+		if bp.config.CompactTags {
+			_, err := bp.execer().Exec(`DELETE FROM post_tag WHERE post_id IN (SELECT _id FROM posts WHERE url = ?)`, url)
+			if err != nil {
+				return err
+			}
+		}
+		if bp.replyStmt != nil {
+			if _, err := bp.execer().Exec(`DELETE FROM replies WHERE url = ?`, url); err != nil {
+				return err
+			}
+		}
+		if bp.beneficiaryStmt != nil {
+			if _, err := bp.execer().Exec(`DELETE FROM post_beneficiaries WHERE url = ?`, url); err != nil {
+				return err
+			}
+		}
+		if bp.config.PartitionByMonth {
+			return bp.deleteFromPartitions(url)
+		}
+		_, err := bp.txStmt(bp.deleteStmt).Exec(url)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error deleting post: %w", err)
+	}
+
+	return 0, nil
+}
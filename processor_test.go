@@ -0,0 +1,825 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestProcessor opens a fresh database in a temporary directory and creates a
+// BlockProcessor for it, with the default configuration changed by configure
+func newTestProcessor(t *testing.T, configure func(*Config)) (*sql.DB, *BlockProcessor) {
+	t.Helper()
+	config := DefaultConfig()
+	config.DBPath = filepath.Join(t.TempDir(), "blocks.db")
+	config.MaxRetries = 1
+	config.RetryDelay = time.Millisecond
+	if configure != nil {
+		configure(config)
+	}
+
+	db, err := initDB(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bp, err := NewBlockProcessor(db, config)
+	if err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		bp.Close()
+		db.Close()
+	})
+	return db, bp
+}
+
+// testBlock builds a block with the given number holding one transaction with ops
+func testBlock(num int64, ops ...Operation) Block {
+	return Block{
+		BlockNum:     fmt.Sprintf("%08x%032x", num, 0),
+		Timestamp:    "2024-01-02T03:04:05",
+		Witness:      "witness",
+		Transactions: []Transaction{{Operations: ops}},
+	}
+}
+
+// testPost returns the comment operation of a top-level post
+func testPost(author, permlink, title string) Operation {
+	return Operation{Type: "comment_operation", Value: OperationValue{
+		Author:         author,
+		Permlink:       permlink,
+		Title:          title,
+		ParentPermlink: "hive",
+		JsonMetadata:   `{"tags":["hive","test"]}`,
+	}}
+}
+
+func TestProcessBlockDispatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(*Config)
+		ops       []Operation
+		wantCount int
+		wantTypes []string
+	}{
+		{
+			name:      "registered handler",
+			ops:       []Operation{{Type: "custom_operation"}},
+			wantCount: 1,
+			wantTypes: []string{"custom_operation"},
+		},
+		{
+			name:      "unregistered operation",
+			ops:       []Operation{{Type: "claim_account_operation"}},
+			wantCount: 0,
+		},
+		{
+			name:      "built-in comment handler",
+			ops:       []Operation{testPost("alice", "first", "First"), {Type: "custom_operation"}},
+			wantCount: 2,
+			wantTypes: []string{"custom_operation"},
+		},
+		{
+			name:      "votes with -votes=false",
+			configure: func(c *Config) { c.ProcessVotes = false },
+			ops:       []Operation{{Type: "vote_operation", Value: OperationValue{Voter: "bob", Author: "alice", Permlink: "first", Weight: 10000}}},
+			wantCount: 0,
+		},
+		{
+			name:      "built-in vote handler",
+			ops:       []Operation{{Type: "vote_operation", Value: OperationValue{Voter: "bob", Author: "alice", Permlink: "first", Weight: 10000}}},
+			wantCount: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, bp := newTestProcessor(t, tt.configure)
+
+			var dispatched []string
+			bp.Registry().Register("custom_operation", func(ctx *OpContext, value OperationValue) (int, error) {
+				dispatched = append(dispatched, "custom_operation")
+				return 1, nil
+			})
+
+			count, err := bp.processBlock(context.Background(), testBlock(100, tt.ops...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != tt.wantCount {
+				t.Errorf("processBlock() = %d, want %d", count, tt.wantCount)
+			}
+			if !reflect.DeepEqual(dispatched, tt.wantTypes) {
+				t.Errorf("dispatched %v, want %v", dispatched, tt.wantTypes)
+			}
+		})
+	}
+}
+
+func TestNormalizeTags(t *testing.T) {
+	tests := []struct {
+		name string
+		tags []string
+		want []string
+	}{
+		{"empty", nil, []string{}},
+		{"lowercased and trimmed", []string{" Hive ", "PHOTOGRAPHY"}, []string{"hive", "photography"}},
+		{"repeats dropped", []string{"hive", "HIVE", "art", "hive"}, []string{"hive", "art"}},
+		{"blank tags dropped", []string{"", "  ", "art"}, []string{"art"}},
+		{"order kept", []string{"b", "a", "c"}, []string{"b", "a", "c"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeTags(tt.tags); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("normalizeTags(%q) = %q, want %q", tt.tags, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterTags(t *testing.T) {
+	tests := []struct {
+		name        string
+		tagsJson    string
+		maxLength   int
+		maxTags     int
+		want        string
+		wantDropped int
+	}{
+		{"valid tags", `["hive","art"]`, 0, 0, `["hive","art"]`, 0},
+		{"invalid JSON", `not json`, 0, 0, `[]`, 0},
+		{"normalized", `["Hive"," art "]`, 0, 0, `["hive","art"]`, 0},
+		{"invalid tags dropped", `["hive","1st","with space","ok-tag"]`, 0, 0, `["hive","ok-tag"]`, 2},
+		{"non-string entries dropped", `["hive",42,null]`, 0, 0, `["hive"]`, 2},
+		{"repeats not counted", `["hive","hive","HIVE"]`, 0, 0, `["hive"]`, 0},
+		{"too long", `["hive","photography"]`, 5, 0, `["hive"]`, 1},
+		{"truncated to maxTags", `["a","b","c"]`, 0, 2, `["a","b"]`, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, dropped := filterTags(tt.tagsJson, tt.maxLength, tt.maxTags)
+			if got != tt.want || dropped != tt.wantDropped {
+				t.Errorf("filterTags(%s) = %s, %d, want %s, %d", tt.tagsJson, got, dropped, tt.want, tt.wantDropped)
+			}
+		})
+	}
+}
+
+func TestMaxTags(t *testing.T) {
+	var tags []string
+	for i := 1; i <= 20; i++ {
+		tags = append(tags, fmt.Sprintf("tag%d", i))
+	}
+	metadata, err := json.Marshal(map[string][]string{"tags": tags})
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := testPost("alice", "stuffed", "Stuffed")
+	post.Value.JsonMetadata = string(metadata)
+
+	tests := []struct {
+		name    string
+		maxTags int
+		want    []string
+	}{
+		{"default", DefaultConfig().MaxTags, tags[:10]},
+		{"custom", 3, tags[:3]},
+		{"unlimited", 0, tags},
+	}
+	for _, tt := range tests {
+		db, bp := newTestProcessor(t, func(c *Config) { c.MaxTags = tt.maxTags })
+		if _, err := bp.processBlock(context.Background(), testBlock(100, post)); err != nil {
+			t.Fatal(err)
+		}
+		var stored string
+		var tagCount int
+		if err := db.QueryRow("SELECT tags, tag_count FROM posts").Scan(&stored, &tagCount); err != nil {
+			t.Fatal(err)
+		}
+		// The first tags are the ones frontends show, so they are the ones kept
+		if got := tagList(stored); !reflect.DeepEqual(got, tt.want) || tagCount != len(tt.want) {
+			t.Errorf("%s: stored %d tags %v, want %v", tt.name, tagCount, got, tt.want)
+		}
+	}
+}
+
+func TestPostSavepoints(t *testing.T) {
+	// failPostTrigger fails the insert of the post with the permlink "bad", and
+	// failTagTrigger fails linking its compact tags once the post is inserted
+	const (
+		failPostTrigger = `CREATE TRIGGER fail_post BEFORE INSERT ON posts WHEN new.permlink = 'bad'
+			BEGIN SELECT RAISE(ABORT, 'bad post'); END`
+		failTagTrigger = `CREATE TRIGGER fail_tag BEFORE INSERT ON post_tag
+			WHEN new.post_id = (SELECT _id FROM posts WHERE permlink = 'bad')
+			BEGIN SELECT RAISE(ABORT, 'bad tag'); END`
+	)
+
+	tests := []struct {
+		name           string
+		savepoints     bool
+		compactTags    bool
+		trigger        string
+		wantErr        bool
+		wantURLs       []string
+		wantRolledBack int
+	}{
+		{
+			name:           "failed insert",
+			savepoints:     true,
+			trigger:        failPostTrigger,
+			wantURLs:       []string{"@alice/good", "@carol/good"},
+			wantRolledBack: 1,
+		},
+		{
+			name:           "failed write after the insert",
+			savepoints:     true,
+			compactTags:    true,
+			trigger:        failTagTrigger,
+			wantURLs:       []string{"@alice/good", "@carol/good"},
+			wantRolledBack: 1,
+		},
+		{
+			name:        "all or nothing without savepoints",
+			compactTags: true,
+			trigger:     failTagTrigger,
+			wantErr:     true,
+			wantURLs:    []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, bp := newTestProcessor(t, func(c *Config) {
+				c.BatchTransaction = true
+				c.PostSavepoints = tt.savepoints
+				c.CompactTags = tt.compactTags
+			})
+			if _, err := db.Exec(tt.trigger); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := bp.Begin(); err != nil {
+				t.Fatal(err)
+			}
+			_, err := bp.processBlock(context.Background(), testBlock(100,
+				testPost("alice", "good", "Good"),
+				testPost("bob", "bad", "Bad"),
+				testPost("carol", "good", "Good"),
+			))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("processBlock() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				bp.Rollback()
+			} else if err := bp.Commit(); err != nil {
+				t.Fatal(err)
+			}
+
+			rows, err := db.Query("SELECT url FROM posts ORDER BY url")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+			urls := []string{}
+			for rows.Next() {
+				var url string
+				if err := rows.Scan(&url); err != nil {
+					t.Fatal(err)
+				}
+				urls = append(urls, url)
+			}
+			if !reflect.DeepEqual(urls, tt.wantURLs) {
+				t.Errorf("stored %v, want %v", urls, tt.wantURLs)
+			}
+			if rolledBack := bp.RolledBackPosts(); rolledBack != tt.wantRolledBack {
+				t.Errorf("RolledBackPosts() = %d, want %d", rolledBack, tt.wantRolledBack)
+			}
+		})
+	}
+}
+
+func TestBatchTransaction(t *testing.T) {
+	for _, commit := range []bool{true, false} {
+		db, bp := newTestProcessor(t, func(c *Config) { c.BatchTransaction = true })
+		count := func() int {
+			t.Helper()
+			var posts int
+			if err := db.QueryRow("SELECT COUNT(*) FROM posts").Scan(&posts); err != nil {
+				t.Fatal(err)
+			}
+			return posts
+		}
+
+		if err := bp.Begin(); err != nil {
+			t.Fatal(err)
+		}
+		for i, author := range []string{"alice", "bob", "carol"} {
+			if _, err := bp.processBlock(context.Background(), testBlock(int64(100+i), testPost(author, "post", "Post"))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// Nothing of the batch is visible before it is committed
+		if posts := count(); posts != 0 {
+			t.Errorf("%d posts visible before the batch was committed, want 0", posts)
+		}
+
+		want := 0
+		if commit {
+			if err := bp.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			want = 3
+		} else {
+			bp.Rollback()
+		}
+		if posts := count(); posts != want {
+			t.Errorf("commit %v: %d posts stored after the batch, want %d", commit, posts, want)
+		}
+	}
+}
+
+func TestStrictTopLevel(t *testing.T) {
+	withParent := func(permlink, parentAuthor, parentPermlink string) Operation {
+		op := testPost("alice", permlink, permlink)
+		op.Value.ParentAuthor, op.Value.ParentPermlink = parentAuthor, parentPermlink
+		return op
+	}
+	ops := []Operation{
+		withParent("genuine", "", "hive"),
+		withParent("self-parented", "", "self-parented"),
+		withParent("malformed-parent", "", "Not A Category!"),
+		withParent("reply", "bob", "bobs-post"),
+		withParent("reply-to-self", "alice", "genuine"),
+	}
+
+	tests := []struct {
+		strict        bool
+		wantURLs      []string
+		wantMalformed int
+	}{
+		{false, []string{"@alice/genuine", "@alice/malformed-parent", "@alice/self-parented"}, 0},
+		{true, []string{"@alice/genuine"}, 2},
+	}
+	for _, tt := range tests {
+		db, bp := newTestProcessor(t, func(c *Config) { c.StrictTopLevel = tt.strict })
+		if _, err := bp.processBlock(context.Background(), testBlock(100, ops...)); err != nil {
+			t.Fatal(err)
+		}
+
+		rows, err := db.Query("SELECT url FROM posts ORDER BY url")
+		if err != nil {
+			t.Fatal(err)
+		}
+		urls := []string{}
+		for rows.Next() {
+			var url string
+			if err := rows.Scan(&url); err != nil {
+				t.Fatal(err)
+			}
+			urls = append(urls, url)
+		}
+		rows.Close()
+		if !reflect.DeepEqual(urls, tt.wantURLs) {
+			t.Errorf("StrictTopLevel %v: stored %v, want %v", tt.strict, urls, tt.wantURLs)
+		}
+		if got := bp.MalformedSkipped(); got != tt.wantMalformed {
+			t.Errorf("StrictTopLevel %v: MalformedSkipped() = %d, want %d", tt.strict, got, tt.wantMalformed)
+		}
+	}
+}
+
+func TestStoreBlockSeq(t *testing.T) {
+	first := testBlock(100)
+	first.Transactions = []Transaction{
+		{Operations: []Operation{testPost("alice", "a", "A"), {Type: "custom_operation"}, testPost("bob", "b", "B")}},
+		{Operations: []Operation{testPost("carol", "c", "C")}},
+	}
+	blocks := []Block{first, testBlock(101, testPost("dave", "d", "D"))}
+
+	for _, store := range []bool{true, false} {
+		db, bp := newTestProcessor(t, func(c *Config) { c.StoreBlockSeq = store })
+		for _, block := range blocks {
+			if _, err := bp.processBlock(context.Background(), block); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		seqs := map[string]sql.NullInt64{}
+		rows, err := db.Query("SELECT url, block_seq FROM posts")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+			var url string
+			var seq sql.NullInt64
+			if err := rows.Scan(&url, &seq); err != nil {
+				t.Fatal(err)
+			}
+			seqs[url] = seq
+		}
+		rows.Close()
+
+		if !store {
+			for url, seq := range seqs {
+				if seq.Valid {
+					t.Errorf("block_seq of %s = %d without StoreBlockSeq, want NULL", url, seq.Int64)
+				}
+			}
+			continue
+		}
+		// The sequence follows the chain order within a block and starts over in
+		// the next one
+		want := map[string]int64{"@alice/a": 0, "@bob/b": 2, "@carol/c": 1 << blockSeqOpBits, "@dave/d": 0}
+		for url, seq := range want {
+			if got := seqs[url]; !got.Valid || got.Int64 != seq {
+				t.Errorf("block_seq of %s = %v, want %d", url, got, seq)
+			}
+		}
+		if !(seqs["@alice/a"].Int64 < seqs["@bob/b"].Int64 && seqs["@bob/b"].Int64 < seqs["@carol/c"].Int64) {
+			t.Errorf("block_seq %v does not increase in chain order", seqs)
+		}
+	}
+}
+
+func TestStorePostLink(t *testing.T) {
+	db, bp := newTestProcessor(t, nil)
+	post := testPost("Alice", "my-post", "My post")
+	post.Value.ParentPermlink = "photography"
+	if _, err := bp.processBlock(context.Background(), testBlock(100, post)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The url stays the key posts are deduplicated by, next to the frontend link
+	var url, link string
+	if err := db.QueryRow("SELECT url, link FROM posts").Scan(&url, &link); err != nil {
+		t.Fatal(err)
+	}
+	if url != "@alice/my-post" || link != "/photography/@alice/my-post" {
+		t.Errorf("stored url %q and link %q, want %q and %q", url, link, "@alice/my-post", "/photography/@alice/my-post")
+	}
+}
+
+func TestStoreWitness(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("enabled=%v", enabled), func(t *testing.T) {
+			db, bp := newTestProcessor(t, func(c *Config) { c.StoreWitness = enabled })
+			block := testBlock(100, testPost("alice", "first", "First"))
+			block.Witness = "blocktrades"
+			if _, err := bp.processBlock(context.Background(), block); err != nil {
+				t.Fatal(err)
+			}
+
+			var witness sql.NullString
+			if err := db.QueryRow("SELECT witness FROM posts WHERE url = '@alice/first'").Scan(&witness); err != nil {
+				t.Fatal(err)
+			}
+			want := sql.NullString{}
+			if enabled {
+				want = sql.NullString{String: "blocktrades", Valid: true}
+			}
+			if witness != want {
+				t.Errorf("witness = %+v, want %+v", witness, want)
+			}
+		})
+	}
+}
+
+func TestCompactTags(t *testing.T) {
+	db, bp := newTestProcessor(t, func(c *Config) { c.CompactTags = true })
+	second := testPost("bob", "second", "Second")
+	second.Value.JsonMetadata = `{"tags":["art","hive","photography"]}`
+	if _, err := bp.processBlock(context.Background(), testBlock(100, testPost("alice", "first", "First"), second)); err != nil {
+		t.Fatal(err)
+	}
+
+	var dictSize int
+	if err := db.QueryRow("SELECT COUNT(*) FROM tag_dict").Scan(&dictSize); err != nil {
+		t.Fatal(err)
+	}
+	if dictSize != 4 {
+		t.Errorf("tag_dict holds %d tags, want 4 with the shared tag stored once", dictSize)
+	}
+
+	tests := []struct {
+		url  string
+		want []string
+	}{
+		{"@alice/first", []string{"hive", "test"}},
+		{"@bob/second", []string{"art", "hive", "photography"}},
+	}
+	for _, tt := range tests {
+		var id int64
+		var tags sql.NullString
+		if err := db.QueryRow("SELECT _id, tags FROM posts WHERE url = ?", tt.url).Scan(&id, &tags); err != nil {
+			t.Fatal(err)
+		}
+		if tags.Valid {
+			t.Errorf("%s stores tags %q in the posts table in compact mode", tt.url, tags.String)
+		}
+		got, err := getPostTags(db, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("getPostTags(%s) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestValidateMetadata(t *testing.T) {
+	db, bp := newTestProcessor(t, func(c *Config) { c.ValidateMetadata = true })
+	withMetadata := func(permlink, metadata string) Operation {
+		op := testPost("alice", permlink, "Title")
+		op.Value.JsonMetadata = metadata
+		return op
+	}
+	reply := testPost("bob", "reply", "")
+	reply.Value.ParentAuthor = "alice"
+	block := testBlock(100,
+		withMetadata("empty", ""),
+		withMetadata("valid", `{"tags":["hive"]}`),
+		withMetadata("no-tags", `{"app":"peakd"}`),
+		withMetadata("single-tag", `{"tags":"hive"}`),
+		withMetadata("not-json", `hive photography`),
+		withMetadata("bad-tags", `{"tags":42}`),
+		reply,
+	)
+	if _, err := bp.processBlock(context.Background(), block); err != nil {
+		t.Fatal(err)
+	}
+
+	want := MetadataStats{Empty: 1, Valid: 3, Fallback: 1, Unparsable: 1}
+	if got := bp.MetadataStats(); got != want {
+		t.Errorf("MetadataStats() = %+v, want %+v", got, want)
+	}
+	var posts int
+	if err := db.QueryRow("SELECT COUNT(*) FROM posts").Scan(&posts); err != nil {
+		t.Fatal(err)
+	}
+	if posts != 0 {
+		t.Errorf("validation run stored %d posts, want none", posts)
+	}
+}
+
+func TestTimestampRegression(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		t.Run(fmt.Sprintf("strict=%v", strict), func(t *testing.T) {
+			db, bp := newTestProcessor(t, func(c *Config) { c.StrictTimestamps = strict })
+			if _, err := bp.processBlock(context.Background(), testBlock(100, testPost("alice", "first", "First"))); err != nil {
+				t.Fatal(err)
+			}
+
+			block := testBlock(101, testPost("bob", "second", "Second"))
+			block.Timestamp = "2024-01-02T03:04:02"
+			_, err := bp.processBlock(context.Background(), block)
+			if strict != errors.Is(err, errTimestampRegression) {
+				t.Fatalf("processBlock() error = %v, want regression error %v", err, strict)
+			}
+			if !strict && err != nil {
+				t.Fatal(err)
+			}
+			if got := bp.TimestampRegressions(); got != 1 {
+				t.Errorf("TimestampRegressions() = %d, want 1", got)
+			}
+
+			var stored int
+			if err := db.QueryRow("SELECT COUNT(*) FROM posts WHERE url = '@bob/second'").Scan(&stored); err != nil {
+				t.Fatal(err)
+			}
+			if want := map[bool]int{false: 1, true: 0}[strict]; stored != want {
+				t.Errorf("stored %d posts of the regressed block, want %d", stored, want)
+			}
+		})
+	}
+}
+
+func TestCollapseDuplicateOps(t *testing.T) {
+	for _, collapse := range []bool{false, true} {
+		t.Run(fmt.Sprintf("collapse=%v", collapse), func(t *testing.T) {
+			db, bp := newTestProcessor(t, func(c *Config) { c.CollapseDuplicateOps = collapse })
+			// The duplicate differs only in the case of its author
+			count, err := bp.processBlock(context.Background(), testBlock(100,
+				testPost("alice", "first", "First"),
+				testPost("Alice", "first", "First"),
+				testPost("bob", "second", "Second"),
+			))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != 2 {
+				t.Errorf("processBlock() = %d, want 2", count)
+			}
+
+			var posts int
+			if err := db.QueryRow("SELECT COUNT(*) FROM posts").Scan(&posts); err != nil {
+				t.Fatal(err)
+			}
+			if posts != 2 {
+				t.Errorf("stored %d posts, want 2", posts)
+			}
+		})
+	}
+}
+
+func TestTitleContains(t *testing.T) {
+	tests := []struct {
+		name        string
+		keywords    []string
+		wantURLs    []string
+		wantSkipped int
+	}{
+		{"no keywords", nil, []string{"@alice/a", "@bob/b", "@carol/c"}, 0},
+		{"one keyword ignoring case", []string{"HIVE"}, []string{"@alice/a"}, 2},
+		{"several keywords", []string{"hive", "Splinterlands"}, []string{"@alice/a", "@carol/c"}, 1},
+		{"no match", []string{"bitcoin"}, []string{}, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, bp := newTestProcessor(t, func(c *Config) { c.TitleContains = tt.keywords })
+			if _, err := bp.processBlock(context.Background(), testBlock(100,
+				testPost("alice", "a", "My Hive journey"),
+				testPost("bob", "b", "Cooking pasta"),
+				testPost("carol", "c", "splinterlands season recap"),
+			)); err != nil {
+				t.Fatal(err)
+			}
+
+			rows, err := db.Query("SELECT url FROM posts ORDER BY url")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+			urls := []string{}
+			for rows.Next() {
+				var url string
+				if err := rows.Scan(&url); err != nil {
+					t.Fatal(err)
+				}
+				urls = append(urls, url)
+			}
+			if !reflect.DeepEqual(urls, tt.wantURLs) {
+				t.Errorf("stored %v, want %v", urls, tt.wantURLs)
+			}
+			if skipped := bp.TitleSkipped(); skipped != tt.wantSkipped {
+				t.Errorf("TitleSkipped() = %d, want %d", skipped, tt.wantSkipped)
+			}
+		})
+	}
+}
+
+func TestExtractThumbnail(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata string
+		want     string
+	}{
+		{"array of images", `{"image":["https://images.hive.blog/a.png","https://images.hive.blog/b.png"]}`, "https://images.hive.blog/a.png"},
+		{"single string", `{"image":"https://images.hive.blog/a.png"}`, "https://images.hive.blog/a.png"},
+		{"missing image", `{"tags":["hive"]}`, ""},
+		{"empty array", `{"image":[]}`, ""},
+		{"invalid entries skipped", `{"image":[42,"not a url","ipfs://abc"," http://example.com/c.jpg "]}`, "http://example.com/c.jpg"},
+		{"relative path", `{"image":"/images/a.png"}`, ""},
+		{"invalid json", `{"image":`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractThumbnail(tt.metadata); got != tt.want {
+				t.Errorf("extractThumbnail(%s) = %q, want %q", tt.metadata, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStoreThumbnail(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("enabled=%v", enabled), func(t *testing.T) {
+			db, bp := newTestProcessor(t, func(c *Config) { c.StoreThumbnail = enabled })
+			withImage := testPost("alice", "image", "Image")
+			withImage.Value.JsonMetadata = `{"tags":["hive"],"image":["https://images.hive.blog/a.png"]}`
+			if _, err := bp.processBlock(context.Background(), testBlock(100, withImage, testPost("bob", "plain", "Plain"))); err != nil {
+				t.Fatal(err)
+			}
+
+			want := map[string]sql.NullString{"@alice/image": {}, "@bob/plain": {}}
+			if enabled {
+				want["@alice/image"] = sql.NullString{String: "https://images.hive.blog/a.png", Valid: true}
+			}
+			for url, want := range want {
+				var got sql.NullString
+				if err := db.QueryRow("SELECT thumbnail FROM posts WHERE url = ?", url).Scan(&got); err != nil {
+					t.Fatal(err)
+				}
+				if got != want {
+					t.Errorf("thumbnail of %s = %+v, want %+v", url, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNormalizePermlinks(t *testing.T) {
+	tests := []struct {
+		normalize bool
+		wantURLs  []string
+	}{
+		{false, []string{"@alice/My-Post", "@alice/my-post", "@alice/my-post-2"}},
+		{true, []string{"@alice/my-post", "@alice/my-post-2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("normalize=%v", tt.normalize), func(t *testing.T) {
+			db, bp := newTestProcessor(t, func(c *Config) { c.NormalizePermlinks = tt.normalize })
+			// Distinct permlinks stay distinct; only case variants are collapsed
+			if _, err := bp.processBlock(context.Background(), testBlock(100,
+				testPost("alice", "My-Post", "Mixed case"),
+				testPost("alice", "my-post", "Lowercase"),
+				testPost("alice", "my-post-2", "Another post"),
+			)); err != nil {
+				t.Fatal(err)
+			}
+
+			rows, err := db.Query("SELECT url FROM posts ORDER BY url")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+			urls := []string{}
+			for rows.Next() {
+				var url string
+				if err := rows.Scan(&url); err != nil {
+					t.Fatal(err)
+				}
+				urls = append(urls, url)
+			}
+			if !reflect.DeepEqual(urls, tt.wantURLs) {
+				t.Errorf("stored %v, want %v", urls, tt.wantURLs)
+			}
+		})
+	}
+}
+
+func TestTagCount(t *testing.T) {
+	for _, compact := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compact=%v", compact), func(t *testing.T) {
+			db, bp := newTestProcessor(t, func(c *Config) {
+				c.MaxTags = 3
+				c.CompactTags = compact
+			})
+			withTags := func(permlink, metadata string) Operation {
+				op := testPost("alice", permlink, "Title")
+				op.Value.JsonMetadata = metadata
+				return op
+			}
+			if _, err := bp.processBlock(context.Background(), testBlock(100,
+				withTags("plain", `{"tags":["hive","art"]}`),
+				withTags("duplicates", `{"tags":["Hive","hive"," HIVE ","art"]}`),
+				withTags("capped", `{"tags":["a","b","c","d","e"]}`),
+				withTags("invalid", `{"tags":["hive","not a tag!",""]}`),
+				withTags("none", `{"app":"peakd"}`),
+			)); err != nil {
+				t.Fatal(err)
+			}
+
+			rows, err := db.Query("SELECT _id, url, tags, tag_count FROM posts")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+			want := map[string]int{"@alice/plain": 2, "@alice/duplicates": 2, "@alice/capped": 3, "@alice/invalid": 1, "@alice/none": 0}
+			for rows.Next() {
+				var id int64
+				var url string
+				var tagsJson sql.NullString
+				var count int
+				if err := rows.Scan(&id, &url, &tagsJson, &count); err != nil {
+					t.Fatal(err)
+				}
+				if count != want[url] {
+					t.Errorf("tag_count of %s = %d, want %d", url, count, want[url])
+				}
+
+				// Compact tags are only stored in the tag dictionary
+				tags := tagList(tagsJson.String)
+				if !tagsJson.Valid {
+					if tags, err = getPostTags(db, id); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if count != len(tags) {
+					t.Errorf("tag_count of %s = %d, but %d tags %v are stored", url, count, len(tags), tags)
+				}
+			}
+		})
+	}
+}
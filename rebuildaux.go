@@ -0,0 +1,141 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log/slog"
+)
+
+// runRebuildAux implements the "rebuild-aux" command, which recomputes the data
+// derived from the stored posts without re-indexing the chain: the tag_count of
+// every post, the tag dictionary, the posts view over the monthly partitions,
+// the search index and the SQLite indexes.
+//
+// In compact mode post_tag is where a post's tags are stored rather than derived
+// data, so only its links to posts that no longer exist are removed. Unused tags
+// are dropped from the dictionary as well, so the command must not run while a
+// sync in compact mode is writing to the same database.
+func runRebuildAux(config *Config, args []string) error {
+	fs := flag.NewFlagSet("rebuild-aux", flag.ExitOnError)
+	batchSize := fs.Int("batch", 1000, "number of posts updated per transaction")
+	fs.Parse(args)
+
+	if *batchSize < 1 {
+		return fmt.Errorf("invalid -batch %d", *batchSize)
+	}
+
+	db, err := initDB(config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	corrected, err := rebuildTagCounts(db, *batchSize)
+	if err != nil {
+		return err
+	}
+	slog.Info("Corrected tag counts", "posts", corrected)
+
+	links, tags, err := pruneTagDict(db)
+	if err != nil {
+		return err
+	}
+	slog.Info("Pruned the tag dictionary", "removed_links", links, "removed_tags", tags)
+
+	if err := refreshPostsView(db); err != nil {
+		return err
+	}
+
+	rebuilt, err := rebuildSearchIndex(db)
+	if err != nil {
+		return err
+	}
+	if rebuilt {
+		slog.Info("Rebuilt the search index")
+	}
+
+	if _, err := db.Exec("REINDEX"); err != nil {
+		return fmt.Errorf("error rebuilding indexes: %v", err)
+	}
+	slog.Info("Rebuilt the indexes")
+	return nil
+}
+
+// rebuildTagCounts recomputes the tag_count of every post in the posts table and
+// its partitions from the stored tags, returning the number of posts whose count
+// was wrong. Posts are updated in ranges of batchSize ids, each in its own
+// transaction, so an interrupted rebuild keeps its progress.
+func rebuildTagCounts(db *sql.DB, batchSize int) (int64, error) {
+	tables, err := postTables(db)
+	if err != nil {
+		return 0, err
+	}
+
+	var corrected int64
+	for _, table := range tables {
+		var maxID sql.NullInt64
+		if err := db.QueryRow("SELECT MAX(_id) FROM " + table).Scan(&maxID); err != nil {
+			return corrected, fmt.Errorf("error reading %s: %v", table, err)
+		}
+
+		update := fmt.Sprintf(`
+			UPDATE %[1]s SET tag_count = (%[2]s)
+			WHERE _id > ? AND _id <= ? AND tag_count IS NOT (%[2]s)
+		`, table, tagCountExpr(table))
+		for start := int64(0); start < maxID.Int64; start += int64(batchSize) {
+			tx, err := db.Begin()
+			if err != nil {
+				return corrected, fmt.Errorf("error starting transaction: %v", err)
+			}
+			res, err := tx.Exec(update, start, start+int64(batchSize))
+			if err != nil {
+				tx.Rollback()
+				return corrected, fmt.Errorf("error updating tag counts of %s: %v", table, err)
+			}
+			if err := tx.Commit(); err != nil {
+				return corrected, fmt.Errorf("error committing tag counts: %v", err)
+			}
+			n, _ := res.RowsAffected()
+			corrected += n
+		}
+	}
+	return corrected, nil
+}
+
+// tagCountExpr returns the SQL expression computing the number of tags of a post
+// in table: the length of its tags array, or its post_tag links in compact mode
+func tagCountExpr(table string) string {
+	return fmt.Sprintf(`CASE
+		WHEN %[1]s.tags IS NOT NULL THEN json_array_length(%[1]s.tags)
+		ELSE (SELECT COUNT(*) FROM post_tag WHERE post_tag.post_id = %[1]s._id)
+	END`, table)
+}
+
+// pruneTagDict removes post_tag links to posts that no longer exist and the
+// tag_dict entries no post links to anymore, returning how many of each were
+// removed
+func pruneTagDict(db *sql.DB) (int64, int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM post_tag WHERE post_id NOT IN (SELECT _id FROM posts)")
+	if err != nil {
+		return 0, 0, fmt.Errorf("error pruning post_tag: %v", err)
+	}
+	links, _ := res.RowsAffected()
+
+	res, err = tx.Exec("DELETE FROM tag_dict WHERE id NOT IN (SELECT tag_id FROM post_tag)")
+	if err != nil {
+		return 0, 0, fmt.Errorf("error pruning tag_dict: %v", err)
+	}
+	tags, _ := res.RowsAffected()
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("error committing tag pruning: %v", err)
+	}
+	return links, tags, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRebuildAux(t *testing.T) {
+	for _, compact := range []bool{false, true} {
+		db, bp := newTestProcessor(t, func(c *Config) { c.CompactTags = compact })
+		withTags := func(permlink, metadata string) Operation {
+			op := testPost("alice", permlink, permlink)
+			op.Value.JsonMetadata = metadata
+			return op
+		}
+		if _, err := bp.processBlock(context.Background(), testBlock(100,
+			withTags("none", `{}`),
+			withTags("one", `{"tags":["hive"]}`),
+			withTags("two", `{"tags":["hive","art"]}`),
+			withTags("three", `{"tags":["hive","art","photo"]}`),
+			withTags("also-two", `{"tags":["art","travel"]}`),
+		)); err != nil {
+			t.Fatal(err)
+		}
+
+		// The derived data drifted: counts are wrong, a link points to a post
+		// that is gone and a tag is used by no post
+		if _, err := db.Exec(`
+			UPDATE posts SET tag_count = 7 WHERE url IN ('@alice/two', '@alice/none');
+			UPDATE posts SET tag_count = NULL WHERE url = '@alice/three';
+			INSERT INTO tag_dict (tag) VALUES ('orphaned');
+			INSERT INTO post_tag (post_id, tag_id, position) SELECT 999, id, 0 FROM tag_dict WHERE tag = 'orphaned';
+			INSERT INTO tag_dict (tag) VALUES ('unused');
+		`); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := runRebuildAux(bp.config, []string{"-batch", "2"}); err != nil {
+			t.Fatal(err)
+		}
+
+		want := map[string]int{"@alice/none": 0, "@alice/one": 1, "@alice/two": 2, "@alice/three": 3, "@alice/also-two": 2}
+		for url, count := range want {
+			var got int
+			if err := db.QueryRow("SELECT tag_count FROM posts WHERE url = ?", url).Scan(&got); err != nil {
+				t.Fatalf("compact %v: tag_count of %s: %v", compact, url, err)
+			}
+			if got != count {
+				t.Errorf("compact %v: tag_count of %s = %d, want %d", compact, url, got, count)
+			}
+		}
+
+		var orphanedLinks, unusedTags, usedTags int
+		if err := db.QueryRow("SELECT COUNT(*) FROM post_tag WHERE post_id = 999").Scan(&orphanedLinks); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.QueryRow("SELECT COUNT(*) FROM tag_dict WHERE tag IN ('orphaned', 'unused')").Scan(&unusedTags); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.QueryRow("SELECT COUNT(*) FROM tag_dict").Scan(&usedTags); err != nil {
+			t.Fatal(err)
+		}
+		if orphanedLinks != 0 || unusedTags != 0 {
+			t.Errorf("compact %v: %d orphaned links and %d unused tags left, want none", compact, orphanedLinks, unusedTags)
+		}
+		// The tags of the stored posts are kept
+		wantTags := 0
+		if compact {
+			wantTags = 4
+		}
+		if usedTags != wantTags {
+			t.Errorf("compact %v: tag_dict holds %d tags, want %d", compact, usedTags, wantTags)
+		}
+	}
+}
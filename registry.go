@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// OpContext carries the block-level information available to an operation
+// handler while a block is being processed.
+type OpContext struct {
+	// Context is the context the block is processed under. Handlers making
+	// requests to the node pass it on, so a shutdown abandons them.
+	Context   context.Context
+	BlockNum  int64
+	BlockID   string
+	Timestamp string
+	Witness   string
+	// FetchedAt is when the block was received from the node
+	FetchedAt time.Time
+	// TxIndex and OpIndex locate the operation within its block: the position of
+	// its transaction in the block, and its position in that transaction
+	TxIndex int
+	OpIndex int
+	// TransactionID is the id of the operation's transaction, "" if the node
+	// didn't return it
+	TransactionID string
+}
+
+// OpHandler processes a single operation of the type it was registered for.
+//
+// It returns the number of rows written to the database, which is added to the
+// block's processed count, and an error if the operation could not be stored.
+type OpHandler func(ctx *OpContext, value OperationValue) (int, error)
+
+// OpRegistry maps operation types (e.g. "comment_operation") to the handler
+// responsible for them. Operations without a registered handler are ignored.
+type OpRegistry struct {
+	handlers map[string]OpHandler
+}
+
+// NewOpRegistry creates an empty OpRegistry
+func NewOpRegistry() *OpRegistry {
+	return &OpRegistry{handlers: make(map[string]OpHandler)}
+}
+
+// Register adds a handler for the given operation type, replacing any handler
+// previously registered for it.
+func (r *OpRegistry) Register(opType string, handler OpHandler) {
+	r.handlers[opType] = handler
+}
+
+// Unregister removes the handler for the given operation type, if any
+func (r *OpRegistry) Unregister(opType string) {
+	delete(r.handlers, opType)
+}
+
+// Lookup returns the handler registered for the given operation type
+func (r *OpRegistry) Lookup(opType string) (OpHandler, bool) {
+	handler, ok := r.handlers[opType]
+	return handler, ok
+}
@@ -0,0 +1,71 @@
+package main
+
+import "testing"
+
+func TestOpRegistry(t *testing.T) {
+	handler := func(n int) OpHandler {
+		return func(ctx *OpContext, value OperationValue) (int, error) { return n, nil }
+	}
+
+	tests := []struct {
+		name    string
+		setup   func(r *OpRegistry)
+		opType  string
+		wantOK  bool
+		wantRet int
+	}{
+		{
+			name:   "empty registry",
+			setup:  func(r *OpRegistry) {},
+			opType: "comment_operation",
+		},
+		{
+			name:    "registered",
+			setup:   func(r *OpRegistry) { r.Register("comment_operation", handler(1)) },
+			opType:  "comment_operation",
+			wantOK:  true,
+			wantRet: 1,
+		},
+		{
+			name:   "other type",
+			setup:  func(r *OpRegistry) { r.Register("comment_operation", handler(1)) },
+			opType: "vote_operation",
+		},
+		{
+			name: "replaced",
+			setup: func(r *OpRegistry) {
+				r.Register("comment_operation", handler(1))
+				r.Register("comment_operation", handler(2))
+			},
+			opType:  "comment_operation",
+			wantOK:  true,
+			wantRet: 2,
+		},
+		{
+			name: "unregistered",
+			setup: func(r *OpRegistry) {
+				r.Register("comment_operation", handler(1))
+				r.Unregister("comment_operation")
+			},
+			opType: "comment_operation",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewOpRegistry()
+			tt.setup(r)
+
+			got, ok := r.Lookup(tt.opType)
+			if ok != tt.wantOK {
+				t.Fatalf("Lookup(%q) found = %v, want %v", tt.opType, ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if n, _ := got(&OpContext{}, OperationValue{}); n != tt.wantRet {
+				t.Errorf("handler returned %d, want %d", n, tt.wantRet)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import "fmt"
+
+// repliesTableSQL creates the replies table, which holds the comments replying to
+// posts and other comments when IndexReplies is enabled. Replies are linked to
+// what they reply to by parent_author and parent_permlink, and are never
+// partitioned.
+const repliesTableSQL = `
+	CREATE TABLE IF NOT EXISTS replies (
+		_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url TEXT UNIQUE,
+		author TEXT,
+		permlink TEXT,
+		parent_author TEXT,
+		parent_permlink TEXT,
+		block_num INTEGER,
+		timestamp TEXT,
+		timestamp_epoch INTEGER
+	);
+	CREATE INDEX IF NOT EXISTS idx_replies_parent ON replies(parent_author, parent_permlink);
+	CREATE INDEX IF NOT EXISTS idx_replies_block_num ON replies(block_num);
+`
+
+// insertReplySQL inserts a reply, keeping the first version of a reply that is
+// already stored just like the posts insert does
+const insertReplySQL = `
+	INSERT INTO replies (url, author, permlink, parent_author, parent_permlink, block_num, timestamp, timestamp_epoch)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(url) DO NOTHING
+`
+
+// handleReply stores a reply from a "comment_operation" in the replies table.
+// Top-level posts are left to handleComment; handleComment hands replies to
+// handleReply, which is registered on its own when only IndexReplies is enabled.
+func (bp *BlockProcessor) handleReply(ctx *OpContext, value OperationValue) (int, error) {
+	if value.ParentAuthor == "" {
+		return 0, nil
+	}
+
+	timestamp, epoch := normalizeTimestamp(ctx.Timestamp)
+	var inserted int64
+	err := bp.retryDB(func() error {
+		result, err := bp.txStmt(bp.replyStmt).Exec(
+			constructAuthorPerm(value.Author, value.Permlink),
+			value.Author,
+			value.Permlink,
+			value.ParentAuthor,
+			value.ParentPermlink,
+			ctx.BlockNum,
+			timestamp,
+			epoch,
+		)
+		if err != nil {
+			return err
+		}
+		inserted, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error inserting reply: %w", err)
+	}
+	return int(inserted), nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestIndexReplies(t *testing.T) {
+	reply := func(author, permlink, parentAuthor, parentPermlink string) Operation {
+		op := testPost(author, permlink, "")
+		op.Value.ParentAuthor, op.Value.ParentPermlink = parentAuthor, parentPermlink
+		return op
+	}
+	block := testBlock(100,
+		testPost("alice", "post", "Post"),
+		reply("bob", "re-post", "alice", "post"),
+		reply("carol", "re-re-post", "bob", "re-post"),
+		// An edit of a stored reply keeps the reply stored first
+		reply("bob", "re-post", "alice", "other"),
+	)
+
+	tests := []struct {
+		name        string
+		configure   func(*Config)
+		wantPosts   []string
+		wantReplies []string
+	}{
+		{"disabled", nil, []string{"@alice/post"}, []string{}},
+		{
+			"enabled",
+			func(c *Config) { c.IndexReplies = true },
+			[]string{"@alice/post"},
+			[]string{"@bob/re-post -> @alice/post", "@carol/re-re-post -> @bob/re-post"},
+		},
+		{
+			"without posts",
+			func(c *Config) { c.IndexReplies = true; c.ProcessComments = false },
+			[]string{},
+			[]string{"@bob/re-post -> @alice/post", "@carol/re-re-post -> @bob/re-post"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, bp := newTestProcessor(t, tt.configure)
+			if _, err := bp.processBlock(context.Background(), block); err != nil {
+				t.Fatal(err)
+			}
+
+			query := func(q string) []string {
+				t.Helper()
+				rows, err := db.Query(q)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer rows.Close()
+				result := []string{}
+				for rows.Next() {
+					var s string
+					if err := rows.Scan(&s); err != nil {
+						t.Fatal(err)
+					}
+					result = append(result, s)
+				}
+				return result
+			}
+			if posts := query("SELECT url FROM posts ORDER BY url"); !reflect.DeepEqual(posts, tt.wantPosts) {
+				t.Errorf("posts holds %v, want %v", posts, tt.wantPosts)
+			}
+			replies := query("SELECT url || ' -> @' || parent_author || '/' || parent_permlink FROM replies ORDER BY url")
+			if !reflect.DeepEqual(replies, tt.wantReplies) {
+				t.Errorf("replies holds %v, want %v", replies, tt.wantReplies)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// reputationCache looks up author reputations for StoreReputation, caching them
+// for the rest of the run.
+//
+// Stored reputations are a point-in-time snapshot taken when the post is indexed,
+// not the reputation the author had when the post was published. Lookups are
+// limited to ReputationConcurrency in-flight requests.
+type reputationCache struct {
+	config *Config
+	fetch  func(ctx context.Context, account string) (int64, error)
+	sem    chan struct{}
+
+	mu    sync.Mutex
+	cache map[string]int64
+}
+
+// newReputationCache creates a reputationCache that queries the configured node
+func newReputationCache(config *Config) *reputationCache {
+	concurrency := config.ReputationConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	return &reputationCache{
+		config: config,
+		fetch: func(ctx context.Context, account string) (int64, error) {
+			return getAccountReputation(ctx, config, account)
+		},
+		sem:   make(chan struct{}, concurrency),
+		cache: make(map[string]int64),
+	}
+}
+
+// Get returns the reputation of account. It returns false if the reputation could
+// not be retrieved, in which case the failure is logged and not cached. A lookup
+// still in flight when ctx is cancelled is abandoned rather than holding up the
+// shutdown.
+func (c *reputationCache) Get(ctx context.Context, account string) (int64, bool) {
+	c.mu.Lock()
+	reputation, ok := c.cache[account]
+	c.mu.Unlock()
+	if ok {
+		return reputation, true
+	}
+
+	c.sem <- struct{}{}
+	defer func() { <-c.sem }()
+
+	err := retryWithBackoff(c.config.MaxRetries, c.config.RetryDelay, func() error {
+		var err error
+		reputation, err = c.fetch(ctx, account)
+		return err
+	})
+	if err != nil {
+		slog.Error("Error getting reputation", "account", account, "error", err)
+		return 0, false
+	}
+
+	c.mu.Lock()
+	c.cache[account] = reputation
+	c.mu.Unlock()
+	return reputation, true
+}
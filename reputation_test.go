@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestStoreReputation(t *testing.T) {
+	// Nodes return reputations either as numbers or as numeric strings
+	reputations := map[string]json.RawMessage{
+		"alice": json.RawMessage(`95832978796820`),
+		"bob":   json.RawMessage(`"-1234"`),
+	}
+	var mu sync.Mutex
+	lookups := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string     `json:"method"`
+			Params [][]string `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Method != "condenser_api.get_accounts" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		account := req.Params[0][0]
+		mu.Lock()
+		lookups[account]++
+		mu.Unlock()
+
+		accounts := []map[string]interface{}{}
+		if reputation, ok := reputations[account]; ok {
+			accounts = append(accounts, map[string]interface{}{"name": account, "reputation": reputation})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "result": accounts, "id": 1})
+	}))
+	defer srv.Close()
+
+	db, bp := newTestProcessor(t, func(c *Config) {
+		c.HiveAPIURLs = []string{srv.URL}
+		c.StoreReputation = true
+	})
+	block := testBlock(100,
+		testPost("alice", "first", "First"),
+		testPost("alice", "second", "Second"),
+		testPost("bob", "third", "Third"),
+		testPost("carol", "fourth", "Fourth"),
+	)
+	if _, err := bp.processBlock(context.Background(), block); err != nil {
+		t.Fatal(err)
+	}
+
+	for url, want := range map[string]sql.NullInt64{
+		"@alice/first":  {Int64: 95832978796820, Valid: true},
+		"@alice/second": {Int64: 95832978796820, Valid: true},
+		"@bob/third":    {Int64: -1234, Valid: true},
+		// Accounts the node doesn't know about are stored without a reputation
+		"@carol/fourth": {},
+	} {
+		var got sql.NullInt64
+		if err := db.QueryRow("SELECT author_reputation FROM posts WHERE url = ?", url).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("author_reputation of %s = %+v, want %+v", url, got, want)
+		}
+	}
+	if lookups["alice"] != 1 {
+		t.Errorf("looked up alice %d times, want the cached reputation to be reused", lookups["alice"])
+	}
+}
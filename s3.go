@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Uploader stores an object in an S3-compatible object store. The export command
+// writes s3:// outputs through it, so tests can substitute a fake.
+type Uploader interface {
+	Upload(ctx context.Context, bucket, key string, body io.Reader) error
+}
+
+// parseS3URL splits an s3://bucket/key output path into its bucket and key. The
+// boolean is false for anything else, such as a local file path.
+func parseS3URL(path string) (bucket, key string, ok bool) {
+	rest, found := strings.CutPrefix(path, "s3://")
+	if !found {
+		return "", "", false
+	}
+	bucket, key, _ = strings.Cut(rest, "/")
+	return bucket, key, bucket != "" && key != ""
+}
+
+// s3Writer streams written bytes to an Uploader running in the background. The
+// upload completes, and its error is reported, on Close.
+type s3Writer struct {
+	pipe *io.PipeWriter
+	done chan error
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// newS3Writer starts uploading everything written to the returned writer to
+// bucket/key
+func newS3Writer(ctx context.Context, uploader Uploader, bucket, key string) *s3Writer {
+	pr, pw := io.Pipe()
+	w := &s3Writer{pipe: pw, done: make(chan error, 1)}
+	go func() {
+		err := uploader.Upload(ctx, bucket, key, pr)
+		// Unblock the writer if the upload stopped reading early
+		pr.CloseWithError(err)
+		w.done <- err
+	}()
+	return w
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	return w.pipe.Write(p)
+}
+
+// Close ends the object and waits for the upload to finish. Calling it again
+// returns the same result.
+func (w *s3Writer) Close() error {
+	return w.finish(nil)
+}
+
+// Abort fails the upload with err instead of completing the object, so a failed
+// export never leaves a truncated object behind
+func (w *s3Writer) Abort(err error) {
+	w.finish(err)
+}
+
+// finish closes the pipe, with err if set, and waits for the upload
+func (w *s3Writer) finish(err error) error {
+	w.closeOnce.Do(func() {
+		w.pipe.CloseWithError(err)
+		w.closeErr = <-w.done
+	})
+	return w.closeErr
+}
+
+// s3Uploader uploads objects with a signed PUT request. Credentials are read
+// from the standard AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and optional
+// AWS_SESSION_TOKEN environment variables.
+type s3Uploader struct {
+	client       *http.Client
+	endpoint     string
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+// newS3Uploader creates an s3Uploader for the configured region and endpoint,
+// returning an error if no credentials are set
+func newS3Uploader(config *Config) (*s3Uploader, error) {
+	u := &s3Uploader{
+		client:       http.DefaultClient,
+		endpoint:     strings.TrimSuffix(config.S3Endpoint, "/"),
+		region:       config.S3Region,
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if u.accessKey == "" || u.secretKey == "" {
+		return nil, errors.New("S3 output requires AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	if u.endpoint == "" {
+		u.endpoint = "https://s3." + u.region + ".amazonaws.com"
+	}
+	return u, nil
+}
+
+// Upload stores body as bucket/key with a single PUT request, addressing the
+// bucket in the path so custom endpoints work too.
+//
+// S3 needs the size of an object up front, so the body is spooled to a temporary
+// file first. Single requests are limited to objects of 5 GB.
+func (u *s3Uploader) Upload(ctx context.Context, bucket, key string, body io.Reader) error {
+	spool, err := os.CreateTemp("", "post-stuffer-upload-*")
+	if err != nil {
+		return fmt.Errorf("error creating upload spool file: %v", err)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	size, err := io.Copy(spool, body)
+	if err != nil {
+		return fmt.Errorf("error spooling upload: %v", err)
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("error spooling upload: %v", err)
+	}
+
+	path := "/" + bucket + "/" + awsURIEncodePath(key)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.endpoint+path, io.NopCloser(spool))
+	if err != nil {
+		return fmt.Errorf("error creating upload request: %v", err)
+	}
+	req.ContentLength = size
+	req.Header.Set("x-amz-content-sha256", "UNSIGNED-PAYLOAD")
+	if u.sessionToken != "" {
+		req.Header.Set("x-amz-security-token", u.sessionToken)
+	}
+	u.sign(req, path, time.Now())
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error uploading s3://%s/%s: %w", bucket, key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("error uploading s3://%s/%s: %s: %s", bucket, key, resp.Status, msg)
+	}
+	return nil
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to req, covering
+// the host and every x-amz-* header. path is the URI-encoded request path, and
+// the payload hash is taken from the x-amz-content-sha256 header.
+func (u *s3Uploader) sign(req *http.Request, path string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("x-amz-date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") || lower == "range" {
+			headers[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("x-amz-content-sha256"),
+	}, "\n")
+
+	scope := date + "/" + u.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hexSHA256(canonicalRequest)
+
+	signingKey := hmacSHA256([]byte("AWS4"+u.secretKey), date)
+	signingKey = hmacSHA256(signingKey, u.region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		u.accessKey, scope, signedHeaders, signature))
+}
+
+// awsURIEncodePath percent-encodes an object key as required by Signature
+// Version 4, leaving only unreserved characters and slashes as they are
+func awsURIEncodePath(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9',
+			c == '-', c == '.', c == '_', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data under key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// hexSHA256 returns the hex-encoded SHA-256 of s
+func hexSHA256(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
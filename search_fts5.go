@@ -0,0 +1,181 @@
+//go:build sqlite_fts5
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// SearchResult is a single post matched by the /search endpoint
+type SearchResult struct {
+	URL      string  `json:"url"`
+	Author   string  `json:"author"`
+	Permlink string  `json:"permlink"`
+	Title    string  `json:"title"`
+	Snippet  string  `json:"snippet"`
+	Rank     float64 `json:"rank"`
+}
+
+// searchIndexSQL creates posts_fts, an external-content FTS5 table over the
+// title column of posts, and the triggers keeping it in step with the table
+const searchIndexSQL = `
+	CREATE VIRTUAL TABLE IF NOT EXISTS posts_fts USING fts5(title, content='posts', content_rowid='_id');
+	CREATE TRIGGER IF NOT EXISTS posts_fts_insert AFTER INSERT ON posts
+	BEGIN
+		INSERT INTO posts_fts (rowid, title) VALUES (new._id, new.title);
+	END;
+	CREATE TRIGGER IF NOT EXISTS posts_fts_update AFTER UPDATE OF title ON posts
+	WHEN old.title IS NOT new.title
+	BEGIN
+		INSERT INTO posts_fts (posts_fts, rowid, title) VALUES ('delete', old._id, old.title);
+		INSERT INTO posts_fts (rowid, title) VALUES (new._id, new.title);
+	END;
+	CREATE TRIGGER IF NOT EXISTS posts_fts_delete AFTER DELETE ON posts
+	BEGIN
+		INSERT INTO posts_fts (posts_fts, rowid, title) VALUES ('delete', old._id, old.title);
+	END;
+`
+
+// migrateSearchIndex creates the search index of an FTS5 build, filling it from
+// the posts already stored, unless its triggers are already installed.
+//
+// Only the posts table is indexed. The monthly partitions of PartitionByMonth
+// number their posts independently, so posts stored in them can't be searched.
+func migrateSearchIndex(db *sql.DB) error {
+	installed, err := searchIndexInstalled(db)
+	if err != nil || installed {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error creating search index: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(searchIndexSQL); err != nil {
+		return fmt.Errorf("error creating search index: %v", err)
+	}
+	if _, err := tx.Exec("INSERT INTO posts_fts (posts_fts) VALUES ('rebuild')"); err != nil {
+		return fmt.Errorf("error filling search index: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error creating search index: %v", err)
+	}
+	slog.Info("Created the search index")
+	return nil
+}
+
+// searchHandler serves full-text searches over post titles using the posts_fts
+// index maintained by the indexer
+type searchHandler struct {
+	db *sql.DB
+}
+
+// newSearchHandler returns the handler for /search. A database last migrated by
+// a build without FTS5 support has no search index, which is reported by the
+// handler rather than failing the server.
+func newSearchHandler(db *sql.DB) (http.Handler, error) {
+	installed, err := searchIndexInstalled(db)
+	if err != nil {
+		return nil, err
+	}
+	if !installed {
+		slog.Warn("The database has no search index; run a sync with a build with -tags sqlite_fts5 to create it")
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeError(w, http.StatusServiceUnavailable, "the database has no search index")
+		}), nil
+	}
+
+	tables, err := postTables(db)
+	if err != nil {
+		return nil, err
+	}
+	if len(tables) > 1 {
+		slog.Warn("Posts stored in monthly partitions are not searchable", "partitions", len(tables)-1)
+	}
+	return &searchHandler{db: db}, nil
+}
+
+// rebuildSearchIndex repopulates posts_fts from the posts table and reports true
+func rebuildSearchIndex(db *sql.DB) (bool, error) {
+	if _, err := db.Exec("INSERT INTO posts_fts (posts_fts) VALUES ('rebuild')"); err != nil {
+		return false, fmt.Errorf("error rebuilding search index: %v", err)
+	}
+	return true, nil
+}
+
+// ServeHTTP handles GET /search?q=&limit=, returning the matching posts ranked by
+// BM25 along with a highlighted snippet of the title
+func (h *searchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := sanitizeSearchQuery(r.URL.Query().Get("q"))
+	if query == "" {
+		writeError(w, http.StatusBadRequest, "missing search query")
+		return
+	}
+
+	limit := 20
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		if n > 100 {
+			n = 100
+		}
+		limit = n
+	}
+
+	rows, err := h.db.Query(`
+		SELECT posts.url, posts.author, posts.permlink, posts.title,
+			snippet(posts_fts, 0, '[', ']', '...', 10), posts_fts.rank
+		FROM posts_fts
+		JOIN posts ON posts._id = posts_fts.rowid
+		WHERE posts_fts MATCH ?
+		ORDER BY posts_fts.rank
+		LIMIT ?
+	`, query, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var res SearchResult
+		if err := rows.Scan(&res.URL, &res.Author, &res.Permlink, &res.Title, &res.Snippet, &res.Rank); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		results = append(results, res)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, results)
+}
+
+// sanitizeSearchQuery turns free text into an FTS5 query that matches posts
+// containing all of its words. Every word is quoted, so FTS5 operators and column
+// filters in the input are treated as plain text.
+func sanitizeSearchQuery(q string) string {
+	words := strings.FieldsFunc(q, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	quoted := make([]string, 0, len(words))
+	for _, word := range words {
+		quoted = append(quoted, `"`+word+`"`)
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,164 @@
+//go:build sqlite_fts5
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSearchIndex(t *testing.T) {
+	db, bp := newTestProcessor(t, nil)
+	block := testBlock(100,
+		testPost("alice", "first", "Hello world"),
+		testPost("bob", "second", "Goodbye moon"),
+		testPost("carol", "third", "Hello moon"),
+	)
+	if _, err := bp.processBlock(context.Background(), block); err != nil {
+		t.Fatal(err)
+	}
+
+	search := func(t *testing.T, q string) []string {
+		t.Helper()
+		readOnly, err := openCurrentDB(bp.config.DBPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer readOnly.Close()
+		h, err := newSearchHandler(readOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q="+q, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /search?q=%s: %d %s", q, rec.Code, rec.Body)
+		}
+		var results []SearchResult
+		if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+			t.Fatal(err)
+		}
+		urls := []string{}
+		for _, res := range results {
+			urls = append(urls, res.URL)
+		}
+		return urls
+	}
+
+	steps := []struct {
+		name string
+		sql  string
+		q    string
+		want []string
+	}{
+		{"stored posts", "", "moon", []string{"@bob/second", "@carol/third"}},
+		{"edited title", "UPDATE posts SET title = 'Hello sun' WHERE url = '@bob/second'", "hello", []string{"@alice/first", "@bob/second", "@carol/third"}},
+		{"old title dropped", "", "goodbye", []string{}},
+		{"deleted post", "DELETE FROM posts WHERE url = '@alice/first'", "hello", []string{"@bob/second", "@carol/third"}},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			if step.sql != "" {
+				if _, err := db.Exec(step.sql); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got := search(t, step.q)
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, step.want) {
+				t.Errorf("search %q = %v, want %v", step.q, got, step.want)
+			}
+		})
+	}
+}
+
+func TestMigrateSearchIndex(t *testing.T) {
+	db, bp := newTestProcessor(t, nil)
+	// Posts stored while the index triggers are missing, as in a database last
+	// migrated by a build without FTS5
+	if _, err := db.Exec("DROP TRIGGER posts_fts_insert"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bp.processBlock(context.Background(), testBlock(100, testPost("alice", "first", "Hello world"))); err != nil {
+		t.Fatal(err)
+	}
+
+	migrated, err := initDB(bp.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer migrated.Close()
+
+	var count int
+	if err := migrated.QueryRow("SELECT COUNT(*) FROM posts_fts WHERE posts_fts MATCH 'hello'").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("search index holds %d matching posts after migration, want 1", count)
+	}
+	if installed, err := searchIndexInstalled(migrated); err != nil || !installed {
+		t.Errorf("searchIndexInstalled() = %v, %v, want true", installed, err)
+	}
+}
+
+func TestSearchRank(t *testing.T) {
+	db, bp := newTestProcessor(t, nil)
+	block := testBlock(100,
+		testPost("alice", "long", "Notes on cooking pasta with friends and then some hive"),
+		testPost("bob", "unrelated", "Cooking pasta"),
+		testPost("carol", "repeated", "Hive hive hive"),
+		testPost("dave", "short", "Hive news today"),
+	)
+	if _, err := bp.processBlock(context.Background(), block); err != nil {
+		t.Fatal(err)
+	}
+	h, err := newSearchHandler(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		q    string
+		want []string
+	}{
+		{"rank order", "hive", []string{"@carol/repeated", "@dave/short", "@alice/long"}},
+		{"limit", "hive&limit=2", []string{"@carol/repeated", "@dave/short"}},
+		{"all words", "hive+pasta", []string{"@alice/long"}},
+		{"query syntax is plain text", url.QueryEscape(`hive" OR title:*`), []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q="+tt.q, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("GET /search?q=%s: %d %s", tt.q, rec.Code, rec.Body)
+			}
+			var results []SearchResult
+			if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+				t.Fatal(err)
+			}
+			urls := []string{}
+			for i, res := range results {
+				urls = append(urls, res.URL)
+				if i > 0 && res.Rank < results[i-1].Rank {
+					t.Errorf("result %d ranks %v, better than %v before it", i, res.Rank, results[i-1].Rank)
+				}
+				if !strings.Contains(strings.ToLower(res.Snippet), "[hive]") {
+					t.Errorf("snippet %q of %s does not highlight the match", res.Snippet, res.URL)
+				}
+			}
+			if !reflect.DeepEqual(urls, tt.want) {
+				t.Errorf("search %q = %v, want %v", tt.q, urls, tt.want)
+			}
+		})
+	}
+}
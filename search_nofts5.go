@@ -0,0 +1,37 @@
+//go:build !sqlite_fts5
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+)
+
+// newSearchHandler returns a /search handler reporting that search is unavailable,
+// since full-text search needs SQLite's FTS5 extension, which go-sqlite3 only
+// includes when built with -tags sqlite_fts5
+func newSearchHandler(db *sql.DB) (http.Handler, error) {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotImplemented, "search requires a build with -tags sqlite_fts5")
+	}), nil
+}
+
+// migrateSearchIndex refuses a database holding the search index of an FTS5
+// build, since its triggers can't update the index without the extension, so
+// every post stored would fail
+func migrateSearchIndex(db *sql.DB) error {
+	installed, err := searchIndexInstalled(db)
+	if err != nil {
+		return err
+	}
+	if installed {
+		return fmt.Errorf("the database has a search index, which only a build with -tags sqlite_fts5 can keep up to date")
+	}
+	return nil
+}
+
+// rebuildSearchIndex reports false, as a build without FTS5 has no search index
+func rebuildSearchIndex(db *sql.DB) (bool, error) {
+	return false, nil
+}
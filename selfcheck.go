@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// rpcError is the error object of a JSON-RPC response
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// callRPC makes a single JSON-RPC call to node and returns the raw result,
+// reporting an error response from the node as an error
+func callRPC(node string, method string, params interface{}) (json.RawMessage, error) {
+	payload := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  method,
+		"params":  params,
+		"id":      1,
+	}
+
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Result json.RawMessage `json:"result"`
+		Error  *rpcError       `json:"error"`
+	}
+	if err := postNode(context.Background(), node, jsonData, &result); err != nil {
+		return nil, err
+	}
+	if result.Error != nil {
+		return nil, fmt.Errorf("rpc error %d: %s", result.Error.Code, result.Error.Message)
+	}
+	if len(result.Result) == 0 {
+		return nil, fmt.Errorf("empty result")
+	}
+	return result.Result, nil
+}
+
+// selfCheck verifies that every configured node is reachable and supports the
+// API methods the indexer relies on; see checkNode
+func selfCheck(config *Config) error {
+	for _, node := range config.HiveAPIURLs {
+		if err := checkNode(config, node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkNode verifies a single node by reading the head block number and fetching
+// the head block with the method used for batches. The returned error names the
+// node and the failing method.
+func checkNode(config *Config, node string) error {
+	raw, err := callRPC(node, "database_api.get_dynamic_global_properties", map[string]interface{}{})
+	if err != nil {
+		return fmt.Errorf("self-check of %s failed: database_api.get_dynamic_global_properties: %v", node, err)
+	}
+	var props struct {
+		HeadBlockNumber int `json:"head_block_number"`
+	}
+	if err := json.Unmarshal(raw, &props); err != nil || props.HeadBlockNumber <= 0 {
+		return fmt.Errorf("self-check of %s failed: database_api.get_dynamic_global_properties returned no head block", node)
+	}
+
+	method := "block_api.get_block_range"
+	params := map[string]interface{}{"starting_block_num": props.HeadBlockNumber, "count": 1}
+	if config.BatchRequests {
+		method = "block_api.get_block"
+		params = map[string]interface{}{"block_num": props.HeadBlockNumber}
+	}
+	if _, err := callRPC(node, method, params); err != nil {
+		return fmt.Errorf("self-check of %s failed: %s: %v", node, method, err)
+	}
+
+	if config.StoreReputation {
+		if _, err := callRPC(node, "condenser_api.get_accounts", []interface{}{[]string{}}); err != nil {
+			return fmt.Errorf("self-check of %s failed: condenser_api.get_accounts: %v", node, err)
+		}
+	}
+
+	return nil
+}
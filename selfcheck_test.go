@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSelfCheck(t *testing.T) {
+	results := map[string]interface{}{
+		"database_api.get_dynamic_global_properties": map[string]int{"head_block_number": 100},
+		"block_api.get_block_range":                  map[string][]Block{"blocks": {testBlock(100)}},
+		"block_api.get_block":                        map[string]Block{"block": testBlock(100)},
+		"condenser_api.get_accounts":                 []interface{}{},
+	}
+	// newNode serves every method the self-check uses except missing, which it
+	// answers with the JSON-RPC error of an unknown method
+	newNode := func(missing string) string {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req struct {
+				Method string `json:"method"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			resp := map[string]interface{}{"jsonrpc": "2.0", "id": 1}
+			if result, ok := results[req.Method]; ok && req.Method != missing {
+				resp["result"] = result
+			} else {
+				resp["error"] = rpcError{Code: -32601, Message: "Could not find method " + req.Method}
+			}
+			json.NewEncoder(w).Encode(resp)
+		}))
+		t.Cleanup(srv.Close)
+		return srv.URL
+	}
+
+	tests := []struct {
+		name      string
+		missing   string
+		configure func(*Config)
+		wantErr   string
+	}{
+		{name: "supported node"},
+		{name: "missing head block", missing: "database_api.get_dynamic_global_properties", wantErr: "database_api.get_dynamic_global_properties"},
+		{name: "missing block range", missing: "block_api.get_block_range", wantErr: "block_api.get_block_range"},
+		{name: "block range unused with batch requests", missing: "block_api.get_block_range", configure: func(c *Config) { c.BatchRequests = true }},
+		{name: "missing single block with batch requests", missing: "block_api.get_block", configure: func(c *Config) { c.BatchRequests = true }, wantErr: "block_api.get_block"},
+		{name: "missing accounts with reputations", missing: "condenser_api.get_accounts", configure: func(c *Config) { c.StoreReputation = true }, wantErr: "condenser_api.get_accounts"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			healthy, node := newNode(""), newNode(tt.missing)
+			config := DefaultConfig()
+			config.HiveAPIURLs = []string{healthy, node}
+			if tt.configure != nil {
+				tt.configure(config)
+			}
+
+			err := selfCheck(config)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("selfCheck() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), node) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("selfCheck() = %v, want an error naming %s and %s", err, node, tt.wantErr)
+			}
+		})
+	}
+}
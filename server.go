@@ -0,0 +1,90 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"log/slog"
+	"net/http"
+)
+
+// runServe implements the "serve" command, which exposes the indexed posts over a
+// small read-only HTTP API.
+//
+// The database is opened read-only and is not migrated, so the server never
+// changes it; the search index is kept up to date by the indexer.
+func runServe(config *Config, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", ":8080", "address to listen on")
+	fs.Parse(args)
+
+	db, err := openCurrentDB(config.DBPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	mux, err := newServeMux(db)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Serving", "addr", *addr)
+	return http.ListenAndServe(*addr, mux)
+}
+
+// newServeMux creates the HTTP handler serving the API endpoints from db
+func newServeMux(db *sql.DB) (*http.ServeMux, error) {
+	mux := http.NewServeMux()
+
+	search, err := newSearchHandler(db)
+	if err != nil {
+		return nil, err
+	}
+	mux.Handle("/search", search)
+	mux.HandleFunc("GET /posts/{author}/{permlink}/raw", handleRawMetadata(db))
+	mux.HandleFunc("GET /status", handleStatus(db))
+	mux.HandleFunc("GET /metrics", handleMetrics(db))
+
+	return mux, nil
+}
+
+// handleRawMetadata serves GET /posts/{author}/{permlink}/raw, returning the raw
+// JSON metadata stored for the post exactly as it appeared on chain. It responds
+// with 404 when the post is unknown or was stored without StoreRawMetadata.
+func handleRawMetadata(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		url := constructAuthorPerm(r.PathValue("author"), r.PathValue("permlink"))
+		raw, ok, err := getRawMetadata(db, url)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !ok {
+			writeError(w, http.StatusNotFound, "no raw metadata stored for "+url)
+			return
+		}
+
+		// Malformed metadata is returned verbatim too, just not labelled as JSON
+		contentType := "text/plain; charset=utf-8"
+		if json.Valid([]byte(raw)) {
+			contentType = "application/json"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Write([]byte(raw))
+	}
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Error writing response", "error", err)
+	}
+}
+
+// writeError writes an error message as a JSON response
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
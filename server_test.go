@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestServeLeavesDatabaseUnchanged(t *testing.T) {
+	db, bp := newTestProcessor(t, nil)
+	if _, err := bp.processBlock(context.Background(), testBlock(100, testPost("alice", "first", "Hello world"))); err != nil {
+		t.Fatal(err)
+	}
+
+	schema := func(db *sql.DB) []string {
+		t.Helper()
+		rows, err := db.Query("SELECT type || ' ' || name FROM sqlite_master ORDER BY name")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var names []string
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, name)
+		}
+		return names
+	}
+	before := schema(db)
+
+	readOnly, err := openCurrentDB(bp.config.DBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readOnly.Close()
+	mux, err := newServeMux(readOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/search?q=hello", "/status", "/posts/alice/first/raw"} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code == http.StatusInternalServerError {
+			t.Errorf("GET %s: %d %s", path, rec.Code, rec.Body)
+		}
+	}
+
+	if _, err := readOnly.Exec("CREATE TABLE written (id INTEGER)"); err == nil {
+		t.Error("the server's database handle accepted a write")
+	}
+	if after := schema(db); !reflect.DeepEqual(after, before) {
+		t.Errorf("schema after serving = %v, want %v", after, before)
+	}
+}
+
+func TestRawMetadataEndpoint(t *testing.T) {
+	db, bp := newTestProcessor(t, nil)
+	const metadata = `{"tags": ["hive",  "test"], "app":"peakd/2024.1.1" }`
+	withMetadata := func(permlink, metadata string) Operation {
+		op := testPost("alice", permlink, "Title")
+		op.Value.JsonMetadata = metadata
+		return op
+	}
+	if _, err := bp.processBlock(context.Background(), testBlock(100,
+		withMetadata("json", metadata),
+		withMetadata("malformed", "hive photography"),
+		withMetadata("without-raw", metadata),
+	)); err != nil {
+		t.Fatal(err)
+	}
+	// As stored without StoreRawMetadata
+	if _, err := db.Exec("UPDATE posts SET json_metadata = NULL WHERE url = '@alice/without-raw'"); err != nil {
+		t.Fatal(err)
+	}
+
+	mux, err := newServeMux(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path            string
+		wantStatus      int
+		wantContentType string
+		wantBody        string
+	}{
+		{"/posts/alice/json/raw", http.StatusOK, "application/json", metadata},
+		{"/posts/Alice/json/raw", http.StatusOK, "application/json", metadata},
+		{"/posts/alice/malformed/raw", http.StatusOK, "text/plain; charset=utf-8", "hive photography"},
+		{"/posts/alice/without-raw/raw", http.StatusNotFound, "", ""},
+		{"/posts/alice/unknown/raw", http.StatusNotFound, "", ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.wantStatus {
+			t.Errorf("GET %s: status %d, want %d", tt.path, rec.Code, tt.wantStatus)
+			continue
+		}
+		if tt.wantStatus != http.StatusOK {
+			continue
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != tt.wantContentType {
+			t.Errorf("GET %s: content type %q, want %q", tt.path, ct, tt.wantContentType)
+		}
+		if body := rec.Body.String(); body != tt.wantBody {
+			t.Errorf("GET %s = %q, want %q", tt.path, body, tt.wantBody)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// socketWriteTimeout is how long a consumer may take to accept a post before it
+// is disconnected, so a stalled consumer doesn't hold up the others
+const socketWriteTimeout = time.Second * 5
+
+// socketStore streams every post as a line of JSON to the local processes
+// connected to a Unix domain socket. Posts are queued, up to a bound, while no
+// consumer is connected or the consumers fall behind; once the queue is full,
+// further posts are dropped rather than holding up processing.
+type socketStore struct {
+	path     string
+	listener net.Listener
+	queue    chan []byte
+	done     chan struct{}
+
+	mu      sync.Mutex
+	conns   map[net.Conn]bool
+	waiting chan struct{}
+	dropped int
+	closed  bool
+}
+
+// newSocketStore listens on a Unix domain socket at path, replacing a socket
+// left behind by an earlier run, and queues up to buffer posts
+func newSocketStore(path string, buffer int) (*socketStore, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&fs.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("error removing stale socket %s: %v", path, err)
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on socket: %v", err)
+	}
+
+	s := &socketStore{
+		path:     path,
+		listener: listener,
+		queue:    make(chan []byte, buffer),
+		done:     make(chan struct{}),
+		conns:    make(map[net.Conn]bool),
+		waiting:  make(chan struct{}),
+	}
+	go s.accept()
+	go s.send()
+	return s, nil
+}
+
+// accept adds every consumer that connects until the listener is closed
+func (s *socketStore) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = true
+		// Wake up send if it is waiting for a consumer
+		close(s.waiting)
+		s.waiting = make(chan struct{})
+		s.mu.Unlock()
+	}
+}
+
+// send writes the queued posts to every connected consumer, waiting for a
+// consumer to connect while there is none. A consumer that fails a write is
+// disconnected.
+func (s *socketStore) send() {
+	defer close(s.done)
+
+	for line := range s.queue {
+		for {
+			s.mu.Lock()
+			conns := make([]net.Conn, 0, len(s.conns))
+			for conn := range s.conns {
+				conns = append(conns, conn)
+			}
+			waiting, closed := s.waiting, s.closed
+			s.mu.Unlock()
+
+			if len(conns) > 0 {
+				s.write(conns, line)
+				break
+			}
+			// Posts still queued at shutdown have no consumer to go to
+			if closed {
+				return
+			}
+			<-waiting
+		}
+	}
+}
+
+// write writes a line to conns, disconnecting the ones that fail or time out
+func (s *socketStore) write(conns []net.Conn, line []byte) {
+	for _, conn := range conns {
+		conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+		if _, err := conn.Write(line); err != nil {
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+			conn.Close()
+		}
+	}
+}
+
+// StorePost queues the post for the consumers. A post that doesn't fit in the
+// queue is dropped and counted, without an error.
+func (s *socketStore) StorePost(post ExportedPost) error {
+	line, err := json.Marshal(post)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errors.New("socket output is closed")
+	}
+	select {
+	case s.queue <- line:
+	default:
+		s.dropped++
+	}
+	return nil
+}
+
+// Close stops accepting consumers, writes the queued posts to the connected
+// ones, disconnects them and removes the socket
+func (s *socketStore) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.queue)
+	close(s.waiting)
+	dropped := s.dropped
+	s.mu.Unlock()
+
+	err := s.listener.Close()
+	<-s.done
+
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	if dropped > 0 {
+		slog.Warn("Dropped posts that did not fit in the socket queue", "path", s.path, "dropped", dropped)
+	}
+	if err != nil {
+		return fmt.Errorf("error closing socket %s: %v", s.path, err)
+	}
+	return nil
+}
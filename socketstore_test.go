@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testSocketPath returns a socket path short enough for the Unix socket limit,
+// which the nested test directories can exceed
+func testSocketPath(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "posts.sock")
+}
+
+// readSocketPosts reads n posts from a socket consumer
+func readSocketPosts(t *testing.T, conn net.Conn, n int) []string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	scanner := bufio.NewScanner(conn)
+	urls := []string{}
+	for len(urls) < n && scanner.Scan() {
+		var post ExportedPost
+		if err := json.Unmarshal(scanner.Bytes(), &post); err != nil {
+			t.Fatalf("socket received invalid JSON %q: %v", scanner.Text(), err)
+		}
+		urls = append(urls, post.URL)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("reading the socket after %v: %v", urls, err)
+	}
+	return urls
+}
+
+func TestSocketStore(t *testing.T) {
+	path := testSocketPath(t)
+	_, bp := newTestProcessor(t, func(c *Config) { c.SocketPath = path })
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	blocks := []Block{
+		testBlock(100, testPost("alice", "first", "First"), testPost("bob", "second", "Second")),
+		// A post stored before is not streamed again
+		testBlock(101, testPost("alice", "first", "First"), testPost("carol", "third", "Third")),
+	}
+	for _, block := range blocks {
+		if _, err := bp.processBlock(context.Background(), block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{"@alice/first", "@bob/second", "@carol/third"}
+	if got := readSocketPosts(t, conn, len(want)); !reflect.DeepEqual(got, want) {
+		t.Errorf("socket consumer received %v, want %v", got, want)
+	}
+}
+
+func TestSocketStoreDrop(t *testing.T) {
+	path := testSocketPath(t)
+	s, err := newSocketStore(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// Without a consumer, the queue holds two posts and the sender one more
+	// while it waits for a consumer; the others are dropped
+	const stored = 5
+	for i := 0; i < stored; i++ {
+		if err := s.StorePost(ExportedPost{URL: fmt.Sprintf("@alice/post-%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.mu.Lock()
+	dropped := s.dropped
+	s.mu.Unlock()
+	if dropped < 2 || dropped > 3 {
+		t.Fatalf("dropped %d of %d posts with a queue of 2, want 2 or 3", dropped, stored)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The posts kept are delivered in order once a consumer connects
+	got := readSocketPosts(t, conn, stored-dropped)
+	if len(got) != stored-dropped || got[0] != "@alice/post-0" || got[1] != "@alice/post-1" {
+		t.Errorf("socket consumer received %v, want the %d posts kept, starting with the first two", got, stored-dropped)
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i] <= got[i-1] {
+			t.Errorf("socket consumer received %v out of order", got)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats accumulates the totals of a run across restarts of the sync loop
+//
+// All methods are safe for concurrent use, so blocks may be counted from several
+// goroutines while progress is being reported from another.
+type Stats struct {
+	startTime time.Time
+	processed atomic.Int64
+	inserts   atomic.Int64
+
+	mu     sync.Mutex
+	errors errorCounts
+}
+
+// StatsSnapshot is a consistent copy of the counters of a Stats at one point in
+// time
+type StatsSnapshot struct {
+	Processed int64
+	Inserts   int64
+	Errors    errorCounts
+	Elapsed   time.Duration
+}
+
+// NewStats creates a Stats whose elapsed time starts now
+func NewStats() *Stats {
+	return &Stats{startTime: time.Now(), errors: make(errorCounts)}
+}
+
+// AddProcessed counts n processed blocks
+func (s *Stats) AddProcessed(n int) {
+	s.processed.Add(int64(n))
+}
+
+// AddInserts counts n stored posts
+func (s *Stats) AddInserts(n int) {
+	s.inserts.Add(int64(n))
+}
+
+// RecordError counts err under its category
+func (s *Stats) RecordError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errors.record(err)
+}
+
+// Snapshot returns a copy of the current counters
+func (s *Stats) Snapshot() StatsSnapshot {
+	s.mu.Lock()
+	errors := make(errorCounts, len(s.errors))
+	for category, n := range s.errors {
+		errors[category] = n
+	}
+	s.mu.Unlock()
+
+	return StatsSnapshot{
+		Processed: s.processed.Load(),
+		Inserts:   s.inserts.Load(),
+		Errors:    errors,
+		Elapsed:   time.Since(s.startTime),
+	}
+}
+
+// Elapsed returns the time since the Stats were created
+func (s *Stats) Elapsed() time.Duration {
+	return time.Since(s.startTime)
+}
+
+// PostsPerBlock returns the average of posts stored per processed block
+func (s StatsSnapshot) PostsPerBlock() float64 {
+	if s.Processed == 0 {
+		return 0
+	}
+	return float64(s.Inserts) / float64(s.Processed)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func TestPostsPerBlock(t *testing.T) {
+	db, bp := newTestProcessor(t, nil)
+	stats := NewStats()
+	if got := stats.Snapshot().PostsPerBlock(); got != 0 {
+		t.Errorf("PostsPerBlock() = %v before any block, want 0", got)
+	}
+
+	// 5 posts in 4 blocks over two batches, one of the posts a repeat
+	batches := [][]Block{
+		{
+			testBlock(101, testPost("alice", "a", "A"), testPost("bob", "b", "B")),
+			testBlock(102),
+		},
+		{
+			testBlock(103, testPost("carol", "c", "C"), testPost("alice", "a", "A again")),
+			testBlock(104, testPost("dave", "d", "D")),
+		},
+	}
+	for _, blocks := range batches {
+		start, _ := blocks[0].Number()
+		batch := fetchedBatch{startBlock: start, count: len(blocks), blocks: blocks}
+		if _, err := processFetched(context.Background(), bp.config, db, bp, stats, batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snap := stats.Snapshot()
+	if snap.Processed != 4 || snap.Inserts != 4 {
+		t.Errorf("counted %d blocks and %d posts, want 4 and 4", snap.Processed, snap.Inserts)
+	}
+	if got := snap.PostsPerBlock(); got != 1 {
+		t.Errorf("PostsPerBlock() = %v, want 1", got)
+	}
+}
+
+func TestErrorReport(t *testing.T) {
+	var syntaxErr error = json.Unmarshal([]byte("{"), &struct{}{})
+	_, numErr := strconv.ParseInt("zz", 16, 64)
+	errs := []error{
+		fmt.Errorf("error fetching blocks: %w", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}),
+		fmt.Errorf("error fetching blocks: %w", &url.Error{Op: "Post", URL: "http://node", Err: &net.DNSError{Err: "no such host", IsNotFound: true}}),
+		fmt.Errorf("error decoding response: %w", syntaxErr),
+		fmt.Errorf("error decoding response: %w", io.ErrUnexpectedEOF),
+		fmt.Errorf("error storing post: %w", sqlite3.Error{Code: sqlite3.ErrBusy}),
+		fmt.Errorf("error converting block number from hex: %w", numErr),
+		fmt.Errorf("%w: block 101", errTimestampRegression),
+		errors.New("something else"),
+	}
+
+	stats := NewStats()
+	for _, err := range errs {
+		stats.RecordError(err)
+	}
+	report := stats.Snapshot().Errors
+	if got, want := report.String(), "network: 2, decode: 2, db: 1, parse: 2, other: 1"; got != want {
+		t.Errorf("error report = %q, want %q", got, want)
+	}
+	if total := report.total(); total != len(errs) {
+		t.Errorf("total() = %d, want %d", total, len(errs))
+	}
+}
+
+func TestStatsConcurrent(t *testing.T) {
+	const workers, iterations = 8, 1000
+	stats := NewStats()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				stats.AddProcessed(1)
+				stats.AddInserts(2)
+				stats.RecordError(errors.New("failed"))
+			}
+		}()
+		// Snapshots are taken while the counters are being updated
+		go func() {
+			defer wg.Done()
+			for j := 0; j < iterations/10; j++ {
+				snap := stats.Snapshot()
+				snap.Errors.total()
+				snap.PostsPerBlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	snap := stats.Snapshot()
+	if snap.Processed != workers*iterations || snap.Inserts != 2*workers*iterations {
+		t.Errorf("Snapshot() counts %d processed and %d inserts, want %d and %d",
+			snap.Processed, snap.Inserts, workers*iterations, 2*workers*iterations)
+	}
+	if total := snap.Errors.total(); total != workers*iterations {
+		t.Errorf("Snapshot() counts %d errors, want %d", total, workers*iterations)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// IndexStatus describes how far the index has progressed
+type IndexStatus struct {
+	// LastBlock is the highest block a post was stored from
+	LastBlock int64 `json:"last_block"`
+	// LastBlockTime is the timestamp of LastBlock
+	LastBlockTime *time.Time `json:"last_block_time,omitempty"`
+	// LagSeconds is how far LastBlockTime lies behind the current time. Unlike the
+	// number of blocks behind the head, it needs no request to a node.
+	LagSeconds *float64 `json:"lag_seconds,omitempty"`
+}
+
+// indexLag returns how far the timestamp of the last processed block lies behind
+// now. A block timestamp ahead of the local clock counts as no lag.
+func indexLag(lastBlockTime, now time.Time) time.Duration {
+	if lag := now.Sub(lastBlockTime); lag > 0 {
+		return lag
+	}
+	return 0
+}
+
+// getIndexStatus reads the last processed block and its timestamp from the
+// stored posts and computes the lag relative to now. The time fields are left
+// empty while no posts are stored.
+func getIndexStatus(db *sql.DB, now time.Time) (*IndexStatus, error) {
+	tables, err := postTables(db)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &IndexStatus{}
+	var timestamp string
+	for _, table := range tables {
+		var blockNum int64
+		var ts string
+		err := db.QueryRow("SELECT block_num, timestamp FROM "+table+" ORDER BY block_num DESC LIMIT 1").Scan(&blockNum, &ts)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading last block: %v", err)
+		}
+		if blockNum > status.LastBlock {
+			status.LastBlock, timestamp = blockNum, ts
+		}
+	}
+
+	if status.LastBlock == 0 {
+		return status, nil
+	}
+	lastBlockTime, err := parseTimestamp(timestamp)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing timestamp of block %d: %v", status.LastBlock, err)
+	}
+	lag := indexLag(lastBlockTime, now).Seconds()
+	status.LastBlockTime = &lastBlockTime
+	status.LagSeconds = &lag
+	return status, nil
+}
+
+// handleStatus serves GET /status with the IndexStatus as JSON
+func handleStatus(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status, err := getIndexStatus(db, time.Now())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, status)
+	}
+}
+
+// handleMetrics serves GET /metrics with the IndexStatus as gauges and the tags
+// per post as a histogram, in the Prometheus text format
+func handleMetrics(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status, err := getIndexStatus(db, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tagsPerPost, err := getTagsPerPost(db)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		fmt.Fprintf(w, "# HELP post_stuffer_last_block Highest block a post was stored from.\n")
+		fmt.Fprintf(w, "# TYPE post_stuffer_last_block gauge\n")
+		fmt.Fprintf(w, "post_stuffer_last_block %d\n", status.LastBlock)
+		if status.LagSeconds != nil {
+			fmt.Fprintf(w, "# HELP post_stuffer_lag_seconds Time between now and the timestamp of the last processed block.\n")
+			fmt.Fprintf(w, "# TYPE post_stuffer_lag_seconds gauge\n")
+			fmt.Fprintf(w, "post_stuffer_lag_seconds %g\n", *status.LagSeconds)
+		}
+		writeTagsPerPostMetric(w, tagsPerPost)
+	}
+}
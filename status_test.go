@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestIndexLag(t *testing.T) {
+	blockTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name string
+		now  time.Time
+		want time.Duration
+	}{
+		{"behind", blockTime.Add(90 * time.Second), 90 * time.Second},
+		{"caught up", blockTime, 0},
+		{"block ahead of the local clock", blockTime.Add(-time.Second), 0},
+	}
+	for _, tt := range tests {
+		if got := indexLag(blockTime, tt.now); got != tt.want {
+			t.Errorf("%s: indexLag() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGetIndexStatus(t *testing.T) {
+	db, bp := newTestProcessor(t, nil)
+	now := time.Date(2024, 1, 2, 3, 5, 35, 0, time.UTC)
+
+	status, err := getIndexStatus(db, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.LastBlock != 0 || status.LastBlockTime != nil || status.LagSeconds != nil {
+		t.Errorf("getIndexStatus() of an empty database = %+v, want no last block and no lag", status)
+	}
+
+	older := testBlock(100, testPost("alice", "older", "Older"))
+	older.Timestamp = "2024-01-02T03:00:00"
+	// testBlock is timestamped 2024-01-02T03:04:05, 90 seconds before now
+	for _, block := range []Block{older, testBlock(101, testPost("bob", "latest", "Latest"))} {
+		if _, err := bp.processBlock(context.Background(), block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	status, err = getIndexStatus(db, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if status.LastBlock != 101 || status.LastBlockTime == nil || !status.LastBlockTime.Equal(wantTime) {
+		t.Errorf("getIndexStatus() = block %d at %v, want block 101 at %s", status.LastBlock, status.LastBlockTime, wantTime)
+	}
+	if status.LagSeconds == nil || *status.LagSeconds != 90 {
+		t.Errorf("getIndexStatus() lag = %v, want 90 seconds", status.LagSeconds)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Storage is an additional output that receives every new post stored in the
+// database. The SQLite database remains the primary store: it decides which posts
+// are new and drives checkpointing, so a failing secondary store never causes
+// blocks to be skipped or reprocessed.
+type Storage interface {
+	StorePost(post ExportedPost) error
+	Close() error
+}
+
+// MultiStore fans every post out to several stores
+type MultiStore struct {
+	stores []Storage
+}
+
+// NewMultiStore creates a MultiStore writing to all of stores
+func NewMultiStore(stores ...Storage) *MultiStore {
+	return &MultiStore{stores: stores}
+}
+
+// StorePost writes the post to every store, even when some of them fail, and
+// returns the errors of all failing stores joined together
+func (m *MultiStore) StorePost(post ExportedPost) error {
+	var errs []error
+	for _, store := range m.stores {
+		if err := store.StorePost(post); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes every store, returning the errors of all failing stores joined
+// together
+func (m *MultiStore) Close() error {
+	var errs []error
+	for _, store := range m.stores {
+		if err := store.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Len returns the number of stores
+func (m *MultiStore) Len() int {
+	return len(m.stores)
+}
+
+// newOutputStores creates the secondary stores enabled in the configuration
+func newOutputStores(config *Config) (*MultiStore, error) {
+	var stores []Storage
+	if config.JSONLOutput != "" {
+		store, err := newJSONLStore(config.JSONLOutput)
+		if err != nil {
+			return nil, err
+		}
+		stores = append(stores, store)
+	}
+	if config.SocketPath != "" {
+		store, err := newSocketStore(config.SocketPath, config.SocketBuffer)
+		if err != nil {
+			NewMultiStore(stores...).Close()
+			return nil, err
+		}
+		stores = append(stores, store)
+	}
+	return NewMultiStore(stores...), nil
+}
+
+// jsonlStore appends every post to a file as a line of JSON, in the same format as
+// the jsonl export
+type jsonlStore struct {
+	path string
+	mu   sync.Mutex
+	file *os.File
+	w    *jsonlWriter
+}
+
+// newJSONLStore opens path for appending, creating it if necessary
+func newJSONLStore(path string) (*jsonlStore, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening JSONL output: %v", err)
+	}
+	return &jsonlStore{path: path, file: f, w: newJSONLWriter(f)}, nil
+}
+
+// StorePost appends the post to the file. Every post is flushed right away, so
+// the file is complete up to the last stored post if the process stops.
+func (s *jsonlStore) StorePost(post ExportedPost) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.w.Write(post); err != nil {
+		return fmt.Errorf("error writing %s: %v", s.path, err)
+	}
+	if err := s.w.Flush(); err != nil {
+		return fmt.Errorf("error writing %s: %v", s.path, err)
+	}
+	return nil
+}
+
+// Close closes the file
+func (s *jsonlStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// memStore is a Storage keeping the posts it receives in memory
+type memStore struct {
+	urls   []string
+	err    error
+	closed bool
+}
+
+func (s *memStore) StorePost(post ExportedPost) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.urls = append(s.urls, post.URL)
+	return nil
+}
+
+func (s *memStore) Close() error {
+	s.closed = true
+	return s.err
+}
+
+func TestMultiStore(t *testing.T) {
+	db, bp := newTestProcessor(t, nil)
+	first, second := &memStore{}, &memStore{}
+	failing := &memStore{err: errors.New("disk full")}
+	bp.outputs = NewMultiStore(first, failing, second)
+
+	blocks := []Block{
+		testBlock(100, testPost("alice", "a", "A"), testPost("bob", "b", "B")),
+		// A post stored before is not sent again
+		testBlock(101, testPost("alice", "a", "A"), testPost("carol", "c", "C")),
+	}
+	for _, block := range blocks {
+		// A failing secondary store doesn't stop the primary one
+		if _, err := bp.processBlock(context.Background(), block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{"@alice/a", "@bob/b", "@carol/c"}
+	for name, store := range map[string]*memStore{"first": first, "second": second} {
+		if !reflect.DeepEqual(store.urls, want) {
+			t.Errorf("%s store received %v, want %v", name, store.urls, want)
+		}
+	}
+	var stored int
+	if err := db.QueryRow("SELECT COUNT(*) FROM posts").Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != 3 {
+		t.Errorf("primary store holds %d posts, want 3", stored)
+	}
+
+	if err := bp.outputs.Close(); !errors.Is(err, failing.err) {
+		t.Errorf("Close() = %v, want the error of the failing store", err)
+	}
+	if !first.closed || !second.closed {
+		t.Error("Close() did not close every store")
+	}
+}
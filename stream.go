@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// wsSubscribeRequest is sent after connecting to subscribe to new blocks. The
+// node then pushes a JSON-RPC notification for every new block, with the block
+// in params.block.
+const wsSubscribeRequest = `{"jsonrpc":"2.0","method":"block_api.subscribe_new_blocks","params":{},"id":1}`
+
+// errRunExpired ends a block stream once MaxRunDuration has passed
+var errRunExpired = errors.New("maximum run duration reached")
+
+// wsMaxBackoff caps the delay between reconnection attempts
+const wsMaxBackoff = time.Minute
+
+// followStream catches up over HTTP and then keeps processing the blocks pushed
+// by the node at config.WSURL, until ctx is cancelled or a database error that
+// requires reconnecting occurs.
+//
+// When the socket drops, blocks are polled over HTTP again while reconnecting with
+// exponential backoff, starting at RetryDelay, so nothing pushed in the meantime
+// is missed.
+func followStream(ctx context.Context, config *Config, db *sql.DB, processor *BlockProcessor, head *headCache, pause *pauseControl, stats *Stats) error {
+	backoff := config.RetryDelay
+	for {
+		last, err := syncBlocks(ctx, config, db, processor, head, pause, stats)
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil || runExpired(config, stats) {
+			return nil
+		}
+
+		received, err := streamBlocks(ctx, config, db, processor, pause, stats, last)
+		if ctx.Err() != nil || errors.Is(err, errRunExpired) {
+			return nil
+		}
+		if isRecoverableDBError(err) || errors.Is(err, errExecHookFailed) {
+			return err
+		}
+		stats.RecordError(err)
+
+		// A connection that delivered blocks was healthy, so start over
+		if received > 0 {
+			backoff = config.RetryDelay
+		}
+		slog.Warn("Block stream ended, reconnecting", "error", err, "backoff", backoff)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > wsMaxBackoff {
+			backoff = wsMaxBackoff
+		}
+	}
+}
+
+// streamBlocks subscribes to new blocks over a WebSocket connection and processes
+// them as they arrive, starting after block last. A block arriving ahead of the
+// next expected one first has the missing blocks fetched over HTTP. While no
+// blocks arrive, a heartbeat is logged every HeartbeatInterval.
+//
+// It returns the number of blocks received along with the error that ended the
+// stream.
+func streamBlocks(ctx context.Context, config *Config, db *sql.DB, processor *BlockProcessor, pause *pauseControl, stats *Stats, last int64) (int, error) {
+	conn, err := dialWebSocket(ctx, config.WSURL)
+	if err != nil {
+		return 0, fmt.Errorf("error connecting to %s: %w", config.WSURL, err)
+	}
+	defer conn.Close()
+	// Cancelling ctx unblocks the read of the next message
+	stop := context.AfterFunc(ctx, func() { conn.conn.Close() })
+	defer stop()
+
+	if err := conn.WriteText([]byte(wsSubscribeRequest)); err != nil {
+		return 0, fmt.Errorf("error subscribing to blocks: %w", err)
+	}
+	slog.Info("Streaming blocks", "url", config.WSURL, "last_processed_block", last)
+
+	beat := startHeartbeat(config.HeartbeatInterval, last)
+	defer beat.Stop()
+
+	received := 0
+	for {
+		data, err := conn.ReadMessage()
+		if err != nil {
+			return received, err
+		}
+
+		var notification struct {
+			Params struct {
+				Block *Block `json:"block"`
+			} `json:"params"`
+		}
+		if err := json.Unmarshal(data, &notification); err != nil {
+			return received, fmt.Errorf("error decoding stream message: %w", err)
+		}
+		// Other messages, such as the reply to the subscription, carry no block
+		block := notification.Params.Block
+		if block == nil {
+			continue
+		}
+		received++
+		block.FetchedAt = time.Now()
+
+		blockNum, err := block.Number()
+		if err != nil {
+			return received, err
+		}
+		if blockNum <= last {
+			continue
+		}
+
+		if err := pause.Wait(ctx, time.Second); err != nil {
+			return received, err
+		}
+
+		if blockNum > last+1 {
+			res, err := processBatch(ctx, config, db, processor, stats, last+1, int(blockNum-last-1), blockNum, last)
+			if err != nil {
+				return received, err
+			}
+			if !res.fetched || res.handledThrough < blockNum-1 {
+				return received, fmt.Errorf("error fetching blocks %d-%d missed by the stream", last+1, blockNum-1)
+			}
+		}
+
+		res, err := processFetched(ctx, config, db, processor, stats, fetchedBatch{
+			startBlock: blockNum,
+			count:      1,
+			blocks:     []Block{*block},
+		})
+		if err != nil {
+			return received, err
+		}
+		last = blockNum
+		saveForwardCheckpoint(config, db, stats, last)
+		beat.Advance(last)
+		if res.inserts > 0 {
+			slog.Info("Block processed", "block_num", blockNum, "posts_inserted", res.inserts)
+		}
+		if runExpired(config, stats) {
+			return received, errRunExpired
+		}
+	}
+}
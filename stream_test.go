@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testWSNode serves a WebSocket endpoint that waits for the block subscription,
+// pushes a notification for each of blocks and then closes the connection
+func testWSNode(t *testing.T, blocks []Block) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sum := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + wsAcceptGUID))
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+			base64.StdEncoding.EncodeToString(sum[:]))
+		rw.Flush()
+
+		// The server side reads masked client frames like the client reads
+		// unmasked ones
+		ws := &wsConn{conn: conn, br: rw.Reader}
+		subscription, err := ws.ReadMessage()
+		if err != nil || string(subscription) != wsSubscribeRequest {
+			t.Errorf("received subscription %q, %v", subscription, err)
+			return
+		}
+
+		send := func(opcode byte, payload []byte) {
+			header := []byte{0x80 | opcode, 126, 0, 0}
+			binary.BigEndian.PutUint16(header[2:], uint16(len(payload)))
+			conn.Write(append(header, payload...))
+		}
+		send(wsOpText, []byte(`{"jsonrpc":"2.0","result":{},"id":1}`))
+		for _, block := range blocks {
+			data, _ := json.Marshal(map[string]interface{}{
+				"jsonrpc": "2.0",
+				"method":  "notice",
+				"params":  map[string]interface{}{"block": block},
+			})
+			send(wsOpText, data)
+		}
+		send(wsOpClose, nil)
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func TestStreamBlocks(t *testing.T) {
+	post := func(n int64) Block {
+		return testBlock(n, testPost("alice", fmt.Sprintf("post-%d", n), "Post"))
+	}
+	// Block 100 was processed before, and block 103 is only available over HTTP
+	wsURL := testWSNode(t, []Block{post(100), post(101), post(102), post(104)})
+
+	db, bp := newTestProcessor(t, func(c *Config) {
+		c.WSURL = wsURL
+		c.HiveAPIURLs = []string{testNode(t, 104, nil)}
+	})
+	received, err := streamBlocks(context.Background(), bp.config, db, bp, &pauseControl{}, NewStats(), 100)
+	if !errors.Is(err, errWSClosed) {
+		t.Fatalf("streamBlocks() error = %v, want %v", err, errWSClosed)
+	}
+	if received != 4 {
+		t.Errorf("streamBlocks() received %d blocks, want 4", received)
+	}
+
+	rows, err := db.Query("SELECT block_num FROM posts ORDER BY block_num")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var stored []int64
+	for rows.Next() {
+		var n int64
+		if err := rows.Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		stored = append(stored, n)
+	}
+	if fmt.Sprint(stored) != "[101 102 103 104]" {
+		t.Errorf("stored posts of blocks %v, want 101 through 104", stored)
+	}
+}
@@ -0,0 +1,764 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// batchRamp grows the batch size from InitialBatchSize up to BatchSize, doubling
+// after every successfully fetched batch, so the first requests against a cold
+// node are small enough not to time out
+type batchRamp struct {
+	size   int
+	target int
+}
+
+// newBatchRamp creates a batchRamp for the configuration. Without an
+// InitialBatchSize smaller than BatchSize, it always returns BatchSize.
+func newBatchRamp(config *Config) *batchRamp {
+	size := config.InitialBatchSize
+	if size <= 0 || size > config.BatchSize {
+		size = config.BatchSize
+	}
+	return &batchRamp{size: size, target: config.BatchSize}
+}
+
+// Size returns the number of blocks to request in the next batch
+func (r *batchRamp) Size() int {
+	return r.size
+}
+
+// Succeeded grows the batch size after a successful fetch
+func (r *batchRamp) Succeeded() {
+	r.size *= 2
+	if r.size > r.target {
+		r.size = r.target
+	}
+}
+
+// batchResult summarizes the outcome of processing a single batch of blocks
+type batchResult struct {
+	// fetched is false when the batch could not be fetched at all
+	fetched bool
+	// blocks is the number of blocks returned by the node
+	blocks int
+	// inserts is the number of rows written while processing the batch
+	inserts int
+	// lastProcessed is the highest block processed successfully, or 0 if none
+	lastProcessed int64
+	// handledThrough is the highest block of the batch that was either processed
+	// or recorded in failed_blocks, which the cursor can safely move to, or 0 if
+	// none
+	handledThrough int64
+	// interrupted is true when ctx was cancelled before every block of the batch
+	// was processed
+	interrupted bool
+	// duration is the time spent processing the fetched blocks
+	duration time.Duration
+}
+
+// fetchedBatch is the outcome of fetching a batch of blocks
+type fetchedBatch struct {
+	startBlock int64
+	count      int
+	blocks     []Block
+	// failed lists blocks of a batched request that could not be retrieved
+	failed []BlockFetchError
+	// err is set when the batch could not be fetched at all
+	err error
+	// rows is the number of posts in the batch, set by the prefetcher
+	rows int
+	// processedThrough is the last block processed before the batch; blocks at
+	// or below it are skipped when SkipProcessedBlocks is enabled
+	processedThrough int64
+}
+
+// fetchBatch fetches count blocks starting at startBlock, retrying on failure.
+// head is the head block the range was planned against; see checkBlockCount.
+func fetchBatch(ctx context.Context, config *Config, startBlock int64, count int, head int64) fetchedBatch {
+	batch := fetchedBatch{startBlock: startBlock, count: count}
+
+	fetchCtx, fetchSpan := tracer.Start(ctx, "fetch")
+	defer fetchSpan.End()
+	batch.err = retryWithBackoff(config.MaxRetries, config.RetryDelay, func() error {
+		var err error
+		if config.BatchRequests {
+			batch.blocks, batch.failed, err = getBlocksBatch(fetchCtx, config, startBlock, count)
+		} else {
+			batch.blocks, err = getBlockRange(fetchCtx, config, startBlock, count)
+			if err == nil && config.ValidateBlockCount {
+				err = checkBlockCount(batch.blocks, startBlock, count, head)
+			}
+		}
+		return err
+	})
+	if batch.err != nil {
+		fetchSpan.RecordError(batch.err)
+		fetchSpan.SetStatus(codes.Error, batch.err.Error())
+		return batch
+	}
+	fetchSpan.SetAttributes(attribute.Int("blocks", len(batch.blocks)), attribute.Int("failed", len(batch.failed)))
+
+	fetchedAt := time.Now()
+	for i := range batch.blocks {
+		batch.blocks[i].FetchedAt = fetchedAt
+	}
+	return batch
+}
+
+// processBatch fetches count blocks starting at startBlock and processes them in
+// ascending order; see processFetched. head is the head block the batch was
+// planned against, and processedThrough is the last block processed before the
+// batch, or 0 if unknown.
+func processBatch(ctx context.Context, config *Config, db *sql.DB, processor *BlockProcessor, stats *Stats, startBlock int64, count int, head, processedThrough int64) (batchResult, error) {
+	batchCtx, batchSpan := tracer.Start(ctx, "batch", trace.WithAttributes(
+		attribute.Int64("start_block", startBlock),
+		attribute.Int("count", count),
+	))
+	defer batchSpan.End()
+
+	batch := fetchBatch(batchCtx, config, startBlock, count, head)
+	batch.processedThrough = processedThrough
+	return processFetched(batchCtx, config, db, processor, stats, batch)
+}
+
+// processFetched processes the blocks of a fetched batch in ascending order.
+//
+// A batch that could not be fetched, or a block that fails to process, is logged
+// and reflected in the result. An error is only returned for database errors that
+// may be resolved by reconnecting (see isRecoverableDBError) and for a failed exec
+// hook configured as fatal.
+func processFetched(ctx context.Context, config *Config, db *sql.DB, processor *BlockProcessor, stats *Stats, batch fetchedBatch) (batchResult, error) {
+	var res batchResult
+
+	// A fetch abandoned by a shutdown is not an error
+	if batch.err != nil && ctx.Err() != nil {
+		res.interrupted = true
+		return res, nil
+	}
+	if batch.err != nil {
+		stats.RecordError(batch.err)
+		slog.Error("Error getting blocks", "start_block", batch.startBlock, "batch_size", batch.count, "error", batch.err)
+		return res, nil
+	}
+	res.fetched = true
+	startBlock, count, blocks, failed := batch.startBlock, batch.count, batch.blocks, batch.failed
+
+	// Block numbers are derived from the block ids, so bad data can make them
+	// collide or go backwards
+	consistent, inconsistent := checkBlockSequence(blocks, startBlock, count)
+	if len(inconsistent) > 0 {
+		for _, b := range inconsistent {
+			slog.Warn("Inconsistent block in batch", "start_block", startBlock, "block_num", b.BlockNum, "detail", b.Message)
+		}
+		if config.InconsistentBlocks == InconsistentBlocksSkip {
+			blocks = consistent
+			failed = append(failed, inconsistent...)
+		}
+	}
+
+	// Blocks that were already processed before the batch are not processed again
+	if config.SkipProcessedBlocks && batch.processedThrough > 0 {
+		var skipped int
+		blocks, skipped = skipProcessedBlocks(blocks, batch.processedThrough)
+		if skipped > 0 {
+			slog.Info("Skipped already processed blocks", "start_block", startBlock, "blocks", skipped)
+		}
+	}
+	res.blocks = len(blocks)
+
+	// Blocks the node has not produced yet are left for a later batch
+	failed = dropPendingBlocks(blocks, failed)
+
+	// Blocks that failed inside a batch are recorded for a later retry
+	if len(failed) > 0 {
+		slog.Warn("Failed to fetch blocks of batch", "start_block", startBlock, "batch_size", count, "failed", len(failed))
+		if config.ValidateMetadata {
+			// A validation run records nothing, so the blocks are only logged
+		} else if err := recordFailedBlocks(db, failed); err != nil {
+			stats.RecordError(err)
+			slog.Error("Error recording failed blocks", "error", err)
+		} else {
+			for _, f := range failed {
+				if f.BlockNum < startBlock+int64(count) && f.BlockNum > res.handledThrough {
+					res.handledThrough = f.BlockNum
+				}
+			}
+		}
+	}
+
+	processCtx, processSpan := tracer.Start(ctx, "process")
+	defer processSpan.End()
+	batchStartTime := time.Now()
+
+	if err := processor.Begin(); err != nil {
+		stats.RecordError(err)
+		return res, err
+	}
+
+	// Blocks with a timestamp regression are dead-lettered once the batch is
+	// written, outside of its transaction
+	var regressed []BlockFetchError
+	for _, block := range blocks {
+		// A shutdown stops between blocks, so the current block is always finished
+		if ctx.Err() != nil {
+			res.interrupted = true
+			break
+		}
+		if block.BlockNum == "0" {
+			continue
+		}
+
+		insertCount, err := processor.processBlock(processCtx, block)
+		if err != nil {
+			stats.RecordError(err)
+			if isRecoverableDBError(err) || errors.Is(err, errExecHookFailed) {
+				processor.Rollback()
+				return res, fmt.Errorf("error processing block %s: %w", block.BlockNum, err)
+			}
+			if errors.Is(err, errTimestampRegression) {
+				// Dead-letter the suspicious block so it can be inspected later
+				blockNum, _ := block.Number()
+				regressed = append(regressed, BlockFetchError{BlockNum: blockNum, Message: err.Error()})
+			}
+			slog.Error("Error processing block", "block_id", block.BlockNum, "error", err)
+			continue
+		}
+
+		// Update progress tracking
+		blockNum, _ := block.Number()
+		res.lastProcessed = blockNum
+		res.inserts += insertCount
+		stats.AddProcessed(1)
+	}
+	// Queued multi-row inserts are written once per batch
+	flushed, err := processor.Flush()
+	if err != nil {
+		processor.Rollback()
+		stats.RecordError(err)
+		return res, err
+	}
+	res.inserts += flushed
+	_, commitSpan := tracer.Start(processCtx, "commit")
+	if err := processor.Commit(); err != nil {
+		commitSpan.RecordError(err)
+		commitSpan.SetStatus(codes.Error, err.Error())
+		commitSpan.End()
+		stats.RecordError(err)
+		return res, err
+	}
+	commitSpan.End()
+	if len(regressed) > 0 && !config.ValidateMetadata {
+		if err := recordFailedBlocks(db, regressed); err != nil {
+			stats.RecordError(err)
+			slog.Error("Error recording failed blocks", "error", err)
+		}
+	}
+	processSpan.SetAttributes(attribute.Int("posts", res.inserts))
+
+	// Failed blocks past an interruption are retried from failed_blocks, but the
+	// cursor must not skip the unprocessed blocks before them
+	if res.interrupted {
+		res.handledThrough = res.lastProcessed
+	}
+	if res.lastProcessed > res.handledThrough {
+		res.handledThrough = res.lastProcessed
+	}
+
+	if config.RecordProcessedRanges && !config.ValidateMetadata && res.handledThrough >= startBlock {
+		if err := recordProcessedRange(db, startBlock, res.handledThrough); err != nil {
+			stats.RecordError(err)
+			slog.Error("Error recording processed range", "error", err)
+		}
+	}
+
+	stats.AddInserts(res.inserts)
+	res.duration = time.Since(batchStartTime)
+	return res, nil
+}
+
+// skipProcessedBlocks removes the blocks at or below processedThrough from the
+// start of a batch, returning the remaining blocks and the number removed
+func skipProcessedBlocks(blocks []Block, processedThrough int64) ([]Block, int) {
+	skipped := 0
+	for _, block := range blocks {
+		if n, err := block.Number(); err != nil || n > processedThrough {
+			break
+		}
+		skipped++
+	}
+	return blocks[skipped:], skipped
+}
+
+// dropPendingBlocks removes the blocks that were not found because they lie
+// beyond the node's head from a batch's failures. These are the not-found blocks
+// above the highest block returned; they are not failures, just not produced yet.
+func dropPendingBlocks(blocks []Block, failed []BlockFetchError) []BlockFetchError {
+	var highest int64
+	for _, block := range blocks {
+		if n, err := block.Number(); err == nil && n > highest {
+			highest = n
+		}
+	}
+
+	kept := failed[:0]
+	for _, f := range failed {
+		if f.NotFound && f.BlockNum > highest {
+			continue
+		}
+		kept = append(kept, f)
+	}
+	return kept
+}
+
+// checkBlockSequence validates the block numbers derived from the ids of a batch
+// of blocks fetched from startBlock. Every number must lie within the requested
+// range and be higher than the one before it, which also catches two blocks whose
+// id prefixes collide. The blocks passing the check are returned in order, and the
+// others are returned as failures describing the inconsistency.
+func checkBlockSequence(blocks []Block, startBlock int64, count int) ([]Block, []BlockFetchError) {
+	endBlock := startBlock + int64(count) - 1
+	consistent := make([]Block, 0, len(blocks))
+	var inconsistent []BlockFetchError
+	prev := startBlock - 1
+	for _, block := range blocks {
+		if block.BlockNum == "0" {
+			consistent = append(consistent, block)
+			continue
+		}
+
+		blockNum, err := block.Number()
+		switch {
+		case err != nil:
+			inconsistent = append(inconsistent, BlockFetchError{BlockNum: prev + 1, Message: err.Error()})
+		case blockNum < startBlock || blockNum > endBlock:
+			inconsistent = append(inconsistent, BlockFetchError{
+				BlockNum: blockNum,
+				Message:  fmt.Sprintf("block %s is outside the requested range %d-%d", block.BlockNum, startBlock, endBlock),
+			})
+		case blockNum <= prev:
+			inconsistent = append(inconsistent, BlockFetchError{
+				BlockNum: blockNum,
+				Message:  fmt.Sprintf("block %s does not follow block %d", block.BlockNum, prev),
+			})
+		default:
+			consistent = append(consistent, block)
+			prev = blockNum
+		}
+	}
+	return consistent, inconsistent
+}
+
+// checkBlockCount checks that a block range fetched from startBlock holds at
+// most count blocks, each following the one before it. A range may only fall
+// short once it reaches head, the head block it was planned against, as the node
+// has no blocks beyond its head; a block missing anywhere else would be skipped
+// silently once the cursor moves past it. Blocks whose number can't be derived
+// are left to checkBlockSequence.
+func checkBlockCount(blocks []Block, startBlock int64, count int, head int64) error {
+	if len(blocks) > count {
+		return fmt.Errorf("node returned %d blocks for a range of %d starting at %d", len(blocks), count, startBlock)
+	}
+	if last := startBlock + int64(len(blocks)) - 1; len(blocks) < count && last < head {
+		return fmt.Errorf("node returned %d of %d blocks starting at %d, ending before head block %d",
+			len(blocks), count, startBlock, head)
+	}
+	for i, block := range blocks {
+		blockNum, err := block.Number()
+		if err != nil {
+			continue
+		}
+		if expected := startBlock + int64(i); blockNum != expected {
+			return fmt.Errorf("node returned block %d where block %d was expected in range starting at %d; %d of %d blocks returned",
+				blockNum, expected, startBlock, len(blocks), count)
+		}
+	}
+	return nil
+}
+
+// logProgress logs the statistics of a completed batch
+func logProgress(percentage float64, startBlock int64, res batchResult, stats *Stats) {
+	snap := stats.Snapshot()
+	slog.Info("Progress",
+		"percent", math.Round(percentage*100)/100,
+		"block_num", startBlock,
+		"batch_size", res.blocks,
+		"posts_inserted", res.inserts,
+		"duration_ms", res.duration.Milliseconds(),
+		"blocks_per_second", math.Round(float64(res.blocks)/res.duration.Seconds()*10)/10,
+		"total_blocks", snap.Processed,
+		"total_posts", snap.Inserts,
+		"posts_per_block", math.Round(snap.PostsPerBlock()*100)/100,
+		"elapsed_ms", snap.Elapsed.Milliseconds(),
+	)
+}
+
+// resolveStartBlock returns the next block a forward run should process.
+//
+// Progress is read from the forward checkpoint, see getForwardCheckpoint. The
+// genesis block itself is treated as already processed, so an empty database, a
+// database whose posts all lie at or below the genesis block, and a zero block_num
+// left by bad data all start right after GenesisBlock.
+func resolveStartBlock(db *sql.DB, config *Config) (int64, error) {
+	last, err := getForwardCheckpoint(db, config.GenesisBlock)
+	if err != nil {
+		return 0, fmt.Errorf("error getting last processed block: %w", err)
+	}
+	if last < config.GenesisBlock {
+		last = config.GenesisBlock
+	}
+	return last + 1, nil
+}
+
+// syncBlocks processes all blocks between the last processed block and the current
+// head block in batches of config.BatchSize, starting smaller if InitialBatchSize
+// is set.
+//
+// When config.PrefetchBlocks is set, batches are fetched in the background ahead
+// of processing; see blockPrefetcher.
+//
+// While paused through pause, processing waits between batches. Errors that are
+// specific to a single batch or block are logged and processing continues. An
+// error is returned when the starting point cannot be determined or when a
+// database error occurs that may be resolved by reconnecting (see
+// isRecoverableDBError), so the caller can reopen the database and resume.
+// Otherwise the highest block processed is returned.
+func syncBlocks(ctx context.Context, config *Config, db *sql.DB, processor *BlockProcessor, head *headCache, pause *pauseControl, stats *Stats) (int64, error) {
+	if config.Reverse {
+		return syncBlocksReverse(ctx, config, db, processor, head, pause, stats)
+	}
+
+	// Get current block and last processed block with retry
+	var currentBlock, lastProcessed int64
+	err := retryWithBackoff(config.MaxRetries, config.RetryDelay, func() error {
+		var err error
+		currentBlock, err = head.Get(ctx)
+		if err != nil {
+			return fmt.Errorf("error getting latest block: %w", err)
+		}
+
+		startBlock, err := resolveStartBlock(db, config)
+		if err != nil {
+			return err
+		}
+		lastProcessed = startBlock - 1
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Calculate initial variance
+	variance := currentBlock - lastProcessed
+	// A follower polling an up-to-date index would log this on every poll
+	if variance > 0 || !config.Follow {
+		slog.Info("Starting block processing", "head_block", currentBlock,
+			"last_processed_block", lastProcessed, "variance", variance)
+	}
+
+	// With prefetching, blocks are fetched in the background while earlier ones
+	// are processed
+	var prefetch *blockPrefetcher
+	if config.PrefetchBlocks > 0 {
+		prefetch = newBlockPrefetcher(ctx, config, lastProcessed+1, currentBlock+int64(config.BeyondHeadBlocks), currentBlock)
+		defer func() { prefetch.Stop() }()
+	}
+
+	ramp := newBatchRamp(config)
+	failures := &fetchFailures{config: config}
+	for variance > 0 && ctx.Err() == nil && !runExpired(config, stats) {
+		if pause.Wait(ctx, time.Second) != nil {
+			break
+		}
+
+		var startBlock int64
+		var count int
+		var res batchResult
+		if prefetch != nil {
+			batch, ok := prefetch.Next()
+			if !ok {
+				break
+			}
+			startBlock, count = batch.startBlock, batch.count
+			// Batches planned after one whose end wasn't handled would skip the
+			// blocks in between, so fetching starts over after the last block
+			// handled
+			if startBlock != lastProcessed+1 {
+				slog.Warn("Prefetched batch does not follow the last processed block, fetching again",
+					"start_block", startBlock, "last_processed_block", lastProcessed)
+				prefetch.Release(batch)
+				prefetch.Stop()
+				prefetch = newBlockPrefetcher(ctx, config, lastProcessed+1, currentBlock+int64(config.BeyondHeadBlocks), currentBlock)
+				continue
+			}
+			batch.processedThrough = lastProcessed
+			res, err = processFetched(ctx, config, db, processor, stats, batch)
+			prefetch.Release(batch)
+		} else {
+			startBlock = lastProcessed + 1
+			count = ramp.Size()
+			if end := currentBlock + int64(config.BeyondHeadBlocks); startBlock+int64(count) > end {
+				count = int(end - startBlock + 1)
+			}
+			res, err = processBatch(ctx, config, db, processor, stats, startBlock, count, currentBlock, lastProcessed)
+		}
+		if err != nil {
+			return 0, err
+		}
+		if res.interrupted {
+			if res.handledThrough > lastProcessed {
+				lastProcessed = res.handledThrough
+				saveForwardCheckpoint(config, db, stats, lastProcessed)
+			}
+			break
+		}
+		if !res.fetched {
+			if err := failures.Failed(ctx, startBlock); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		failures.Succeeded()
+		ramp.Succeeded()
+
+		// The cursor only moves to the highest block actually handled, so blocks
+		// missing from the end of the batch are requested again
+		if res.handledThrough > lastProcessed {
+			lastProcessed = res.handledThrough
+			saveForwardCheckpoint(config, db, stats, lastProcessed)
+		}
+		if res.handledThrough < startBlock {
+			// Nothing new was returned, e.g. because the batch lies beyond the
+			// node's head, so give the node time to catch up
+			select {
+			case <-ctx.Done():
+			case <-time.After(config.RetryDelay):
+			}
+		}
+
+		head.Invalidate()
+		percentage := float64(lastProcessed-config.GenesisBlock) / float64(currentBlock-config.GenesisBlock) * 100
+		logProgress(percentage, startBlock, res, stats)
+		if !config.ValidateMetadata {
+			mirrorForwardCheckpoint(config, db, lastProcessed)
+		}
+
+		// Recalculate variance
+		variance = currentBlock - lastProcessed
+	}
+
+	return lastProcessed, nil
+}
+
+// saveForwardCheckpoint records the last block handled by a forward run, so a
+// restart resumes after it even when the blocks stored no posts. A failure is
+// only logged: the checkpoint then lags behind and the blocks since the last
+// saved one are processed again. A validation run saves no checkpoint, so it
+// doesn't make a later run skip the blocks it validated.
+func saveForwardCheckpoint(config *Config, db *sql.DB, stats *Stats, block int64) {
+	if config.ValidateMetadata {
+		return
+	}
+	if err := setSyncState(db, syncStateForwardBlock, block); err != nil {
+		stats.RecordError(err)
+		slog.Error("Error saving forward checkpoint", "error", err)
+	}
+}
+
+// maxFetchFailureDelay caps the wait between requests of a batch that keeps
+// failing to fetch
+const maxFetchFailureDelay = time.Minute * 5
+
+// fetchFailures counts the consecutive batches of a sync loop that failed to
+// fetch, so a node that stays down neither spins the loop nor keeps it running
+// forever when MaxFetchFailures is set
+type fetchFailures struct {
+	config *Config
+	count  int
+}
+
+// Failed records that the batch starting at startBlock failed to fetch and
+// waits FetchFailureDelay, doubled for every earlier consecutive failure, before
+// the batch is requested again. It returns an error once MaxFetchFailures
+// batches in a row failed, and returns early without an error when ctx is
+// cancelled.
+func (f *fetchFailures) Failed(ctx context.Context, startBlock int64) error {
+	f.count++
+	if f.config.MaxFetchFailures > 0 && f.count >= f.config.MaxFetchFailures {
+		return fmt.Errorf("giving up after %d consecutive failed fetches, the last of blocks starting at %d", f.count, startBlock)
+	}
+
+	delay := f.config.FetchFailureDelay
+	for i := 1; i < f.count && delay < maxFetchFailureDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxFetchFailureDelay {
+		delay = maxFetchFailureDelay
+	}
+	if delay <= 0 {
+		return nil
+	}
+
+	slog.Warn("Requesting blocks again", "start_block", startBlock, "delay", delay)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+// Succeeded resets the count after a batch was fetched
+func (f *fetchFailures) Succeeded() {
+	f.count = 0
+}
+
+// runExpired reports whether the run has lasted MaxRunDuration, after which the
+// sync loops stop at the next batch boundary
+func runExpired(config *Config, stats *Stats) bool {
+	return config.MaxRunDuration > 0 && stats.Elapsed() >= config.MaxRunDuration
+}
+
+// followPolling keeps the index current by running syncBlocks repeatedly: after
+// each catch-up it waits PollInterval, queries the head block again and processes
+// any new blocks. It runs until ctx is cancelled or an error is returned by
+// syncBlocks. While no new blocks are found, a heartbeat is logged every
+// HeartbeatInterval.
+func followPolling(ctx context.Context, config *Config, db *sql.DB, processor *BlockProcessor, head *headCache, pause *pauseControl, stats *Stats) error {
+	var beat *heartbeat
+	defer func() { beat.Stop() }()
+
+	var last int64
+	for {
+		processed, err := syncBlocks(ctx, config, db, processor, head, pause, stats)
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil || runExpired(config, stats) {
+			return nil
+		}
+		if beat == nil {
+			beat = startHeartbeat(config.HeartbeatInterval, processed)
+		} else if processed > last {
+			beat.Advance(processed)
+		}
+		last = processed
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(config.PollInterval):
+		}
+		head.Invalidate()
+	}
+}
+
+// syncBlocksReverse processes blocks from the head block backwards towards the
+// genesis block, for runs that should index the most recent posts first.
+//
+// Batches are walked in descending order, while the blocks within a batch are
+// still processed in ascending order. Because MAX(block_num) says nothing about
+// progress in this direction, the lowest fully processed block is checkpointed in
+// the sync_state table, together with the head block the reverse run started
+// from, so an interrupted run resumes where it stopped. Once done, the head block
+// the run started from is returned.
+func syncBlocksReverse(ctx context.Context, config *Config, db *sql.DB, processor *BlockProcessor, head *headCache, pause *pauseControl, stats *Stats) (int64, error) {
+	var low, high int64
+	err := retryWithBackoff(config.MaxRetries, config.RetryDelay, func() error {
+		var err error
+		low, err = getSyncState(db, syncStateReverseLow)
+		if err != nil {
+			return fmt.Errorf("error getting reverse checkpoint: %w", err)
+		}
+		high, err = getSyncState(db, syncStateReverseHigh)
+		if err != nil {
+			return fmt.Errorf("error getting reverse checkpoint: %w", err)
+		}
+
+		// A new reverse run starts just above the current head
+		if low == 0 {
+			currentBlock, err := head.Get(ctx)
+			if err != nil {
+				return fmt.Errorf("error getting latest block: %w", err)
+			}
+			high = currentBlock
+			low = currentBlock + 1
+			if config.ValidateMetadata {
+				return nil
+			}
+			if err := setSyncState(db, syncStateReverseHigh, high); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Like a forward run, the genesis block itself is treated as already processed
+	floor := config.GenesisBlock + 1
+	slog.Info("Starting reverse block processing", "head_block", high,
+		"lowest_processed_block", low, "floor", floor, "remaining", low-floor)
+
+	ramp := newBatchRamp(config)
+	failures := &fetchFailures{config: config}
+	for low > floor && ctx.Err() == nil && !runExpired(config, stats) {
+		if pause.Wait(ctx, time.Second) != nil {
+			break
+		}
+
+		count := ramp.Size()
+		startBlock := low - int64(count)
+		if startBlock < floor {
+			startBlock = floor
+			count = int(low - startBlock)
+		}
+
+		processor.resetTimestampCheck()
+		res, err := processBatch(ctx, config, db, processor, stats, startBlock, count, high, 0)
+		if err != nil {
+			return 0, err
+		}
+		// A partly processed batch is processed again on the next run, since the
+		// checkpoint only covers whole batches
+		if res.interrupted {
+			break
+		}
+		// Reverse batches lie below the head, so blocks missing from the end of
+		// one were omitted by the node and the batch is requested again; moving
+		// the checkpoint below them would skip them for good
+		if !res.fetched || res.handledThrough < low-1 {
+			if err := failures.Failed(ctx, startBlock); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		failures.Succeeded()
+		ramp.Succeeded()
+
+		// The whole fetched batch has been handled, so it becomes the new checkpoint
+		low = startBlock
+		if !config.ValidateMetadata {
+			if err := setSyncState(db, syncStateReverseLow, low); err != nil {
+				return 0, fmt.Errorf("error saving reverse checkpoint: %w", err)
+			}
+			mirrorReverseCheckpoint(config, low, high)
+		}
+
+		percentage := float64(high-low+1) / float64(high-floor+1) * 100
+		logProgress(percentage, startBlock, res, stats)
+	}
+
+	return high, nil
+}
@@ -0,0 +1,736 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testNode serves the JSON-RPC methods a forward sync uses from a fake chain of
+// blocks up to head, each holding one post. blockRange may shorten the blocks
+// returned for a get_block_range request; it is called with the requested range
+// and the number of the request, starting at 0.
+func testNode(t *testing.T, head int64, blockRange func(start int64, count, request int) (int64, int)) string {
+	t.Helper()
+	return testGrowingNode(t, func() int64 { return head }, blockRange)
+}
+
+// testGrowingNode is testNode for a chain whose head block is read from head on
+// every request, so a test can produce new blocks while a sync runs
+func testGrowingNode(t *testing.T, head func() int64, blockRange func(start int64, count, request int) (int64, int)) string {
+	t.Helper()
+	var mu sync.Mutex
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string `json:"method"`
+			Params struct {
+				StartingBlockNum int64 `json:"starting_block_num"`
+				Count            int   `json:"count"`
+			} `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var result interface{}
+		switch req.Method {
+		case "database_api.get_dynamic_global_properties":
+			result = map[string]int64{"head_block_number": head()}
+		case "block_api.get_block_range":
+			start, count := req.Params.StartingBlockNum, req.Params.Count
+			mu.Lock()
+			if blockRange != nil {
+				start, count = blockRange(start, count, requests)
+			}
+			requests++
+			mu.Unlock()
+			blocks := []Block{}
+			for n, last := start, head(); n < start+int64(count) && n <= last; n++ {
+				blocks = append(blocks, testBlock(n, testPost("alice", fmt.Sprintf("post-%d", n), "Post")))
+			}
+			result = map[string][]Block{"blocks": blocks}
+		default:
+			http.Error(w, "unknown method "+req.Method, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "result": result, "id": 1})
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// testSync runs a forward sync against the fake node and returns the number of
+// posts stored and the last block processed
+func testSync(t *testing.T, node string, configure func(*Config)) (int, int64) {
+	t.Helper()
+	db, bp := newTestProcessor(t, func(c *Config) {
+		c.HiveAPIURLs = []string{node}
+		c.GenesisBlock = 100
+		c.BatchSize = 10
+		c.InitialBatchSize = 0
+		c.FetchFailureDelay = time.Millisecond
+		if configure != nil {
+			configure(c)
+		}
+	})
+
+	last, err := syncBlocks(context.Background(), bp.config, db, bp, newHeadCache(bp.config), &pauseControl{}, NewStats())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var posts int
+	if err := db.QueryRow("SELECT COUNT(*) FROM posts").Scan(&posts); err != nil {
+		t.Fatal(err)
+	}
+	return posts, last
+}
+
+func TestSyncShortBatch(t *testing.T) {
+	// The first range requested is cut short in the middle, well below the head
+	shortFirst := func(start int64, count, request int) (int64, int) {
+		if request == 0 {
+			return start, count / 2
+		}
+		return start, count
+	}
+
+	tests := []struct {
+		name      string
+		configure func(*Config)
+	}{
+		{"sequential", nil},
+		{"sequential without validation", func(c *Config) { c.ValidateBlockCount = false }},
+		{"prefetched", func(c *Config) { c.PrefetchBlocks = 30; c.PrefetchWorkers = 2 }},
+		{"prefetched without validation", func(c *Config) {
+			c.PrefetchBlocks = 30
+			c.PrefetchWorkers = 2
+			c.ValidateBlockCount = false
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			posts, last := testSync(t, testNode(t, 130, shortFirst), tt.configure)
+			if posts != 30 || last != 130 {
+				t.Errorf("stored %d posts through block %d, want 30 through block 130", posts, last)
+			}
+		})
+	}
+}
+
+func TestSyncBatchRamp(t *testing.T) {
+	var counts []int
+	record := func(start int64, count, request int) (int64, int) {
+		counts = append(counts, count)
+		return start, count
+	}
+
+	posts, last := testSync(t, testNode(t, 140, record), func(c *Config) { c.InitialBatchSize = 2 })
+	if posts != 40 || last != 140 {
+		t.Errorf("stored %d posts through block %d, want 40 through block 140", posts, last)
+	}
+	// The ramp doubles from 2 up to the batch size of 10; the last batch only
+	// holds the blocks left up to the head
+	if want := []int{2, 4, 8, 10, 10, 6}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("requested batches of %v blocks, want %v", counts, want)
+	}
+}
+
+func TestSyncBeyondHead(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(*Config)
+	}{
+		{"sequential", nil},
+		{"prefetched", func(c *Config) { c.PrefetchBlocks = 30; c.PrefetchWorkers = 2 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var requestedThrough int64
+			node := testNode(t, 125, func(start int64, count, request int) (int64, int) {
+				mu.Lock()
+				if end := start + int64(count) - 1; end > requestedThrough {
+					requestedThrough = end
+				}
+				mu.Unlock()
+				return start, count
+			})
+
+			posts, last := testSync(t, node, func(c *Config) {
+				c.BeyondHeadBlocks = 10
+				if tt.configure != nil {
+					tt.configure(c)
+				}
+			})
+			// The last batch asks for blocks past the head, but the cursor only
+			// moves to the last block the node returned
+			if requestedThrough <= 125 || requestedThrough > 135 {
+				t.Errorf("requested blocks through %d, want past the head of 125 up to 135", requestedThrough)
+			}
+			if posts != 25 || last != 125 {
+				t.Errorf("stored %d posts through block %d, want 25 through block 125", posts, last)
+			}
+		})
+	}
+}
+
+func TestSyncCancelWhileWaitingForNode(t *testing.T) {
+	// The node is behind its reported head and returns no blocks at all
+	node := testNode(t, 130, func(start int64, count, request int) (int64, int) { return start, 0 })
+	db, bp := newTestProcessor(t, func(c *Config) {
+		c.HiveAPIURLs = []string{node}
+		c.GenesisBlock = 100
+		c.BatchSize = 10
+		c.ValidateBlockCount = false
+		c.RetryDelay = time.Hour
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := syncBlocks(ctx, bp.config, db, bp, newHeadCache(bp.config), &pauseControl{}, NewStats())
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("syncBlocks kept waiting for the node after ctx was cancelled")
+	}
+}
+
+func TestCheckBlockCount(t *testing.T) {
+	blocks := func(nums ...int64) []Block {
+		var result []Block
+		for _, n := range nums {
+			result = append(result, testBlock(n))
+		}
+		return result
+	}
+
+	tests := []struct {
+		name       string
+		blocks     []Block
+		startBlock int64
+		count      int
+		head       int64
+		wantErr    bool
+	}{
+		{"complete range", blocks(10, 11, 12), 10, 3, 100, false},
+		{"empty range beyond head", nil, 10, 3, 9, false},
+		{"empty range below head", nil, 10, 3, 100, true},
+		{"shortfall at the head", blocks(10, 11), 10, 3, 11, false},
+		{"shortfall in the middle of the range", blocks(10, 11), 10, 3, 100, true},
+		{"too many blocks", blocks(10, 11, 12, 13), 10, 3, 100, true},
+		{"gap", blocks(10, 12), 10, 3, 100, true},
+		{"wrong start", blocks(11, 12), 10, 3, 100, true},
+		{"out of order", blocks(10, 12, 11), 10, 3, 100, true},
+		{"unparsable id left to checkBlockSequence", []Block{testBlock(10), {BlockNum: "bad"}, testBlock(12)}, 10, 3, 100, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkBlockCount(tt.blocks, tt.startBlock, tt.count, tt.head)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkBlockCount() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckBlockSequence(t *testing.T) {
+	// withID builds a block whose id starts with the number prefix but differs in
+	// the rest of the id
+	withID := func(num int64, rest string) Block {
+		block := testBlock(num)
+		block.BlockNum = fmt.Sprintf("%08x%032s", num, rest)
+		return block
+	}
+
+	tests := []struct {
+		name             string
+		blocks           []Block
+		wantConsistent   []int64
+		wantInconsistent []int64
+	}{
+		{"in order", []Block{testBlock(10), testBlock(11), testBlock(12)}, []int64{10, 11, 12}, nil},
+		{"colliding prefixes", []Block{testBlock(10), withID(11, "a"), withID(11, "b"), testBlock(12)}, []int64{10, 11, 12}, []int64{11}},
+		{"going backwards", []Block{testBlock(10), testBlock(12), testBlock(11)}, []int64{10, 12}, []int64{11}},
+		{"outside the range", []Block{testBlock(10), testBlock(99)}, []int64{10}, []int64{99}},
+		{"unparsable id", []Block{testBlock(10), {BlockNum: "bad"}, testBlock(12)}, []int64{10, 12}, []int64{11}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			consistent, inconsistent := checkBlockSequence(tt.blocks, 10, 3)
+			var gotConsistent, gotInconsistent []int64
+			for _, block := range consistent {
+				num, _ := block.Number()
+				gotConsistent = append(gotConsistent, num)
+			}
+			for _, failure := range inconsistent {
+				gotInconsistent = append(gotInconsistent, failure.BlockNum)
+			}
+			if !reflect.DeepEqual(gotConsistent, tt.wantConsistent) {
+				t.Errorf("consistent blocks = %v, want %v", gotConsistent, tt.wantConsistent)
+			}
+			if !reflect.DeepEqual(gotInconsistent, tt.wantInconsistent) {
+				t.Errorf("inconsistent blocks = %v, want %v", gotInconsistent, tt.wantInconsistent)
+			}
+		})
+	}
+}
+
+func TestBatchRequestsPartialFailure(t *testing.T) {
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reqs []struct {
+			Params struct {
+				BlockNum int64 `json:"block_num"`
+			} `json:"params"`
+			ID int64 `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var resps []interface{}
+		for _, req := range reqs {
+			num := req.Params.BlockNum
+			if num == 102 {
+				resps = append(resps, map[string]interface{}{"jsonrpc": "2.0", "id": req.ID,
+					"error": map[string]interface{}{"code": -32003, "message": "assertion failed"}})
+				continue
+			}
+			block := testBlock(num, testPost("alice", fmt.Sprintf("post-%d", num), "Post"))
+			resps = append(resps, map[string]interface{}{"jsonrpc": "2.0", "id": req.ID,
+				"result": map[string]interface{}{"block": block}})
+		}
+		json.NewEncoder(w).Encode(resps)
+	}))
+	defer node.Close()
+
+	db, bp := newTestProcessor(t, func(c *Config) {
+		c.HiveAPIURLs = []string{node.URL}
+		c.BatchRequests = true
+	})
+	res, err := processBatch(context.Background(), bp.config, db, bp, NewStats(), 101, 3, 200, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.inserts != 2 || res.handledThrough != 103 {
+		t.Errorf("batch inserted %d posts and handled blocks through %d, want 2 through 103", res.inserts, res.handledThrough)
+	}
+
+	var posts int
+	if err := db.QueryRow("SELECT COUNT(*) FROM posts WHERE url IN ('@alice/post-101', '@alice/post-103')").Scan(&posts); err != nil {
+		t.Fatal(err)
+	}
+	if posts != 2 {
+		t.Errorf("stored %d of the posts in the blocks answered, want 2", posts)
+	}
+	var failed int64
+	var message string
+	if err := db.QueryRow("SELECT block_num, error FROM failed_blocks").Scan(&failed, &message); err != nil {
+		t.Fatal(err)
+	}
+	if failed != 102 || !strings.Contains(message, "assertion failed") {
+		t.Errorf("failed_blocks holds block %d with %q, want block 102 with the node's error", failed, message)
+	}
+}
+
+func TestSyncBlocksReverse(t *testing.T) {
+	var db *sql.DB
+	var starts, checkpoints []int64
+	node := testNode(t, 130, func(start int64, count, request int) (int64, int) {
+		low, err := getSyncState(db, syncStateReverseLow)
+		if err != nil {
+			t.Error(err)
+		}
+		starts = append(starts, start)
+		checkpoints = append(checkpoints, low)
+		return start, count
+	})
+	db, bp := newTestProcessor(t, func(c *Config) {
+		c.HiveAPIURLs = []string{node}
+		c.GenesisBlock = 100
+		c.BatchSize = 10
+		c.InitialBatchSize = 0
+		c.Reverse = true
+	})
+
+	high, err := syncBlocks(context.Background(), bp.config, db, bp, newHeadCache(bp.config), &pauseControl{}, NewStats())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if high != 130 {
+		t.Errorf("syncBlocks() = %d, want the head block 130", high)
+	}
+	if want := []int64{121, 111, 101}; !reflect.DeepEqual(starts, want) {
+		t.Errorf("requested batches starting at %v, want %v", starts, want)
+	}
+	// Each checkpoint is the start of the batch before, once it was stored
+	if want := []int64{0, 121, 111}; !reflect.DeepEqual(checkpoints, want) {
+		t.Errorf("checkpoints while requesting = %v, want %v", checkpoints, want)
+	}
+	for key, want := range map[string]int64{syncStateReverseLow: 101, syncStateReverseHigh: 130} {
+		if got, err := getSyncState(db, key); err != nil || got != want {
+			t.Errorf("sync_state %s = %d, %v, want %d", key, got, err, want)
+		}
+	}
+	var posts int
+	if err := db.QueryRow("SELECT COUNT(*) FROM posts").Scan(&posts); err != nil {
+		t.Fatal(err)
+	}
+	if posts != 30 {
+		t.Errorf("stored %d posts, want 30", posts)
+	}
+
+	// A finished reverse run requests nothing more
+	starts = nil
+	if _, err := syncBlocks(context.Background(), bp.config, db, bp, newHeadCache(bp.config), &pauseControl{}, NewStats()); err != nil {
+		t.Fatal(err)
+	}
+	if len(starts) > 0 {
+		t.Errorf("finished reverse run requested batches starting at %v", starts)
+	}
+}
+
+func TestSyncBlocksReverseResume(t *testing.T) {
+	var starts []int64
+	node := testNode(t, 140, func(start int64, count, request int) (int64, int) {
+		starts = append(starts, start)
+		return start, count
+	})
+	db, bp := newTestProcessor(t, func(c *Config) {
+		c.HiveAPIURLs = []string{node}
+		c.GenesisBlock = 100
+		c.BatchSize = 10
+		c.InitialBatchSize = 0
+		c.Reverse = true
+	})
+	// An earlier run started at head 130 and stopped after storing blocks 111-130
+	if err := setSyncState(db, syncStateReverseHigh, 130); err != nil {
+		t.Fatal(err)
+	}
+	if err := setSyncState(db, syncStateReverseLow, 111); err != nil {
+		t.Fatal(err)
+	}
+
+	high, err := syncBlocks(context.Background(), bp.config, db, bp, newHeadCache(bp.config), &pauseControl{}, NewStats())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int64{101}; high != 130 || !reflect.DeepEqual(starts, want) {
+		t.Errorf("resumed run requested %v and returned %d, want %v and 130", starts, high, want)
+	}
+}
+
+func TestResolveStartBlock(t *testing.T) {
+	tests := []struct {
+		name       string
+		blocks     []int64
+		sql        string
+		checkpoint int64
+		want       int64
+	}{
+		{name: "empty database", want: 101},
+		{name: "populated database", blocks: []int64{120, 150}, want: 151},
+		{name: "checkpoint past the stored posts", blocks: []int64{150}, checkpoint: 200, want: 201},
+		{name: "posts below the genesis block", blocks: []int64{50}, want: 101},
+		{name: "zero block number", blocks: []int64{150}, sql: "UPDATE posts SET block_num = 0", want: 101},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, bp := newTestProcessor(t, func(c *Config) { c.GenesisBlock = 100 })
+			for _, n := range tt.blocks {
+				if _, err := bp.processBlock(context.Background(), testBlock(n, testPost("alice", fmt.Sprintf("post-%d", n), "Post"))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.sql != "" {
+				if _, err := db.Exec(tt.sql); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.checkpoint > 0 {
+				if err := setSyncState(db, syncStateForwardBlock, tt.checkpoint); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := resolveStartBlock(db, bp.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("resolveStartBlock() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSkipProcessedBlocks(t *testing.T) {
+	tests := []struct {
+		skip      bool
+		wantPosts int
+	}{
+		{false, 6},
+		{true, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("skip=%v", tt.skip), func(t *testing.T) {
+			db, bp := newTestProcessor(t, func(c *Config) { c.SkipProcessedBlocks = tt.skip })
+			var blocks []Block
+			for n := int64(101); n <= 106; n++ {
+				blocks = append(blocks, testBlock(n, testPost("alice", fmt.Sprintf("post-%d", n), "Post")))
+			}
+			// The checkpoint sits in the middle of the fetched window. The posts
+			// of the blocks up to it are missing, so reprocessing them shows.
+			batch := fetchedBatch{startBlock: 101, count: len(blocks), blocks: blocks, processedThrough: 103}
+			res, err := processFetched(context.Background(), bp.config, db, bp, NewStats(), batch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.handledThrough != 106 {
+				t.Errorf("handled blocks through %d, want 106", res.handledThrough)
+			}
+
+			var posts int
+			if err := db.QueryRow("SELECT COUNT(*) FROM posts").Scan(&posts); err != nil {
+				t.Fatal(err)
+			}
+			if posts != tt.wantPosts {
+				t.Errorf("stored %d posts, want %d", posts, tt.wantPosts)
+			}
+		})
+	}
+}
+
+func TestFollowPolling(t *testing.T) {
+	var head atomic.Int64
+	head.Store(110)
+	node := testGrowingNode(t, head.Load, nil)
+	db, bp := newTestProcessor(t, func(c *Config) {
+		c.HiveAPIURLs = []string{node}
+		c.GenesisBlock = 100
+		c.BatchSize = 10
+		c.InitialBatchSize = 0
+		c.Follow = true
+		c.PollInterval = 5 * time.Millisecond
+		c.HeartbeatInterval = 0
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- followPolling(ctx, bp.config, db, bp, newHeadCache(bp.config), &pauseControl{}, NewStats())
+	}()
+
+	waitForPosts := func(want int) {
+		t.Helper()
+		var posts int
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+			if err := db.QueryRow("SELECT COUNT(*) FROM posts").Scan(&posts); err != nil {
+				t.Fatal(err)
+			}
+			if posts >= want {
+				break
+			}
+		}
+		if posts != want {
+			t.Fatalf("stored %d posts, want %d", posts, want)
+		}
+	}
+
+	// After catching up, the follower keeps polling and picks up new blocks
+	waitForPosts(10)
+	select {
+	case err := <-done:
+		t.Fatalf("followPolling() returned %v after catching up", err)
+	default:
+	}
+	head.Store(125)
+	waitForPosts(25)
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("followPolling() = %v after cancellation, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("followPolling() did not return after cancellation")
+	}
+
+	last, err := getLastProcessedBlock(db, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last != 125 {
+		t.Errorf("last processed block = %d, want 125", last)
+	}
+}
+
+func TestMaxRunDuration(t *testing.T) {
+	// Each batch takes a while to fetch and the head is far away, so only the
+	// duration limit ends the run
+	node := testNode(t, 1000000, func(start int64, count, request int) (int64, int) {
+		time.Sleep(10 * time.Millisecond)
+		return start, count
+	})
+
+	for _, follow := range []bool{false, true} {
+		db, bp := newTestProcessor(t, func(c *Config) {
+			c.HiveAPIURLs = []string{node}
+			c.GenesisBlock = 100
+			c.BatchSize = 10
+			c.InitialBatchSize = 0
+			c.MaxRunDuration = 50 * time.Millisecond
+			c.Follow = follow
+			c.HeartbeatInterval = 0
+		})
+
+		start := time.Now()
+		var last int64
+		var err error
+		if follow {
+			err = followPolling(context.Background(), bp.config, db, bp, newHeadCache(bp.config), &pauseControl{}, NewStats())
+		} else {
+			last, err = syncBlocks(context.Background(), bp.config, db, bp, newHeadCache(bp.config), &pauseControl{}, NewStats())
+		}
+		if err != nil {
+			t.Fatalf("follow %v: run = %v, want a clean stop", follow, err)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("follow %v: run stopped after %s, want it shortly after 50ms", follow, elapsed)
+		}
+
+		// The run stops at a batch boundary, with its progress checkpointed
+		checkpoint, err := getForwardCheckpoint(db, bp.config.GenesisBlock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !follow && checkpoint != last {
+			t.Errorf("syncBlocks() = %d, checkpoint %d, want them equal", last, checkpoint)
+		}
+		var posts int64
+		if err := db.QueryRow("SELECT COUNT(*) FROM posts").Scan(&posts); err != nil {
+			t.Fatal(err)
+		}
+		if checkpoint <= 100 || (checkpoint-100)%10 != 0 || posts != checkpoint-100 {
+			t.Errorf("follow %v: stopped at block %d with %d posts, want a batch boundary past 100 with every post stored", follow, checkpoint, posts)
+		}
+	}
+}
+
+func TestSkipBelowBlock(t *testing.T) {
+	node := testNode(t, 130, nil)
+	db, bp := newTestProcessor(t, func(c *Config) {
+		c.HiveAPIURLs = []string{node}
+		c.GenesisBlock = 100
+		c.BatchSize = 10
+		c.InitialBatchSize = 0
+		c.SkipBelowBlock = 115
+	})
+
+	last, err := syncBlocks(context.Background(), bp.config, db, bp, newHeadCache(bp.config), &pauseControl{}, NewStats())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The cursor advances through the skipped blocks, whose posts are not stored
+	checkpoint, err := getForwardCheckpoint(db, bp.config.GenesisBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last != 130 || checkpoint != 130 {
+		t.Errorf("syncBlocks() = %d with checkpoint %d, want 130", last, checkpoint)
+	}
+	var posts, lowest int64
+	if err := db.QueryRow("SELECT COUNT(*), MIN(block_num) FROM posts").Scan(&posts, &lowest); err != nil {
+		t.Fatal(err)
+	}
+	if posts != 16 || lowest != 115 {
+		t.Errorf("stored %d posts from block %d up, want 16 from block 115 up", posts, lowest)
+	}
+}
+
+func TestSyncFetchFailures(t *testing.T) {
+	// The node reports its head but fails every block range request
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string `json:"method"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Method == "database_api.get_dynamic_global_properties" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"result": map[string]int64{"head_block_number": 130}})
+			return
+		}
+		requests.Add(1)
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	newSync := func(configure func(*Config)) (*sql.DB, *BlockProcessor) {
+		return newTestProcessor(t, func(c *Config) {
+			c.HiveAPIURLs = []string{srv.URL}
+			c.GenesisBlock = 100
+			c.BatchSize = 10
+			configure(c)
+		})
+	}
+
+	t.Run("give up", func(t *testing.T) {
+		requests.Store(0)
+		db, bp := newSync(func(c *Config) {
+			c.MaxFetchFailures = 3
+			c.FetchFailureDelay = time.Millisecond
+		})
+		_, err := syncBlocks(context.Background(), bp.config, db, bp, newHeadCache(bp.config), &pauseControl{}, NewStats())
+		if err == nil || !strings.Contains(err.Error(), "giving up after 3 consecutive failed fetches") {
+			t.Errorf("syncBlocks() = %v, want it to give up after 3 failed fetches", err)
+		}
+		if n := requests.Load(); n != 3 {
+			t.Errorf("node received %d requests, want 3", n)
+		}
+	})
+
+	t.Run("back off", func(t *testing.T) {
+		requests.Store(0)
+		db, bp := newSync(func(c *Config) {
+			c.MaxFetchFailures = 0
+			c.FetchFailureDelay = 20 * time.Millisecond
+		})
+		// Without a limit the run only ends when it is stopped, and waits longer
+		// after every failure instead of spinning: 20ms, 40ms, 80ms, ...
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		last, err := syncBlocks(ctx, bp.config, db, bp, newHeadCache(bp.config), &pauseControl{}, NewStats())
+		if err != nil || last != 100 {
+			t.Errorf("syncBlocks() = %d, %v after being stopped, want 100 without an error", last, err)
+		}
+		if n := requests.Load(); n < 2 || n > 5 {
+			t.Errorf("node received %d requests in 200ms, want the retries to back off", n)
+		}
+	})
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// timestampLayouts are the layouts accepted when parsing stored or fetched
+// timestamps. The Hive API omits the zone, which is always UTC.
+var timestampLayouts = []string{
+	time.RFC3339,
+	hiveTimeLayout,
+	"2006-01-02 15:04:05",
+}
+
+// parseTimestamp parses a timestamp in any of timestampLayouts, returning it in UTC
+func parseTimestamp(raw string) (time.Time, error) {
+	for _, layout := range timestampLayouts {
+		if ts, err := time.Parse(layout, raw); err == nil {
+			return ts.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized timestamp %q", raw)
+}
+
+// normalizeTimestamp returns the RFC 3339 UTC form of a timestamp and its Unix
+// time. Unparsable timestamps are returned unchanged with an invalid epoch.
+func normalizeTimestamp(raw string) (string, sql.NullInt64) {
+	ts, err := parseTimestamp(raw)
+	if err != nil {
+		return raw, sql.NullInt64{}
+	}
+	return ts.Format(time.RFC3339), sql.NullInt64{Int64: ts.Unix(), Valid: true}
+}
+
+// runRepairTimestamps implements the "repair-timestamps" command, which fills in
+// timestamp_epoch and normalizes the timestamp text of posts stored before
+// timestamps were normalized on insert.
+//
+// Posts are repaired in batches, each in its own transaction, so an interrupted
+// repair keeps its progress and can simply be run again. Timestamps that cannot
+// be parsed are left untouched and reported.
+func runRepairTimestamps(config *Config, args []string) error {
+	fs := flag.NewFlagSet("repair-timestamps", flag.ExitOnError)
+	batchSize := fs.Int("batch", 1000, "number of posts updated per transaction")
+	fs.Parse(args)
+
+	if *batchSize < 1 {
+		return fmt.Errorf("invalid -batch %d", *batchSize)
+	}
+
+	db, err := initDB(config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	repaired, unparsable, err := repairTimestamps(db, *batchSize)
+	slog.Info("Repaired timestamps", "repaired", repaired, "unparsable", unparsable)
+	return err
+}
+
+// repairTimestamps normalizes the timestamps of all posts without an epoch,
+// returning the number of posts repaired and the number skipped as unparsable
+func repairTimestamps(db *sql.DB, batchSize int) (int, int, error) {
+	repaired, unparsable := 0, 0
+	var lastID int64
+	for {
+		rows, err := db.Query(`
+			SELECT _id, url, timestamp FROM posts
+			WHERE timestamp_epoch IS NULL AND _id > ?
+			ORDER BY _id LIMIT ?
+		`, lastID, batchSize)
+		if err != nil {
+			return repaired, unparsable, fmt.Errorf("error querying posts: %v", err)
+		}
+
+		type repair struct {
+			id        int64
+			timestamp string
+			epoch     int64
+		}
+		var batch []repair
+		n := 0
+		for rows.Next() {
+			var (
+				id  int64
+				url string
+				raw sql.NullString
+			)
+			if err := rows.Scan(&id, &url, &raw); err != nil {
+				rows.Close()
+				return repaired, unparsable, fmt.Errorf("error reading post: %v", err)
+			}
+			lastID = id
+			n++
+
+			ts, err := parseTimestamp(raw.String)
+			if err != nil {
+				slog.Warn("Skipping post", "url", url, "error", err)
+				unparsable++
+				continue
+			}
+			batch = append(batch, repair{id: id, timestamp: ts.Format(time.RFC3339), epoch: ts.Unix()})
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return repaired, unparsable, fmt.Errorf("error reading posts: %v", err)
+		}
+		if n == 0 {
+			return repaired, unparsable, nil
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return repaired, unparsable, fmt.Errorf("error starting transaction: %v", err)
+		}
+		for _, r := range batch {
+			if _, err := tx.Exec("UPDATE posts SET timestamp = ?, timestamp_epoch = ? WHERE _id = ?",
+				r.timestamp, r.epoch, r.id); err != nil {
+				tx.Rollback()
+				return repaired, unparsable, fmt.Errorf("error updating post %d: %v", r.id, err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return repaired, unparsable, fmt.Errorf("error committing repairs: %v", err)
+		}
+		repaired += len(batch)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func TestRepairTimestamps(t *testing.T) {
+	db, bp := newTestProcessor(t, nil)
+	raw := map[string]string{
+		"@alice/a": "2024-01-02T03:04:05",
+		"@bob/b":   "2024-01-02 03:04:06",
+		"@carol/c": "2024-01-02T05:04:07+02:00",
+		"@dave/d":  "yesterday",
+		"@erin/e":  "2024-01-02T03:04:08Z",
+	}
+	block := testBlock(100,
+		testPost("alice", "a", "A"),
+		testPost("bob", "b", "B"),
+		testPost("carol", "c", "C"),
+		testPost("dave", "d", "D"),
+		testPost("erin", "e", "E"),
+	)
+	if _, err := bp.processBlock(context.Background(), block); err != nil {
+		t.Fatal(err)
+	}
+	// Store the timestamps the way posts were stored before normalization
+	for url, ts := range raw {
+		if _, err := db.Exec("UPDATE posts SET timestamp = ?, timestamp_epoch = NULL WHERE url = ?", ts, url); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	repaired, unparsable, err := repairTimestamps(db, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repaired != 4 || unparsable != 1 {
+		t.Errorf("repairTimestamps() = %d repaired, %d unparsable, want 4 and 1", repaired, unparsable)
+	}
+
+	want := map[string]struct {
+		timestamp string
+		epoch     sql.NullInt64
+	}{
+		"@alice/a": {"2024-01-02T03:04:05Z", sql.NullInt64{Int64: 1704164645, Valid: true}},
+		"@bob/b":   {"2024-01-02T03:04:06Z", sql.NullInt64{Int64: 1704164646, Valid: true}},
+		"@carol/c": {"2024-01-02T03:04:07Z", sql.NullInt64{Int64: 1704164647, Valid: true}},
+		"@dave/d":  {"yesterday", sql.NullInt64{}},
+		"@erin/e":  {"2024-01-02T03:04:08Z", sql.NullInt64{Int64: 1704164648, Valid: true}},
+	}
+	for url, w := range want {
+		var timestamp string
+		var epoch sql.NullInt64
+		if err := db.QueryRow("SELECT timestamp, timestamp_epoch FROM posts WHERE url = ?", url).Scan(&timestamp, &epoch); err != nil {
+			t.Fatal(err)
+		}
+		if timestamp != w.timestamp || epoch != w.epoch {
+			t.Errorf("%s stored %q with epoch %+v, want %q with epoch %+v", url, timestamp, epoch, w.timestamp, w.epoch)
+		}
+	}
+
+	// A second run has nothing left to repair
+	if repaired, unparsable, err := repairTimestamps(db, 2); err != nil || repaired != 0 || unparsable != 1 {
+		t.Errorf("repeated repairTimestamps() = %d, %d, %v, want 0 repaired and 1 unparsable", repaired, unparsable, err)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// metadataKindNames describe each metadataKind in block traces
+var metadataKindNames = map[metadataKind]string{
+	metadataEmpty:      "empty",
+	metadataValid:      "valid JSON",
+	metadataFallback:   "single-string fallback",
+	metadataUnparsable: "unparsable tags",
+}
+
+// traceBlock fetches the block config.TraceBlock and writes to w what processing
+// it would do: for every operation, whether it would be handled or skipped and
+// why, and the fields parsed from each post. Nothing is written to the database,
+// and reputations are not looked up.
+func traceBlock(ctx context.Context, config *Config, w io.Writer) error {
+	blocks, err := getBlockRange(ctx, config, config.TraceBlock, 1)
+	if err != nil {
+		return fmt.Errorf("error fetching block %d: %w", config.TraceBlock, err)
+	}
+	if len(blocks) == 0 {
+		return fmt.Errorf("block %d has not been produced yet", config.TraceBlock)
+	}
+	block := blocks[0]
+
+	blockNum, err := block.Number()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Block %d (id %s, %s, witness %s): %d transactions\n",
+		blockNum, block.BlockNum, block.Timestamp, block.Witness, len(block.Transactions))
+	if blockNum < config.SkipBelowBlock {
+		fmt.Fprintf(w, "  skipped: below -skip-below %d\n", config.SkipBelowBlock)
+		return nil
+	}
+
+	bp := &BlockProcessor{config: config}
+	for _, keyword := range config.TitleContains {
+		bp.titleKeywords = append(bp.titleKeywords, strings.ToLower(keyword))
+	}
+	opCtx := &OpContext{
+		Context:   ctx,
+		BlockNum:  blockNum,
+		BlockID:   block.BlockNum,
+		Timestamp: block.Timestamp,
+		Witness:   block.Witness,
+	}
+
+	seen := make(map[string]bool)
+	for txIndex, tx := range block.Transactions {
+		for opIndex, op := range tx.Operations {
+			if config.NormalizePermlinks {
+				op.Value.Permlink = normalizePermlink(op.Value.Permlink)
+			}
+			op.Value.Author = strings.ToLower(op.Value.Author)
+			op.Value.ParentAuthor = strings.ToLower(op.Value.ParentAuthor)
+			opCtx.TxIndex, opCtx.OpIndex = txIndex, opIndex
+			opCtx.TransactionID = block.TransactionID(txIndex)
+
+			fmt.Fprintf(w, "tx %d op %d %s: ", txIndex, opIndex, op.Type)
+			switch op.Type {
+			case "comment_operation":
+				url := constructAuthorPerm(op.Value.Author, op.Value.Permlink)
+				if config.CollapseDuplicateOps && seen[url] {
+					fmt.Fprintf(w, "skipped: duplicate of an earlier operation for %s\n", url)
+					continue
+				}
+				seen[url] = true
+				if err := traceComment(w, bp, opCtx, op.Value); err != nil {
+					return err
+				}
+			case "delete_comment_operation":
+				if !config.ProcessDeletes {
+					fmt.Fprintln(w, "skipped: deletes are not processed")
+					continue
+				}
+				fmt.Fprintf(w, "deletes %s\n", constructAuthorPerm(op.Value.Author, op.Value.Permlink))
+			case "custom_json_operation":
+				switch {
+				case !config.ProcessCustomJSON:
+					fmt.Fprintln(w, "skipped: custom_json operations are not processed")
+				case len(config.CustomJSONIDs) > 0 && !slices.Contains(config.CustomJSONIDs, string(op.Value.Id)):
+					fmt.Fprintf(w, "skipped: id %q is not in -custom-json-ids\n", op.Value.Id)
+				default:
+					fmt.Fprintf(w, "stored with id %q\n", op.Value.Id)
+				}
+			case "transfer_operation":
+				if !config.ProcessTransfers {
+					fmt.Fprintln(w, "skipped: transfers are not processed")
+					continue
+				}
+				fmt.Fprintf(w, "stored: %s from %s to %s\n", op.Value.Amount.Raw, op.Value.From, op.Value.To)
+			case "vote_operation":
+				if !config.ProcessVotes {
+					fmt.Fprintln(w, "skipped: votes are not processed")
+					continue
+				}
+				fmt.Fprintf(w, "stored: %s votes %d on %s\n", op.Value.Voter, op.Value.Weight,
+					constructAuthorPerm(op.Value.Author, op.Value.Permlink))
+			case "comment_options_operation":
+				if !config.StoreBeneficiaries {
+					fmt.Fprintln(w, "skipped: beneficiaries are not stored")
+					continue
+				}
+				fmt.Fprintf(w, "stores %d beneficiaries of %s\n", len(parseBeneficiaries(op.Value.Extensions)),
+					constructAuthorPerm(op.Value.Author, op.Value.Permlink))
+			default:
+				fmt.Fprintln(w, "skipped: no handler")
+			}
+		}
+	}
+	return nil
+}
+
+// traceComment writes how a comment operation would be handled, following the
+// same checks as the registered comment handler
+func traceComment(w io.Writer, bp *BlockProcessor, ctx *OpContext, value OperationValue) error {
+	config := bp.config
+	if config.ValidateMetadata {
+		_, kind := extractTags(value.JsonMetadata)
+		fmt.Fprintf(w, "validated only: metadata %s\n", metadataKindNames[kind])
+		return nil
+	}
+	if value.ParentAuthor != "" && config.IndexReplies {
+		fmt.Fprintf(w, "stored as a reply to %s\n", constructAuthorPerm(value.ParentAuthor, value.ParentPermlink))
+		return nil
+	}
+	if !config.ProcessComments {
+		fmt.Fprintln(w, "skipped: comments are not processed")
+		return nil
+	}
+	if reason := bp.commentSkipReason(value); reason != "" {
+		fmt.Fprintf(w, "skipped: %s\n", reason)
+		return nil
+	}
+
+	dropped := bp.droppedTags
+	row, err := bp.newPostRow(ctx, value)
+	if err != nil {
+		return err
+	}
+	dropped = bp.droppedTags - dropped
+	_, kind := extractTags(value.JsonMetadata)
+	fmt.Fprintln(w, "stored as a post")
+	fmt.Fprintf(w, "  url: %s\n", row.url)
+	fmt.Fprintf(w, "  link: %s\n", row.link)
+	fmt.Fprintf(w, "  title: %q\n", row.title)
+	fmt.Fprintf(w, "  tags: %s (metadata %s, %d invalid tags dropped)\n", row.tagsJson, metadataKindNames[kind], dropped)
+	fmt.Fprintf(w, "  app: %q\n", row.app)
+	fmt.Fprintf(w, "  timestamp: %s\n", row.timestamp)
+	if row.thumbnail.Valid {
+		fmt.Fprintf(w, "  thumbnail: %s\n", row.thumbnail.String)
+	}
+	if row.wordCount.Valid {
+		fmt.Fprintf(w, "  word_count: %d\n", row.wordCount.Int64)
+	}
+	if row.blockSeq.Valid {
+		fmt.Fprintf(w, "  block_seq: %d\n", row.blockSeq.Int64)
+	}
+	if row.transactionID.Valid {
+		fmt.Fprintf(w, "  transaction_id: %s\n", row.transactionID.String)
+	}
+	if row.slug.Valid {
+		fmt.Fprintf(w, "  slug: %s\n", row.slug.String)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTraceBlock(t *testing.T) {
+	post := testPost("Alice", "my-post", "My post")
+	post.Value.JsonMetadata = `{"tags":["hive","Photo Graphy","art"],"app":"peakd/2024.1.1"}`
+	reply := testPost("bob", "re-my-post", "")
+	reply.Value.ParentAuthor, reply.Value.ParentPermlink = "alice", "my-post"
+	block := testBlock(120, post, reply, Operation{Type: "custom_operation"})
+
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Params struct {
+				StartingBlockNum int64 `json:"starting_block_num"`
+			} `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Params.StartingBlockNum != 120 {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"result": map[string][]Block{"blocks": {block}}})
+	}))
+	defer node.Close()
+
+	config := DefaultConfig()
+	config.HiveAPIURLs = []string{node.URL}
+	config.TraceBlock = 120
+	var out bytes.Buffer
+	if err := traceBlock(context.Background(), config, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"Block 120 (id " + block.BlockNum + ", 2024-01-02T03:04:05, witness witness): 1 transactions\n",
+		"tx 0 op 0 comment_operation: stored as a post\n" +
+			"  url: @alice/my-post\n" +
+			"  link: /hive/@alice/my-post\n" +
+			"  title: \"My post\"\n" +
+			"  tags: [\"hive\",\"art\"] (metadata valid JSON, 1 invalid tags dropped)\n" +
+			"  app: \"peakd/2024.1.1\"\n" +
+			"  timestamp: 2024-01-02T03:04:05Z\n",
+		"tx 0 op 1 comment_operation: skipped: reply to @alice/my-post\n",
+		"tx 0 op 2 custom_operation: skipped: no handler\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("trace output:\n%s\nwant it to contain:\n%s", out.String(), want)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// otlpExportTimeout bounds each export to the collector, so an unreachable
+// collector can't hold up the batch span processor or the shutdown
+const otlpExportTimeout = time.Second * 10
+
+// tracingShutdownTimeout bounds the final flush of buffered spans at exit
+const tracingShutdownTimeout = time.Second * 15
+
+// tracer creates the spans emitted while processing batches. Until initTracing
+// installs an exporting tracer provider, the global provider is a no-op and
+// starting spans costs next to nothing.
+var tracer = otel.Tracer("post-stuffer")
+
+// initTracing installs a tracer provider that exports spans to the OTLP endpoint
+// configured in OTLPEndpoint. When no endpoint is configured tracing stays a no-op.
+//
+// The returned function flushes any buffered spans and shuts the provider down; it
+// should be called before the program exits.
+func initTracing(config *Config) (func(context.Context) error, error) {
+	if config.OTLPEndpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter := newOTLPExporter(config.OTLPEndpoint)
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// otlpExporter is a span exporter that sends spans to an OTLP collector using the
+// OTLP/HTTP JSON encoding.
+type otlpExporter struct {
+	url    string
+	client *http.Client
+}
+
+// newOTLPExporter creates an exporter for the collector at endpoint, e.g.
+// "http://localhost:4318". Spans are posted to the collector's /v1/traces path.
+func newOTLPExporter(endpoint string) *otlpExporter {
+	return &otlpExporter{
+		url:    strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		client: &http.Client{Timeout: otlpExportTimeout},
+	}
+}
+
+// ExportSpans sends a batch of completed spans to the collector
+func (e *otlpExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	if len(spans) == 0 {
+		return nil
+	}
+
+	otlpSpans := make([]map[string]interface{}, 0, len(spans))
+	for _, span := range spans {
+		s := map[string]interface{}{
+			"traceId":           span.SpanContext().TraceID().String(),
+			"spanId":            span.SpanContext().SpanID().String(),
+			"name":              span.Name(),
+			"kind":              int(span.SpanKind()),
+			"startTimeUnixNano": strconv.FormatInt(span.StartTime().UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(span.EndTime().UnixNano(), 10),
+			"attributes":        otlpAttributes(span.Attributes()),
+			"status":            otlpStatus(span.Status()),
+		}
+		if span.Parent().HasSpanID() {
+			s["parentSpanId"] = span.Parent().SpanID().String()
+		}
+		otlpSpans = append(otlpSpans, s)
+	}
+
+	payload := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes([]attribute.KeyValue{
+						attribute.String("service.name", "post-stuffer"),
+					}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "post-stuffer"},
+						"spans": otlpSpans,
+					},
+				},
+			},
+		},
+	}
+
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("otlp collector returned status %s", resp.Status)
+	}
+	return nil
+}
+
+// Shutdown is called by the tracer provider when it shuts down
+func (e *otlpExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// otlpAttributes converts span attributes into their OTLP JSON representation
+func otlpAttributes(attrs []attribute.KeyValue) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(attrs))
+	for _, kv := range attrs {
+		var value map[string]interface{}
+		switch kv.Value.Type() {
+		case attribute.BOOL:
+			value = map[string]interface{}{"boolValue": kv.Value.AsBool()}
+		case attribute.INT64:
+			// OTLP JSON encodes 64-bit integers as strings
+			value = map[string]interface{}{"intValue": strconv.FormatInt(kv.Value.AsInt64(), 10)}
+		case attribute.FLOAT64:
+			value = map[string]interface{}{"doubleValue": kv.Value.AsFloat64()}
+		default:
+			value = map[string]interface{}{"stringValue": kv.Value.Emit()}
+		}
+		result = append(result, map[string]interface{}{
+			"key":   string(kv.Key),
+			"value": value,
+		})
+	}
+	return result
+}
+
+// otlpStatus converts a span status into its OTLP JSON representation. OTLP
+// numbers the status codes differently from the OpenTelemetry Go API.
+func otlpStatus(status sdktrace.Status) map[string]interface{} {
+	code := 0
+	switch status.Code {
+	case codes.Ok:
+		code = 1
+	case codes.Error:
+		code = 2
+	}
+	return map[string]interface{}{
+		"code":    code,
+		"message": status.Description,
+	}
+}
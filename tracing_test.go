@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestBatchSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	db, bp := newTestProcessor(t, func(c *Config) {
+		c.HiveAPIURLs = []string{testNode(t, 130, nil)}
+	})
+	if _, err := processBatch(context.Background(), bp.config, db, bp, NewStats(), 101, 2, 130, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	want := map[string][]attribute.KeyValue{
+		"batch":     {attribute.Int64("start_block", 101), attribute.Int("count", 2)},
+		"fetch":     {attribute.Int("blocks", 2), attribute.Int("failed", 0)},
+		"process":   {attribute.Int("posts", 2)},
+		"commit":    nil,
+		"handle_op": {attribute.String("op.type", "comment_operation")},
+	}
+	for name, attrs := range want {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("no %q span emitted", name)
+			continue
+		}
+		got := make(map[attribute.Key]attribute.Value)
+		for _, kv := range span.Attributes() {
+			got[kv.Key] = kv.Value
+		}
+		for _, kv := range attrs {
+			if v, ok := got[kv.Key]; !ok || v != kv.Value {
+				t.Errorf("%q span has %s = %v, want %v", name, kv.Key, v.Emit(), kv.Value.Emit())
+			}
+		}
+	}
+	if batch, fetch := spans["batch"], spans["fetch"]; batch != nil && fetch != nil && fetch.Parent().SpanID() != batch.SpanContext().SpanID() {
+		t.Error("fetch span is not a child of the batch span")
+	}
+}
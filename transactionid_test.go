@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+)
+
+func TestStoreTransactionID(t *testing.T) {
+	// block_api lists the ids next to the transactions, while other APIs put the
+	// id on the transaction itself
+	var block Block
+	if err := json.Unmarshal([]byte(`{
+		"block_id": "0000006400000000000000000000000000000000",
+		"timestamp": "2024-01-02T03:04:05",
+		"witness": "witness",
+		"transaction_ids": ["aaaa000000000000000000000000000000000001", "aaaa000000000000000000000000000000000002"],
+		"transactions": [
+			{"operations": [{"type": "comment_operation", "value": {"author": "alice", "permlink": "listed", "title": "Listed", "parent_permlink": "hive", "json_metadata": "{}"}}]},
+			{"operations": [{"type": "comment_operation", "value": {"author": "bob", "permlink": "inline", "title": "Inline", "parent_permlink": "hive", "json_metadata": "{}"}}],
+			 "transaction_id": "bbbb000000000000000000000000000000000002"},
+			{"operations": [{"type": "comment_operation", "value": {"author": "carol", "permlink": "unknown", "title": "Unknown", "parent_permlink": "hive", "json_metadata": "{}"}}]}
+		]
+	}`), &block); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, store := range []bool{true, false} {
+		db, bp := newTestProcessor(t, func(c *Config) { c.StoreTransactionID = store })
+		if _, err := bp.processBlock(context.Background(), block); err != nil {
+			t.Fatal(err)
+		}
+
+		want := map[string]sql.NullString{
+			"@alice/listed":  {String: "aaaa000000000000000000000000000000000001", Valid: true},
+			"@bob/inline":    {String: "bbbb000000000000000000000000000000000002", Valid: true},
+			"@carol/unknown": {},
+		}
+		for url, id := range want {
+			if !store {
+				id = sql.NullString{}
+			}
+			var got sql.NullString
+			if err := db.QueryRow("SELECT transaction_id FROM posts WHERE url = ?", url).Scan(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got != id {
+				t.Errorf("StoreTransactionID %v: transaction_id of %s = %v, want %v", store, url, got, id)
+			}
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// transfersTableSQL creates the transfers table, which holds transfer operations
+// when ProcessTransfers is enabled. Like custom_json operations, a transfer is
+// identified by its position in the chain.
+const transfersTableSQL = `
+	CREATE TABLE IF NOT EXISTS transfers (
+		_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		block_num INTEGER,
+		tx_index INTEGER,
+		op_index INTEGER,
+		timestamp TEXT,
+		transaction_id TEXT,
+		from_account TEXT,
+		to_account TEXT,
+		amount TEXT,
+		amount_value REAL,
+		symbol TEXT,
+		memo TEXT,
+		UNIQUE (block_num, tx_index, op_index)
+	);
+	CREATE INDEX IF NOT EXISTS idx_transfers_to ON transfers(to_account);
+	CREATE INDEX IF NOT EXISTS idx_transfers_from ON transfers(from_account);
+`
+
+// insertTransferSQL inserts a transfer operation
+const insertTransferSQL = `
+	INSERT INTO transfers (block_num, tx_index, op_index, timestamp, transaction_id,
+		from_account, to_account, amount, amount_value, symbol, memo)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(block_num, tx_index, op_index) DO NOTHING
+`
+
+// assetSymbols maps the numeric asset identifiers (NAIs) used by block_api to
+// the symbols of the legacy asset format
+var assetSymbols = map[string]string{
+	"@@000000021": "HIVE",
+	"@@000000013": "HBD",
+	"@@000000037": "VESTS",
+}
+
+// Asset is an amount of HIVE, HBD or VESTS from an operation. block_api sends
+// assets as {"amount": "1000", "precision": 3, "nai": "@@000000021"} objects and
+// condenser_api as "1.000 HIVE" strings; both are decoded into the string form.
+// Anything else is kept as its JSON text, so an unexpected value never fails to
+// decode the whole block.
+type Asset struct {
+	// Raw is the amount in the legacy string form, e.g. "1.000 HIVE"
+	Raw    string
+	Value  float64
+	Symbol string
+}
+
+// UnmarshalJSON decodes an asset in either format
+func (a *Asset) UnmarshalJSON(data []byte) error {
+	var legacy string
+	if err := json.Unmarshal(data, &legacy); err == nil {
+		*a = parseLegacyAsset(legacy)
+		return nil
+	}
+
+	var nai struct {
+		Amount    string `json:"amount"`
+		Precision int    `json:"precision"`
+		NAI       string `json:"nai"`
+	}
+	if err := json.Unmarshal(data, &nai); err != nil || nai.Amount == "" {
+		*a = Asset{Raw: string(data)}
+		return nil
+	}
+	units, err := strconv.ParseInt(nai.Amount, 10, 64)
+	if err != nil {
+		*a = Asset{Raw: string(data)}
+		return nil
+	}
+	symbol, ok := assetSymbols[nai.NAI]
+	if !ok {
+		symbol = nai.NAI
+	}
+
+	// The amount is an integer number of the asset's smallest unit
+	digits := strconv.FormatInt(units, 10)
+	if nai.Precision > 0 {
+		digits = fmt.Sprintf("%0*d", nai.Precision+1, units)
+		digits = digits[:len(digits)-nai.Precision] + "." + digits[len(digits)-nai.Precision:]
+	}
+	value, _ := strconv.ParseFloat(digits, 64)
+	*a = Asset{Raw: digits + " " + symbol, Value: value, Symbol: symbol}
+	return nil
+}
+
+// parseLegacyAsset parses an asset string such as "1.000 HIVE". A string that
+// doesn't parse is kept as Raw without a value.
+func parseLegacyAsset(raw string) Asset {
+	amount, symbol, ok := strings.Cut(raw, " ")
+	if !ok {
+		return Asset{Raw: raw}
+	}
+	value, err := strconv.ParseFloat(amount, 64)
+	if err != nil {
+		return Asset{Raw: raw}
+	}
+	return Asset{Raw: raw, Value: value, Symbol: symbol}
+}
+
+// handleTransfer stores a "transfer_operation" in the transfers table. The
+// amount_value of an amount that could not be parsed is NULL.
+func (bp *BlockProcessor) handleTransfer(ctx *OpContext, value OperationValue) (int, error) {
+	timestamp, _ := normalizeTimestamp(ctx.Timestamp)
+	amountValue := sql.NullFloat64{Float64: value.Amount.Value, Valid: value.Amount.Symbol != ""}
+
+	var inserted int64
+	err := bp.retryDB(func() error {
+		result, err := bp.txStmt(bp.transferStmt).Exec(ctx.BlockNum, ctx.TxIndex, ctx.OpIndex, timestamp,
+			ctx.TransactionID, value.From, value.To, value.Amount.Raw, amountValue, value.Amount.Symbol,
+			value.Memo)
+		if err != nil {
+			return err
+		}
+		inserted, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error inserting transfer: %w", err)
+	}
+	return int(inserted), nil
+}
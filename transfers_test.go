@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+)
+
+func TestAssetUnmarshal(t *testing.T) {
+	tests := []struct {
+		json string
+		want Asset
+	}{
+		{`"1.000 HIVE"`, Asset{Raw: "1.000 HIVE", Value: 1, Symbol: "HIVE"}},
+		{`"0.250 HBD"`, Asset{Raw: "0.250 HBD", Value: 0.25, Symbol: "HBD"}},
+		{`{"amount": "1500", "precision": 3, "nai": "@@000000021"}`, Asset{Raw: "1.500 HIVE", Value: 1.5, Symbol: "HIVE"}},
+		{`{"amount": "7", "precision": 3, "nai": "@@000000013"}`, Asset{Raw: "0.007 HBD", Value: 0.007, Symbol: "HBD"}},
+		{`{"amount": "123456789", "precision": 6, "nai": "@@000000037"}`, Asset{Raw: "123.456789 VESTS", Value: 123.456789, Symbol: "VESTS"}},
+		{`"lots of HIVE"`, Asset{Raw: "lots of HIVE"}},
+		{`42`, Asset{Raw: "42"}},
+	}
+	for _, tt := range tests {
+		var got Asset
+		if err := json.Unmarshal([]byte(tt.json), &got); err != nil {
+			t.Errorf("decoding %s: %v", tt.json, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("decoding %s = %+v, want %+v", tt.json, got, tt.want)
+		}
+	}
+}
+
+func TestProcessTransfers(t *testing.T) {
+	var ops []Operation
+	if err := json.Unmarshal([]byte(`[
+		{"type": "transfer_operation", "value": {"from": "alice", "to": "tipper", "amount": "1.000 HIVE", "memo": "tip @bob/post"}},
+		{"type": "transfer_operation", "value": {"from": "bob", "to": "tipper", "amount": {"amount": "250", "precision": 3, "nai": "@@000000013"}, "memo": ""}},
+		{"type": "transfer_operation", "value": {"from": "carol", "to": "tipper", "amount": "garbage", "memo": ""}}
+	]`), &ops); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, process := range []bool{true, false} {
+		db, bp := newTestProcessor(t, func(c *Config) { c.ProcessTransfers = process })
+		if _, err := bp.processBlock(context.Background(), testBlock(100, ops...)); err != nil {
+			t.Fatal(err)
+		}
+
+		type transfer struct {
+			from, to, amount, symbol, memo string
+			value                          sql.NullFloat64
+		}
+		rows, err := db.Query("SELECT from_account, to_account, amount, amount_value, symbol, memo FROM transfers ORDER BY op_index")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []transfer
+		for rows.Next() {
+			var tr transfer
+			if err := rows.Scan(&tr.from, &tr.to, &tr.amount, &tr.value, &tr.symbol, &tr.memo); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, tr)
+		}
+		rows.Close()
+
+		if !process {
+			if len(got) != 0 {
+				t.Errorf("stored %d transfers without ProcessTransfers, want none", len(got))
+			}
+			continue
+		}
+		want := []transfer{
+			{"alice", "tipper", "1.000 HIVE", "HIVE", "tip @bob/post", sql.NullFloat64{Float64: 1, Valid: true}},
+			{"bob", "tipper", "0.250 HBD", "HBD", "", sql.NullFloat64{Float64: 0.25, Valid: true}},
+			// The raw amount is kept even when it can't be parsed
+			{"carol", "tipper", "garbage", "", "", sql.NullFloat64{}},
+		}
+		if len(got) != len(want) {
+			t.Fatalf("stored %d transfers, want %d: %+v", len(got), len(want), got)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("transfer %d = %+v, want %+v", i, got[i], want[i])
+			}
+		}
+	}
+}
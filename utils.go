@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"strings"
 	"time"
+	"unicode"
 )
 
 // retryWithBackoff executes the given function with exponential backoff. It
@@ -14,18 +18,84 @@ func retryWithBackoff(maxRetries int, retryDelay time.Duration, operation func()
 	var lastErr error
 	for i := 0; i < maxRetries; i++ {
 		if err := operation(); err != nil {
+			// A cancelled operation is not retried
+			if errors.Is(err, context.Canceled) {
+				return err
+			}
 			lastErr = err
 			delay := retryDelay * time.Duration(1<<uint(i))
-			log.Printf("Attempt %d/%d failed: %v. Retrying in %v...", i+1, maxRetries, err, delay)
+			slog.Warn("Attempt failed, retrying", "attempt", i+1, "max_retries", maxRetries, "error", err, "delay", delay)
 			time.Sleep(delay)
 			continue
 		}
 		return nil
 	}
-	return fmt.Errorf("operation failed after %d attempts. Last error: %v", maxRetries, lastErr)
+	return fmt.Errorf("operation failed after %d attempts. Last error: %w", maxRetries, lastErr)
 }
 
 // constructAuthorPerm creates a string in the format "@author/permlink"
+//
+// Hive account names are always lowercase, so the author is lowercased to keep
+// mixed-case operation data from producing a second url for the same post.
+// Trailing slashes, which can't be part of a valid permlink, are stripped.
 func constructAuthorPerm(author, permlink string) string {
-	return fmt.Sprintf("@%s/%s", author, permlink)
+	return fmt.Sprintf("@%s/%s", strings.ToLower(author), strings.TrimRight(permlink, "/"))
+}
+
+// constructPostLink creates the path Hive frontends use for a post,
+// "/category/@author/permlink". The category is the parent permlink of a
+// top-level post, falling back to its first tag; without either the path is
+// "/@author/permlink", which frontends resolve as well.
+func constructPostLink(parentPermlink string, tags []string, author, permlink string) string {
+	category := strings.ToLower(parentPermlink)
+	if category == "" && len(tags) > 0 {
+		category = tags[0]
+	}
+	if category == "" {
+		return "/" + constructAuthorPerm(author, permlink)
+	}
+	return "/" + category + "/" + constructAuthorPerm(author, permlink)
+}
+
+// slugMaxLength is the most characters a slug keeps
+const slugMaxLength = 80
+
+// slugify derives a URL-friendly slug from a title: letters and digits are
+// lowercased and kept, including those of other scripts, and every run of
+// anything else becomes a single hyphen. The slug is cut to slugMaxLength
+// characters and never starts or ends with a hyphen, so a title without letters
+// or digits gives an empty slug.
+func slugify(title string) string {
+	var b strings.Builder
+	length := 0
+	pendingHyphen := false
+	for _, r := range strings.ToLower(title) {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			pendingHyphen = length > 0
+			continue
+		}
+		if pendingHyphen {
+			if length+1 >= slugMaxLength {
+				break
+			}
+			b.WriteByte('-')
+			length++
+			pendingHyphen = false
+		}
+		if length >= slugMaxLength {
+			break
+		}
+		b.WriteRune(r)
+		length++
+	}
+	return b.String()
+}
+
+// normalizePermlink returns the canonical form of a permlink: lowercased, without
+// trailing slashes.
+//
+// Hive only accepts lowercase permlinks, so case variants in operation data can't
+// name distinct posts and are folded into the one the chain stores.
+func normalizePermlink(permlink string) string {
+	return strings.ToLower(strings.TrimRight(permlink, "/"))
 }
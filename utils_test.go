@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConstructAuthorPerm(t *testing.T) {
+	tests := []struct {
+		author, permlink string
+		want             string
+	}{
+		{"alice", "my-post", "@alice/my-post"},
+		{"Alice", "my-post", "@alice/my-post"},
+		{"alice", "my-post/", "@alice/my-post"},
+		{"alice", "my-post//", "@alice/my-post"},
+		{"alice", "My-Post", "@alice/My-Post"},
+		{"", "", "@/"},
+	}
+
+	for _, tt := range tests {
+		if got := constructAuthorPerm(tt.author, tt.permlink); got != tt.want {
+			t.Errorf("constructAuthorPerm(%q, %q) = %q, want %q", tt.author, tt.permlink, got, tt.want)
+		}
+	}
+}
+
+func TestConstructPostLink(t *testing.T) {
+	tests := []struct {
+		parentPermlink string
+		tags           []string
+		author         string
+		permlink       string
+		want           string
+	}{
+		{"hive", []string{"art"}, "alice", "my-post", "/hive/@alice/my-post"},
+		{"Hive", nil, "Alice", "my-post/", "/hive/@alice/my-post"},
+		{"", []string{"art", "hive"}, "alice", "my-post", "/art/@alice/my-post"},
+		{"", nil, "alice", "my-post", "/@alice/my-post"},
+	}
+
+	for _, tt := range tests {
+		if got := constructPostLink(tt.parentPermlink, tt.tags, tt.author, tt.permlink); got != tt.want {
+			t.Errorf("constructPostLink(%q, %v, %q, %q) = %q, want %q", tt.parentPermlink, tt.tags, tt.author, tt.permlink, got, tt.want)
+		}
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		name  string
+		title string
+		want  string
+	}{
+		{"plain", "Hello World", "hello-world"},
+		{"punctuation runs", "Hello,   World!!", "hello-world"},
+		{"leading and trailing", "  --Hello--  ", "hello"},
+		{"digits", "Top 10 Posts of 2024", "top-10-posts-of-2024"},
+		{"other scripts", "Привет мир", "привет-мир"},
+		{"no letters", "!!! ???", ""},
+		{"empty", "", ""},
+		{"cut to the maximum", strings.Repeat("a", slugMaxLength+10), strings.Repeat("a", slugMaxLength)},
+		{"no hyphen at the cut", strings.Repeat("a", slugMaxLength-1) + " b", strings.Repeat("a", slugMaxLength-1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := slugify(tt.title); got != tt.want {
+				t.Errorf("slugify(%q) = %q, want %q", tt.title, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import "fmt"
+
+// votesTableSQL creates the votes table, which holds vote operations when
+// ProcessVotes is enabled. A vote is identified by its position in the chain,
+// since an account can vote on the same post again to change its weight.
+const votesTableSQL = `
+	CREATE TABLE IF NOT EXISTS votes (
+		_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		block_num INTEGER,
+		tx_index INTEGER,
+		op_index INTEGER,
+		timestamp TEXT,
+		transaction_id TEXT,
+		voter TEXT,
+		author TEXT,
+		permlink TEXT,
+		url TEXT,
+		weight INTEGER,
+		UNIQUE (block_num, tx_index, op_index)
+	);
+	CREATE INDEX IF NOT EXISTS idx_votes_url ON votes(url);
+	CREATE INDEX IF NOT EXISTS idx_votes_voter ON votes(voter);
+`
+
+// insertVoteSQL inserts a vote operation
+const insertVoteSQL = `
+	INSERT INTO votes (block_num, tx_index, op_index, timestamp, transaction_id,
+		voter, author, permlink, url, weight)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(block_num, tx_index, op_index) DO NOTHING
+`
+
+// handleVote stores a "vote_operation" in the votes table. The weight is in
+// basis points, negative for a downvote and 0 for a removed vote.
+func (bp *BlockProcessor) handleVote(ctx *OpContext, value OperationValue) (int, error) {
+	timestamp, _ := normalizeTimestamp(ctx.Timestamp)
+	url := constructAuthorPerm(value.Author, value.Permlink)
+
+	var inserted int64
+	err := bp.retryDB(func() error {
+		result, err := bp.txStmt(bp.voteStmt).Exec(ctx.BlockNum, ctx.TxIndex, ctx.OpIndex, timestamp,
+			ctx.TransactionID, value.Voter, value.Author, value.Permlink, url, value.Weight)
+		if err != nil {
+			return err
+		}
+		inserted, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error inserting vote: %w", err)
+	}
+	return int(inserted), nil
+}
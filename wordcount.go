@@ -0,0 +1,39 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var (
+	// markdownImage matches ![alt](url), which is replaced by nothing
+	markdownImage = regexp.MustCompile(`!\[[^\]]*\]\([^)]*\)`)
+	// markdownLink matches [text](url), which is replaced by its text
+	markdownLink = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+	// htmlTag matches HTML tags, which posts often mix into their markdown
+	htmlTag = regexp.MustCompile(`<[^>]*>`)
+	// bareURL matches URLs written out in the text, including image links
+	bareURL = regexp.MustCompile(`https?://\S+`)
+)
+
+// countWords returns a rough count of the words in a post body written in
+// markdown. Images, link targets, HTML tags and bare URLs are removed first, and
+// of the remaining whitespace-separated tokens only those containing a letter or
+// digit are counted, so markup such as "#", "**" or "---" is not.
+func countWords(body string) int {
+	body = markdownImage.ReplaceAllString(body, " ")
+	body = markdownLink.ReplaceAllString(body, " $1 ")
+	body = htmlTag.ReplaceAllString(body, " ")
+	body = bareURL.ReplaceAllString(body, " ")
+
+	count := 0
+	for _, token := range strings.Fields(body) {
+		if strings.IndexFunc(token, func(r rune) bool {
+			return unicode.IsLetter(r) || unicode.IsDigit(r)
+		}) >= 0 {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func TestCountWords(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"plain text", "Hello Hive, this is my first post.", 7},
+		{"empty", "", 0},
+		{"headings and emphasis", "# Title\n\nSome **bold** and _italic_ words\n\n---", 6},
+		{"image", "Look ![a sunset over the sea](https://images.hive.blog/sunset.jpg) here", 2},
+		{"link keeps its text", "Read [the whole story](https://hive.blog/@alice/story) now", 5},
+		{"html tags", "<center><img src=\"https://images.hive.blog/a.png\"></center>\n<p>Two words</p>", 2},
+		{"bare url", "Source: https://example.com/a/b?c=d", 1},
+		{"list markers", "- one\n- two\n* three\n1. four", 5},
+	}
+	for _, tt := range tests {
+		if got := countWords(tt.body); got != tt.want {
+			t.Errorf("%s: countWords(%q) = %d, want %d", tt.name, tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestStoreWordCount(t *testing.T) {
+	post := testPost("alice", "counted", "Counted")
+	post.Value.Body = "## Hello\n\nA [linked phrase](https://hive.blog) and ![img](https://i.imgur.com/x.png) text"
+
+	for _, store := range []bool{true, false} {
+		db, bp := newTestProcessor(t, func(c *Config) { c.StoreWordCount = store })
+		if _, err := bp.processBlock(context.Background(), testBlock(100, post)); err != nil {
+			t.Fatal(err)
+		}
+		var count sql.NullInt64
+		if err := db.QueryRow("SELECT word_count FROM posts WHERE url = '@alice/counted'").Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		want := sql.NullInt64{Int64: 6, Valid: true}
+		if !store {
+			want = sql.NullInt64{}
+		}
+		if count != want {
+			t.Errorf("StoreWordCount %v stored word_count %v, want %v", store, count, want)
+		}
+	}
+}